@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// alphaThresholds is a flag.Value holding the -alphamin of each input, as a
+// comma separated list such as "1,0.5".  Inputs beyond the end of the list
+// use its last value.
+type alphaThresholds []float64
+
+func (t *alphaThresholds) String() string {
+	var s []string
+	for _, v := range *t {
+		s = append(s, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return strings.Join(s, ",")
+}
+
+func (t *alphaThresholds) Set(s string) error {
+	var values alphaThresholds
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || v < 0 || v > 1 {
+			return fmt.Errorf("invalid alpha threshold %q: must be between 0 and 1", field)
+		}
+		values = append(values, v)
+	}
+	*t = values
+	return nil
+}
+
+// threshold returns the threshold of input i as a 16-bit alpha value.
+func (t alphaThresholds) threshold(i int) uint32 {
+	return uint32(t[min(i, len(t)-1)] * 0xffff)
+}
+
+// AlphaFrames applies the alpha threshold of the input each frame was decoded
+// from, making pixels below it fully transparent and those at or above it
+// fully opaque.  Because frames are blended when they are resized the
+// thresholds must be applied before resizing.  AlphaFrames does nothing
+// unless thresholds differ between inputs, a single threshold is applied by
+// the palette through AlphaThreshold instead.
+func AlphaFrames(ctx context.Context, thresholds alphaThresholds, frames <-chan *Frame) <-chan *Frame {
+	if len(thresholds) < 2 {
+		return frames
+	}
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		g := *f
+		g.Image = thresholdAlpha(f.Image, thresholds.threshold(f.Source))
+		return &g, nil
+	})
+}
+
+func thresholdAlpha(src image.Image, threshold uint32) image.Image {
+	rect := src.Bounds()
+	img := image.NewRGBA64(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := src.At(x, y)
+			if IsTransparent(c, threshold) {
+				continue
+			}
+			img.Set(x, y, opaque(c))
+		}
+	}
+	return img
+}
+
+// opaque returns c at full opacity.  Colors returned by the RGBA method are
+// premultiplied by alpha, so a semi-transparent pixel used as is would be
+// drawn darker than it is.  Cells are either drawn or left transparent, so a
+// pixel that passes the alpha threshold is drawn with its channels divided
+// by its alpha.
+func opaque(c color.Color) color.Color {
+	r, g, b, a := c.RGBA()
+	if a == 0xffff || a == 0 {
+		return c
+	}
+	return color.RGBA64{
+		R: uint16(r * 0xffff / a),
+		G: uint16(g * 0xffff / a),
+		B: uint16(b * 0xffff / a),
+		A: 0xffff,
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Animation strategies for FrameOptions.Strategy.
+const (
+	// StrategyCursor moves the cursor up over the previous frame before
+	// drawing the next one.
+	StrategyCursor = "cursor"
+
+	// StrategyRegion confines the animation to a scroll region and positions
+	// each frame absolutely.
+	StrategyRegion = "region"
+)
+
+// Synchronized update sequences (DEC private mode 2026).  Terminals buffer
+// output between them and display it atomically.
+const (
+	syncBegin = "\033[?2026h"
+	syncEnd   = "\033[?2026l"
+)
+
+// syncModeSupported reports whether the controlling terminal recognizes
+// synchronized updates.
+func syncModeSupported() bool {
+	ok, err := queryPrivateMode(2026)
+	if err != nil {
+		logger(logRender).Debug("synchronized output query failed", "err", err)
+	}
+	return ok
+}
+
+// scrollRegion positions animation frames inside a terminal scroll region so
+// that drawing a frame can never scroll the rest of the screen.
+type scrollRegion struct {
+	top  int
+	rows int
+}
+
+// Begin reserves rows lines below the cursor for the animation and sets the
+// scroll region around them.  The region has one extra line so that the
+// newline ending the final row of a frame does not scroll the region.
+func (r *scrollRegion) Begin(w io.Writer, rows int) error {
+	if rows < 1 {
+		rows = 1
+	}
+	r.rows = rows
+	_, err := fmt.Fprintf(w, "%s\033[%dA", strings.Repeat("\n", rows), rows)
+	if err != nil {
+		return err
+	}
+	r.top, err = queryCursorRow()
+	if err != nil {
+		return fmt.Errorf("scroll region: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "\033[%d;%dr", r.top, r.top+r.rows)
+	return err
+}
+
+// Frame returns the sequence that begins drawing a frame.
+func (r *scrollRegion) Frame() string {
+	return fmt.Sprintf("\033[%d;1H", r.top)
+}
+
+// End restores the full-screen scroll region and leaves the cursor below the
+// animation.  Calls after the first have no effect.
+func (r *scrollRegion) End(w io.Writer) error {
+	if r.top == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "\033[r\033[%d;1H", r.top+r.rows)
+	r.top = 0
+	return err
+}
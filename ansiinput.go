@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ansiInputFrame is a frame of ANSI input, its lines without the sequences
+// which positioned it.
+type ansiInputFrame struct {
+	data  []byte
+	delay time.Duration
+}
+
+// ansiInputPath reports whether the file at path holds ANSI output, which
+// is drawn as it is rather than decoded as an image.
+func ansiInputPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ans", ".cast":
+		return true
+	}
+	return false
+}
+
+// decodeANSIArgs reads the ANSI input named by args, or standard input, and
+// concatenates the frames of each.
+func decodeANSIArgs(stdin bool, args []string) ([]ansiInputFrame, error) {
+	if stdin || len(args) == 0 {
+		frames, err := decodeANSIInput(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading standard input: %w", err)
+		}
+		return frames, nil
+	}
+	var frames []ansiInputFrame
+	for _, filename := range args {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		fileFrames, err := decodeANSIInput(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", filename, err)
+		}
+		frames = append(frames, fileFrames...)
+	}
+	return frames, nil
+}
+
+// decodeANSIInput reads ANSI output from r and splits it into frames.  The
+// output may be an asciinema recording (asciicast v2), whose frames are
+// timed by the recording, or ANSI text like a .ans file or a recording made
+// with -o, whose frames have no delay.  ANSI text which is not valid UTF-8 is
+// decoded as code page 437, as DOS and BBS art is.  If the text ends with a
+// SAUCE record giving the width of the art its lines wrap at that width, as
+// they did on the terminal the art was made for.
+func decodeANSIInput(r io.Reader) ([]ansiInputFrame, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(1)
+	if len(head) > 0 && head[0] == '{' {
+		return decodeCastInput(br)
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	data, cols := stripSAUCE(data)
+	data, err = decodeANSIArt(data, "auto")
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if cols > 0 {
+		data = wrapANSILines(data, cols)
+	}
+	var frames []ansiInputFrame
+	for _, c := range splitANSIFrames(data) {
+		frames = append(frames, ansiInputFrame{data: c.data})
+	}
+	return frames, nil
+}
+
+// wrapANSILines breaks the lines of data which are wider than cols columns,
+// as a terminal cols columns wide wraps them.  As in the terminal, a line
+// filling every column followed by a line feed is not broken again.
+func wrapANSILines(data []byte, cols int) []byte {
+	var out []byte
+	col := 0
+	for i := 0; i < len(data); {
+		if n := escapeLen(data[i:]); n > 0 {
+			seq := data[i : i+n]
+			if n > 2 && seq[1] == '[' && (seq[n-1] == 'C' || seq[n-1] == 'D') {
+				k, err := strconv.Atoi(string(seq[2 : n-1]))
+				if err != nil {
+					k = 1
+				}
+				if seq[n-1] == 'C' {
+					col = min(col+k, cols)
+				} else {
+					col = max(col-k, 0)
+				}
+			}
+			out = append(out, seq...)
+			i += n
+			continue
+		}
+		_, size := utf8.DecodeRune(data[i:])
+		switch data[i] {
+		case '\n', '\r':
+			col = 0
+		default:
+			if col >= cols {
+				out = append(out, '\n')
+				col = 0
+			}
+			col++
+		}
+		out = append(out, data[i:i+size]...)
+		i += size
+	}
+	return out
+}
+
+// decodeCastInput reads the output events of an asciicast v2 recording.  A
+// frame's delay is the time until the next frame begins.
+func decodeCastInput(r io.Reader) ([]ansiInputFrame, error) {
+	dec := json.NewDecoder(r)
+	var header struct {
+		Version int `json:"version"`
+	}
+	err := dec.Decode(&header)
+	if err != nil {
+		return nil, fmt.Errorf("asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("asciicast version %d is not supported", header.Version)
+	}
+
+	// events are joined, recording where each begins to time the frames
+	// split from the output.
+	var data []byte
+	var offsets []int
+	var times []float64
+	for {
+		var event []any
+		err := dec.Decode(&event)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("asciicast event: %w", err)
+		}
+		if len(event) != 3 {
+			return nil, fmt.Errorf("asciicast event has %d fields", len(event))
+		}
+		t, _ := event[0].(float64)
+		code, _ := event[1].(string)
+		out, _ := event[2].(string)
+		if code != "o" {
+			continue
+		}
+		offsets = append(offsets, len(data))
+		times = append(times, t)
+		data = append(data, strings.ReplaceAll(out, "\r\n", "\n")...)
+	}
+
+	// timeAt returns the time of the event holding the output at off.
+	timeAt := func(off int) float64 {
+		i := sort.SearchInts(offsets, off+1) - 1
+		if i < 0 {
+			return 0
+		}
+		return times[i]
+	}
+	chunks := splitANSIFrames(data)
+	frames := make([]ansiInputFrame, len(chunks))
+	for i, c := range chunks {
+		frames[i].data = c.data
+		if i+1 < len(chunks) {
+			secs := timeAt(chunks[i+1].off) - timeAt(c.off)
+			frames[i].delay = time.Duration(secs * float64(time.Second))
+		}
+	}
+	return frames, nil
+}
+
+// ansiChunk is a frame split from ANSI output beginning at offset off.
+type ansiChunk struct {
+	off  int
+	data []byte
+}
+
+// splitANSIFrames splits ANSI output into frames.  A frame begins where the
+// cursor is moved home, or the screen is cleared, at the start of a line.
+// Other cursor movement positions the cells of art and does not begin a
+// frame.  These sequences and synchronized update sequences are removed,
+// frames are positioned again when they are drawn.  Empty frames are
+// dropped.
+func splitANSIFrames(data []byte) []ansiChunk {
+	var chunks []ansiChunk
+	var cur []byte
+	start := 0
+	flush := func(next int) {
+		if len(bytes.TrimSpace(cur)) > 0 {
+			chunks = append(chunks, ansiChunk{start, cur})
+		}
+		cur, start = nil, next
+	}
+	for i := 0; i < len(data); {
+		n := escapeLen(data[i:])
+		if n == 0 {
+			cur = append(cur, data[i])
+			i++
+			continue
+		}
+		seq := string(data[i : i+n])
+		lineStart := len(cur) == 0 || cur[len(cur)-1] == '\n'
+		switch {
+		case seq == syncBegin || seq == syncEnd:
+		case lineStart && isFrameStart(seq):
+			flush(i)
+		default:
+			cur = append(cur, seq...)
+		}
+		i += n
+	}
+	flush(len(data))
+	return chunks
+}
+
+// isFrameStart reports whether the escape sequence seq moves the cursor to
+// the top of a frame, by clearing the screen or moving the cursor home.
+func isFrameStart(seq string) bool {
+	if !strings.HasPrefix(seq, "\033[") {
+		return false
+	}
+	params, final := seq[2:len(seq)-1], seq[len(seq)-1]
+	switch final {
+	case 'H', 'f':
+		return params == "" || params == "1;1" || params == "1" || params == ";"
+	case 'J':
+		return params == "2"
+	}
+	return false
+}
+
+// escapeLen returns the length of the escape sequence beginning b, or 0 if b
+// does not begin with ESC.
+func escapeLen(b []byte) int {
+	if len(b) == 0 || b[0] != '\033' {
+		return 0
+	}
+	if len(b) == 1 {
+		return 1
+	}
+	switch b[1] {
+	case '[':
+		for i := 2; i < len(b); i++ {
+			if b[i] >= 0x40 && b[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(b)
+	case ']', 'P', '_':
+		// strings end with BEL or ST.
+		for i := 2; i < len(b); i++ {
+			if b[i] == '\a' {
+				return i + 1
+			}
+			if b[i] == '\033' && i+1 < len(b) && b[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(b)
+	}
+	return 2
+}
+
+// ansiWidth returns the number of columns occupied by the widest line of an
+// ANSI frame.
+func ansiWidth(data []byte) int {
+	width := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		n := 0
+		for i := 0; i < len(line); {
+			if k := escapeLen(line[i:]); k > 0 {
+				i += k
+				continue
+			}
+			_, size := utf8.DecodeRune(line[i:])
+			i += size
+			n++
+		}
+		width = max(width, n)
+	}
+	return width
+}
+
+// PlayANSIFrames plays frames of ANSI input like the frames of an image,
+// looping them according to opts.Repeat and padding each line with
+// opts.Pad.  Frames are drawn by drawANSIFrames, which paces them.
+func PlayANSIFrames(ctx context.Context, frames []ansiInputFrame, opts *FrameOptions) <-chan *ANSIFrame {
+	out := make(chan *ANSIFrame, PipelineBuffer)
+	go func() {
+		defer close(out)
+		numloop := opts.Repeat
+		if !opts.Animate {
+			numloop = 0
+		}
+		lastRows := 0
+		for loop := 0; numloop < 0 || loop <= numloop; loop++ {
+			for _, f := range frames {
+				buf := &frameBuffer{}
+				if opts.Animate && opts.Strategy != StrategyRegion && lastRows > 0 {
+					fmt.Fprintf(buf, "\033[%dA", lastRows)
+				}
+				lines := bytes.Split(bytes.TrimSuffix(f.data, []byte("\n")), []byte("\n"))
+				for i, line := range lines {
+					buf.WriteString(opts.Pad)
+					buf.Write(line)
+					if i == len(lines)-1 {
+						buf.WriteString(ANSIClear)
+					}
+					buf.WriteString("\n")
+				}
+				lastRows = len(lines)
+				b := &ANSIFrame{
+					Buffer: buf,
+					Rows:   len(lines),
+					Cols:   ansiWidth(f.data) + opts.padWidth(),
+					Delay:  f.delay,
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- b:
+				}
+			}
+			if len(frames) == 0 {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeANSIInput(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		input  string
+		frames []string
+		delays []time.Duration
+	}{
+		{
+			name:   "still",
+			input:  "\033[41m ab \033[0m\r\nxyz\r\n\x1aSAUCE00",
+			frames: []string{"\033[41m ab \033[0m\nxyz\n"},
+			delays: []time.Duration{0},
+		},
+		{
+			name:   "sauce",
+			input:  "\xdb\xdb\xdbab\r\ncd\x1a" + sauceRecord(3),
+			frames: []string{"███\nab\ncd"},
+			delays: []time.Duration{0},
+		},
+		{
+			name:   "cursor",
+			input:  "a\nb\n\033[2Ac\nd\n\033[?2026h\033[Ae\nf\n\033[?2026l",
+			frames: []string{"a\nb\n\033[2Ac\nd\n\033[Ae\nf\n"},
+			delays: []time.Duration{0},
+		},
+		{
+			name:   "home",
+			input:  "a\nb\n\033[Hc\nd\n\033[?2026h\033[1;1He\nf\n\033[?2026l",
+			frames: []string{"a\nb\n", "c\nd\n", "e\nf\n"},
+			delays: []time.Duration{0, 0, 0},
+		},
+		{
+			name:   "clear",
+			input:  "\033[2J\033[Ha\n\033[2J\033[H\n\033[1;1Hb\033[3A\n",
+			frames: []string{"a\n", "b\033[3A\n"},
+			delays: []time.Duration{0, 0},
+		},
+		{
+			name: "cast",
+			input: `{"version": 2, "width": 80, "height": 24}
+[0, "o", "a\r\n"]
+[0.25, "i", "q"]
+[0.5, "o", "\u001b[Hb\r\n"]
+[0.75, "o", "\u001b[Hc"]
+[1, "o", "\r\n"]
+`,
+			frames: []string{"a\n", "b\n", "c\n"},
+			delays: []time.Duration{500 * time.Millisecond, 250 * time.Millisecond, 0},
+		},
+	} {
+		frames, err := decodeANSIInput(strings.NewReader(test.input))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(frames) != len(test.frames) {
+			t.Errorf("%s: %d frames (expected %d)", test.name, len(frames), len(test.frames))
+			continue
+		}
+		for i, f := range frames {
+			if string(f.data) != test.frames[i] {
+				t.Errorf("%s: frame %d %q (expected %q)", test.name, i, f.data, test.frames[i])
+			}
+			if f.delay != test.delays[i] {
+				t.Errorf("%s: frame %d delay %v (expected %v)", test.name, i, f.delay, test.delays[i])
+			}
+		}
+	}
+}
+
+func TestANSIWidth(t *testing.T) {
+	width := ansiWidth([]byte("\033[41m ab \033[0m\n\033]8;;http://x\033\\héllo\033]8;;\033\\\n"))
+	if width != 5 {
+		t.Errorf("width %d (expected 5)", width)
+	}
+}
+
+// sauceRecord returns a SAUCE record for character art cols wide.
+func sauceRecord(cols int) string {
+	rec := make([]byte, 128)
+	copy(rec, "SAUCE00")
+	rec[94] = 1
+	binary.LittleEndian.PutUint16(rec[96:], uint16(cols))
+	return string(rec)
+}
+
+func TestWrapANSILines(t *testing.T) {
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"abcdef", "abc\ndef"},
+		{"abc\ndef\n", "abc\ndef\n"},
+		{"\033[31mab\033[0mcd", "\033[31mab\033[0mc\nd"},
+		{"a\033[2Cb", "a\033[2C\nb"},
+		{"ab\033[5Dcd", "ab\033[5Dcd"},
+	} {
+		got := string(wrapANSILines([]byte(test.input), 3))
+		if got != test.want {
+			t.Errorf("%q wrapped %q (expected %q)", test.input, got, test.want)
+		}
+	}
+}
@@ -2,23 +2,75 @@ package main
 
 import (
 	"image/color"
+	"math"
 	"strconv"
 )
 
+// Render modes for FrameOptions.Render, which select the layer palette
+// colors are drawn in.
+const (
+	// RenderBackground draws each cell as a space on a background of its
+	// color.
+	RenderBackground = "background"
+
+	// RenderForeground draws each cell as a full block in the foreground
+	// color, for terminals or fonts where background colors leave gaps
+	// between lines.
+	RenderForeground = "foreground"
+)
+
+// renderBlock is the glyph drawn in each cell by RenderForeground.
+const renderBlock = "█"
+
+// Block modes for FrameOptions.Blocks, which select how many pixels each
+// cell draws.
+const (
+	// BlocksFull draws one pixel in each cell.
+	BlocksFull = "full"
+
+	// BlocksHalf draws two pixels, one above the other, in each cell with
+	// an upper half block in the color of the upper pixel on a background
+	// of the color of the lower pixel.  Half block pixels are close to
+	// square in most fonts, doubling the vertical resolution.
+	BlocksHalf = "half"
+)
+
+// Half block glyphs drawn by BlocksHalf.
+const (
+	halfBlockUpper = "▀"
+	halfBlockLower = "▄"
+)
+
 type ANSIPalette interface {
+	// ANSI returns the escape sequence that sets the terminal background
+	// color closest to c.
 	ANSI(color.Color) string
+
+	// ANSIForeground returns the escape sequence that sets the terminal
+	// foreground color closest to c.
+	ANSIForeground(color.Color) string
+
+	// Convert returns the color displayed by the terminal for the escape
+	// sequence returned by ANSI.  Convert returns nil for transparent colors.
+	Convert(color.Color) color.Color
 }
 
 var ansiPalettes = map[string]ANSIPalette{
 	"256":       new(Palette256Precise),
 	"256-color": new(Palette256Precise),
 	"256-fast":  new(Palette256),
+	"truecolor": new(PaletteTrueColor),
+	"24bit":     new(PaletteTrueColor),
 	"8":         DefaultPalette8,
 	"8-color":   DefaultPalette8,
+	"16":        DefaultPalette16,
+	"16-color":  DefaultPalette16,
 	"gray":      new(PaletteGray),
 	"grayscale": new(PaletteGray),
 	"grey":      new(PaletteGray),
 	"greyscale": new(PaletteGray),
+	"gray-full": &PaletteGray{Full: true},
+	"grey-full": &PaletteGray{Full: true},
 }
 
 func ANSIPalettes() []string {
@@ -30,20 +82,80 @@ func ANSIPalettes() []string {
 }
 
 // PaletteGray is an ANSIPalette that maps color.Color values to one of twenty
-// four grayscale values.
+// four grayscale values, or twenty six if Full is true, by their Rec. 709
+// luminance.
 type PaletteGray struct {
+	// Full includes the black and white entries of the color cube, which
+	// extend the grayscale ramp at both ends.
+	Full bool
 }
 
 func (p *PaletteGray) ANSI(c color.Color) string {
-	const begin = 0xe8
-	const ratio = 24.0 / 255.0
 	if IsTransparent(c, AlphaThreshold) {
 		return ANSIClear
 	}
-	gray := color.GrayModel.Convert(c).(color.Gray).Y
-	scaled := int(round(ratio * float64(gray)))
-	value := scaled + begin
-	return "\033[48;5;" + strconv.Itoa(value) + "m"
+	return sgr256(p.index(c), false)
+}
+
+func (p *PaletteGray) ANSIForeground(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgr256(p.index(c), true)
+}
+
+func (p *PaletteGray) Convert(c color.Color) color.Color {
+	if IsTransparent(c, AlphaThreshold) {
+		return nil
+	}
+	return palette256[p.index(c)]
+}
+
+// index returns the index of the 256 color palette entry nearest to the
+// luminance of c.  The ramp runs from 8 to 238 in steps of 10, and the cube
+// adds 0 and 255.
+func (p *PaletteGray) index(c color.Color) int {
+	const begin = 0xe8
+	y := 255 * luminance(c)
+	if p.Full {
+		switch {
+		case y < 4:
+			return 16
+		case y > 246.5:
+			return 231
+		}
+	}
+	scaled := int(round((y - 8) / 10))
+	return begin + max(0, min(23, scaled))
+}
+
+// luminance returns the Rec. 709 luminance of c in the range [0, 1], encoded
+// with the sRGB transfer function so that it can be compared with the gray
+// levels displayed by terminals.  Channels are weighted in linear light
+// rather than averaged as encoded values, as color.GrayModel does.
+func luminance(c color.Color) float64 {
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return 0
+	}
+	lin := func(v uint32) float64 {
+		return srgbToLinear(float64(v) / float64(a))
+	}
+	return linearToSRGB(0.2126*lin(r) + 0.7152*lin(g) + 0.0722*lin(b))
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
 }
 
 // Color8 represents the set of colors in an 8-color palette.
@@ -79,14 +191,67 @@ func (p *Palette8) ANSI(c color.Color) string {
 	if IsTransparent(c, AlphaThreshold) {
 		return ANSIClear
 	}
+	return sgr8(p.index(c), false)
+}
+
+func (p *Palette8) ANSIForeground(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgr8(p.index(c), true)
+}
+
+func (p *Palette8) index(c color.Color) int {
 	var imin int // minimizing index
-	cpalette := color.Palette((*p)[:]).Convert(c)
+	cpalette := color.Palette((*p)[:]).Convert(opaque(c))
 	for i, c2 := range *p {
 		if c2 == cpalette {
 			imin = i
 		}
 	}
-	return "\033[4" + strconv.Itoa(imin) + "m"
+	return imin
+}
+
+func (p *Palette8) Convert(c color.Color) color.Color {
+	if IsTransparent(c, AlphaThreshold) {
+		return nil
+	}
+	return color.Palette((*p)[:]).Convert(opaque(c))
+}
+
+// Palette16 is an ANSIPalette that maps color.Color values to one of the 8
+// standard colors or their 8 bright variants by minimizing euclidean RGB
+// distance.  Bright colors are selected with the aixterm codes, 90–97 and
+// 100–107, which unlike bold do not depend on the terminal brightening bold
+// text and unlike reverse video work for backgrounds.
+type Palette16 [16]color.Color
+
+// DefaultPalette16 holds the colors xterm uses for the 16 standard colors.
+var DefaultPalette16 = func() *Palette16 {
+	var p Palette16
+	copy(p[:], palette256[:16])
+	return &p
+}()
+
+func (p *Palette16) ANSI(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgr16(color.Palette((*p)[:]).Index(opaque(c)), false)
+}
+
+func (p *Palette16) ANSIForeground(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgr16(color.Palette((*p)[:]).Index(opaque(c)), true)
+}
+
+func (p *Palette16) Convert(c color.Color) color.Color {
+	if IsTransparent(c, AlphaThreshold) {
+		return nil
+	}
+	return color.Palette((*p)[:]).Convert(opaque(c))
 }
 
 // Palette256 is an ANSIPalette that maps color.Color to one of 256 RGB colors.
@@ -94,25 +259,144 @@ type Palette256 struct {
 }
 
 func (p *Palette256) ANSI(c color.Color) string {
-	const begin = 16
-	const ratio = 5.0 / (1<<16 - 1)
-	rf, gf, bf, af := c.RGBA()
-	if af < AlphaThreshold {
+	if IsTransparent(c, AlphaThreshold) {
 		return ANSIClear
 	}
+	return sgr256(p.index(c), false)
+}
+
+func (p *Palette256) ANSIForeground(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgr256(p.index(c), true)
+}
+
+func (p *Palette256) Convert(c color.Color) color.Color {
+	if IsTransparent(c, AlphaThreshold) {
+		return nil
+	}
+	return palette256[p.index(c)]
+}
+
+// index returns the index of the color cube entry nearest to c, rounding
+// each channel independently.
+func (p *Palette256) index(c color.Color) int {
+	const begin = 16
+	const ratio = 5.0 / (1<<16 - 1)
+	rf, gf, bf, _ := opaque(c).RGBA()
 	r := int(round(ratio * float64(rf)))
 	g := int(round(ratio * float64(gf)))
 	b := int(round(ratio * float64(bf)))
-	val := r*6*6 + g*6 + b + begin
-	return "\033[48;5;" + strconv.Itoa(val) + "m"
+	return r*6*6 + g*6 + b + begin
 }
 
+// Palette256Precise is an ANSIPalette that maps color.Color to the nearest of
+// the 256 colors by euclidean RGB distance, searching palette256Tree rather
+// than comparing every color.
 type Palette256Precise struct{}
 
 func (p *Palette256Precise) ANSI(c color.Color) string {
 	if IsTransparent(c, AlphaThreshold) {
 		return ANSIClear
 	}
-	val := palette256.Index(c)
-	return "\033[48;5;" + strconv.Itoa(val) + "m"
+	return sgr256(palette256Tree.Index(opaque(c)), false)
+}
+
+func (p *Palette256Precise) ANSIForeground(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgr256(palette256Tree.Index(opaque(c)), true)
+}
+
+func (p *Palette256Precise) Convert(c color.Color) color.Color {
+	if IsTransparent(c, AlphaThreshold) {
+		return nil
+	}
+	return palette256[palette256Tree.Index(opaque(c))]
+}
+
+// PaletteTrueColor is an ANSIPalette that draws colors with 24-bit RGB
+// escape sequences, so that gradients are not banded by quantization.
+// Channels are only rounded to 8 bits.
+type PaletteTrueColor struct{}
+
+func (p *PaletteTrueColor) ANSI(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgrRGB(p.rgb(c), false)
+}
+
+func (p *PaletteTrueColor) ANSIForeground(c color.Color) string {
+	if IsTransparent(c, AlphaThreshold) {
+		return ANSIClear
+	}
+	return sgrRGB(p.rgb(c), true)
+}
+
+func (p *PaletteTrueColor) Convert(c color.Color) color.Color {
+	if IsTransparent(c, AlphaThreshold) {
+		return nil
+	}
+	return p.rgb(c)
+}
+
+// rgb returns c made opaque, with each channel rounded to 8 bits.
+func (p *PaletteTrueColor) rgb(c color.Color) color.RGBA {
+	r, g, b, _ := opaque(c).RGBA()
+	round8 := func(v uint32) uint8 {
+		return uint8((v*0xff + 0x7fff) / 0xffff)
+	}
+	return color.RGBA{round8(r), round8(g), round8(b), 0xff}
+}
+
+// sgrRGB returns the escape sequence selecting the 24-bit color c as the
+// foreground or background.
+func sgrRGB(c color.RGBA, fg bool) string {
+	b := make([]byte, 0, len("\033[48;2;255;255;255m"))
+	if fg {
+		b = append(b, "\033[38;2;"...)
+	} else {
+		b = append(b, "\033[48;2;"...)
+	}
+	b = strconv.AppendUint(b, uint64(c.R), 10)
+	b = append(b, ';')
+	b = strconv.AppendUint(b, uint64(c.G), 10)
+	b = append(b, ';')
+	b = strconv.AppendUint(b, uint64(c.B), 10)
+	b = append(b, 'm')
+	return string(b)
+}
+
+// sgr8 returns the escape sequence selecting color i of the 8 color palette
+// as the foreground or background.
+func sgr8(i int, fg bool) string {
+	if fg {
+		return "\033[3" + strconv.Itoa(i) + "m"
+	}
+	return "\033[4" + strconv.Itoa(i) + "m"
+}
+
+// sgr16 returns the escape sequence selecting color i of the 16 color
+// palette as the foreground or background.  Colors 8 through 15 use the
+// aixterm bright color codes.
+func sgr16(i int, fg bool) string {
+	if i < 8 {
+		return sgr8(i, fg)
+	}
+	if fg {
+		return "\033[9" + strconv.Itoa(i-8) + "m"
+	}
+	return "\033[10" + strconv.Itoa(i-8) + "m"
+}
+
+// sgr256 returns the escape sequence selecting color i of the 256 color
+// palette as the foreground or background.
+func sgr256(i int, fg bool) string {
+	if fg {
+		return "\033[38;5;" + strconv.Itoa(i) + "m"
+	}
+	return "\033[48;5;" + strconv.Itoa(i) + "m"
 }
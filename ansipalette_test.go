@@ -0,0 +1,97 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPaletteTrueColor(t *testing.T) {
+	p := new(PaletteTrueColor)
+	c := color.RGBA64{0x1234, 0x8080, 0xffff, 0xffff}
+	if s := p.ANSI(c); s != "\033[48;2;18;128;255m" {
+		t.Errorf("background %q", s)
+	}
+	if s := p.ANSIForeground(c); s != "\033[38;2;18;128;255m" {
+		t.Errorf("foreground %q", s)
+	}
+	if s := p.ANSI(color.Transparent); s != ANSIClear {
+		t.Errorf("transparent %q", s)
+	}
+
+	// runs of one color are written with one sequence.
+	img := image.NewRGBA(image.Rect(0, 0, 8, 2))
+	for x := 0; x < 8; x++ {
+		img.Set(x, 0, color.RGBA{10, 20, 30, 0xff})
+		img.Set(x, 1, color.RGBA{uint8(x), 20, 30, 0xff})
+	}
+	var buf frameBuffer
+	if _, _, err := encodeANSIFrame(&buf, img, p, &FrameOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(string(buf.b), "\n")
+	if n := strings.Count(rows[0], "48;2;"); n != 1 {
+		t.Errorf("uniform row written with %d sequences", n)
+	}
+	if n := strings.Count(rows[1], "48;2;"); n != 8 {
+		t.Errorf("gradient row written with %d sequences", n)
+	}
+}
+
+// TestHalfBlocks checks that half blocks display as the cells of json-cells
+// frames describe them, two pixels to a cell.
+func TestHalfBlocks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 6, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 6; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 50), uint8(y * 60), 0, 0xff})
+		}
+	}
+	// transparent pixels above, below and on both halves of a cell.
+	img.Set(1, 0, color.Transparent)
+	img.Set(2, 1, color.Transparent)
+	img.Set(3, 2, color.Transparent)
+	img.Set(3, 3, color.Transparent)
+	// a cell of one color.
+	img.Set(4, 3, color.RGBA{200, 120, 0, 0xff})
+
+	for _, p := range []ANSIPalette{new(Palette256), new(PaletteTrueColor)} {
+		opts := &FrameOptions{Pad: " ", Blocks: BlocksHalf}
+		var buf frameBuffer
+		rows, _, err := encodeANSIFrame(&buf, img, p, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows != 3 {
+			t.Errorf("%T: %d lines drawn (expected 3)", p, rows)
+		}
+		grid := newCellGrid(8, palette256)
+		grid.Write(buf.b)
+		want := newJSONFrame(img, 0, p, opts)
+		for y, row := range want.Cells {
+			if !reflect.DeepEqual(grid.cells[y][1:7], row) {
+				t.Errorf("%T: line %d drawn differently than its cells", p, y)
+			}
+		}
+		if c := want.Cells[0][0]; c.Glyph != halfBlockUpper || c.FG == nil || c.BG == nil {
+			t.Errorf("%T: opaque cell %+v", p, c)
+		}
+		if c := want.Cells[0][1]; c.Glyph != halfBlockLower || c.BG != nil {
+			t.Errorf("%T: cell transparent above %+v", p, c)
+		}
+		if c := want.Cells[0][2]; c.Glyph != halfBlockUpper || c.BG != nil {
+			t.Errorf("%T: cell transparent below %+v", p, c)
+		}
+		if c := want.Cells[1][3]; c.Glyph != " " || c.FG != nil || c.BG != nil {
+			t.Errorf("%T: transparent cell %+v", p, c)
+		}
+		if c := want.Cells[1][4]; c.Glyph != " " || c.BG == nil {
+			t.Errorf("%T: cell of one color %+v", p, c)
+		}
+		if c := want.Cells[2][0]; c.Glyph != halfBlockUpper || c.BG != nil {
+			t.Errorf("%T: last line of an odd image %+v", p, c)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+const (
+	artNetPort          = "6454"
+	artNetOpDMX         = 0x5000
+	artNetProtocol      = 14
+	artNetPixelsPerUniv = 170 // 510 of the 512 DMX channels
+)
+
+// artNetSink sends frames as Art-Net ArtDmx packets.  Pixels are packed three
+// channels (RGB) at a time into consecutive universes starting at universe.
+type artNetSink struct {
+	conn     net.Conn
+	universe int
+	seq      uint8
+	buf      []byte
+}
+
+// newArtNetSink returns a sink for a URL of the form
+// artnet://host[:port][?universe=N].
+func newArtNetSink(u *url.URL) (*artNetSink, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), artNetPort)
+	}
+	s := &artNetSink{}
+	if univ := u.Query().Get("universe"); univ != "" {
+		n, err := strconv.Atoi(univ)
+		if err != nil || n < 0 || n > 0x7fff {
+			return nil, fmt.Errorf("artnet: invalid universe: %q", univ)
+		}
+		s.universe = n
+	}
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+func (s *artNetSink) WritePixels(size image.Point, pixels []color.RGBA) error {
+	s.seq++
+	if s.seq == 0 {
+		// sequence zero disables reordering on the receiver.
+		s.seq = 1
+	}
+	for i := 0; i*artNetPixelsPerUniv < len(pixels); i++ {
+		chunk := pixels[i*artNetPixelsPerUniv:]
+		if len(chunk) > artNetPixelsPerUniv {
+			chunk = chunk[:artNetPixelsPerUniv]
+		}
+		_, err := s.conn.Write(s.packet(s.universe+i, chunk))
+		if err != nil {
+			return fmt.Errorf("artnet: %w", err)
+		}
+	}
+	return nil
+}
+
+// packet returns an ArtDmx packet carrying pixels for the given universe.  The
+// returned slice is only valid until the next call to packet.
+func (s *artNetSink) packet(universe int, pixels []color.RGBA) []byte {
+	n := 3 * len(pixels)
+	if n%2 == 1 {
+		// the DMX data length must be even.
+		n++
+	}
+	b := append(s.buf[:0], "Art-Net\x00"...)
+	b = binary.LittleEndian.AppendUint16(b, artNetOpDMX)
+	b = binary.BigEndian.AppendUint16(b, artNetProtocol)
+	b = append(b, s.seq, 0)
+	b = binary.LittleEndian.AppendUint16(b, uint16(universe))
+	b = binary.BigEndian.AppendUint16(b, uint16(n))
+	for _, c := range pixels {
+		b = append(b, c.R, c.G, c.B)
+	}
+	if len(b)-18 < n {
+		b = append(b, 0)
+	}
+	s.buf = b
+	return b
+}
+
+func (s *artNetSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"image"
+	"strconv"
+	"strings"
+)
+
+// CellAttr is a set of SGR attributes drawn with the color of a cell.
+type CellAttr uint8
+
+// Cell attributes.
+const (
+	AttrBold CellAttr = 1 << iota
+	AttrUnderline
+	AttrReverse
+)
+
+// cellAttrCodes are the SGR parameters which set and reset each attribute.
+var cellAttrCodes = []struct {
+	attr    CellAttr
+	on, off int
+}{
+	{AttrBold, 1, 22},
+	{AttrUnderline, 4, 24},
+	{AttrReverse, 7, 27},
+}
+
+// AttrImage is an image with attributes for its cells.  Images which
+// implement AttrImage are encoded with the attributes of each cell, other
+// images are encoded without attributes.
+type AttrImage interface {
+	image.Image
+
+	// CellAttrAt returns the attributes of the cell at x, y.
+	CellAttrAt(x, y int) CellAttr
+}
+
+// cellAttrAt returns the attributes of the cell of img at x, y.
+func cellAttrAt(img image.Image, x, y int) CellAttr {
+	if m, ok := img.(AttrImage); ok {
+		return m.CellAttrAt(x, y)
+	}
+	return 0
+}
+
+// sgrAttr returns the escape sequence which changes the attributes from to
+// a, leaving colors unchanged, or the empty string if they are the same.
+func sgrAttr(from, a CellAttr) string {
+	if from == a {
+		return ""
+	}
+	var params []string
+	for _, c := range cellAttrCodes {
+		switch {
+		case a&c.attr != 0 && from&c.attr == 0:
+			params = append(params, strconv.Itoa(c.on))
+		case a&c.attr == 0 && from&c.attr != 0:
+			params = append(params, strconv.Itoa(c.off))
+		}
+	}
+	return "\033[" + strings.Join(params, ";") + "m"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testAttrImage struct {
+	*image.RGBA
+	attrs []CellAttr
+}
+
+func (m *testAttrImage) CellAttrAt(x, y int) CellAttr {
+	return m.attrs[y*m.Rect.Dx()+x]
+}
+
+// TestCellAttr checks that encoded attributes display as the cells of
+// json-cells frames describe them, and do not extend past the image.
+func TestCellAttr(t *testing.T) {
+	img := &testAttrImage{RGBA: image.NewRGBA(image.Rect(0, 0, 5, 2))}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 5; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 50), 0, uint8(y * 200), 0xff})
+		}
+	}
+	img.Set(3, 1, color.Transparent)
+	img.attrs = []CellAttr{
+		0, AttrBold, AttrBold | AttrUnderline, AttrUnderline, AttrReverse,
+		AttrReverse, AttrReverse | AttrBold, 0, AttrUnderline, AttrUnderline,
+	}
+
+	p := new(Palette256)
+	for _, render := range []string{RenderBackground, RenderForeground} {
+		opts := &FrameOptions{Pad: " ", Render: render}
+		var buf frameBuffer
+		if _, _, err := encodeANSIFrame(&buf, img, p, opts); err != nil {
+			t.Fatal(err)
+		}
+		grid := newCellGrid(7, palette256)
+		grid.Write(buf.b)
+		want := newJSONFrame(img, 0, p, opts)
+		for y, row := range want.Cells {
+			if !reflect.DeepEqual(grid.cells[y][1:6], row) {
+				t.Errorf("%s: row %d drawn differently than its cells", render, y)
+			}
+			if pad := grid.cells[y][6]; pad.Bold || pad.Underline {
+				t.Errorf("%s: row %d padding has attributes", render, y)
+			}
+		}
+		if !strings.HasSuffix(string(buf.b), ANSIClear+"\n") {
+			t.Errorf("%s: frame does not end with a reset: %q", render, buf.b)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// baudWriter paces writes so they do not exceed the throughput of a serial
+// line running at a given baud rate with 8N1 framing (ten bits per byte).
+type baudWriter struct {
+	ctx         context.Context
+	w           io.Writer
+	bytesPerSec float64
+
+	// start is when the current burst of writes began and n is the number of
+	// bytes written since.
+	start time.Time
+	n     int64
+}
+
+func newBaudWriter(ctx context.Context, w io.Writer, baud int) *baudWriter {
+	return &baudWriter{
+		ctx:         ctx,
+		w:           w,
+		bytesPerSec: float64(baud) / 10,
+	}
+}
+
+func (w *baudWriter) Write(p []byte) (int, error) {
+	// chunks of roughly 10ms keep output smooth without excessive syscalls.
+	chunk := int(w.bytesPerSec / 100)
+	if chunk < 1 {
+		chunk = 1
+	}
+	now := time.Now()
+	if now.After(w.due()) {
+		// the line has been idle, it does not bank unused throughput.
+		w.start = now
+		w.n = 0
+	}
+	written := 0
+	for len(p) > 0 {
+		n := chunk
+		if n > len(p) {
+			n = len(p)
+		}
+		m, err := w.w.Write(p[:n])
+		written += m
+		w.n += int64(m)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		select {
+		case <-w.ctx.Done():
+			return written, w.ctx.Err()
+		case <-time.After(time.Until(w.due())):
+		}
+	}
+	return written, nil
+}
+
+// due returns the time at which all bytes written so far would have finished
+// transmitting.
+func (w *baudWriter) due() time.Time {
+	return w.start.Add(time.Duration(float64(w.n) / w.bytesPerSec * float64(time.Second)))
+}
+
+// FlushFrame passes the end of a frame to the writer beneath.
+func (w *baudWriter) FlushFrame() error {
+	return flushFrame(w.w)
+}
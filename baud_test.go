@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBaudWriterFlushFrame checks that a command run for each frame still
+// runs when output is paced.
+func TestBaudWriterFlushFrame(t *testing.T) {
+	ctx := context.Background()
+	log := filepath.Join(t.TempDir(), "frames")
+	w := newBaudWriter(ctx, newExecFrameWriter(ctx, "cat >> "+log+"; echo >> "+log), 1000000)
+	for _, frame := range []string{"a", "b"} {
+		if _, err := w.Write([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+		if err := flushFrame(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("commands received %q (expected one for each frame)", got)
+	}
+}
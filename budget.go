@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a number of bytes given to a flag, with an optional K or M
+// suffix for multiples of 1024.
+type byteSize int
+
+func (n *byteSize) String() string {
+	switch {
+	case *n > 0 && *n%(1<<20) == 0:
+		return strconv.Itoa(int(*n>>20)) + "M"
+	case *n > 0 && *n%(1<<10) == 0:
+		return strconv.Itoa(int(*n>>10)) + "K"
+	}
+	return strconv.Itoa(int(*n))
+}
+
+func (n *byteSize) Set(s string) error {
+	unit := 1
+	switch {
+	case strings.HasSuffix(s, "K"), strings.HasSuffix(s, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"), strings.HasSuffix(s, "m"):
+		unit = 1 << 20
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("size must be a number of bytes like 4096 or 64K")
+	}
+	*n = byteSize(v * unit)
+	return nil
+}
+
+// budgetLevel is a reduction in the quality of a frame made to shrink its
+// output.
+type budgetLevel struct {
+	// levels is the number of levels each color channel is reduced to, or
+	// zero to leave colors unchanged.
+	levels int
+
+	// merge is the largest difference in a channel of displayed colors, out
+	// of 0xffff, for which a cell is drawn with the color of the cell before
+	// it.  Runs of cells with one color are written with a single escape
+	// sequence.
+	merge uint32
+
+	// scale is the size of the frame drawn relative to the frame, or zero
+	// for the same size.
+	scale float64
+}
+
+// budgetLevels are tried in order until a frame fits its budget.  Merging
+// runs of similar cells costs least, then reducing colors, and only then is
+// the frame made smaller.
+var budgetLevels = []budgetLevel{
+	{merge: 0x0800},
+	{merge: 0x1800},
+	{merge: 0x1800, levels: 6},
+	{merge: 0x3000, levels: 4},
+	{merge: 0x3000, levels: 3},
+	{merge: 0x3000, levels: 3, scale: 0.75},
+	{merge: 0x3000, levels: 3, scale: 0.5},
+	{merge: 0x3000, levels: 3, scale: 0.35},
+	{merge: 0x3000, levels: 3, scale: 0.25},
+	{merge: 0x3000, levels: 2, scale: 0.125},
+}
+
+// budgetRecoveryFrames is the number of frames which must fit the budget at
+// a level before the level of higher quality is tried again.
+const budgetRecoveryFrames = 10
+
+// frameBudget encodes frames which exceed a number of bytes at lower quality
+// until they fit, for terminals reached over serial lines or shared services
+// where output is scarce.  The level which made the last frame fit is tried
+// first for the next, so the frames of an animation are not each encoded
+// many times.  Once budgetRecoveryFrames frames have fit at a level the
+// level above it is tried first, so quality returns when frames become
+// simpler.
+type frameBudget struct {
+	max   int
+	level int
+	fits  int // frames in a row which fit at level
+}
+
+// newFrameBudget returns a frameBudget for opts, or nil if frames have no
+// budget.
+func newFrameBudget(opts *FrameOptions) *frameBudget {
+	if opts == nil || opts.MaxFrameBytes <= 0 {
+		return nil
+	}
+	return &frameBudget{max: opts.MaxFrameBytes}
+}
+
+// Exceeded reports whether n bytes of output exceed the budget.
+func (b *frameBudget) Exceeded(n int) bool {
+	return b != nil && n > b.max
+}
+
+// Encode writes img to buf at the highest quality which fits the budget,
+// starting from the level which fit the last frame, and returns the number
+// of rows and columns it occupies as encodeANSIFrame does.  If no level fits,
+// img is written at the lowest quality.  Frames reduced in size clear the
+// rest of the area img would occupy, where earlier frames were drawn.
+func (b *frameBudget) Encode(buf *frameBuffer, img image.Image, p ANSIPalette, opts *FrameOptions) (rows, cols int, err error) {
+	start := len(buf.b)
+	first := b.level
+	if b.fits >= budgetRecoveryFrames && first > 0 {
+		first--
+		b.fits = 0
+	}
+	for i := first; ; i++ {
+		buf.b = buf.b[:start]
+		reduced := budgetLevels[i].apply(img, p)
+		rows, cols, err = encodeANSIFrame(buf, reduced, p, opts)
+		if err == nil && reduced.Bounds().Size() != img.Bounds().Size() {
+			rows, cols = padReduced(buf, start, rows, cols, reduced, img, opts)
+		}
+		fits := len(buf.b)-start <= b.max
+		if err != nil || fits || i == len(budgetLevels)-1 {
+			if i != b.level {
+				logger(logRender).Debug("frame quality changed to fit -max-frame-bytes", "level", i+1, "bytes", len(buf.b)-start)
+			}
+			if !fits || i != b.level {
+				b.fits = 0
+			}
+			if fits {
+				b.fits++
+			}
+			b.level = i
+			return rows, cols, err
+		}
+	}
+}
+
+// padReduced clears the rows and columns which img, drawn in full, would
+// occupy beyond reduced, a smaller copy of it written to buf from start and
+// occupying rows and cols.  The rows and columns of img are returned.
+func padReduced(buf *frameBuffer, start, rows, cols int, reduced, img image.Image, opts *FrameOptions) (int, int) {
+	// lines are cleared to their end after the cells and padding.
+	frame := bytes.ReplaceAll(buf.b[start:], []byte("\n"), []byte("\033[K\n"))
+	// rows of text below the pixels, such as the histogram, are kept.
+	full := rows + opts.lines(img.Bounds().Dy()) - opts.lines(reduced.Bounds().Dy())
+	for ; rows < full; rows++ {
+		frame = append(frame, "\033[K\n"...)
+	}
+	buf.b = append(buf.b[:start], frame...)
+	return rows, cols + img.Bounds().Dx() - reduced.Bounds().Dx()
+}
+
+// apply returns img reduced in quality by l.
+func (l budgetLevel) apply(img image.Image, p ANSIPalette) image.Image {
+	if l.scale > 0 {
+		size := img.Bounds().Size()
+		w := max(1, int(float64(size.X)*l.scale))
+		h := max(1, int(float64(size.Y)*l.scale))
+		img = scaleImage(img, w, h)
+	}
+	rect := img.Bounds()
+	out := image.NewRGBA64(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		// the displayed color of the run of cells being merged.
+		var run color.Color
+		var runSrc color.Color
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := posterize(img.At(x, y), l.levels)
+			d := p.Convert(c)
+			if d == nil {
+				run = nil
+			} else if run != nil && colorDiff(run, d) <= l.merge {
+				c = runSrc
+			} else {
+				run, runSrc = d, c
+			}
+			out.Set(x, y, c)
+		}
+	}
+	return out
+}
+
+// posterize returns c with each channel reduced to the given number of
+// levels.  Colors are returned unchanged if levels is less than 2.
+func posterize(c color.Color, levels int) color.Color {
+	if levels < 2 {
+		return c
+	}
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return c
+	}
+	step := 0xffff / uint32(levels-1)
+	q := func(v uint32) uint16 {
+		// channels are premultiplied, so they are reduced as a fraction
+		// of alpha.
+		v = v * 0xffff / a
+		v = (v + step/2) / step * step
+		return uint16(v * a / 0xffff)
+	}
+	return color.RGBA64{q(r), q(g), q(b), uint16(a)}
+}
+
+// colorDiff returns the largest difference between the channels of a and b.
+func colorDiff(a, b color.Color) uint32 {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	diff := func(x, y uint32) uint32 {
+		if x > y {
+			return x - y
+		}
+		return y - x
+	}
+	return max(diff(ar, br), diff(ag, bg), diff(ab, bb))
+}
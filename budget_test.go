@@ -0,0 +1,108 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestByteSize(t *testing.T) {
+	for s, expect := range map[string]int{"4096": 4096, "64K": 64 << 10, "2m": 2 << 20} {
+		var n byteSize
+		if err := n.Set(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if int(n) != expect {
+			t.Errorf("%q parsed as %d (expected %d)", s, n, expect)
+		}
+	}
+	var n byteSize
+	if err := n.Set("64KB"); err == nil {
+		t.Errorf("64KB parsed as %d", n)
+	}
+}
+
+// TestFrameBudget checks that frames are reduced until they fit their budget
+// and that later frames start at the level which fit.
+func TestFrameBudget(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 60, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 60; x++ {
+			img.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff})
+		}
+	}
+	p := new(Palette256)
+	opts := &FrameOptions{Pad: " ", MaxFrameBytes: 8 << 10}
+
+	var full frameBuffer
+	if _, _, err := encodeANSIFrame(&full, img, p, opts); err != nil {
+		t.Fatal(err)
+	}
+	budget := newFrameBudget(opts)
+	if !budget.Exceeded(len(full.b)) {
+		t.Fatalf("full frame of %d bytes within the budget", len(full.b))
+	}
+	var buf frameBuffer
+	rows, _, err := budget.Encode(&buf, img, p, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.Exceeded(len(buf.b)) {
+		t.Errorf("reduced frame of %d bytes exceeds the budget", len(buf.b))
+	}
+	if rows < 1 || rows > 30 {
+		t.Errorf("reduced frame has %d rows", rows)
+	}
+	level := budget.level
+	if level == 0 {
+		t.Errorf("noise fit the budget without reducing colors")
+	}
+	buf.b = buf.b[:0]
+	budget.Encode(&buf, img, p, opts)
+	if budget.level != level {
+		t.Errorf("second frame encoded at level %d (expected %d)", budget.level, level)
+	}
+}
+
+// TestFrameBudgetScaled checks that frames reduced in size clear the area of
+// the full frame and that quality returns once frames fit.
+func TestFrameBudgetScaled(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := image.NewRGBA(image.Rect(0, 0, 60, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 60; x++ {
+			noise.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff})
+		}
+	}
+	p := new(Palette256)
+	opts := &FrameOptions{MaxFrameBytes: 2 << 10}
+	budget := newFrameBudget(opts)
+	var buf frameBuffer
+	rows, cols, err := budget.Encode(&buf, noise, p, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budgetLevels[budget.level].scale == 0 {
+		t.Fatalf("noise fit at level %d without scaling", budget.level)
+	}
+	if rows != 30 || cols != 60 {
+		t.Errorf("scaled frame occupies %dx%d (expected the full 60x30)", cols, rows)
+	}
+	grid := newCellGrid(60, palette256)
+	grid.Write(buf.b)
+	if grid.y != 30 {
+		t.Errorf("scaled frame ends on line %d (expected 30)", grid.y)
+	}
+
+	// a frame which fits at every level.
+	plain := image.NewRGBA(image.Rect(0, 0, 60, 30))
+	level := budget.level
+	for i := 0; i < budgetRecoveryFrames*level+1; i++ {
+		buf.b = buf.b[:0]
+		budget.Encode(&buf, plain, p, opts)
+	}
+	if budget.level != 0 {
+		t.Errorf("level %d after %d frames fit (expected 0, from %d)", budget.level, budgetRecoveryFrames*level+1, level)
+	}
+}
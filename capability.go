@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ColorAuto is the -color value which selects a palette by probing the
+// terminal.
+const ColorAuto = "auto"
+
+// capabilityLevel is one step of the fallback chain.  Palette is the name of
+// the palette rendering the level, or empty if img2ansi cannot render it yet,
+// and Protocol the -protocol drawing it.  Detect reports whether the terminal
+// supports the level along with a description of the evidence.
+type capabilityLevel struct {
+	Name     string
+	Palette  string
+	Protocol string
+	Detect   func() (bool, string)
+}
+
+// capabilityChain lists output levels from most to least capable.  The
+// first level that img2ansi can render and the terminal supports is used.
+// The graphics protocols draw pixels in full color.
+//
+//	kitty → sixel → iterm2 → truecolor → 256 → 16 → 8 → ascii
+var capabilityChain = []capabilityLevel{
+	{Name: "kitty", Palette: "truecolor", Protocol: ProtocolKitty, Detect: detectKitty},
+	{Name: "sixel", Palette: "truecolor", Protocol: ProtocolSixel, Detect: detectSixel},
+	{Name: "iterm2", Palette: "truecolor", Protocol: ProtocolITerm2, Detect: detectITerm2},
+	{Name: "truecolor", Palette: "truecolor", Protocol: ProtocolANSI, Detect: detectTruecolor},
+	{Name: "256", Palette: "256", Protocol: ProtocolANSI, Detect: detect256},
+	{Name: "16", Palette: "16", Protocol: ProtocolANSI, Detect: detect16},
+	{Name: "8", Palette: "8", Protocol: ProtocolANSI, Detect: detect8},
+	{Name: "ascii", Detect: func() (bool, string) { return true, "always available" }},
+}
+
+// capabilityFallback is used when no level in the chain can be used.
+const capabilityFallback = "8"
+
+// detectPalette walks capabilityChain and returns the name of the palette and
+// protocol to use along with an explanation of each check.  Levels drawn with
+// graphics protocols are skipped unless noGraphics is empty, noGraphics
+// otherwise giving the reason they cannot be used.
+func detectPalette(noGraphics string) (string, string, []string) {
+	var why []string
+	if !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		// output is probably being saved and will be displayed later on an
+		// unknown terminal.
+		why = append(why, "stdout is not a terminal, assuming 256 colors")
+		return "256", ProtocolANSI, why
+	}
+	for _, level := range capabilityChain {
+		switch {
+		case level.Palette == "":
+			why = append(why, fmt.Sprintf("%s: skipped, not supported by img2ansi", level.Name))
+			continue
+		case level.Protocol != ProtocolANSI && noGraphics != "":
+			why = append(why, fmt.Sprintf("%s: skipped, %s", level.Name, noGraphics))
+			continue
+		}
+		ok, reason := level.Detect()
+		if ok {
+			why = append(why, fmt.Sprintf("%s: yes, %s", level.Name, reason))
+			return level.Palette, level.Protocol, why
+		}
+		why = append(why, fmt.Sprintf("%s: no, %s", level.Name, reason))
+	}
+	why = append(why, fmt.Sprintf("no level detected, using %s", capabilityFallback))
+	return capabilityFallback, ProtocolANSI, why
+}
+
+func detectKitty() (bool, string) {
+	if os.Getenv("KITTY_WINDOW_ID") != "" {
+		return true, "KITTY_WINDOW_ID is set"
+	}
+	if term := os.Getenv("TERM"); term == "xterm-kitty" {
+		return true, "TERM=" + term
+	}
+	return false, "TERM is not xterm-kitty and KITTY_WINDOW_ID is unset"
+}
+
+func detectSixel() (bool, string) {
+	attrs, err := queryDeviceAttributes()
+	if err != nil {
+		return false, fmt.Sprintf("device attributes: %v", err)
+	}
+	for _, a := range attrs[min(1, len(attrs)):] {
+		if a == 4 {
+			return true, "device attributes include 4 (sixel)"
+		}
+	}
+	return false, fmt.Sprintf("device attributes %v do not include 4 (sixel)", attrs)
+}
+
+func detectTruecolor() (bool, string) {
+	if p := selftestProfile(); p != nil {
+		return p.Truecolor, "selftest profile " + loadedProfilePath
+	}
+	switch ct := os.Getenv("COLORTERM"); ct {
+	case "truecolor", "24bit":
+		return true, "COLORTERM=" + ct
+	}
+	if tc := detectColors(); tc.RGB {
+		return true, tc.Source + " reports RGB"
+	}
+	return false, "COLORTERM is not truecolor or 24bit" + colorsEvidence()
+}
+
+func detect256() (bool, string) {
+	return detectColorCount(256)
+}
+
+func detect16() (bool, string) {
+	return detectColorCount(16)
+}
+
+func detect8() (bool, string) {
+	return detectColorCount(8)
+}
+
+// detectColorCount reports whether the terminal supports at least n colors.
+// The count confirmed by img2ansi selftest is preferred, then the count
+// reported by the terminal or terminfo, over guessing from environment
+// variables.
+func detectColorCount(n int) (bool, string) {
+	if p := selftestProfile(); p != nil {
+		return p.Colors >= n, fmt.Sprintf("selftest profile %s reports %d colors", loadedProfilePath, p.Colors)
+	}
+	if tc := detectColors(); tc.Counted {
+		return tc.Colors >= n, fmt.Sprintf("%s reports %d colors", tc.Source, tc.Colors)
+	}
+	term := os.Getenv("TERM")
+	switch {
+	case n > 256:
+	case strings.Contains(term, "256color"):
+		return true, "TERM=" + term
+	case n > 16:
+		if ct := os.Getenv("COLORTERM"); ct != "" {
+			return true, "COLORTERM=" + ct
+		}
+	case strings.Contains(term, "16color"):
+		return true, "TERM=" + term
+	case n > 8:
+	case term != "" && term != "dumb":
+		return true, "TERM=" + term
+	}
+	return false, fmt.Sprintf("no evidence of %d colors from TERM=%q", n, term) + colorsEvidence()
+}
+
+// colorsEvidence describes why detectColors could not be used.
+func colorsEvidence() string {
+	if detectedColors == nil || detectedColors.Source == "" {
+		return ", no XTGETTCAP response or terminfo entry"
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"time"
+)
+
+// Output formats accepted by -output-format.
+const (
+	OutputANSI      = "ansi"
+	OutputJSONCells = "json-cells"
+)
+
+// jsonFrame is the representation of a frame written by writeJSONFrames.
+type jsonFrame struct {
+	DelayMS int          `json:"delay_ms"`
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Cells   [][]jsonCell `json:"cells"`
+}
+
+// jsonCell is a single terminal cell.  Colors are hex RGB strings, or null
+// when the cell uses the terminal's default color.  Colors are given as they
+// are displayed, reversed for cells with the reverse attribute.
+type jsonCell struct {
+	Glyph     string  `json:"glyph"`
+	FG        *string `json:"fg"`
+	BG        *string `json:"bg"`
+	Bold      bool    `json:"bold,omitempty"`
+	Underline bool    `json:"underline,omitempty"`
+}
+
+// writeJSONFrames writes the cells of each frame received over frames to w as
+// a JSON object on its own line.  Frames are written as fast as they arrive,
+// consumers are expected to honor the delay of each frame themselves.
+func writeJSONFrames(ctx context.Context, w io.Writer, frames <-chan *Frame, p ANSIPalette, opts *FrameOptions) error {
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case f, ok := <-frames:
+			if !ok {
+				return nil
+			}
+			delay := f.Delay
+			if opts != nil && opts.Delay > 0 {
+				delay = time.Duration(opts.Delay) * time.Millisecond
+			}
+			err := enc.Encode(newJSONFrame(f.Image, delay, p, opts))
+			if err != nil {
+				return err
+			}
+			err = flushFrame(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// newJSONFrame returns the cells drawing img with p as encodeANSIFrame draws
+// them with opts, which may be nil.
+func newJSONFrame(img image.Image, delay time.Duration, p ANSIPalette, opts *FrameOptions) *jsonFrame {
+	if opts == nil {
+		opts = new(FrameOptions)
+	}
+	rect := img.Bounds()
+	size := rect.Size()
+	lines := opts.lines(size.Y)
+	jf := &jsonFrame{
+		DelayMS: int(delay / time.Millisecond),
+		Width:   size.X,
+		Height:  lines,
+		Cells:   make([][]jsonCell, lines),
+	}
+	for y := range jf.Cells {
+		row := make([]jsonCell, size.X)
+		for x := range row {
+			if opts.Blocks == BlocksHalf {
+				row[x] = halfBlockJSONCell(img, x, y, p)
+			} else {
+				c := hexColor(p.Convert(img.At(rect.Min.X+x, rect.Min.Y+y)))
+				row[x] = jsonCell{Glyph: " ", BG: c}
+				if opts.Render == RenderForeground && c != nil {
+					row[x] = jsonCell{Glyph: renderBlock, FG: c}
+				}
+			}
+			// the attributes of a half block are those of its upper pixel.
+			py := y
+			if opts.Blocks == BlocksHalf {
+				py = 2 * y
+			}
+			attr := cellAttrAt(img, rect.Min.X+x, rect.Min.Y+py)
+			if attr&AttrReverse != 0 {
+				row[x].FG, row[x].BG = reverseColors(row[x].FG, row[x].BG)
+			}
+			row[x].Bold = attr&AttrBold != 0
+			row[x].Underline = attr&AttrUnderline != 0
+		}
+		jf.Cells[y] = row
+	}
+	return jf
+}
+
+// halfBlockJSONCell returns the cell in column x of line y of img drawn with
+// BlocksHalf.
+func halfBlockJSONCell(img image.Image, x, y int, p ANSIPalette) jsonCell {
+	rect := img.Bounds()
+	var upper, lower *string
+	upper = hexColor(p.Convert(img.At(rect.Min.X+x, rect.Min.Y+2*y)))
+	if 2*y+1 < rect.Dy() {
+		lower = hexColor(p.Convert(img.At(rect.Min.X+x, rect.Min.Y+2*y+1)))
+	}
+	switch {
+	case upper == nil && lower == nil:
+		return jsonCell{Glyph: " "}
+	case lower == nil:
+		return jsonCell{Glyph: halfBlockUpper, FG: upper}
+	case upper == nil:
+		return jsonCell{Glyph: halfBlockLower, FG: lower}
+	case *upper == *lower:
+		return jsonCell{Glyph: " ", BG: lower}
+	}
+	return jsonCell{Glyph: halfBlockUpper, FG: upper, BG: lower}
+}
+
+// reverseColors returns the colors displayed for a cell with colors fg and bg
+// and the reverse attribute.  Default colors are taken to be light gray on
+// black, as the terminal defaults are unknown.
+func reverseColors(fg, bg *string) (*string, *string) {
+	if fg == nil {
+		fg = hexColor(palette256[7])
+	}
+	if bg == nil {
+		bg = hexColor(palette256[0])
+	}
+	return bg, fg
+}
+
+// hexColor returns c formatted as "#rrggbb", or nil if c is nil.
+func hexColor(c color.Color) *string {
+	if c == nil {
+		return nil
+	}
+	r, g, b, _ := c.RGBA()
+	s := fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+	return &s
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Character sets accepted by -charset, limiting the glyphs written.
+const (
+	// CharsetUTF8 writes every glyph as UTF-8.
+	CharsetUTF8 = "utf8"
+
+	// CharsetLatin1 writes one byte for each glyph, encoded in code page
+	// 437 as BBS software and DOS terminals expect, so that block and box
+	// drawing glyphs are kept.
+	CharsetLatin1 = "latin1"
+
+	// CharsetASCII writes only ASCII, for legacy systems.  Glyphs are
+	// replaced by ASCII characters of similar shape or density.
+	CharsetASCII = "ascii"
+)
+
+// asciiGlyphs are the ASCII replacements for the glyphs img2ansi draws.
+// Blocks and shades are replaced by characters of similar density, and box
+// drawing characters by their lines.
+var asciiGlyphs = map[rune]string{
+	'█': "#", '▓': "%", '▒': ":", '░': ".",
+	'▀': "\"", '▄': "=", '▌': "|", '▐': "|",
+	'▁': ".", '▂': ":", '▃': "-", '▅': "+", '▆': "*", '▇': "%",
+	'…': "...",
+}
+
+// cp437Glyphs are the replacements for glyphs not in code page 437.  The
+// partial blocks of histograms become the nearest of the blocks it has.
+var cp437Glyphs = map[rune]rune{
+	'▁': '_', '▂': '▄', '▃': '▄', '▅': '▄', '▆': '█', '▇': '█',
+}
+
+// charsetWriter writes through w only the glyphs of its character set.  A
+// rune split between calls to Write is held until the rest of it is written.
+// The bidirectional isolates of -bidi-isolate are dropped, as terminals
+// without UTF-8 would draw them as glyphs.
+type charsetWriter struct {
+	w       io.Writer
+	charset string
+	buf     []byte
+	partial []byte
+}
+
+// newCharsetWriter returns a writer limiting the glyphs written to w to
+// charset, or w itself if charset is CharsetUTF8.
+func newCharsetWriter(w io.Writer, charset string) io.Writer {
+	if charset == CharsetUTF8 || charset == "" {
+		return w
+	}
+	return &charsetWriter{w: w, charset: charset}
+}
+
+func (w *charsetWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(w.partial) > 0 {
+		p = append(w.partial, p...)
+		w.partial = nil
+	}
+	w.buf = w.buf[:0]
+	for len(p) > 0 {
+		if p[0] < utf8.RuneSelf {
+			w.buf = append(w.buf, p[0])
+			p = p[1:]
+			continue
+		}
+		if !utf8.FullRune(p) {
+			w.partial = append([]byte(nil), p...)
+			break
+		}
+		r, size := utf8.DecodeRune(p)
+		if r < 0x2066 || r > 0x2069 {
+			w.buf = w.appendGlyph(w.buf, r)
+		}
+		p = p[size:]
+	}
+	_, err := w.w.Write(w.buf)
+	return n, err
+}
+
+func (w *charsetWriter) FlushFrame() error {
+	return flushFrame(w.w)
+}
+
+// appendGlyph appends r, or its replacement, encoded in the character set.
+func (w *charsetWriter) appendGlyph(b []byte, r rune) []byte {
+	if w.charset == CharsetLatin1 {
+		if c, ok := cp437Glyphs[r]; ok {
+			r = c
+		}
+		if c, ok := charmap.CodePage437.EncodeRune(r); ok {
+			return append(b, c)
+		}
+	}
+	if s, ok := asciiGlyphs[r]; ok {
+		return append(b, s...)
+	}
+	if lines, ok := boxLines[r]; ok {
+		switch {
+		case lines[0] == 0 && lines[1] == 0 && lines[2] == 2:
+			return append(b, '=')
+		case lines[0] == 0 && lines[1] == 0:
+			return append(b, '-')
+		case lines[2] == 0 && lines[3] == 0:
+			return append(b, '|')
+		}
+		return append(b, '+')
+	}
+	return append(b, '?')
+}
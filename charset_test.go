@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCharsetWriter(t *testing.T) {
+	for _, test := range []struct {
+		charset string
+		in      string
+		out     string
+	}{
+		{CharsetUTF8, "\033[38;5;1m█▀…", "\033[38;5;1m█▀…"},
+		{CharsetASCII, "\033[38;5;1m█▀…", "\033[38;5;1m#\"..."},
+		{CharsetASCII, "┌─╥┐│é", "+-++|?"},
+		{CharsetASCII, bidiLRI + "█" + bidiPDI + "\n", "#\n"},
+		{CharsetLatin1, "\033[38;5;1m█▀▂", "\033[38;5;1m\xdb\xdf\xdc"},
+	} {
+		var buf bytes.Buffer
+		w := newCharsetWriter(&buf, test.charset)
+		// runes split between writes are kept whole.
+		in := []byte(test.in)
+		half := len(in) - 2
+		w.Write(in[:half])
+		w.Write(in[half:])
+		if buf.String() != test.out {
+			t.Errorf("%s: %q written as %q (expected %q)", test.charset, test.in, buf.String(), test.out)
+		}
+	}
+}
+
+func TestCharsetWriterFlushFrame(t *testing.T) {
+	var flushed int
+	w := newCharsetWriter(&flushCounter{n: &flushed}, CharsetASCII)
+	w.Write([]byte("█"))
+	if err := flushFrame(w); err != nil {
+		t.Fatal(err)
+	}
+	if flushed != 1 {
+		t.Errorf("frame flushed %d times beneath the charset writer", flushed)
+	}
+}
+
+// flushCounter counts the frames flushed to it.
+type flushCounter struct {
+	bytes.Buffer
+	n *int
+}
+
+func (w *flushCounter) FlushFrame() error {
+	*w.n++
+	return nil
+}
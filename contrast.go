@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+)
+
+// ContrastFrames scales the distance of each color channel from the middle
+// gray by factor, making text and lines in screenshots and charts stand out
+// from their background.  Channels are clipped to black and white.  A factor
+// of 1 leaves frames unchanged.
+func ContrastFrames(ctx context.Context, factor float64, frames <-chan *Frame) <-chan *Frame {
+	if factor == 1 {
+		return frames
+	}
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		g := *f
+		g.Image = contrastImage(f.Image, factor)
+		return &g, nil
+	})
+}
+
+func contrastImage(src image.Image, factor float64) image.Image {
+	rect := src.Bounds()
+	img := image.NewNRGBA64(rect)
+	channel := func(v uint32) uint16 {
+		const mid = 0xffff / 2.0
+		return uint16(max(0, min(0xffff, (float64(v)-mid)*factor+mid)))
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+			img.SetNRGBA64(x, y, color.NRGBA64{
+				R: channel(uint32(c.R)),
+				G: channel(uint32(c.G)),
+				B: channel(uint32(c.B)),
+				A: c.A,
+			})
+		}
+	}
+	return img
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// osdRows is the number of lines the on-screen display occupies below an
+// animation.
+const osdRows = 2
+
+// playbackControls handles keys pressed while an animation plays and draws
+// the on-screen display.
+//
+//	space   pause or resume
+//	o       toggle the on-screen display
+//	c       cycle through the 8, 16, 256, truecolor and gray palettes
+//	d       toggle dithering
+//	r       toggle between background and foreground rendering
+//	q       stop playback
+type playbackControls struct {
+	player *Player
+	live   *liveOptions // may be nil
+	in     *os.File
+	opened bool // in was opened by openPlaybackControls and is closed by Stop
+
+	startOnce sync.Once
+	state     *terminal.State
+	running   atomic.Bool
+	reports   chan time.Time // the times status requests were answered
+
+	osd   atomic.Bool
+	shown bool // the display was drawn with the last frame
+}
+
+func newPlaybackControls(player *Player, in *os.File) *playbackControls {
+	return &playbackControls{player: player, in: in, reports: make(chan time.Time, 1)}
+}
+
+// openPlaybackControls returns controls reading keys from stdin, or from the
+// controlling terminal when stdin is not a terminal or carries image data,
+// as in curl ... | img2ansi -animate.  An error is returned if there is no
+// terminal to read keys from.
+func openPlaybackControls(player *Player, stdinData bool) (*playbackControls, error) {
+	if !stdinData && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return newPlaybackControls(player, os.Stdin), nil
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, err
+	}
+	if !terminal.IsTerminal(int(tty.Fd())) {
+		tty.Close()
+		return nil, fmt.Errorf("/dev/tty is not a terminal")
+	}
+	c := newPlaybackControls(player, tty)
+	c.opened = true
+	return c, nil
+}
+
+// Start puts the terminal in raw mode and begins handling keys.  Start is
+// called after the first frame is drawn so that reading keys does not
+// consume the responses to terminal queries made while drawing it.  Calls
+// after the first have no effect.
+func (c *playbackControls) Start(ctx context.Context) {
+	c.startOnce.Do(func() {
+		state, err := terminal.MakeRaw(int(c.in.Fd()))
+		if err != nil {
+			return
+		}
+		c.state = state
+		c.running.Store(true)
+		go c.run(ctx)
+	})
+}
+
+// StatusReports returns a channel receiving the time of each answer to a
+// status request, or nil if keys are not being read, when the answers would
+// be echoed rather than read.
+func (c *playbackControls) StatusReports() <-chan time.Time {
+	if !c.running.Load() {
+		return nil
+	}
+	return c.reports
+}
+
+// Stop restores the terminal.
+func (c *playbackControls) Stop() {
+	if c.state != nil {
+		terminal.Restore(int(c.in.Fd()), c.state)
+	}
+	if c.opened {
+		c.in.Close()
+	}
+}
+
+func (c *playbackControls) run(ctx context.Context) {
+	events := readInputEvents(ctx, c.in)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			switch ev.Key {
+			case "status":
+				select {
+				case c.reports <- time.Now():
+				default:
+				}
+			case " ":
+				if c.player.Paused() {
+					c.player.Resume()
+				} else {
+					c.player.Pause()
+				}
+			case "o":
+				c.osd.Store(!c.osd.Load())
+			case "c":
+				if c.live != nil {
+					c.live.CyclePalette()
+				}
+			case "d":
+				if c.live != nil {
+					c.live.SetDither(!c.live.Dither())
+				}
+			case "r":
+				if c.live != nil {
+					mode := RenderForeground
+					if c.live.Render() == RenderForeground {
+						mode = RenderBackground
+					}
+					c.live.SetRender(mode)
+				}
+			case "q", "esc", "\x03":
+				c.player.Stop()
+				return
+			}
+		}
+	}
+}
+
+// OSD returns the sequence which draws the on-screen display on the lines
+// starting at the cursor, which must be at the beginning of the line below a
+// frame that is cols columns wide.  The cursor is left where it began.
+func (c *playbackControls) OSD(cols int) string {
+	if !c.osd.Load() {
+		if !c.shown {
+			return ""
+		}
+		c.shown = false
+		return "\033[J"
+	}
+	c.shown = true
+
+	frame, total, loaded := c.player.Position()
+	elapsed, length := c.player.Elapsed()
+	if cols < 10 {
+		cols = 10
+	}
+	filled := 0
+	if length > 0 {
+		filled = int(int64(cols) * int64(elapsed) / int64(length))
+	}
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", cols-filled)
+
+	count := fmt.Sprint(total)
+	remaining := "-" + formatOSDTime(length-elapsed)
+	if !loaded {
+		count += "+"
+		remaining = "--:--.-"
+	}
+	status := fmt.Sprintf("frame %d/%s  %s %s", frame+1, count, formatOSDTime(elapsed), remaining)
+	if c.player.Paused() {
+		status += "  paused"
+	}
+	return ANSIClear + "\033[K" + bar + "\n\033[K" + status + "\r\033[1A"
+}
+
+func formatOSDTime(d time.Duration) string {
+	d = d.Round(100 * time.Millisecond)
+	return fmt.Sprintf("%02d:%04.1f", int(d.Minutes()), (d % time.Minute).Seconds())
+}
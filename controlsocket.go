@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// controlServer accepts commands for a running animation over a unix socket,
+// one per line, so that scripts and status bars can drive img2ansi.  Each
+// command is answered with a line, "ok", "error: " and a message, or for
+// status a JSON object.
+//
+//	pause            pause playback
+//	resume           resume playback
+//	toggle           pause or resume playback
+//	next             play one frame while paused
+//	seek N           play frame N next, counting from 1
+//	speed X          scale the rate of playback by X
+//	load URL         play the image at URL, a path or http(s) URL, instead
+//	palette NAME     draw frames with the named color palette
+//	dither on|off    dither frames or not
+//	render MODE      draw cells in the render mode, background or foreground
+//	status           report the frame, source and drawing settings
+//	quit             stop playback
+type controlServer struct {
+	l       net.Listener
+	player  *Player
+	prepare func(ctx context.Context, frames <-chan *Frame) <-chan *Frame
+	fopts   *FrameOptions
+
+	loadMu     sync.Mutex // held while loading an image
+	mu         sync.Mutex
+	source     string
+	cancelLoad context.CancelFunc // stops the stages preparing the source
+}
+
+// listenControl listens for commands on the unix socket at path.  Frames of
+// images loaded with the load command are passed through prepare, the
+// stages which come before player in the pipeline.  The source stages of
+// the first image are stopped with cancel when another is loaded.  Drawing
+// settings are changed through fopts.live.
+func listenControl(path string, player *Player, prepare func(ctx context.Context, frames <-chan *Frame) <-chan *Frame, source string, cancel context.CancelFunc, fopts *FrameOptions) (*controlServer, error) {
+	// the socket of an instance which did not exit cleanly is left behind.
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("control socket %s is in use", path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &controlServer{
+		l:          l,
+		player:     player,
+		prepare:    prepare,
+		fopts:      fopts,
+		source:     source,
+		cancelLoad: cancel,
+	}, nil
+}
+
+// Serve accepts connections until ctx is done or Close is called.
+func (c *controlServer) Serve(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() { c.l.Close() })
+	defer stop()
+	for {
+		conn, err := c.l.Accept()
+		if err != nil {
+			return
+		}
+		go c.serveConn(ctx, conn)
+	}
+}
+
+// Close stops accepting commands and removes the socket.
+func (c *controlServer) Close() error {
+	return c.l.Close()
+}
+
+func (c *controlServer) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	r := bufio.NewScanner(conn)
+	for r.Scan() {
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(r.Text()), " ")
+		if cmd == "" {
+			continue
+		}
+		reply, err := c.run(ctx, cmd, strings.TrimSpace(arg))
+		if err != nil {
+			reply = "error: " + err.Error()
+		}
+		logger(logRender).Debug("control command", "cmd", cmd, "arg", arg, "reply", reply)
+		_, err = fmt.Fprintln(conn, reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// run executes a command and returns the reply.
+func (c *controlServer) run(ctx context.Context, cmd, arg string) (string, error) {
+	switch cmd {
+	case "pause":
+		c.player.Pause()
+	case "resume":
+		c.player.Resume()
+	case "toggle":
+		if c.player.Paused() {
+			c.player.Resume()
+		} else {
+			c.player.Pause()
+		}
+	case "next":
+		c.player.Next()
+	case "seek":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("seek requires a frame number, counting from 1")
+		}
+		c.player.Seek(n - 1)
+		// a paused player draws the frame sought.
+		c.player.Next()
+	case "speed":
+		x, err := strconv.ParseFloat(arg, 64)
+		if err != nil || x <= 0 {
+			return "", fmt.Errorf("speed requires a positive number")
+		}
+		c.player.SetSpeed(x)
+	case "load":
+		if arg == "" {
+			return "", fmt.Errorf("load requires a path or URL")
+		}
+		return "ok", c.load(ctx, arg)
+	case "palette":
+		return "ok", c.fopts.live.SetPalette(arg)
+	case "dither":
+		switch arg {
+		case "on", "off":
+			c.fopts.live.SetDither(arg == "on")
+		default:
+			return "", fmt.Errorf("dither requires on or off")
+		}
+	case "render":
+		return "ok", c.fopts.live.SetRender(arg)
+	case "status":
+		frame, total, loaded := c.player.Position()
+		live := c.fopts.live
+		c.mu.Lock()
+		status := struct {
+			Frame   int    `json:"frame"`
+			Frames  int    `json:"frames"`
+			Loaded  bool   `json:"loaded"`
+			Paused  bool   `json:"paused"`
+			Source  string `json:"source"`
+			Palette string `json:"palette"`
+			Dither  bool   `json:"dither"`
+			Render  string `json:"render"`
+		}{frame + 1, total, loaded, c.player.Paused(), c.source, live.PaletteName(), live.Dither(), live.Render()}
+		c.mu.Unlock()
+		b, err := json.Marshal(status)
+		return string(b), err
+	case "quit":
+		c.player.Stop()
+	default:
+		return "", fmt.Errorf("unknown command %q", cmd)
+	}
+	return "ok", nil
+}
+
+// load decodes the image at urlstr and plays it in place of the current
+// source.
+func (c *controlServer) load(ctx context.Context, urlstr string) error {
+	c.loadMu.Lock()
+	defer c.loadMu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	frames, err := decodeFramesURL(ctx, urlstr, c.fopts)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("decoding image %s: %w", urlstr, err)
+	}
+	frames = monitorFrames(ctx, c.fopts.monitor, "decode", frames)
+	if !c.player.Load(c.prepare(ctx, frames)) {
+		cancel()
+		return fmt.Errorf("playback has ended")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelLoad != nil {
+		c.cancelLoad()
+	}
+	c.cancelLoad, c.source = cancel, urlstr
+	c.player.Redraw()
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestControlSeek checks that seeking while paused draws the frame sought.
+func TestControlSeek(t *testing.T) {
+	done := checkLeaks(t)
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames := make(chan *Frame, 3)
+	for i := 1; i <= 3; i++ {
+		frames <- &Frame{Image: testImage(2, 2), Index: i}
+	}
+	close(frames)
+	player := NewPlayer(&FrameOptions{Animate: true, Repeat: -1})
+	c := &controlServer{player: player}
+	if _, err := c.run(ctx, "pause", ""); err != nil {
+		t.Fatal(err)
+	}
+	out := player.Play(ctx, frames)
+	// frames not yet received cannot be sought.
+	for _, _, loaded := player.Position(); !loaded; _, _, loaded = player.Position() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.run(ctx, "seek", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if f := <-out; f.Index != 2 {
+		t.Errorf("frame %d drawn after seek 2", f.Index)
+	}
+	if frame, _, _ := player.Position(); frame+1 != 2 {
+		t.Errorf("status reports frame %d after seek 2", frame+1)
+	}
+	cancel()
+	for range out {
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bmatsuo/img2ansi/gif"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Output formats of the convert subcommand.
+const (
+	ConvertGIF  = "gif"
+	ConvertWebP = "webp"
+)
+
+// convertMain implements the convert subcommand, which saves images as they
+// appear when drawn in a terminal, for sharing where terminal output cannot
+// be pasted.  Each cell is rasterized as solid blocks of the palette colors
+// the terminal would show, which is exactly how cells drawn with background
+// colors, full blocks or half blocks look.  No font is bundled, so renders
+// drawing other glyphs are rejected rather than drawn wrong.
+func convertMain(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", ConvertGIF, "output format (gif)")
+	output := fs.String("o", "", "path of the output file (default standard output)")
+	width := fs.Int("width", 0, "width of the image in terminal columns")
+	height := fs.Int("height", 0, "height of the image in terminal lines")
+	paletteName := fs.String("color", "256", "color palette (8, 16, 256, gray, ...)")
+	cell := fs.String("cell", "8x16", "size of a terminal cell in pixels")
+	blocks := fs.String("blocks", BlocksFull, "draw one pixel in each cell, or two pixels with half blocks (full, half)")
+	background := fs.String("background", "#000000", "color of the terminal background, shown through transparent cells")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: img2ansi convert [flags] image ...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	switch *to {
+	case ConvertGIF:
+	case ConvertWebP:
+		return fmt.Errorf("convert: webp output is not supported, no webp encoder is available")
+	default:
+		return fmt.Errorf("convert: format not one of %q", []string{ConvertGIF})
+	}
+	rows := 1
+	switch *blocks {
+	case BlocksFull:
+	case BlocksHalf:
+		rows = 2
+	default:
+		return fmt.Errorf("convert: block mode not one of %q, other glyphs cannot be drawn without a font", []string{BlocksFull, BlocksHalf})
+	}
+	palette := ansiPalettes[*paletteName]
+	if palette == nil {
+		return fmt.Errorf("convert: color palette not one of %q", ANSIPalettes())
+	}
+	var cellSize image.Point
+	_, err := fmt.Sscanf(*cell, "%dx%d", &cellSize.X, &cellSize.Y)
+	if err != nil || cellSize.X < 1 || cellSize.Y < 1 {
+		return fmt.Errorf("convert: invalid cell size %q", *cell)
+	}
+	bg, err := parseHexColor(*background)
+	if err != nil {
+		return fmt.Errorf("convert: %w", err)
+	}
+
+	if *output == "" && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("convert: refusing to write an image to a terminal, use -o")
+	}
+
+	ctx := context.Background()
+	fopts := &FrameOptions{Animate: true, GIFShortDelay: GIFShortDelayDefault}
+	frames, err := decodeFramesArgs(ctx, false, fs.Args(), fopts)
+	if err != nil {
+		return err
+	}
+	if *width == 0 && *height == 0 {
+		*width = 80
+	}
+	fontAspect := float64(cellSize.X) / float64(cellSize.Y)
+	if rows == 2 {
+		*height *= 2
+		fontAspect *= 2
+	}
+	frames = ResizeFrames(ctx, *width, *height, fontAspect, frames)
+
+	g := &gif.GIF{}
+	for f := range frames {
+		g.Image = append(g.Image, rasterizeCells(f.Image, palette, cellSize, rows, bg))
+		g.Delay = append(g.Delay, gifDelay(f.Delay))
+	}
+	if len(g.Image) == 1 {
+		g.Delay[0] = 0
+	}
+	if *output == "" {
+		return gif.EncodeAll(os.Stdout, g)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = gif.EncodeAll(f, g)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rasterizeCells draws each pixel of img, a frame with rows pixels stacked in
+// each terminal cell, as a block of pixels in the color p displays it with.
+// With two rows the blocks are the halves of each cell split as half block
+// glyphs are.  Transparent pixels, and the lower half of a last line left
+// incomplete, show bg.
+func rasterizeCells(img image.Image, p ANSIPalette, cell image.Point, rows int, bg color.Color) *image.Paletted {
+	rect := img.Bounds()
+	lines := (rect.Dy() + rows - 1) / rows
+	colors := make([]color.Color, 0, rect.Dx()*lines*rows)
+	index := make(map[color.Color]uint8)
+	var pal color.Palette
+	for y := rect.Min.Y; y < rect.Min.Y+lines*rows; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			var c color.Color
+			if y < rect.Max.Y {
+				c = p.Convert(img.At(x, y))
+			}
+			if c == nil {
+				c = bg
+			}
+			c = color.RGBAModel.Convert(c)
+			colors = append(colors, c)
+			if _, ok := index[c]; !ok && len(pal) < 256 {
+				index[c] = uint8(len(pal))
+				pal = append(pal, c)
+			}
+		}
+	}
+	if len(pal) == 0 {
+		pal = color.Palette{bg}
+	}
+
+	out := image.NewPaletted(image.Rect(0, 0, rect.Dx()*cell.X, lines*cell.Y), pal)
+	for i, c := range colors {
+		x, y := i%rect.Dx(), i/rect.Dx()
+		ci, ok := index[c]
+		if !ok {
+			// palettes have at most 256 colors but the background may
+			// be one more.
+			ci = uint8(pal.Index(c))
+		}
+		top := (y/rows)*cell.Y + (y%rows)*cell.Y/rows
+		bottom := (y/rows)*cell.Y + (y%rows+1)*cell.Y/rows
+		for py := top; py < bottom; py++ {
+			row := out.Pix[py*out.Stride+x*cell.X:][:cell.X]
+			for j := range row {
+				row[j] = ci
+			}
+		}
+	}
+	return out
+}
+
+// gifDelay returns d in the hundredths of a second of GIF frame delays.
+// Delays below 2 are avoided because browsers draw them much more slowly,
+// see GIFShortDelayDefault.
+func gifDelay(d time.Duration) int {
+	if d == 0 {
+		d = DelayDefault
+	}
+	return max(2, int((d+5*time.Millisecond)/(10*time.Millisecond)))
+}
+
+// parseHexColor parses an opaque color written as #rrggbb.
+func parseHexColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
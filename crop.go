@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// CropFrames crops frames to the aspect ratio of width by height cells so that
+// when they are resized they cover that area instead of fitting inside it.
+// The crop is centered unless smart is true, in which case it is moved along
+// the cropped axis to the most salient region, as measured by
+// salientOffset.  The crop of each input is chosen from its first frame and
+// reused for the rest so that animations do not jump around.
+func CropFrames(ctx context.Context, width, height int, fontAspect float64, smart bool, frames <-chan *Frame) <-chan *Frame {
+	if width <= 0 || height <= 0 {
+		return frames
+	}
+	// the aspect ratio of the crop in image pixels.  cells are fontAspect
+	// times as wide as they are tall.
+	aspect := float64(width) * fontAspect / float64(height)
+	crops := make(map[int]image.Rectangle)
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		rect := f.Image.Bounds()
+		crop, ok := crops[f.Source]
+		if !ok || !crop.In(rect) {
+			crop = coverRect(f.Image, aspect, smart)
+			crops[f.Source] = crop
+		}
+		g := *f
+		g.Image = cropImage(f.Image, crop)
+		g.Changed = image.Rectangle{}
+		return &g, nil
+	})
+}
+
+// coverRect returns the largest rectangle within the bounds of img having
+// the given aspect ratio.
+func coverRect(img image.Image, aspect float64, smart bool) image.Rectangle {
+	rect := img.Bounds()
+	size := rect.Size()
+	if size.X == 0 || size.Y == 0 {
+		return rect
+	}
+	if float64(size.X)/float64(size.Y) > aspect {
+		w := max(1, int(round(float64(size.Y)*aspect)))
+		x0 := (size.X - w) / 2
+		if smart {
+			x0 = salientOffset(columnEnergy(img), w)
+		}
+		return image.Rect(x0, 0, x0+w, size.Y).Add(rect.Min)
+	}
+	h := max(1, int(round(float64(size.X)/aspect)))
+	y0 := (size.Y - h) / 2
+	if smart {
+		y0 = salientOffset(rowEnergy(img), h)
+	}
+	return image.Rect(0, y0, size.X, y0+h).Add(rect.Min)
+}
+
+// salientOffset returns the start of the window of n consecutive values of
+// energy with the greatest sum.  Ties, including images without detail,
+// favor the window nearest the center.
+func salientOffset(energy []float64, n int) int {
+	center := (len(energy) - n) / 2
+	var sum float64
+	for _, e := range energy[:n] {
+		sum += e
+	}
+	best, bestSum := 0, sum
+	for i := 1; i+n <= len(energy); i++ {
+		sum += energy[i+n-1] - energy[i-1]
+		// a small tolerance keeps rounding error from defeating the center
+		// preference.
+		if sum > bestSum+1e-9 || (math.Abs(sum-bestSum) <= 1e-9 && abs(i-center) < abs(best-center)) {
+			best, bestSum = i, sum
+		}
+	}
+	return best
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// columnEnergy returns the edge density of each column of img, the sum of
+// the luminance gradient magnitude of its pixels.  Rows are sampled so that
+// large images are measured quickly.
+func columnEnergy(img image.Image) []float64 {
+	rect := img.Bounds()
+	energy := make([]float64, rect.Dx())
+	step := max(1, rect.Dy()/256)
+	for y := rect.Min.Y; y < rect.Max.Y; y += step {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			energy[x-rect.Min.X] += gradient(img, x, y)
+		}
+	}
+	return energy
+}
+
+// rowEnergy is like columnEnergy but measures rows.
+func rowEnergy(img image.Image) []float64 {
+	rect := img.Bounds()
+	energy := make([]float64, rect.Dy())
+	step := max(1, rect.Dx()/256)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x += step {
+			energy[y-rect.Min.Y] += gradient(img, x, y)
+		}
+	}
+	return energy
+}
+
+// gradient returns the magnitude of the luminance gradient of img at x, y
+// using forward differences.  Transparent pixels have no detail.
+func gradient(img image.Image, x, y int) float64 {
+	rect := img.Bounds()
+	l := func(x, y int) float64 {
+		x = min(x, rect.Max.X-1)
+		y = min(y, rect.Max.Y-1)
+		c := img.At(x, y)
+		if IsTransparent(c, AlphaThreshold) {
+			return 0
+		}
+		return luminance(c)
+	}
+	v := l(x, y)
+	return math.Abs(l(x+1, y)-v) + math.Abs(l(x, y+1)-v)
+}
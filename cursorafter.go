@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Cursor placements after an image has been drawn.
+const (
+	// CursorBelow leaves the cursor at the start of the line below the
+	// image.
+	CursorBelow = "below"
+	// CursorRight moves the cursor beside the top row of the image.
+	CursorRight = "right"
+	// CursorSaveRestore returns the cursor to where the image began using
+	// DECSC and DECRC.
+	CursorSaveRestore = "save-restore"
+)
+
+// cursorSave returns the sequence written before the first frame when
+// opts.CursorAfter is CursorSaveRestore.  Unless reserved is true, rows lines
+// are first scrolled into view so that drawing the image does not move the
+// saved position up the screen.
+func cursorSave(rows int, reserved bool) string {
+	if reserved {
+		return "\0337"
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return fmt.Sprintf("%s\033[%dA\0337", strings.Repeat("\n", rows), rows)
+}
+
+// cursorAfter returns the sequence which moves the cursor from below f to
+// the position specified by opts.CursorAfter.
+func cursorAfter(opts *FrameOptions, f *ANSIFrame) string {
+	switch opts.CursorAfter {
+	case CursorRight:
+		if f.Rows == 0 {
+			return ""
+		}
+		return fmt.Sprintf("\033[%dA\033[%dC", f.Rows, f.Cols)
+	case CursorSaveRestore:
+		return "\0338"
+	}
+	return ""
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DaemonTimeout bounds how long -preview-mode waits for the daemon before
+// drawing the preview itself.
+const DaemonTimeout = 2 * time.Second
+
+// daemonSocketPath returns the default path of the daemon's unix socket.
+func daemonSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("img2ansi-%d.sock", os.Getuid()))
+}
+
+// daemonRequest asks the daemon to draw the first frame of the image at Path
+// to fit within Width by Height cells.
+type daemonRequest struct {
+	Path       string  `json:"path"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Color      string  `json:"color"`
+	FontAspect float64 `json:"fontaspect"`
+}
+
+// daemonResponse precedes the output the daemon draws, which follows it
+// until the connection is closed.  If Error is not empty nothing follows.
+type daemonResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// daemonMain implements the daemon subcommand, which keeps decoded images
+// and their renderings in memory and draws previews requested over a unix
+// socket, so that a file manager previewing images with -preview-mode does
+// not decode an image again each time the cursor returns to it.
+func daemonMain(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	socket := fs.String("socket", daemonSocketPath(), "path of the unix socket to listen on")
+	size := fs.Int("cache", 128, "number of decoded images to keep in memory")
+	mem := fs.Int("cache-mem", 512, "megabytes of decoded images and their renderings to keep in memory")
+	verbose := fs.Bool("v", false, "log each request")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: img2ansi daemon [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *size < 1 || *mem < 1 {
+		return fmt.Errorf("daemon: cache size must be positive")
+	}
+	if *verbose {
+		LogLevels.lower(slog.LevelDebug)
+	}
+
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("daemon: already running on %s", *socket)
+	}
+	// the socket of a daemon which did not exit cleanly is left behind.
+	os.Remove(*socket)
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		return fmt.Errorf("daemon: %w", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	logger(logRender).Info("daemon listening", "socket", *socket, "cache", *size, "cache_mem", *mem)
+
+	d := &previewDaemon{cache: newImageCache(*size, int64(*mem)<<20)}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("daemon: %w", err)
+		}
+		go d.serve(conn)
+	}
+}
+
+type previewDaemon struct {
+	cache *imageCache
+}
+
+// serve answers the request read from conn.
+func (d *previewDaemon) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	var req daemonRequest
+	err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req)
+	if err == io.EOF {
+		// another daemon checking whether this one is running.
+		return
+	}
+	if err != nil {
+		logger(logRender).Warn("daemon request not read", "err", err)
+		return
+	}
+	start := time.Now()
+	out, cached, err := d.render(&req)
+	var resp daemonResponse
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	b, _ := json.Marshal(resp)
+	w := bufio.NewWriter(conn)
+	w.Write(append(b, '\n'))
+	w.Write(out)
+	err = w.Flush()
+	if err != nil {
+		logger(logRender).Warn("daemon response not written", "path", req.Path, "err", err)
+		return
+	}
+	logger(logRender).Debug("preview", "path", req.Path, "width", req.Width, "height", req.Height,
+		"cached", cached, "time", time.Since(start), "err", resp.Error)
+}
+
+// render draws the preview requested by req, reusing a previous rendering
+// if the image has not changed since it was drawn.
+func (d *previewDaemon) render(req *daemonRequest) (out []byte, cached bool, err error) {
+	p := ansiPalettes[req.Color]
+	if p == nil {
+		return nil, false, fmt.Errorf("color palette not one of %q", ANSIPalettes())
+	}
+	if req.Width < 1 || req.Height < 1 || req.FontAspect <= 0 {
+		return nil, false, fmt.Errorf("invalid preview dimensions")
+	}
+	info, err := os.Stat(req.Path)
+	if err != nil {
+		return nil, false, err
+	}
+	entry := d.cache.Get(req.Path, info)
+	if entry == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		frames, err := decodeFramesFile(ctx, req.Path, &FrameOptions{})
+		if err != nil {
+			cancel()
+			return nil, false, err
+		}
+		f, ok := <-frames
+		cancel()
+		if !ok {
+			return nil, false, fmt.Errorf("no frames")
+		}
+		entry = d.cache.Add(req.Path, info, f.Image)
+	}
+
+	key := renderKey{req.Width, req.Height, req.Color, req.FontAspect}
+	if out, ok := d.cache.Rendering(entry, key); ok {
+		return out, true, nil
+	}
+	out = renderStill(entry.img, req.Width, req.Height, req.FontAspect, p)
+	d.cache.SetRendering(entry, key, out)
+	return out, false, nil
+}
+
+// renderStill draws img to fit within width by height cells, as -preview-mode
+// draws it.
+func renderStill(img image.Image, width, height int, fontAspect float64, p ANSIPalette) []byte {
+	size, area := sizeTarget(img.Bounds().Size(), width, height, fontAspect)
+	if size != img.Bounds().Size() {
+		img = scaleImage(img, size.X, size.Y)
+	}
+	if area != size {
+		img = centerImage(img, area)
+	}
+	var out bytes.Buffer
+	buf := nbuffer(1)[0]
+	writeANSIPixels(buf, img, p, &FrameOptions{})
+	buf.FlushTo(&out)
+	return out.Bytes()
+}
+
+// requestPreview asks the daemon listening on socket to draw req to w.  The
+// preview is written only once all of it has been received, so an error is
+// returned without writing to w if the daemon is not running or fails
+// partway.
+func requestPreview(w io.Writer, socket string, req *daemonRequest) error {
+	conn, err := net.DialTimeout("unix", socket, DaemonTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(DaemonTimeout))
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	var resp daemonResponse
+	err = json.Unmarshal(line, &resp)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("daemon: %s", resp.Error)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// renderKey identifies a rendering of a cached image.
+type renderKey struct {
+	Width, Height int
+	Color         string
+	FontAspect    float64
+}
+
+// imageEntry is a decoded image and its renderings.
+type imageEntry struct {
+	path    string
+	modTime time.Time
+	size    int64
+	img     image.Image
+
+	// renderings and bytes are guarded by the imageCache holding the entry.
+	// bytes is the memory held by img and renderings.
+	renderings map[renderKey][]byte
+	bytes      int64
+}
+
+// imageBytes returns the number of bytes of pixel data held by img.
+func imageBytes(img image.Image) int64 {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return int64(len(img.Pix))
+	case *image.NRGBA:
+		return int64(len(img.Pix))
+	case *image.Paletted:
+		return int64(len(img.Pix))
+	case *image.Gray:
+		return int64(len(img.Pix))
+	case *image.YCbCr:
+		return int64(len(img.Y) + len(img.Cb) + len(img.Cr))
+	}
+	size := img.Bounds().Size()
+	return int64(size.X) * int64(size.Y) * 4
+}
+
+// imageCache holds the most recently used images, evicting the least
+// recently used when it holds more than max images or maxBytes of images and
+// renderings.  Entries are replaced when the file they were decoded from
+// changes.
+type imageCache struct {
+	mut      sync.Mutex
+	max      int
+	maxBytes int64
+	bytes    int64
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+func newImageCache(max int, maxBytes int64) *imageCache {
+	return &imageCache{
+		max:      max,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entry for path, or nil if it is not cached or the file
+// described by info has changed since it was.
+func (c *imageCache) Get(path string, info os.FileInfo) *imageEntry {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	elem, ok := c.entries[path]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*imageEntry)
+	if !e.modTime.Equal(info.ModTime()) || e.size != info.Size() {
+		c.remove(elem)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return e
+}
+
+// Add caches img, decoded from the file at path described by info.
+func (c *imageCache) Add(path string, info os.FileInfo, img image.Image) *imageEntry {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		c.remove(elem)
+	}
+	e := &imageEntry{
+		path:       path,
+		modTime:    info.ModTime(),
+		size:       info.Size(),
+		img:        img,
+		renderings: make(map[renderKey][]byte),
+		bytes:      imageBytes(img),
+	}
+	c.entries[path] = c.order.PushFront(e)
+	c.bytes += e.bytes
+	c.evict()
+	return e
+}
+
+// Rendering returns the rendering of e identified by key.
+func (c *imageCache) Rendering(e *imageEntry, key renderKey) ([]byte, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	out, ok := e.renderings[key]
+	return out, ok
+}
+
+// SetRendering saves out as the rendering of e identified by key, unless e
+// has been evicted.
+func (c *imageCache) SetRendering(e *imageEntry, key renderKey, out []byte) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if elem, ok := c.entries[e.path]; !ok || elem.Value != e {
+		return
+	}
+	if prev, ok := e.renderings[key]; ok {
+		e.bytes -= int64(len(prev))
+		c.bytes -= int64(len(prev))
+	}
+	e.renderings[key] = out
+	e.bytes += int64(len(out))
+	c.bytes += int64(len(out))
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache is within
+// its bounds.  The renderings of the most recently used entry are dropped
+// if it alone is too large, though its image is kept.
+func (c *imageCache) evict() {
+	for c.order.Len() > c.max || c.bytes > c.maxBytes && c.order.Len() > 1 {
+		c.remove(c.order.Back())
+	}
+	if c.bytes > c.maxBytes && c.order.Len() == 1 {
+		e := c.order.Front().Value.(*imageEntry)
+		for key, out := range e.renderings {
+			e.bytes -= int64(len(out))
+			c.bytes -= int64(len(out))
+			delete(e.renderings, key)
+		}
+	}
+}
+
+func (c *imageCache) remove(elem *list.Element) {
+	e := elem.Value.(*imageEntry)
+	c.order.Remove(elem)
+	delete(c.entries, e.path)
+	c.bytes -= e.bytes
+}
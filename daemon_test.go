@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImageCache(t *testing.T) {
+	dir := t.TempDir()
+	info := make(map[string]os.FileInfo)
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info[name], err = os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	c := newImageCache(2, 1<<20)
+	c.Add("a", info["a"], img)
+	c.Add("b", info["b"], img)
+	if c.Get("a", info["a"]) == nil {
+		t.Fatal("a not cached")
+	}
+	// b is the least recently used.
+	c.Add("c", info["c"], img)
+	if c.Get("b", info["b"]) != nil {
+		t.Error("b not evicted")
+	}
+	if c.Get("a", info["a"]) == nil || c.Get("c", info["c"]) == nil {
+		t.Error("recently used images evicted")
+	}
+
+	// a changes.
+	path := filepath.Join(dir, "a")
+	later := info["a"].ModTime().Add(time.Second)
+	err := os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Get("a", changed) != nil {
+		t.Error("changed image returned from cache")
+	}
+	if c.Get("a", info["a"]) != nil {
+		t.Error("changed image not removed from cache")
+	}
+}
+
+func TestImageCacheBytes(t *testing.T) {
+	dir := t.TempDir()
+	info := make(map[string]os.FileInfo)
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info[name], err = os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each image holds 400 bytes of pixels.
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	c := newImageCache(10, 1000)
+	a := c.Add("a", info["a"], img)
+	c.Add("b", info["b"], img)
+	c.SetRendering(a, renderKey{Width: 1}, make([]byte, 100))
+	if c.Get("a", info["a"]) == nil || c.Get("b", info["b"]) == nil {
+		t.Fatal("images evicted within the byte limit")
+	}
+	// a is the least recently used.
+	b := c.Get("b", info["b"])
+	c.SetRendering(b, renderKey{Width: 1}, make([]byte, 300))
+	if c.Get("a", info["a"]) != nil {
+		t.Error("a not evicted over the byte limit")
+	}
+	if _, ok := c.Rendering(b, renderKey{Width: 1}); !ok {
+		t.Error("rendering of b evicted")
+	}
+
+	// b alone is over the limit with its renderings.
+	c.SetRendering(b, renderKey{Width: 2}, make([]byte, 400))
+	if c.Get("b", info["b"]) == nil {
+		t.Fatal("only image evicted")
+	}
+	if _, ok := c.Rendering(b, renderKey{Width: 2}); ok {
+		t.Error("renderings over the byte limit kept")
+	}
+	if c.bytes != 400 {
+		t.Errorf("cache holds %d bytes, want 400", c.bytes)
+	}
+}
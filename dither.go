@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// DitherBlueNoise is the -dither value which adds a blue-noise threshold mask
+// to frames before they are quantized.
+const DitherBlueNoise = "bluenoise"
+
+// DitherFloydSteinberg is the -dither value which diffuses the error of
+// quantizing each cell to its neighbors.
+const DitherFloydSteinberg = "floyd-steinberg"
+
+// blueNoiseSize is the width and height of the tiled blue-noise mask.
+const blueNoiseSize = 64
+
+// BlueNoise is an ordered dither mask with a blue-noise distribution of
+// thresholds.  Unlike Bayer matrices it has no visible cross-hatch pattern,
+// and unlike error diffusion each cell's threshold depends only on its
+// position so dithered animations do not shimmer between frames.
+type BlueNoise struct {
+	size      int
+	threshold []float64
+}
+
+// NewBlueNoise generates a mask using the void-and-cluster method.  The mask
+// is determined entirely by seed.
+func NewBlueNoise(seed int64) *BlueNoise {
+	const size = blueNoiseSize
+	const n = size * size
+	const sigma = 1.5
+
+	// kernel[dy*size+dx] is the gaussian energy contributed by a point at a
+	// toroidal offset of (dx, dy).
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			x, y := min(dx, size-dx), min(dy, size-dy)
+			kernel[dy*size+dx] = math.Exp(-float64(x*x+y*y) / (2 * sigma * sigma))
+		}
+	}
+	on := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(i int) {
+		sign := 1.0
+		if on[i] {
+			sign = -1
+		}
+		on[i] = !on[i]
+		ix, iy := i%size, i/size
+		for j := range energy {
+			dx := (j%size - ix + size) % size
+			dy := (j/size - iy + size) % size
+			energy[j] += sign * kernel[dy*size+dx]
+		}
+	}
+	// tightestCluster returns the set point with the most energy and
+	// largestVoid the unset point with the least.
+	tightestCluster := func() int {
+		best := -1
+		for i := range energy {
+			if on[i] && (best < 0 || energy[i] > energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for i := range energy {
+			if !on[i] && (best < 0 || energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// start with random points and move them until they are evenly spread.
+	r := rand.New(rand.NewSource(seed))
+	ones := n / 10
+	for _, i := range r.Perm(n)[:ones] {
+		toggle(i)
+	}
+	for {
+		cluster := tightestCluster()
+		toggle(cluster)
+		void := largestVoid()
+		toggle(void)
+		if void == cluster {
+			break
+		}
+	}
+	initial := append([]bool(nil), on...)
+	initialEnergy := append([]float64(nil), energy...)
+
+	rank := make([]int, n)
+	for k := ones - 1; k >= 0; k-- {
+		i := tightestCluster()
+		toggle(i)
+		rank[i] = k
+	}
+	copy(on, initial)
+	copy(energy, initialEnergy)
+	for k := ones; k < n; k++ {
+		i := largestVoid()
+		toggle(i)
+		rank[i] = k
+	}
+
+	b := &BlueNoise{size: size, threshold: make([]float64, n)}
+	for i, k := range rank {
+		b.threshold[i] = (float64(k) + 0.5) / n
+	}
+	return b
+}
+
+// Threshold returns the mask value at x, y, in the range (0, 1).  The mask is
+// tiled over the plane.
+func (b *BlueNoise) Threshold(x, y int) float64 {
+	x = (x%b.size + b.size) % b.size
+	y = (y%b.size + b.size) % b.size
+	return b.threshold[y*b.size+x]
+}
+
+// ditherSpread returns the distance between neighboring colors of p as a
+// fraction of the full channel range, which is the amplitude of noise needed
+// for dithering to reach every color.  Zero means p is not dithered.
+func ditherSpread(p ANSIPalette) float64 {
+	switch p.(type) {
+	case *Palette8, *Palette16:
+		return 0.5
+	case *Palette256, *Palette256Precise:
+		return 0.2
+	case *PaletteGray:
+		return 1.0 / 24
+	}
+	return 0
+}
+
+// DitherFrames adds the thresholds of mask, scaled to spread, to the color
+// channels of each cell of frames.  The mask is aligned with the top left of
+// each frame so that static regions of an animation are dithered identically
+// in every frame.
+func DitherFrames(ctx context.Context, mask *BlueNoise, spread float64, frames <-chan *Frame) <-chan *Frame {
+	if mask == nil || spread <= 0 {
+		return frames
+	}
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		g := *f
+		g.Image = ditherImage(f.Image, mask, spread)
+		return &g, nil
+	})
+}
+
+func ditherImage(src image.Image, mask *BlueNoise, spread float64) image.Image {
+	rect := src.Bounds()
+	img := image.NewRGBA64(rect)
+	channel := func(v uint32, offset float64) uint16 {
+		return uint16(math.Max(0, math.Min(0xffff, float64(v)+offset)))
+	}
+	row := func(y int) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := src.At(x, y)
+			if IsTransparent(c, AlphaThreshold) {
+				img.Set(x, y, c)
+				continue
+			}
+			// colors are offset by up to half the spread in either
+			// direction.
+			offset := (mask.Threshold(x-rect.Min.X, y-rect.Min.Y) - 0.5) * spread * 0xffff
+			r, g, b, a := c.RGBA()
+			img.SetRGBA64(x, y, color.RGBA64{
+				R: min(channel(r, offset), uint16(a)),
+				G: min(channel(g, offset), uint16(a)),
+				B: min(channel(b, offset), uint16(a)),
+				A: uint16(a),
+			})
+		}
+	}
+	if rect.Dx()*rect.Dy() < parallelMinCells {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			row(y)
+		}
+		return img
+	}
+	parallelRows(rect.Dy(), func(y int) { row(rect.Min.Y + y) })
+	return img
+}
+
+// DiffuseFrames quantizes frames to p with Floyd–Steinberg error diffusion.
+// Error diffusion reproduces gradients and photos more faithfully than a
+// mask, but a change to any cell can change every cell after it, so the
+// frames of an animation may shimmer and are always redrawn in full.
+func DiffuseFrames(ctx context.Context, p ANSIPalette, frames <-chan *Frame) <-chan *Frame {
+	if ditherSpread(p) <= 0 {
+		return frames
+	}
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		g := *f
+		g.Image = diffuseImage(f.Image, p)
+		g.Changed = image.Rectangle{}
+		return &g, nil
+	})
+}
+
+// diffuseImage returns src quantized to p with Floyd–Steinberg error
+// diffusion.  The error of each cell is its difference from the color p
+// displays for it, and 7/16 of it is carried to the next cell in the row,
+// 3/16, 5/16 and 1/16 to the cells below.  Rows are scanned in alternating
+// directions, which avoids the diagonal streaks of scanning each row left to
+// right.  Transparent cells are neither quantized nor given error.
+func diffuseImage(src image.Image, p ANSIPalette) image.Image {
+	rect := src.Bounds()
+	w := rect.Dx()
+	img := image.NewRGBA64(rect)
+	// the error carried to the current row and to the row below it, in
+	// channel units, with a cell of margin on either side.
+	cur := make([][3]float64, w+2)
+	next := make([][3]float64, w+2)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		dir := 1
+		x0, x1 := 0, w
+		if (y-rect.Min.Y)%2 == 1 {
+			dir, x0, x1 = -1, w-1, -1
+		}
+		for i := x0; i != x1; i += dir {
+			x := rect.Min.X + i
+			c := src.At(x, y)
+			if IsTransparent(c, AlphaThreshold) {
+				img.Set(x, y, c)
+				continue
+			}
+			r, g, b, _ := opaque(c).RGBA()
+			e := cur[i+1]
+			want := color.RGBA64{
+				R: clampChannel(float64(r) + e[0]),
+				G: clampChannel(float64(g) + e[1]),
+				B: clampChannel(float64(b) + e[2]),
+				A: 0xffff,
+			}
+			got := p.Convert(want)
+			if got == nil {
+				img.Set(x, y, c)
+				continue
+			}
+			// palette colors do not all set their alpha.
+			gr, gg, gb, _ := got.RGBA()
+			img.SetRGBA64(x, y, color.RGBA64{uint16(gr), uint16(gg), uint16(gb), 0xffff})
+			diff := [3]float64{
+				float64(want.R) - float64(gr),
+				float64(want.G) - float64(gg),
+				float64(want.B) - float64(gb),
+			}
+			for k, d := range diff {
+				cur[i+1+dir][k] += d * 7 / 16
+				next[i+1-dir][k] += d * 3 / 16
+				next[i+1][k] += d * 5 / 16
+				next[i+1+dir][k] += d * 1 / 16
+			}
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+	return img
+}
+
+// clampChannel returns v limited to the range of a color channel.
+func clampChannel(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(0xffff, v)))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestDiffuseImage checks that diffusing error quantizes every cell to the
+// palette while keeping the average color of a region close to the source,
+// which quantizing each cell to its nearest color does not.
+func TestDiffuseImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	gray := color.RGBA{0x60, 0x60, 0x60, 0xff}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			src.Set(x, y, gray)
+		}
+	}
+	src.Set(5, 5, color.Transparent)
+
+	p := DefaultPalette8
+	img := diffuseImage(src, p)
+	var sum uint32
+	n := 0
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c := img.At(x, y)
+			if x == 5 && y == 5 {
+				if !IsTransparent(c, AlphaThreshold) {
+					t.Errorf("transparent cell drawn as %v", c)
+				}
+				continue
+			}
+			r, g, b, _ := c.RGBA()
+			pr, pg, pb, _ := p.Convert(c).RGBA()
+			if r != pr || g != pg || b != pb {
+				t.Fatalf("cell %d,%d is %v, not a palette color", x, y, c)
+			}
+			sum += r >> 8
+			n++
+		}
+	}
+	mean := float64(sum) / float64(n)
+	if mean < 0x60-8 || mean > 0x60+8 {
+		t.Errorf("mean red %.1f (expected about %d)", mean, 0x60)
+	}
+	nearest, _, _, _ := p.Convert(gray).RGBA()
+	if nearest>>8 > 0x60-8 && nearest>>8 < 0x60+8 {
+		t.Fatalf("gray is close to a palette color, the test checks nothing")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// dryRunBauds are the serial line speeds used to estimate draw times.
+var dryRunBauds = []int{9600, 38400, 115200}
+
+// renderStats accumulates statistics about rendered output.
+type renderStats struct {
+	Frames   int
+	Bytes    int64
+	MaxFrame int64
+	Delay    time.Duration
+	SGR      map[string]bool
+
+	frameBytes int64
+}
+
+// Write counts the bytes of p and records the SGR sequences it contains.
+// Sequences are assumed not to span calls to Write.
+func (s *renderStats) Write(p []byte) (int, error) {
+	n := len(p)
+	s.Bytes += int64(n)
+	s.frameBytes += int64(n)
+	for {
+		i := bytes.Index(p, []byte("\033["))
+		if i < 0 {
+			break
+		}
+		p = p[i:]
+		// parameter and intermediate bytes run up to the final byte, which
+		// ends every CSI sequence whether or not it is SGR.
+		j := 2
+		for j < len(p) && p[j] >= 0x20 && p[j] <= 0x3f {
+			j++
+		}
+		if j == len(p) {
+			break
+		}
+		if p[j] == 'm' {
+			s.SGR[string(p[:j+1])] = true
+		}
+		p = p[j+1:]
+	}
+	return n, nil
+}
+
+// dryRunFrames consumes frames without drawing them and writes a summary of
+// the output that would have been drawn to w.
+func dryRunFrames(ctx context.Context, w io.Writer, frames <-chan *ANSIFrame, opts *FrameOptions) error {
+	stats := &renderStats{SGR: make(map[string]bool)}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case f, ok := <-frames:
+			if !ok {
+				return stats.WriteSummary(w, opts)
+			}
+			stats.frameBytes = 0
+			err := f.Buffer.FlushTo(stats)
+			if err != nil {
+				return err
+			}
+			if stats.frameBytes > stats.MaxFrame {
+				stats.MaxFrame = stats.frameBytes
+			}
+			stats.Frames++
+			if opts != nil && opts.Delay > 0 {
+				stats.Delay += time.Duration(opts.Delay) * time.Millisecond
+			} else if f.Delay > 0 {
+				stats.Delay += f.Delay
+			} else if opts != nil && opts.Animate {
+				stats.Delay += DelayDefault
+			}
+		}
+	}
+}
+
+func (s *renderStats) WriteSummary(w io.Writer, opts *FrameOptions) error {
+	fmt.Fprintf(w, "frames:        %d\n", s.Frames)
+	fmt.Fprintf(w, "output bytes:  %d\n", s.Bytes)
+	fmt.Fprintf(w, "max frame:     %d bytes\n", s.MaxFrame)
+	fmt.Fprintf(w, "unique SGR:    %d\n", len(s.SGR))
+	if opts != nil && opts.Animate && s.Frames > 1 {
+		fmt.Fprintf(w, "animation:     %s per loop\n", s.Delay)
+	}
+	for _, baud := range dryRunBauds {
+		// 8N1 framing sends ten bits for every byte.
+		secs := float64(s.Bytes*10) / float64(baud)
+		fmt.Fprintf(w, "draw time:     %s at %d baud", time.Duration(secs*float64(time.Second)).Round(time.Millisecond), baud)
+		if opts != nil && opts.Animate && s.Frames > 1 {
+			fps := float64(baud) / float64(s.MaxFrame*10)
+			fmt.Fprintf(w, " (max %.2f fps)", fps)
+		}
+		_, err := fmt.Fprintln(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestRenderStatsSGR(t *testing.T) {
+	stats := &renderStats{SGR: make(map[string]bool)}
+	stats.Write([]byte("\033[2A\033[48;5;1m \033[0m\n\033[3;4H\033[?25l\033[38;5;2m \033[0m"))
+	var sgr []string
+	for seq := range stats.SGR {
+		sgr = append(sgr, seq)
+	}
+	sort.Strings(sgr)
+	want := []string{"\033[0m", "\033[38;5;2m", "\033[48;5;1m"}
+	if len(sgr) != len(want) {
+		t.Fatalf("SGR sequences %q (expected %q)", sgr, want)
+	}
+	for i := range want {
+		if sgr[i] != want[i] {
+			t.Errorf("SGR sequences %q (expected %q)", sgr, want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// dumpEnv lists the environment variables which affect how output is drawn,
+// and which are saved by -dump-state.  Variables with a trailing "_" are
+// prefixes.  SSH_CONNECTION and SSH_CLIENT are left out because they hold IP
+// addresses, which do not belong in a bug report.
+var dumpEnv = []string{
+	"TERM", "COLORTERM", "TERM_PROGRAM", "TERM_PROGRAM_VERSION", "VTE_VERSION",
+	"TMUX", "STY", "SSH_TTY",
+	"LANG", "LC_", "NO_COLOR", "COLUMNS", "LINES", "COLORFGBG",
+}
+
+// stateDump records the output of img2ansi along with the environment and
+// the results of terminal detection, so that a rendering can be reproduced
+// from a bug report.  It is written by -dump-state as a tar archive holding
+// state.json and output.ansi, the exact bytes written.  Only the last
+// dumpOutputMax bytes of output are kept, so that a looping animation does
+// not grow the dump forever.
+type stateDump struct {
+	path   string
+	output teeWriter
+
+	Time       time.Time         `json:"time"`
+	Args       []string          `json:"args"`
+	Flags      map[string]string `json:"flags"`
+	Env        map[string]string `json:"env"`
+	GoVersion  string            `json:"go_version"`
+	GOOS       string            `json:"goos"`
+	GOARCH     string            `json:"goarch"`
+	StdinTTY   bool              `json:"stdin_tty"`
+	StdoutTTY  bool              `json:"stdout_tty"`
+	TermWidth  int               `json:"term_width,omitempty"`
+	TermHeight int               `json:"term_height,omitempty"`
+
+	// OutputDropped is the number of bytes of output, from its start, left
+	// out of output.ansi.
+	OutputDropped int64 `json:"output_dropped,omitempty"`
+
+	// detection results
+	Palette         string          `json:"palette"`
+	PaletteReasons  []string        `json:"palette_reasons"`
+	Theme           string          `json:"theme"`
+	ThemeReason     string          `json:"theme_reason"`
+	Colors          *terminalColors `json:"colors,omitempty"`
+	TerminalQueries bool            `json:"terminal_queries"`
+	TerminalID      string          `json:"terminal_id,omitempty"`
+	Remote          bool            `json:"remote"`
+	Passthrough     bool            `json:"passthrough"`
+	Sync            bool            `json:"sync"`
+	Strategy        string          `json:"strategy,omitempty"`
+}
+
+func newStateDump(path string) *stateDump {
+	d := &stateDump{
+		path:      path,
+		Time:      time.Now(),
+		Args:      os.Args,
+		Flags:     make(map[string]string),
+		Env:       make(map[string]string),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		StdinTTY:  terminal.IsTerminal(int(os.Stdin.Fd())),
+		StdoutTTY: terminal.IsTerminal(int(os.Stdout.Fd())),
+	}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		for _, name := range dumpEnv {
+			if k == name || strings.HasSuffix(name, "_") && strings.HasPrefix(k, name) {
+				d.Env[k] = v
+			}
+		}
+	}
+	d.TermWidth, d.TermHeight, _ = getTermDim()
+	return d
+}
+
+// Writer returns a writer which saves a copy of everything written to w.  If
+// d is nil w is returned.
+func (d *stateDump) Writer(w io.Writer) io.Writer {
+	if d == nil {
+		return w
+	}
+	d.output.w = w
+	return &d.output
+}
+
+// Save writes the archive.  Flags are recorded as they are when Save is
+// called, after any -profile has been applied.
+func (d *stateDump) Save(fs *flag.FlagSet) error {
+	fs.VisitAll(func(f *flag.Flag) {
+		d.Flags[f.Name] = f.Value.String()
+	})
+	d.Colors = detectedColors
+	d.TerminalQueries = TerminalQueries
+	d.TerminalID = terminalCacheKey
+	output := d.output.Bytes()
+	d.OutputDropped = d.output.dropped
+	state, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(d.path)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"state.json", append(state, '\n')},
+		{"output.ansi", output},
+	} {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0644,
+			Size:    int64(len(file.data)),
+			ModTime: d.Time,
+		})
+		if err == nil {
+			_, err = tw.Write(file.data)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dumpOutputMax is the number of bytes of output kept by -dump-state.
+const dumpOutputMax = 16 << 20
+
+// teeWriter writes to w and saves a copy of the last dumpOutputMax bytes of
+// output.  Frames are flushed through to w.  Whole frames are dropped from
+// the start of the copy, so that it begins at a frame, unless the frame
+// being written alone is too large.
+type teeWriter struct {
+	w       io.Writer
+	frames  [][]byte
+	frame   []byte
+	size    int
+	dropped int64
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.frame = append(t.frame, p[:n]...)
+	t.size += n
+	t.trim()
+	return n, err
+}
+
+func (t *teeWriter) FlushFrame() error {
+	if len(t.frame) > 0 {
+		t.frames = append(t.frames, t.frame)
+		t.frame = nil
+	}
+	return flushFrame(t.w)
+}
+
+// trim drops the oldest output until at most dumpOutputMax bytes are kept.
+func (t *teeWriter) trim() {
+	for t.size > dumpOutputMax && len(t.frames) > 0 {
+		t.size -= len(t.frames[0])
+		t.dropped += int64(len(t.frames[0]))
+		t.frames[0] = nil
+		t.frames = t.frames[1:]
+	}
+	if t.size > dumpOutputMax {
+		n := t.size - dumpOutputMax
+		t.frame = append(t.frame[:0], t.frame[n:]...)
+		t.size -= n
+		t.dropped += int64(n)
+	}
+}
+
+// Bytes returns the output kept.
+func (t *teeWriter) Bytes() []byte {
+	return bytes.Join(append(t.frames, t.frame), nil)
+}
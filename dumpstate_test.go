@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestTeeWriterLimit(t *testing.T) {
+	var tw teeWriter
+	tw.w = io.Discard
+	frame := bytes.Repeat([]byte("x"), dumpOutputMax/3+1)
+	for i := 0; i < 5; i++ {
+		frame[0] = byte('0' + i)
+		tw.Write(frame)
+		tw.FlushFrame()
+	}
+	out := tw.Bytes()
+	if len(out) != 2*len(frame) {
+		t.Fatalf("kept %d bytes, want the last two frames (%d)", len(out), 2*len(frame))
+	}
+	if out[0] != '3' || out[len(frame)] != '4' {
+		t.Errorf("kept frames %c and %c, want 3 and 4", out[0], out[len(frame)])
+	}
+	if tw.dropped != int64(3*len(frame)) {
+		t.Errorf("dropped %d bytes, want %d", tw.dropped, 3*len(frame))
+	}
+
+	tw = teeWriter{w: io.Discard}
+	tw.Write(bytes.Repeat([]byte("x"), dumpOutputMax))
+	tw.Write([]byte("end"))
+	out = tw.Bytes()
+	if len(out) != dumpOutputMax || !bytes.HasSuffix(out, []byte("end")) {
+		t.Errorf("unflushed output not trimmed to its last %d bytes", dumpOutputMax)
+	}
+}
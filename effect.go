@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"time"
+)
+
+// Effect is a time-varying color transform applied to each cell of a
+// quantized frame.  Because effects are applied after frames are looped, t
+// keeps advancing across animation loops.
+type Effect interface {
+	Color(c color.Color, x, y int, t time.Duration) color.Color
+}
+
+var frameEffects = map[string]Effect{
+	"rainbow": &EffectRainbow{Spread: 10, Speed: 120},
+	"pulse":   &EffectPulse{Period: 2 * time.Second, Depth: 0.6},
+	"fade-in": &EffectFadeIn{Duration: time.Second},
+}
+
+func FrameEffects() []string {
+	var names []string
+	for name := range frameEffects {
+		names = append(names, name)
+	}
+	return names
+}
+
+// EffectFrames applies e to each frame received over frames.  The time passed
+// to e is the sum of the delays of all preceding frames.  Frames must already
+// be dithered.  Each cell is given its color in the palette returned by
+// palette before e transforms it, so that every frame starts from the same
+// cell colors and only the effect changes between them.  Unless animate is
+// true the frames are drawn once, as stills, so an effect which settles is
+// drawn as it ends.
+func EffectFrames(ctx context.Context, e Effect, animate bool, palette func() ANSIPalette, frames <-chan *Frame) <-chan *Frame {
+	if e == nil {
+		return frames
+	}
+	var t time.Duration
+	if e, ok := e.(settlingEffect); ok && !animate {
+		t = e.Settled()
+	}
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		// frames may be shared between loops so the effect must be
+		// rendered into a new image.
+		rect := f.Image.Bounds()
+		img := image.NewRGBA64(rect)
+		p := palette()
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				c := f.Image.At(x, y)
+				if IsTransparent(c, AlphaThreshold) {
+					img.Set(x, y, c)
+					continue
+				}
+				img.Set(x, y, e.Color(p.Convert(c), x-rect.Min.X, y-rect.Min.Y, t))
+			}
+		}
+		g := &Frame{
+			Image:     img,
+			Delay:     f.Delay,
+			LoopCount: f.LoopCount,
+			Source:    f.Source,
+			LoopEnd:   f.LoopEnd,
+		}
+		if f.Delay > 0 {
+			t += f.Delay
+		} else {
+			t += DelayDefault
+		}
+		return g, nil
+	})
+}
+
+// EffectRainbow rotates the hue of cells in diagonal bands that drift over
+// time, similar to lolcat.
+type EffectRainbow struct {
+	// Spread is the hue rotation in degrees between adjacent diagonals.
+	Spread float64
+	// Speed is the hue rotation in degrees per second.
+	Speed float64
+}
+
+func (e *EffectRainbow) Color(c color.Color, x, y int, t time.Duration) color.Color {
+	h, s, v, a := rgbToHSV(c)
+	h += e.Spread*float64(x+y) + e.Speed*t.Seconds()
+	return hsvToRGB(h, s, v, a)
+}
+
+// EffectPulse periodically dims and brightens cells.
+type EffectPulse struct {
+	Period time.Duration
+	// Depth is the fraction of brightness removed at the dimmest point.
+	Depth float64
+}
+
+func (e *EffectPulse) Color(c color.Color, x, y int, t time.Duration) color.Color {
+	phase := 2 * math.Pi * t.Seconds() / e.Period.Seconds()
+	return scaleColor(c, 1-e.Depth*(1-math.Cos(phase))/2)
+}
+
+// settlingEffect is implemented by effects which stop changing once Settled
+// has passed.
+type settlingEffect interface {
+	Settled() time.Duration
+}
+
+// EffectFadeIn ramps cells up from black over Duration.
+type EffectFadeIn struct {
+	Duration time.Duration
+}
+
+func (e *EffectFadeIn) Settled() time.Duration {
+	return e.Duration
+}
+
+func (e *EffectFadeIn) Color(c color.Color, x, y int, t time.Duration) color.Color {
+	if t >= e.Duration {
+		return c
+	}
+	return scaleColor(c, t.Seconds()/e.Duration.Seconds())
+}
+
+// scaleColor multiplies the color channels of c by k, leaving alpha intact.
+func scaleColor(c color.Color, k float64) color.Color {
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{
+		R: uint16(float64(r) * k),
+		G: uint16(float64(g) * k),
+		B: uint16(float64(b) * k),
+		A: uint16(a),
+	}
+}
+
+// rgbToHSV returns the hue (in degrees), saturation and value of c along with
+// its alpha.  The returned values are relative to the premultiplied color.
+func rgbToHSV(c color.Color) (h, s, v float64, a uint32) {
+	r32, g32, b32, a := c.RGBA()
+	r := float64(r32) / 0xffff
+	g := float64(g32) / 0xffff
+	b := float64(b32) / 0xffff
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	v = max
+	d := max - min
+	if max > 0 {
+		s = d / max
+	}
+	if d == 0 {
+		return 0, s, v, a
+	}
+	switch max {
+	case r:
+		h = (g - b) / d
+	case g:
+		h = 2 + (b-r)/d
+	default:
+		h = 4 + (r-g)/d
+	}
+	return 60 * h, s, v, a
+}
+
+// hsvToRGB is the inverse of rgbToHSV.  The hue h may be any angle.
+func hsvToRGB(h, s, v float64, a uint32) color.Color {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	h /= 60
+	i := math.Floor(h)
+	f := h - i
+	p := v * (1 - s)
+	q := v * (1 - s*f)
+	t := v * (1 - s*(1-f))
+	var r, g, b float64
+	switch int(i) {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return color.RGBA64{
+		R: uint16(r * 0xffff),
+		G: uint16(g * 0xffff),
+		B: uint16(b * 0xffff),
+		A: uint16(a),
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// frameFlusher is implemented by writers which handle each frame written to
+// them as a unit.  FlushFrame is called after all of a frame has been written.
+type frameFlusher interface {
+	FlushFrame() error
+}
+
+// flushFrame calls FlushFrame if w is a frameFlusher.
+func flushFrame(w interface{}) error {
+	if ff, ok := w.(frameFlusher); ok {
+		return ff.FlushFrame()
+	}
+	return nil
+}
+
+// execFrameWriter runs a shell command for each frame, passing the output of
+// the frame on the command's standard input.  The frame number, starting at
+// zero, is in the environment variable IMG2ANSI_FRAME.
+type execFrameWriter struct {
+	ctx     context.Context
+	command string
+	buf     bytes.Buffer
+	nframe  int
+}
+
+func newExecFrameWriter(ctx context.Context, command string) *execFrameWriter {
+	return &execFrameWriter{ctx: ctx, command: command}
+}
+
+func (w *execFrameWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *execFrameWriter) FlushFrame() error {
+	defer w.buf.Reset()
+	cmd := exec.CommandContext(w.ctx, "sh", "-c", w.command)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("IMG2ANSI_FRAME=%d", w.nframe))
+	cmd.Stdin = &w.buf
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	w.nframe++
+	err := cmd.Run()
+	if err != nil && w.ctx.Err() == nil {
+		return fmt.Errorf("exec per frame: %w", err)
+	}
+	return nil
+}
+
+// execLoopHook returns a LoopHook which runs a shell command at the end of
+// each loop.  The loop number, starting at one, is in the environment
+// variable IMG2ANSI_LOOP.  The command's output goes to standard error so
+// it does not disturb the animation.
+func execLoopHook(ctx context.Context, command string) func(loop int) {
+	return func(loop int) {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("IMG2ANSI_LOOP=%d", loop))
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil && ctx.Err() == nil {
+			logger(logRender).Error("exec per loop", "loop", loop, "err", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// execSink runs a command and writes frames to its standard input, allowing
+// displays to be supported by programs outside img2ansi.  The frame format is
+// chosen by the format setting of the sink (see newExecSink):
+//
+//	ppm   each frame is a binary PPM (P6) image, the default
+//	json  each frame is a line of JSON in the json-cells format
+//
+// Frames are written as they are due to be displayed.
+type execSink struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	w      *bufio.Writer
+	format string
+}
+
+// newExecSink starts the command given by spec, the text of an exec: sink
+// following its scheme.  Arguments are separated by spaces, and the command
+// is not a URL, so they may contain any other character.  Words of the form
+// name=value before the command set the format and layout (see
+// readSinkLayout) of the sink, as variables are set before a shell command,
+// for example "exec:format=json my-display --port /dev/ttyUSB0".
+func newExecSink(spec string) (PixelSink, error) {
+	args := strings.Fields(spec)
+	var format, layoutPath string
+	for len(args) > 0 {
+		name, value, ok := strings.Cut(args[0], "=")
+		if !ok {
+			break
+		}
+		switch name {
+		case "format":
+			format = value
+		case "layout":
+			layoutPath = value
+		default:
+			return nil, fmt.Errorf("exec sink: setting not one of %q", []string{"format", "layout"})
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("exec sink: no command")
+	}
+	switch format {
+	case "":
+		format = "ppm"
+	case "ppm", "json":
+	default:
+		return nil, fmt.Errorf("exec sink: format not one of %q", []string{"ppm", "json"})
+	}
+	var layout []int
+	if layoutPath != "" {
+		var err error
+		layout, err = readSinkLayout(layoutPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("exec sink: %w", err)
+	}
+	var sink PixelSink = &execSink{
+		cmd:    cmd,
+		stdin:  stdin,
+		w:      bufio.NewWriter(stdin),
+		format: format,
+	}
+	if layout != nil {
+		sink = &layoutSink{PixelSink: sink, layout: layout}
+	}
+	return sink, nil
+}
+
+func (s *execSink) WritePixels(size image.Point, pixels []color.RGBA) error {
+	switch s.format {
+	case "json":
+		err := json.NewEncoder(s.w).Encode(pixelsJSONFrame(size, pixels))
+		if err != nil {
+			return err
+		}
+	default:
+		fmt.Fprintf(s.w, "P6\n%d %d\n255\n", size.X, size.Y)
+		for _, c := range pixels {
+			s.w.Write([]byte{c.R, c.G, c.B})
+		}
+	}
+	err := s.w.Flush()
+	if err != nil {
+		return fmt.Errorf("exec sink: %w", err)
+	}
+	return nil
+}
+
+func (s *execSink) Close() error {
+	s.w.Flush()
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
+
+// pixelsJSONFrame returns the json-cells representation of row-major pixels
+// of the given size.
+func pixelsJSONFrame(size image.Point, pixels []color.RGBA) *jsonFrame {
+	jf := &jsonFrame{
+		Width:  size.X,
+		Height: size.Y,
+		Cells:  make([][]jsonCell, size.Y),
+	}
+	for y := range jf.Cells {
+		row := make([]jsonCell, size.X)
+		for x := range row {
+			if i := y*size.X + x; i < len(pixels) {
+				row[x] = jsonCell{Glyph: " ", BG: hexColor(pixels[i])}
+			}
+		}
+		jf.Cells[y] = row
+	}
+	return jf
+}
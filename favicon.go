@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// faviconURLs returns candidate icon URLs for site in order of preference.
+// Larger icons are preferred because they scale down better.
+func faviconURLs(site string) []string {
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	u, err := url.Parse(site)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	base := u.Scheme + "://" + u.Host
+	return []string{
+		base + "/apple-touch-icon.png",
+		base + "/apple-touch-icon-precomposed.png",
+		base + "/favicon.ico",
+	}
+}
+
+// decodeFramesFavicon decodes the first icon for site that can be fetched.
+func decodeFramesFavicon(ctx context.Context, site string, fopts *FrameOptions) (<-chan *Frame, error) {
+	urls := faviconURLs(site)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("favicon: invalid site: %q", site)
+	}
+	var err error
+	for _, u := range urls {
+		var frames <-chan *Frame
+		frames, err = decodeFramesHTTP(ctx, u, fopts)
+		if err == nil {
+			return frames, nil
+		}
+		logger(logHTTP).Debug("favicon not found", "url", u, "err", err)
+	}
+	return nil, fmt.Errorf("favicon: %w", err)
+}
+
+// gravatarURL returns the URL of the avatar for email.  Emails without a
+// gravatar get a generated identicon.
+func gravatarURL(email string) string {
+	sum := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?s=256&d=identicon"
+}
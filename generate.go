@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// generators create synthetic images from a seed.  Images are generated at a
+// modest resolution and scaled by the normal pipeline.
+var generators = map[string]func(seed string) image.Image{
+	"identicon":    genIdenticon,
+	"gradient":     genGradient,
+	"checkerboard": genCheckerboard,
+	"plasma":       genPlasma,
+}
+
+func Generators() []string {
+	var names []string
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// genMain implements the gen subcommand, which draws a synthetic image with
+// the flags of img2ansi.
+func genMain(args []string) error {
+	drawMain("gen", args)
+	return nil
+}
+
+// decodeFramesGen generates an image from args, which has the form
+// "kind [seed]", and returns it as a single frame.
+func decodeFramesGen(args []string) (<-chan *Frame, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("usage: img2ansi gen [flags] {%s} [seed]", strings.Join(Generators(), ","))
+	}
+	gen := generators[args[0]]
+	if gen == nil {
+		return nil, fmt.Errorf("generator not one of %q", Generators())
+	}
+	var seed string
+	if len(args) > 1 {
+		seed = args[1]
+	}
+	c := make(chan *Frame, 1)
+	c <- &Frame{Image: gen(seed)}
+	close(c)
+	return c, nil
+}
+
+// seedRand returns a random source determined entirely by seed.
+func seedRand(seed string) *rand.Rand {
+	sum := sha256.Sum256([]byte(seed))
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[:]))))
+}
+
+// seedColor returns a saturated color chosen by r.
+func seedColor(r *rand.Rand) color.Color {
+	return hsvToRGB(360*r.Float64(), 0.5+0.3*r.Float64(), 0.7+0.3*r.Float64(), 0xffff)
+}
+
+// genIdenticon draws a horizontally symmetric 5x5 grid of blocks, in the
+// style of GitHub's identicons.
+func genIdenticon(seed string) image.Image {
+	const grid, block, margin = 5, 8, 4
+	sum := sha256.Sum256([]byte(seed))
+	fg := seedColor(seedRand(seed))
+	bg := color.RGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+	size := grid*block + 2*margin
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, bg)
+		}
+	}
+	for row := 0; row < grid; row++ {
+		for col := 0; col < (grid+1)/2; col++ {
+			bit := row*3 + col
+			if sum[bit/8]&(1<<(bit%8)) == 0 {
+				continue
+			}
+			for _, c := range []int{col, grid - 1 - col} {
+				for y := 0; y < block; y++ {
+					for x := 0; x < block; x++ {
+						img.Set(margin+c*block+x, margin+row*block+y, fg)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+// genGradient draws a diagonal gradient between two colors.
+func genGradient(seed string) image.Image {
+	const w, h = 128, 64
+	r := seedRand(seed)
+	c0, c1 := seedColor(r), seedColor(r)
+	if seed == "" {
+		c0, c1 = color.Black, color.White
+	}
+	img := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := (float64(x)/w + float64(y)/h) / 2
+			img.Set(x, y, TransitionFade{}.Color(c0, c1, x, y, image.Point{}, p))
+		}
+	}
+	return img
+}
+
+// genCheckerboard draws a checkerboard of two colors.
+func genCheckerboard(seed string) image.Image {
+	const w, h, square = 64, 64, 8
+	var c0, c1 color.Color = color.Black, color.White
+	if seed != "" {
+		r := seedRand(seed)
+		c0, c1 = seedColor(r), seedColor(r)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x/square+y/square)%2 == 0 {
+				img.Set(x, y, c0)
+			} else {
+				img.Set(x, y, c1)
+			}
+		}
+	}
+	return img
+}
+
+// genPlasma draws the classic demoscene plasma, a sum of sine waves mapped to
+// a rainbow of hues.
+func genPlasma(seed string) image.Image {
+	const w, h = 128, 64
+	r := seedRand(seed)
+	f := [4]float64{}
+	for i := range f {
+		f[i] = 0.05 + 0.1*r.Float64()
+	}
+	hue := 360 * r.Float64()
+	img := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x), float64(y)
+			v := math.Sin(fx*f[0]) +
+				math.Sin(fy*f[1]) +
+				math.Sin((fx+fy)*f[2]) +
+				math.Sin(math.Hypot(fx-w/2, fy-h/2)*f[3])
+			img.Set(x, y, hsvToRGB(hue+45*v, 0.8, 1, 0xffff))
+		}
+	}
+	return img
+}
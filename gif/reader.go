@@ -15,6 +15,7 @@ import (
 	"image"
 	"image/color"
 	"io"
+	"strings"
 )
 
 var (
@@ -108,6 +109,11 @@ type decoder struct {
 	image          []*image.Paletted
 	tmp            [1024]byte // must be at least 768 so we can read color table
 
+	// If frameErrors is true errors reading an image are returned as a
+	// *FrameError.
+	frameErrors bool
+	inImage     bool
+
 	dndHasTransparentIndex bool
 	dndTransparentIndex    uint8
 	doNotDispose           *image.Paletted
@@ -154,7 +160,7 @@ func (b *blockReader) Read(p []byte) (int, error) {
 }
 
 // decode reads a GIF image from r and stores the result in d.
-func (d *decoder) decode(r io.Reader, configOnly bool) error {
+func (d *decoder) decode(r io.Reader, configOnly bool) (err error) {
 	// Add buffering if r does not provide ReadByte.
 	if rr, ok := r.(reader); ok {
 		d.r = rr
@@ -162,7 +168,13 @@ func (d *decoder) decode(r io.Reader, configOnly bool) error {
 		d.r = bufio.NewReader(r)
 	}
 
-	err := d.readHeaderAndScreenDescriptor()
+	defer func() {
+		if err != nil && d.frameErrors && d.inImage {
+			err = &FrameError{Frame: len(d.image), Err: err}
+		}
+	}()
+
+	err = d.readHeaderAndScreenDescriptor()
 	if err != nil {
 		return err
 	}
@@ -199,6 +211,7 @@ func (d *decoder) decode(r io.Reader, configOnly bool) error {
 			}
 
 		case sImageDescriptor:
+			d.inImage = true
 			m, err := d.newImageFromDescriptor()
 			if err != nil {
 				return err
@@ -321,6 +334,7 @@ func (d *decoder) decode(r io.Reader, configOnly bool) error {
 			*/
 
 			d.image = append(d.image, m)
+			d.inImage = false
 			d.delay = append(d.delay, d.delayTime)
 			d.disposal = append(d.disposal, d.disposalMethod)
 			d.hastransparent = append(d.hastransparent, d.hasTransparentIndex)
@@ -553,6 +567,10 @@ const (
 // and timing information.
 func DecodeAll(r io.Reader) (*GIF, error) {
 	var d decoder
+	return d.decodeAll(r)
+}
+
+func (d *decoder) decodeAll(r io.Reader) (*GIF, error) {
 	if err := d.decode(r, false); err != nil {
 		return nil, err
 	}
@@ -573,6 +591,27 @@ func DecodeAll(r io.Reader) (*GIF, error) {
 	return gif, nil
 }
 
+// FrameError is an error encountered reading an image of a GIF.
+type FrameError struct {
+	Frame int // the index of the image
+	Err   error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("gif: frame %d: %s", e.Frame, strings.TrimPrefix(e.Err.Error(), "gif: "))
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeAllFrames is like DecodeAll but an error reading one of the images
+// is returned as a *FrameError so that the corrupt frame can be reported.
+func DecodeAllFrames(r io.Reader) (*GIF, error) {
+	d := decoder{frameErrors: true}
+	return d.decodeAll(r)
+}
+
 // DecodeConfig returns the global color model and dimensions of a GIF image
 // without decoding the entire image.
 func DecodeConfig(r io.Reader) (image.Config, error) {
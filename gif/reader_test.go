@@ -274,3 +274,21 @@ func TestLoopCount(t *testing.T) {
 		t.Errorf("loop count mismatch: %d vs %d", img.LoopCount, img1.LoopCount)
 	}
 }
+
+func TestDecodeAllFramesError(t *testing.T) {
+	gif := make([]byte, len(testGIF))
+	copy(gif, testGIF)
+	gif[32] = 2
+	_, err := DecodeAllFrames(bytes.NewReader(gif))
+	ferr, ok := err.(*FrameError)
+	if !ok {
+		t.Fatalf("got %v, want *FrameError", err)
+	}
+	if ferr.Frame != 0 {
+		t.Errorf("frame %d, want 0", ferr.Frame)
+	}
+	want := "gif: frame 0: frame bounds larger than image bounds"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
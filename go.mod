@@ -3,8 +3,11 @@ module github.com/bmatsuo/img2ansi
 go 1.21.4
 
 require (
-	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/gorilla/websocket v1.5.3
 	golang.org/x/crypto v0.15.0
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.16.0
+	rsc.io/qr v0.2.0
 )
 
 require (
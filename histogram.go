@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// sparkBlocks are the glyphs of a one line bar chart, from empty to full.
+var sparkBlocks = []string{" ", "▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"}
+
+// histogramRows are the channels drawn by writeHistogram along with the
+// foreground color of their bars.
+var histogramRows = []struct {
+	Name  string
+	Color string
+}{
+	{"R", "\033[31m"},
+	{"G", "\033[32m"},
+	{"B", "\033[34m"},
+	{"L", "\033[37m"},
+}
+
+// writeHistogram writes a histogram of the red, green, blue and luminance
+// channels of the opaque pixels of img, one channel per line, with a bin for
+// each column of img.  writeHistogram returns the number of lines written.
+func writeHistogram(w *frameBuffer, img image.Image, opts *FrameOptions) int {
+	rect := img.Bounds()
+	bins := rect.Dx()
+	if bins == 0 {
+		return 0
+	}
+	counts := make([][]int, len(histogramRows))
+	for i := range counts {
+		counts[i] = make([]int, bins)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := img.At(x, y)
+			if IsTransparent(c, AlphaThreshold) {
+				continue
+			}
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			l := color.GrayModel.Convert(n).(color.Gray).Y
+			for i, v := range []uint8{n.R, n.G, n.B, l} {
+				counts[i][int(v)*bins/256]++
+			}
+		}
+	}
+
+	for i, row := range histogramRows {
+		max := 0
+		for _, n := range counts[i] {
+			if n > max {
+				max = n
+			}
+		}
+		w.WriteString(opts.Pad)
+		w.WriteString(row.Color)
+		for _, n := range counts[i] {
+			level := 0
+			if max > 0 {
+				level = (n*(len(sparkBlocks)-1) + max - 1) / max
+			}
+			w.WriteString(sparkBlocks[level])
+		}
+		w.WriteString(ANSIClear)
+		w.WriteString(" ")
+		w.WriteString(row.Name)
+		w.WriteString("\n")
+	}
+	return len(histogramRows)
+}
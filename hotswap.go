@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+)
+
+// livePalettes are the palettes cycled through by the c key.
+var livePalettes = []string{"8", "16", "256", "truecolor", "gray"}
+
+// liveOptions holds the drawing settings which can be changed while an
+// animation plays, with keys or with -control.  The Player retains scaled
+// frames, which are dithered and encoded again with the new settings, so
+// images are not decoded again.  Each change redraws the current frame if
+// playback is paused.
+type liveOptions struct {
+	player *Player
+	seed   int64
+
+	mu          sync.Mutex
+	palette     ANSIPalette
+	paletteName string
+	dither      bool
+	diffuse     bool       // dithering diffuses error rather than using mask
+	mask        *BlueNoise // created the first time dithering is enabled
+	render      string
+}
+
+// newLiveOptions returns the settings initially given by flags.  dither is
+// the -dither method, or empty, and mask is nil unless it is
+// DitherBlueNoise.
+func newLiveOptions(player *Player, p ANSIPalette, paletteName string, dither string, mask *BlueNoise, seed int64, render string) *liveOptions {
+	return &liveOptions{
+		player:      player,
+		seed:        seed,
+		palette:     p,
+		paletteName: paletteName,
+		dither:      dither != "",
+		diffuse:     dither == DitherFloydSteinberg,
+		mask:        mask,
+		render:      render,
+	}
+}
+
+// SetPalette draws frames with the named palette.
+func (l *liveOptions) SetPalette(name string) error {
+	p := ansiPalettes[name]
+	if p == nil {
+		return fmt.Errorf("color palette not one of %q", ANSIPalettes())
+	}
+	l.mu.Lock()
+	l.palette, l.paletteName = p, name
+	l.mu.Unlock()
+	l.player.Redraw()
+	return nil
+}
+
+// CyclePalette draws frames with the palette following the current one in
+// livePalettes.
+func (l *liveOptions) CyclePalette() {
+	l.mu.Lock()
+	next := livePalettes[0]
+	for i, name := range livePalettes {
+		if ansiPalettes[name] == l.palette {
+			next = livePalettes[(i+1)%len(livePalettes)]
+		}
+	}
+	l.mu.Unlock()
+	l.SetPalette(next)
+}
+
+// SetDither enables or disables dithering.
+func (l *liveOptions) SetDither(on bool) {
+	l.mu.Lock()
+	l.dither = on
+	if on && !l.diffuse && l.mask == nil {
+		l.mask = NewBlueNoise(l.seed)
+	}
+	l.mu.Unlock()
+	l.player.Redraw()
+}
+
+// SetRender draws cells with the given render mode.
+func (l *liveOptions) SetRender(mode string) error {
+	switch mode {
+	case RenderBackground, RenderForeground:
+	default:
+		return fmt.Errorf("render mode not one of %q", []string{RenderBackground, RenderForeground})
+	}
+	l.mu.Lock()
+	l.render = mode
+	l.mu.Unlock()
+	l.player.Redraw()
+	return nil
+}
+
+// Dither reports whether frames are dithered.
+func (l *liveOptions) Dither() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dither
+}
+
+// Render returns the render mode.
+func (l *liveOptions) Render() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.render
+}
+
+// PaletteName returns the name of the palette frames are drawn with.
+func (l *liveOptions) PaletteName() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.paletteName
+}
+
+// Palette returns the palette frames are drawn with.  If l is nil p is
+// returned.
+func (l *liveOptions) Palette(p ANSIPalette) ANSIPalette {
+	if l == nil {
+		return p
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.palette
+}
+
+// frameOptions returns the palette and options to encode the next frame
+// with.  If l is nil p and opts are returned.
+func (l *liveOptions) frameOptions(p ANSIPalette, opts *FrameOptions) (ANSIPalette, *FrameOptions) {
+	if l == nil {
+		return p, opts
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.render == opts.Render {
+		return l.palette, opts
+	}
+	o := *opts
+	o.Render = l.render
+	return l.palette, &o
+}
+
+// DitherFrames dithers frames while dithering is enabled, using the spread
+// of the current palette or diffusing error against it.
+func (l *liveOptions) DitherFrames(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+	var lastSpread float64
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		l.mu.Lock()
+		on, diffuse, mask, p := l.dither, l.diffuse, l.mask, l.palette
+		l.mu.Unlock()
+		spread := ditherSpread(p)
+		if !on || spread < 0 {
+			spread = 0
+		}
+		g := *f
+		if spread != lastSpread {
+			// pixels outside the changed region are dithered
+			// differently than in the last frame.
+			g.Changed = image.Rectangle{}
+			lastSpread = spread
+		}
+		switch {
+		case spread > 0 && diffuse:
+			g.Image = diffuseImage(f.Image, p)
+			g.Changed = image.Rectangle{}
+		case spread > 0:
+			g.Image = ditherImage(f.Image, mask, spread)
+		}
+		return &g, nil
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// ICO decoding supports the formats commonly found in favicons: embedded PNG
+// images and uncompressed 1, 4, 8, 24 and 32 bit DIBs.
+
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeICO, decodeICOConfig)
+}
+
+var errICOFormat = errors.New("ico: invalid format")
+
+type icoEntry struct {
+	Width, Height int
+	Size, Offset  uint32
+}
+
+// readICO reads r and returns its data along with the largest image in its
+// directory.
+func readICO(r io.Reader) ([]byte, icoEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, icoEntry{}, err
+	}
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+		return nil, icoEntry{}, errICOFormat
+	}
+	n := int(binary.LittleEndian.Uint16(data[4:]))
+	if n == 0 || len(data) < 6+16*n {
+		return nil, icoEntry{}, errICOFormat
+	}
+	var best icoEntry
+	for i := 0; i < n; i++ {
+		d := data[6+16*i:]
+		e := icoEntry{
+			Width:  int(d[0]),
+			Height: int(d[1]),
+			Size:   binary.LittleEndian.Uint32(d[8:]),
+			Offset: binary.LittleEndian.Uint32(d[12:]),
+		}
+		// a dimension of zero means 256 pixels.
+		if e.Width == 0 {
+			e.Width = 256
+		}
+		if e.Height == 0 {
+			e.Height = 256
+		}
+		if uint64(e.Offset)+uint64(e.Size) > uint64(len(data)) {
+			continue
+		}
+		if e.Width*e.Height > best.Width*best.Height {
+			best = e
+		}
+	}
+	if best.Size == 0 {
+		return nil, icoEntry{}, errICOFormat
+	}
+	return data, best, nil
+}
+
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	_, e, err := readICO(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      e.Width,
+		Height:     e.Height,
+	}, nil
+}
+
+func decodeICO(r io.Reader) (image.Image, error) {
+	data, e, err := readICO(r)
+	if err != nil {
+		return nil, err
+	}
+	b := data[e.Offset : e.Offset+e.Size]
+	if bytes.HasPrefix(b, []byte("\x89PNG")) {
+		return png.Decode(bytes.NewReader(b))
+	}
+	return decodeICODIB(b)
+}
+
+// decodeICODIB decodes a device independent bitmap stored in an ICO file.
+// The bitmap height includes the 1 bit transparency mask following the color
+// data.
+func decodeICODIB(b []byte) (image.Image, error) {
+	if len(b) < 40 {
+		return nil, errICOFormat
+	}
+	hdrLen := int(binary.LittleEndian.Uint32(b[0:]))
+	width := int(int32(binary.LittleEndian.Uint32(b[4:])))
+	height := int(int32(binary.LittleEndian.Uint32(b[8:]))) / 2
+	bpp := int(binary.LittleEndian.Uint16(b[14:]))
+	compression := binary.LittleEndian.Uint32(b[16:])
+	ncolors := int(binary.LittleEndian.Uint32(b[32:]))
+	if compression != 0 {
+		return nil, fmt.Errorf("ico: unsupported bitmap compression %d", compression)
+	}
+	if width <= 0 || height <= 0 || hdrLen < 40 || hdrLen > len(b) {
+		return nil, errICOFormat
+	}
+
+	var palette []color.NRGBA
+	if bpp <= 8 {
+		if ncolors == 0 {
+			ncolors = 1 << bpp
+		}
+		p := b[hdrLen:]
+		if ncolors < 0 || ncolors > len(p)/4 {
+			return nil, errICOFormat
+		}
+		for i := 0; i < ncolors; i++ {
+			palette = append(palette, color.NRGBA{R: p[4*i+2], G: p[4*i+1], B: p[4*i], A: 0xff})
+		}
+		hdrLen += 4 * ncolors
+	}
+
+	switch bpp {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, fmt.Errorf("ico: unsupported bit depth %d", bpp)
+	}
+	// the dimensions come from the header and their product may overflow, so
+	// they are checked against the length of the data by division.
+	pix := b[hdrLen:]
+	if width > len(pix) || height > len(pix) {
+		return nil, errICOFormat
+	}
+	// rows are padded to multiples of four bytes.
+	stride := (width*bpp + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+	if stride > len(pix)/height {
+		return nil, errICOFormat
+	}
+	mask := pix[stride*height:]
+	hasMask := maskStride <= len(mask)/height
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		// rows are stored bottom-up.
+		row := pix[(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			switch bpp {
+			case 32:
+				c = color.NRGBA{R: row[4*x+2], G: row[4*x+1], B: row[4*x], A: row[4*x+3]}
+			case 24:
+				c = color.NRGBA{R: row[3*x+2], G: row[3*x+1], B: row[3*x], A: 0xff}
+			default:
+				bit := x * bpp
+				i := int(row[bit/8]>>(8-bpp-bit%8)) & (1<<bpp - 1)
+				if i < len(palette) {
+					c = palette[i]
+				}
+			}
+			if bpp != 32 && hasMask {
+				m := mask[(height-1-y)*maskStride:]
+				if m[x/8]&(0x80>>(x%8)) != 0 {
+					c.A = 0
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img, nil
+}
@@ -6,6 +6,11 @@ animated).
 	img2ansi motd.png
 	img2ansi -animate -repeat=5 -scale https://i.imgur.com/872FDBm.gif
 	img2ansi -h
+	img2ansi qr https://github.com/bmatsuo/img2ansi
+	img2ansi gen -width=40 identicon bmatsuo
+	img2ansi pick motd.png
+	img2ansi -protocol=kitty -image-id=7 motd.png
+	img2ansi erase -id 7
 
 The command takes as arguments URLs referencing images to render.  If no
 arguments are given img2ansi reads image data from standard input.  Image
@@ -25,25 +30,48 @@ import (
 	_ "image/png"
 	"io"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bmatsuo/img2ansi/gif"
-	"github.com/nfnt/resize"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 const ANSIClear = "\033[0m"
 const DelayDefault = 33 * time.Millisecond
 
-var Debug = false
+// GIFShortDelayDefault is the delay browsers give GIF frames with a delay of
+// 0 or 1 centiseconds.  Such delays are usually unintended, or meant for old
+// browsers which drew frames no faster than this anyway.
+const GIFShortDelayDefault = 100 * time.Millisecond
+
+// Unicode bidirectional isolate controls.
+const (
+	bidiLRI = "\u2066" // left-to-right isolate
+	bidiPDI = "\u2069" // pop directional isolate
+)
+
 var HTTPUserAgent = ""
 var AlphaThreshold = uint32(0xffff)
 
+// PipelineBuffer is the number of frames each pipeline stage may produce
+// ahead of the stage consuming its output.  A stage blocks once its buffer is
+// full, so a slow consumer (typically the terminal) bounds the memory used by
+// the stages before it to roughly PipelineBuffer frames per stage.  Larger
+// values smooth out uneven decode and encode times in high frame rate
+// animations.
+var PipelineBuffer = 0
+
 var debugProcStartTime = time.Now()
 
 func IsTransparent(c color.Color, threshold uint32) bool {
@@ -56,45 +84,575 @@ func init() {
 	log.SetFlags(0)
 }
 
+// subcommands are invoked by naming them as the first argument to img2ansi.
+// A file with the name of a subcommand is drawn by giving its path as ./name,
+// or after --.
+var subcommands = map[string]func(args []string) error{
+	"gen":       genMain,
+	"pick":      pickMain,
+	"qr":        qrMain,
+	"convert":   convertMain,
+	"daemon":    daemonMain,
+	"erase":     eraseMain,
+	"rasterize": rasterizeMain,
+	"selftest":  selftestMain,
+	"sheet":     sheetMain,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	drawMain("", os.Args[1:])
+}
+
+// drawMain draws images with the flags of img2ansi given in args.  The
+// arguments following the flags are the images to draw, unless command names
+// the subcommand, gen or pick, whose arguments they are.
+func drawMain(command string, args []string) {
 	fopts := new(FrameOptions)
 
+	ansiInput := flag.Bool("ansi-input", false, "draw input which is already ANSI output, such as art or a recording made with -o, with new pacing, looping and padding instead of decoding an image (implied when every argument ends in .ans or .cast)")
+	recordPath := flag.String("o", "", "also record the output to a file, as an asciinema recording if the path ends in .cast, timed by frame delays rather than by drawing")
+	refresh := flag.Duration("refresh", 0, "decode the input again at the given interval and transition to the new image, for images which change such as weather maps or webcam stills (implies -animate)")
+	controlPath := flag.String("control", "", "for -animate, listen on a unix socket at the given path for commands (pause, resume, toggle, next, seek N, speed X, load URL, palette NAME, status, quit)")
+	previewMode := flag.Bool("preview-mode", false, "draw a still preview for a file manager (lf, ranger, nnn): arguments are image [width height [x y]], the output fits exactly and expensive passes are skipped after 100ms")
+	useDaemon := flag.Bool("daemon", false, "for -preview-mode, ask a running img2ansi daemon to draw the preview, which only applies -color and -fontaspect")
+	daemonSocket := flag.String("daemon-socket", daemonSocketPath(), "path of the img2ansi daemon's unix socket")
+	profile := flag.String("profile", "", "set flags for a common scenario (bbs, crisp, local-truecolor, motd, ssh-slow); explicit flags take precedence")
 	cpuprofile := flag.String("cpuprofile", "", "path of pprof CPU profile output")
 	scaleToTerm := flag.Bool("scale", false, "scale to fit the current terminal (overrides -width and -height)")
 	height := flag.Int("height", 0, "desired height in terminal lines")
-	width := flag.Int("width", 0, "desired width in terminal columns")
-	paletteName := flag.String("color", "256", "color palette (8, 256, gray, ...)")
+	width := flag.Int("width", 0, "desired width of the image in terminal columns, excluding -pad")
+	cols := flag.Int("cols", 0, "desired width of output lines in terminal columns, including -pad")
+	flag.IntVar(height, "rows", 0, "alias for -height")
+	reserveRows := flag.Int("reserve-rows", 0, "for -scale without -animate, terminal lines to leave free below the image for a multi-line shell prompt (by default one line is left for the cursor)")
+	factor := scaleFactor{1, 1}
+	noUpscale := flag.Bool("no-upscale", false, "never enlarge images so that a source pixel covers more than one cell, centering them instead (same as -max-scale=1)")
+	scalerName := flag.String("scaler", "box", "how images are resized: by averaging and repeating pixels, nearest neighbor, smoothly, or by repeating and merging pixels for pixel art (box, nearest, bilinear, catmull-rom, pixel)")
+	flag.BoolVar(&SnapScale, "snap-scale", false, "resize images only by whole number ratios, centering them in the area they would have filled, so text and thin lines are sampled evenly")
+	contrast := flag.Float64("contrast", 1, "scale the contrast of images by a factor, e.g. 1.5 to make text in screenshots stand out (1 leaves colors unchanged)")
+	flag.Float64Var(&MaxScale, "max-scale", 0, "enlarge images at most this many cells per source pixel, centering them in the area they would have filled (0 is unlimited)")
+	flag.Var(&factor, "scale-factor", "scale the image by a factor, or by independent X and Y factors (0.5, 0.5x0.25); with -scale, -width or -height the factor applies to those dimensions")
+	cover := flag.Bool("cover", false, "crop images to fill -width and -height, or the terminal with -scale, instead of fitting inside them")
+	smartCrop := flag.Bool("smart-crop", false, "crop like -cover, keeping the most detailed region of the image rather than its center")
+	removeBG := flag.Bool("remove-bg", false, "make a uniform background around the subject of the image transparent")
+	removeBGTolerance := flag.Float64("remove-bg-tolerance", 0.1, "for -remove-bg, how far colors may differ from the background color and still be removed (0 to 1)")
+	paletteName := flag.String("color", ColorAuto, "color palette (auto, 8, 256, truecolor, gray, ...)")
+	dither := flag.String("dither", "", "dither colors before quantizing them with a blue-noise mask, or by diffusing the error of each cell to its neighbors (bluenoise, floyd-steinberg)")
+	seed := flag.Int64("seed", 0, "seed for -dither; 0 chooses a random seed unless -deterministic is given")
+	why := flag.Bool("why", false, "explain which terminal capability checks chose the color palette, protocol and theme")
+	themeName := flag.String("theme", ThemeAuto, "the terminal background, which letterboxes are filled to match and images with transparency are checked against (auto, dark, light)")
+	screensaver := flag.String("screensaver", "", "display random images from a directory full-screen until a key is pressed")
+	screensaverInterval := flag.Duration("screensaver-interval", 10*time.Second, "time each -screensaver image is displayed")
+	panes := flag.String("panes", "", "play each input simultaneously in a grid of panes filling the terminal (e.g. 2x2, implies -animate)")
+	merge := flag.String("merge", MergeSequential, "how multiple inputs are played: one after another, or simultaneously on one timeline in a grid of panes sized to fit them (sequential, concurrent; concurrent implies -animate)")
+	pipPath := flag.String("pip", "", "overlay a second, smaller animation on a corner of the image (implies -animate)")
+	pipPos := flag.String("pip-pos", "bottom-right", "for -pip, the corner to overlay (top-left, top-right, bottom-left, bottom-right)")
+	tile := flag.Bool("tile", false, "repeat the image to fill the current terminal")
+	tileMirror := flag.Bool("tile-mirror", false, "for -tile, mirror alternating tiles")
+	wrapTextPath := flag.String("wrap-text", "", "path of a text file to print beside the image")
+	textSide := flag.String("text-side", "right", "for -wrap-text, the side of the image to print text on (left, right)")
+	kenBurns := flag.Duration("kenburns", 0, "animate still images by panning and zooming for the given duration (implies -animate)")
+	interpolate := interpolation(1)
+	flag.Var(&interpolate, "interpolate", "for -animate, multiply the frame rate by cross-fading between frames (2x, 3x, ...), smoother on fast terminals at the cost of CPU")
+	transitionName := flag.String("transition", "", "transition between images (fade, wipe, dissolve)")
+	transitionDuration := flag.Duration("transition-duration", 500*time.Millisecond, "duration of -transition")
+	outputFormat := flag.String("output-format", OutputANSI, "output format (ansi, json-cells)")
+	var place kittyPlacement
+	flag.Var(&place, "place", "for -protocol=kitty, draw images at a cell of the screen, COLxROW counting from 1, offset by +X+Y pixels within the cell, without moving the cursor")
+	flag.IntVar(&place.Z, "z-index", 0, "for -place, the z-index of images, negative to draw them below text")
+	imageID := flag.Uint("image-id", 0, "for -protocol=kitty, the ID of the image drawn, so that it can be deleted later with img2ansi erase -id")
+	target := flag.String("target", "", "keep sixel output within the limits of a hardware terminal: vt340, 16 color registers on an 800x480 screen (implies -protocol=sixel)")
+	protocol := flag.String("protocol", ProtocolAuto, "draw images with colored cells, or as pixels with sixel graphics, the kitty graphics protocol or iTerm2 inline images in terminals which support them; auto draws pixels when -color=auto detects a terminal supporting them (auto, ansi, sixel, kitty, iterm2)")
+	execPerFrame := flag.String("exec-per-frame", "", "run a shell command for each frame with the frame's output on its standard input, instead of writing to stdout")
+	execPerLoop := flag.String("exec-per-loop", "", "run a shell command at the end of each loop of the animation, with the loop number in IMG2ANSI_LOOP; playback waits for it to finish")
+	sinkURL := flag.String("sink", "", "send frames to a pixel display instead of the terminal (artnet://host, wled://host, mqtt://host/topic, exec:command, ws://host:port/path?origin=URL)")
+	serpentine := flag.Bool("serpentine", false, "for -sink, reverse every other row for zigzag wired LED matrices")
+	toneMapName := flag.String("tonemap", "", "tone map 16-bit images so highlights are not clipped (aces, reinhard)")
+	effectName := flag.String("effect", "", "time-varying color effect (rainbow, pulse, fade-in)")
 	fontAspect := flag.Float64("fontaspect", 0.5, "aspect ratio (width/height)")
-	alphaThreshold := flag.Float64("alphamin", 1.0, "transparency threshold")
+	alphaMin := alphaThresholds{1}
+	flag.Var(&alphaMin, "alphamin", "transparency threshold, or a comma separated list of thresholds for each input")
 	useStdin := flag.Bool("stdin", false, "read image data from stdin")
+	favicon := flag.String("favicon", "", "render the icon of a website")
+	gravatar := flag.String("gravatar", "", "render the gravatar of an email address")
 	flag.StringVar(&HTTPUserAgent, "useragent", "", "user-agent header override for images fetched over http")
 	flag.StringVar(&fopts.Pad, "pad", " ", "specify text to pad output lines on the left")
+	flag.BoolVar(&fopts.Histogram, "show-histogram", false, "draw a color histogram below the image")
+	flag.BoolVar(&fopts.BidiIsolate, "bidi-isolate", false, "wrap output lines in left-to-right isolates for terminals with bidi support")
 	flag.BoolVar(&fopts.Animate, "animate", false, "animate images")
 	flag.IntVar(&fopts.Repeat, "repeat", -1, "number of animated loops")
 	flag.IntVar(&fopts.Delay, "delay", 0, "for -animate, force delay in milliseconds before the next frame")
-	flag.BoolVar(&Debug, "debug", false, "print debug information")
-	flag.Parse()
+	flag.DurationVar(&fopts.MinDelay, "min-delay", 0, "for -animate, lengthen frame delays shorter than the given duration, keeping longer ones")
+	animation := flag.String("animation", "auto", "for -animate, how frames are positioned (auto, cursor, region)")
+	passthrough := flag.String("passthrough", "auto", "wrap sequences GNU screen does not support so they reach the terminal (auto, on, off)")
+	syncOutput := flag.String("sync", "auto", "for -animate, draw frames as synchronized updates (auto, on, off)")
+	flag.DurationVar(&fopts.GIFShortDelay, "gif-short-delay", GIFShortDelayDefault, "delay of GIF frames with a delay of 0 or 1 centiseconds, as browsers draw them (0 keeps the delay in the file)")
+	flag.BoolVar(&fopts.Throttle, "throttle", true, "for -animate, lengthen frame delays that would write output faster than the terminal can keep up with")
+	flag.BoolVar(&fopts.Deterministic, "deterministic", false, "produce identical output for identical inputs: no terminal queries or environment detection, and frames written without pacing")
+	flag.BoolVar(&TerminalCache, "terminal-cache", true, "save terminal query responses in the config directory so each terminal is only queried once")
+	assumeRemote := flag.String("assume-remote", "auto", "treat the session as remote: no terminal queries and at most 15 frames per second (auto detects SSH, on, off)")
+	flag.BoolVar(&fopts.Partial, "partial-frames", true, "for -animate, redraw only the region of each GIF frame which changed, as given by the GIF")
+	progressive := flag.Bool("progressive", false, "for still images fetched over http, draw a coarse image from progressive JPEGs and PNGs while they download")
+	flag.BoolVar(&fopts.Interlace, "interlace", false, "for still images, draw even lines and then odd lines so the image appears sooner over slow connections")
+	baud := flag.Int("baud", 0, "pace output to the throughput of a serial line with the given baud rate")
+	flag.Var((*byteSize)(&fopts.MaxFrameBytes), "max-frame-bytes", "reduce the quality of frames whose output exceeds the given size (e.g. 64K) until they fit: merging similar cells, reducing colors, then shrinking the image")
+	flag.StringVar(&fopts.Render, "render", RenderBackground, "color cells with background colors or with foreground colored blocks (background, foreground)")
+	flag.StringVar(&fopts.Blocks, "blocks", BlocksFull, "draw one pixel in each cell, or two pixels with half blocks doubling the vertical resolution (full, half)")
+	charset := flag.String("charset", CharsetUTF8, "glyphs which may be written: any as UTF-8, code page 437 for BBS software, or only ASCII (utf8, latin1, ascii)")
+	flag.StringVar(&fopts.CursorAfter, "cursor-after", CursorBelow, "where to leave the cursor after drawing (below, right, save-restore)")
+	interactive := flag.Bool("interactive", false, "inspect the source pixels of the image with the mouse or arrow keys")
+	dryRun := flag.Bool("dry-run", false, "render a single loop and print output statistics instead of the image")
+	dumpState := flag.String("dump-state", "", "save the output, environment and terminal detection results to a tar archive at the given path, for bug reports")
+	debugStages := flag.Bool("debug-stages", false, "periodically print statistics for each pipeline stage")
+	flag.IntVar(&PipelineBuffer, "pipeline-buffer", 0, "number of frames each pipeline stage may buffer ahead of the next")
+	verbose := flag.Bool("v", false, "log informational messages")
+	veryVerbose := flag.Bool("vv", false, "log debug messages")
+	debug := flag.Bool("debug", false, "same as -vv")
+	logFormat := flag.String("log-format", LogText, "format of log messages (text, json)")
+	flag.Var(LogLevels, "log-level", "minimum level of logged messages, per module if given as module=level, e.g. info,http=debug (modules: http, decode, render)")
+	flag.CommandLine.Parse(args)
+	if *profile != "" {
+		err := applyProfile(flag.CommandLine, *profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	handler, err := newLogHandler(os.Stderr, *logFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logOutput = handler
+	switch {
+	case *veryVerbose || *debug:
+		LogLevels.lower(slog.LevelDebug)
+	case *verbose:
+		LogLevels.lower(slog.LevelInfo)
+	}
+	if *debugStages {
+		// statistics are logged by the render module.
+		LogLevels.lower(slog.LevelInfo, logRender)
+	}
+	if *previewMode {
+		err = setUnsetFlags(flag.CommandLine, previewFlags)
+		if err != nil {
+			log.Fatal(err)
+		}
+		path, w, h, err := parsePreviewArgs(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if w > 0 {
+			*width, *height, *cols = w, h, 0
+		}
+		if *useDaemon && w > 0 && *paletteName != ColorAuto {
+			abs, err := filepath.Abs(path)
+			if err == nil {
+				err = requestPreview(os.Stdout, *daemonSocket, &daemonRequest{
+					Path:       abs,
+					Width:      w,
+					Height:     h,
+					Color:      *paletteName,
+					FontAspect: *fontAspect,
+				})
+			}
+			if err == nil {
+				return
+			}
+			logger(logRender).Debug("preview not drawn by the daemon", "err", err)
+		}
+		// the remaining arguments are read with flag.Args.
+		flag.CommandLine.Parse([]string{"--", path})
+		fopts.Animate = false
+	}
 	if *useStdin && flag.NArg() > 0 {
 		log.Fatal("no arguments are expected when -stdin provided")
 	}
+	if (*favicon != "" || *gravatar != "") && (*useStdin || flag.NArg() > 0) {
+		log.Fatal("no arguments are expected when -favicon or -gravatar provided")
+	}
 
 	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt)
 	// TODO: Should done be called in a smarter way?
 	defer done()
+	ctx, stop := withPipelineErrors(ctx)
+	defer stop()
 	defer func() {
 		if ctx.Err() != nil {
 			io.WriteString(os.Stdout, ANSIClear)
-			log.Fatal(ctx.Err())
+			log.Fatal(context.Cause(ctx))
 		}
 	}()
 
-	AlphaThreshold = uint32(*alphaThreshold * float64(0xffff))
+	if *wrapTextPath != "" {
+		b, err := os.ReadFile(*wrapTextPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fopts.Text = strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+		switch *textSide {
+		case "left":
+			fopts.TextLeft = true
+		case "right":
+		default:
+			log.Fatalf("text side not one of %q", []string{"left", "right"})
+		}
+		if w, _, err := getTermDim(); err == nil {
+			fopts.TextWidth = w - 1
+		}
+	}
+
+	switch *merge {
+	case MergeSequential:
+	case MergeConcurrent:
+		if *panes == "" && flag.NArg() > 1 {
+			*panes = autoPaneLayout(flag.NArg()).String()
+		}
+	default:
+		log.Fatalf("merge not one of %q", []string{MergeSequential, MergeConcurrent})
+	}
+	if *kenBurns > 0 || *panes != "" || *pipPath != "" || *refresh > 0 {
+		fopts.Animate = true
+	}
+	if *dryRun {
+		fopts.Repeat = 0
+	}
+	if fopts.Deterministic {
+		// settings which would be detected from the terminal or environment
+		// are fixed instead.
+		TerminalQueries = false
+		for _, auto := range []*string{assumeRemote, passthrough} {
+			if *auto == "auto" {
+				*auto = "off"
+			}
+		}
+		if *paletteName == ColorAuto {
+			*paletteName = "256"
+		}
+		if *protocol == ProtocolAuto {
+			*protocol = ProtocolANSI
+		}
+		if *themeName == ThemeAuto {
+			*themeName = ThemeDark
+		}
+		if *seed == 0 {
+			*seed = 1
+		}
+	}
+
+	remote := false
+	switch *assumeRemote {
+	case "auto":
+		remote = sshRemote()
+	case "on":
+		remote = true
+	case "off":
+	default:
+		log.Fatalf("assume-remote not one of %q", []string{"auto", "on", "off"})
+	}
+	if remote {
+		TerminalQueries = false
+		fopts.MinDelay = max(fopts.MinDelay, RemoteMinDelay)
+		logger(logRender).Info("remote session: terminal queries disabled", "min_delay", fopts.MinDelay)
+	}
+
+	switch *passthrough {
+	case "auto":
+		fopts.Passthrough = inScreen()
+	case "on":
+		fopts.Passthrough = true
+	case "off":
+	default:
+		log.Fatalf("passthrough not one of %q", []string{"auto", "on", "off"})
+	}
+
+	// the terminal is only queried when output is an animation drawn to it.
+	// GNU screen answers queries itself, so the outer terminal is not queried.
+	canSync := false
+	if !fopts.Passthrough && fopts.Animate && !*dryRun && *sinkURL == "" && *execPerFrame == "" && *outputFormat == OutputANSI &&
+		terminal.IsTerminal(int(os.Stdout.Fd())) && (*animation == "auto" || *syncOutput == "auto") {
+		canSync = syncModeSupported()
+	}
+
+	switch *animation {
+	case "auto":
+		fopts.Strategy = StrategyCursor
+		if canSync {
+			fopts.Strategy = StrategyRegion
+		}
+	case StrategyCursor, StrategyRegion:
+		fopts.Strategy = *animation
+	default:
+		log.Fatalf("animation strategy not one of %q", []string{"auto", StrategyCursor, StrategyRegion})
+	}
+
+	switch fopts.CursorAfter {
+	case CursorBelow, CursorRight, CursorSaveRestore:
+	default:
+		log.Fatalf("cursor placement not one of %q", []string{CursorBelow, CursorRight, CursorSaveRestore})
+	}
+
+	switch fopts.Render {
+	case RenderBackground:
+	case RenderForeground:
+		if p := selftestProfile(); p != nil && !p.Blocks {
+			logger(logRender).Warn("block glyphs did not fill their cells in selftest", "profile", loadedProfilePath)
+		}
+	default:
+		log.Fatalf("render mode not one of %q", []string{RenderBackground, RenderForeground})
+	}
+
+	switch fopts.Blocks {
+	case BlocksFull:
+	case BlocksHalf:
+		if p := selftestProfile(); p != nil && !p.Blocks {
+			logger(logRender).Warn("block glyphs did not fill their cells in selftest", "profile", loadedProfilePath)
+		}
+	default:
+		log.Fatalf("block mode not one of %q", []string{BlocksFull, BlocksHalf})
+	}
+
+	switch *charset {
+	case CharsetUTF8, CharsetLatin1:
+	case CharsetASCII:
+		if fopts.Blocks == BlocksHalf {
+			log.Fatal("-blocks=half draws half blocks, which -charset=ascii cannot write")
+		}
+	default:
+		log.Fatalf("charset not one of %q", []string{CharsetUTF8, CharsetLatin1, CharsetASCII})
+	}
+
+	if fopts.Interlace {
+		_, h, err := getTermDim()
+		if err != nil {
+			logger(logRender).Debug("interlacing disabled", "err", err)
+			fopts.Interlace = false
+		}
+		// the line below the image scrolls the screen.
+		fopts.InterlaceRows = h - 1
+	}
+
+	switch *syncOutput {
+	case "auto":
+		fopts.Sync = canSync
+	case "on":
+		fopts.Sync = true
+	case "off":
+	default:
+		log.Fatalf("sync not one of %q", []string{"auto", "on", "off"})
+	}
+
+	AlphaThreshold = alphaMin.threshold(0)
+	if len(alphaMin) > 1 {
+		// AlphaFrames makes each input either transparent or opaque, only
+		// pixels blended by resizing are left to the global threshold.
+		AlphaThreshold = 0x8000
+	}
+
+	if *cols > 0 {
+		if *width > 0 {
+			log.Fatal("only one of -width and -cols may be provided")
+		}
+		*width = *cols - 2*fopts.padWidth()
+		if *width < 1 {
+			log.Fatal("-cols leaves no room for the image beside -pad")
+		}
+	}
+
+	if PipelineBuffer < 0 {
+		log.Fatal("pipeline buffer must not be negative")
+	}
+
+	switch *target {
+	case "":
+	case TargetVT340:
+		if *protocol != ProtocolAuto && *protocol != ProtocolSixel {
+			log.Fatal("-target=vt340 requires -protocol=sixel")
+		}
+		*protocol = ProtocolSixel
+		SixelColors = vt340Colors
+		if *paletteName == ColorAuto {
+			// the terminal is not asked for its colors, the sixel
+			// encoder reduces them to its registers.
+			*paletteName = "truecolor"
+		}
+	default:
+		log.Fatalf("target not one of %q", []string{TargetVT340})
+	}
+
+	reasons := []string{fmt.Sprintf("-color=%s given explicitly", *paletteName)}
+	if *paletteName == ColorAuto {
+		// pixels are only drawn to a terminal, as cells of the full
+		// block glyph.
+		noGraphics := ""
+		switch {
+		case *protocol != ProtocolAuto:
+			noGraphics = fmt.Sprintf("-protocol=%s given explicitly", *protocol)
+		case *outputFormat != OutputANSI || fopts.Blocks == BlocksHalf || *interactive || command == "pick":
+			noGraphics = "pixels cannot be drawn with -output-format, -blocks, -interactive or pick"
+		case *sinkURL != "" || *execPerFrame != "" || *dryRun:
+			noGraphics = "output is not drawn by a terminal"
+		}
+		var detected string
+		*paletteName, detected, reasons = detectPalette(noGraphics)
+		if *protocol == ProtocolAuto {
+			*protocol = detected
+		}
+	}
+	if *protocol == ProtocolAuto {
+		*protocol = ProtocolANSI
+	}
+	if *why {
+		for _, r := range reasons {
+			log.Print("why: ", r)
+		}
+		log.Print("why: using palette ", *paletteName)
+		log.Print("why: using protocol ", *protocol)
+	}
+	// coarse frames are only drawn over one another by the terminal, other
+	// outputs would receive each as an image.
+	fopts.Progressive = *progressive && !*dryRun && *sinkURL == "" && *execPerFrame == "" && *outputFormat == OutputANSI && *protocol == ProtocolANSI
 
 	palette := ansiPalettes[*paletteName]
 	if palette == nil {
 		log.Fatalf("color palette not one of %q", ANSIPalettes())
 	}
 
+	var theme *Theme
+	themeReason := fmt.Sprintf("-theme=%s given explicitly", *themeName)
+	switch *themeName {
+	case ThemeAuto:
+		theme, themeReason = detectTheme()
+	case ThemeDark, ThemeLight:
+		theme = newTheme(*themeName)
+	default:
+		log.Fatalf("theme not one of %q", []string{ThemeAuto, ThemeDark, ThemeLight})
+	}
+	if *why {
+		log.Print("why: ", themeReason)
+		log.Print("why: using theme ", theme.Name)
+	}
+	LetterboxFill = theme.Background
+	err = saveTerminalCache()
+	if err != nil {
+		logger(logRender).Warn("terminal cache not saved", "err", err)
+	}
+
+	var dump *stateDump
+	if *dumpState != "" {
+		dump = newStateDump(*dumpState)
+		dump.Palette, dump.PaletteReasons = *paletteName, reasons
+		dump.Theme, dump.ThemeReason = theme.Name, themeReason
+		dump.Remote = remote
+		dump.Passthrough = fopts.Passthrough
+		dump.Sync = fopts.Sync
+		dump.Strategy = fopts.Strategy
+		defer func() {
+			err := dump.Save(flag.CommandLine)
+			if err != nil {
+				log.Fatalf("dump-state: %v", err)
+			}
+		}()
+	}
+
+	if *outputFormat != OutputANSI && *outputFormat != OutputJSONCells {
+		log.Fatalf("output format not one of %q", []string{OutputANSI, OutputJSONCells})
+	}
+
+	switch *protocol {
+	case ProtocolANSI:
+	case ProtocolSixel, ProtocolKitty, ProtocolITerm2:
+		if *outputFormat != OutputANSI || fopts.Blocks == BlocksHalf {
+			log.Fatalf("-protocol=%s cannot be used with -output-format or -blocks", *protocol)
+		}
+	default:
+		log.Fatalf("protocol not one of %q", []string{ProtocolANSI, ProtocolSixel, ProtocolKitty, ProtocolITerm2})
+	}
+	if place.Col > 0 {
+		if *protocol != ProtocolKitty {
+			log.Fatal("-place requires -protocol=kitty")
+		}
+		// placed images take no lines for a scroll region to hold.
+		fopts.Strategy = StrategyCursor
+	}
+	if *imageID > 0 {
+		if *protocol != ProtocolKitty {
+			log.Fatal("-image-id requires -protocol=kitty")
+		}
+		if *imageID > math.MaxUint32 {
+			log.Fatalf("-image-id must be at most %d", uint32(math.MaxUint32))
+		}
+		place.ID = uint32(*imageID)
+	}
+
+	var transition Transition
+	if *transitionName != "" {
+		transition = frameTransitions[*transitionName]
+		if transition == nil {
+			log.Fatalf("transition not one of %q", FrameTransitions())
+		}
+	}
+	if *refresh > 0 {
+		if flag.NArg() != 1 || *useStdin {
+			log.Fatal("-refresh requires a single input path or URL")
+		}
+		if *controlPath != "" {
+			log.Fatal("-refresh cannot be used with -control")
+		}
+	}
+
+	validPiPPos := false
+	for _, pos := range pipPositions {
+		validPiPPos = validPiPPos || pos == *pipPos
+	}
+	if !validPiPPos {
+		log.Fatalf("pip position not one of %q", pipPositions)
+	}
+
+	DefaultScaler = scalers[*scalerName]
+	if DefaultScaler == nil {
+		log.Fatalf("scaler not one of %q", Scalers())
+	}
+
+	var mask *BlueNoise
+	switch *dither {
+	case "", "none":
+	case DitherBlueNoise:
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		mask = NewBlueNoise(*seed)
+	case DitherFloydSteinberg:
+	default:
+		log.Fatalf("dither not one of %q", []string{"none", DitherBlueNoise, DitherFloydSteinberg})
+	}
+	if *dither == "none" {
+		*dither = ""
+	}
+
+	var toneMap ToneMap
+	if *toneMapName != "" && *toneMapName != "none" {
+		toneMap = toneMaps[*toneMapName]
+		if toneMap == nil {
+			log.Fatalf("tone map not one of %q", ToneMaps())
+		}
+	}
+
+	var effect Effect
+	if *effectName != "" {
+		effect = frameEffects[*effectName]
+		if effect == nil {
+			log.Fatalf("effect not one of %q", FrameEffects())
+		}
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -104,27 +662,419 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	frames, err := decodeFramesArgs(ctx, *useStdin, flag.Args(), fopts)
+	if *screensaver != "" {
+		err := runScreensaver(ctx, *screensaver, *screensaverInterval, *fontAspect, palette)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *panes != "" {
+		layout, err := parsePaneLayout(*panes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = runPanes(ctx, flag.Args(), layout, *fontAspect, palette, fopts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if !*useStdin && flag.NArg() > 0 {
+		all := true
+		for _, arg := range flag.Args() {
+			all = all && ansiInputPath(arg)
+		}
+		*ansiInput = *ansiInput || all
+	}
+	if *ansiInput {
+		input, err := decodeANSIArgs(*useStdin, flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, sink, err := openOutput(ctx, *execPerFrame, *sinkURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sink != nil {
+			defer sink.Close()
+		}
+		if out == nil {
+			log.Fatalf("sink %s draws images, not ANSI input", *sinkURL)
+		}
+		ansiFrames, closeRecording, err := recordFrames(ctx, *recordPath, PlayANSIFrames(ctx, input, fopts), fopts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeRecording()
+		err = drawANSIFrames(ctx, dump.Writer(terminalWriter(ctx, out, *charset, *baud)), ansiFrames, fopts)
+		if err != nil {
+			log.Fatal(pipelineError(ctx, err))
+		}
+		return
+	}
+
+	var frames <-chan *Frame
+	stdinData := false // image data is read from stdin, which cannot also be read for keys
+	switch {
+	case *favicon != "":
+		frames, err = decodeFramesFavicon(ctx, *favicon, fopts)
+	case *gravatar != "":
+		frames, err = decodeFramesHTTP(ctx, gravatarURL(*gravatar), fopts)
+	case command == "gen":
+		frames, err = decodeFramesGen(flag.Args())
+	case command == "pick":
+		if flag.NArg() != 1 || *useStdin {
+			log.Fatal("usage: img2ansi pick [flags] image")
+		}
+		*interactive = true
+		frames, err = decodeFramesURL(ctx, flag.Arg(0), fopts)
+	default:
+		stdinData = *useStdin || flag.NArg() == 0
+		frames, err = decodeFramesArgs(ctx, *useStdin, flag.Args(), fopts)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if *scaleToTerm {
+	if *scaleToTerm || (*interactive && *width == 0 && *height == 0) {
 		*width, *height, err = dimensionsFromTerminal(fopts)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if !fopts.Animate && !*interactive && *reserveRows > 1 {
+			// dimensionsFromTerminal leaves one line free, the cursor
+			// position after drawing.  leave more so a multi-line shell
+			// prompt does not scroll the top of the image away.
+			*height -= *reserveRows - 1
+			if *height < 1 {
+				*height = 1
+			}
+		}
+	}
+
+	*width, *height = factor.scale(*width, *height)
+
+	if *noUpscale && (MaxScale <= 0 || MaxScale > 1) {
+		MaxScale = 1
+	}
+
+	if *smartCrop {
+		*cover = true
+	}
+	if *cover && (*width <= 0 || *height <= 0) {
+		log.Fatal("-cover and -smart-crop require -scale or both -width and -height")
+	}
+
+	if *interactive {
+		if fopts.Blocks == BlocksHalf || *protocol != ProtocolANSI {
+			log.Fatal("-blocks=half and -protocol cannot be used with -interactive or pick")
+		}
+		// the status line is drawn below the image.
+		*height--
+		pick := command == "pick"
+		err = inspectFrames(ctx, os.Stdout, frames, *width, *height, *fontAspect, palette, fopts, pick)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *debugStages {
+		fopts.StageHook = logStageStats
 	}
-	scaledFrames := ResizeFrames(ctx, *width, *height, *fontAspect, frames)
+	monitor := newStageMonitor(ctx, fopts)
+	fopts.monitor = monitor
+	defer monitor.Report()
 
-	loopedFrames := LoopFrames(ctx, scaledFrames, fopts)
+	frames = monitorFrames(ctx, monitor, "decode", frames)
+
+	var tileWidth, tileHeight int
+	if *tile {
+		tileWidth, tileHeight, err = dimensionsFromTerminal(fopts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if fopts.Blocks == BlocksHalf {
+		// each line draws two rows of pixels, which are twice as wide,
+		// relative to their height, as a cell.
+		*height *= 2
+		tileHeight *= 2
+		*fontAspect *= 2
+	}
+	var cell image.Point
+	if *protocol != ProtocolANSI {
+		// frames are sized in pixels, which are square.
+		cell = cellSize()
+		if *target == TargetVT340 {
+			cell = vt340Cell
+		}
+		*width, *height = *width*cell.X, *height*cell.Y
+		tileWidth, tileHeight = tileWidth*cell.X, tileHeight*cell.Y
+		if *target == TargetVT340 {
+			if *width == 0 && *height == 0 && MaxScale <= 0 {
+				// images are shrunk to fit the screen, not enlarged
+				// to fill it.
+				MaxScale = 1
+			}
+			*width, *height = fitVT340(*width, *height)
+		}
+		*fontAspect = 1
+		if place.X >= cell.X || place.Y >= cell.Y {
+			log.Fatalf("-place offsets must be smaller than a cell, %dx%d pixels", cell.X, cell.Y)
+		}
+	}
 
-	ansiFrames := writeANSIFrames(ctx, loopedFrames, palette, fopts)
+	var pip []*Frame
+	if *pipPath != "" {
+		pipFrames, err := decodeFramesURL(ctx, *pipPath, fopts)
+		if err != nil {
+			log.Fatalf("decoding pip image: %v", err)
+		}
+		for f := range pipFrames {
+			pip = append(pip, f)
+		}
+	}
 
-	err = drawANSIFrames(ctx, os.Stdout, ansiFrames, fopts)
+	var deadline time.Time
+	if *previewMode {
+		deadline = debugProcStartTime.Add(PreviewBudget)
+	}
+	// frames are prepared for playback by the stages before play, which run
+	// again for each image loaded with -control.
+	prepare := NewPipeline(monitor).SetDeadline(deadline)
+	prepare.AddOptional(toneMap != nil, "tonemap", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return ToneMapFrames(ctx, toneMap, frames)
+	})
+	prepare.Add("alpha", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return AlphaFrames(ctx, alphaMin, frames)
+	})
+	prepare.AddOptional(*removeBG, "remove-bg", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return RemoveBackgroundFrames(ctx, *removeBGTolerance, frames)
+	})
+	prepare.Add("theme", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return WarnContrastFrames(ctx, theme, frames)
+	})
+	prepare.Add("kenburns", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return KenBurnsFrames(ctx, *kenBurns, frames)
+	})
+	prepare.AddIf(*cover, "crop", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return CropFrames(ctx, *width, *height, *fontAspect, *smartCrop, frames)
+	})
+	prepare.Add("resize", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return ResizeFrames(ctx, *width, *height, *fontAspect, frames)
+	})
+	prepare.AddIf(*width == 0 && *height == 0, "scale", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return ScaleFrames(ctx, factor, *fontAspect, frames)
+	})
+	prepare.AddIf(*contrast != 1, "contrast", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return ContrastFrames(ctx, *contrast, frames)
+	})
+	prepare.AddIf(*tile, "tile", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return TileFrames(ctx, tileWidth, tileHeight, *tileMirror, frames)
+	})
+	if fopts.Animate && interpolate > 1 && fopts.Delay > 0 {
+		// -delay replaces the delays interpolation divides, so it is
+		// divided as well.
+		fopts.Delay = max(fopts.Delay/int(interpolate), 1)
+	}
+	prepare.AddIf(fopts.Animate && interpolate > 1, "interpolate", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return InterpolateFrames(ctx, int(interpolate), fopts.Repeat != 0, frames)
+	})
+	prepare.Add("transition", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return TransitionFrames(ctx, transition, *transitionDuration, frames)
+	})
+	if *execPerLoop != "" {
+		fopts.LoopHook = execLoopHook(ctx, *execPerLoop)
+	}
+	player := NewPlayer(fopts)
+	fopts.player = player
+	if *refresh > 0 {
+		// each image is held until it is refreshed.
+		player.Hold()
+	}
+	pipeline := NewPipeline(monitor).SetDeadline(deadline)
+	pipeline.Add("play", player.Play)
+	pipeline.AddIf(*refresh > 0, "refresh-transition", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		// refreshed images are not retained by the player, so the
+		// transition to each is made after it, and fades unless another
+		// transition is given.
+		t := transition
+		if t == nil {
+			t = TransitionFade{}
+		}
+		return TransitionFrames(ctx, t, *transitionDuration, frames)
+	})
+	pipeline.Add("pip", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return PiPFrames(ctx, pip, *pipPos, *fontAspect, frames)
+	})
+	if fopts.Animate && (!fopts.Deterministic || *controlPath != "") {
+		// frames retained by the player are dithered and encoded with
+		// settings that can change during playback.  Deterministic output
+		// has no playback keys but may still be driven over -control.
+		fopts.live = newLiveOptions(player, palette, *paletteName, *dither, mask, *seed, fopts.Render)
+		pipeline.Add("dither", fopts.live.DitherFrames)
+	} else {
+		pipeline.AddOptional(*dither != "", "dither", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			if *dither == DitherFloydSteinberg {
+				return DiffuseFrames(ctx, palette, frames)
+			}
+			return DitherFrames(ctx, mask, ditherSpread(palette), frames)
+		})
+	}
+	// effects transform the colors of quantized cells, after dithering.
+	pipeline.Add("effect", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return EffectFrames(ctx, effect, fopts.Animate, func() ANSIPalette { return fopts.live.Palette(palette) }, frames)
+	})
+	prepareCtx, cancelPrepare := context.WithCancel(ctx)
+	defer cancelPrepare()
+	effectFrames := pipeline.Run(ctx, prepare.Run(prepareCtx, frames))
+
+	if *controlPath != "" {
+		if fopts.live == nil {
+			log.Fatal("-control requires -animate")
+		}
+		source := strings.Join(flag.Args(), " ")
+		control, err := listenControl(*controlPath, player, prepare.Run, source, cancelPrepare, fopts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer control.Close()
+		go control.Serve(ctx)
+	}
+	if *refresh > 0 {
+		r := &refresher{
+			url:      flag.Arg(0),
+			interval: *refresh,
+			player:   player,
+			prepare:  prepare.Run,
+			fopts:    fopts,
+			cancel:   cancelPrepare,
+		}
+		go r.Run(ctx)
+	}
+
+	out, sink, err := openOutput(ctx, *execPerFrame, *sinkURL)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if sink != nil {
+		defer sink.Close()
+	}
+	if sink, ok := sink.(PixelSink); ok {
+		err = drawSinkFrames(ctx, sink, effectFrames, palette, *serpentine, fopts)
+		if err != nil {
+			log.Fatal(pipelineError(ctx, err))
+		}
+		return
+	}
+
+	if *outputFormat == OutputJSONCells {
+		err = writeJSONFrames(ctx, dump.Writer(out), effectFrames, palette, fopts)
+		if err != nil {
+			log.Fatal(pipelineError(ctx, err))
+		}
+		return
+	}
+
+	var ansiFrames <-chan *ANSIFrame
+	switch *protocol {
+	case ProtocolSixel:
+		ansiFrames = writeSixelFrames(ctx, effectFrames, cell, theme.Background, fopts)
+	case ProtocolITerm2:
+		ansiFrames = writeITerm2Frames(ctx, effectFrames, cell, theme.Background, fopts)
+	case ProtocolKitty:
+		ansiFrames = writeKittyFrames(ctx, effectFrames, cell, &place, fopts)
+	default:
+		ansiFrames = writeANSIFrames(ctx, effectFrames, palette, fopts)
+	}
+	ansiFrames = monitorFrames(ctx, monitor, "encode", ansiFrames)
+
+	if *dryRun {
+		if *baud > 0 {
+			dryRunBauds = []int{*baud}
+		}
+		err = dryRunFrames(ctx, dump.Writer(os.Stdout), ansiFrames, fopts)
+		if err != nil {
+			log.Fatal(pipelineError(ctx, err))
+		}
+		return
+	}
+
+	ansiFrames, closeRecording, err := recordFrames(ctx, *recordPath, ansiFrames, fopts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeRecording()
+
+	if fopts.Animate && !fopts.Deterministic && out == os.Stdout && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		// keys are read with the terminal in raw mode.
+		controls, err := openPlaybackControls(player, stdinData)
+		if err != nil {
+			logger(logRender).Debug("keyboard controls disabled", "err", err)
+		} else {
+			fopts.controls = controls
+			controls.live = fopts.live
+			defer fopts.controls.Stop()
+			out = &crlfWriter{w: out}
+		}
+	}
+	err = drawANSIFrames(ctx, dump.Writer(terminalWriter(ctx, out, *charset, *baud)), ansiFrames, fopts)
+	if err != nil {
+		log.Fatal(pipelineError(ctx, err))
+	}
+}
+
+// openOutput returns the writer frames are drawn to: the ANSI sink at
+// sinkURL, the command execPerFrame run for each frame, or standard output.
+// The sink, if any, is also returned so that it can be closed.  A sink
+// displaying pixels is returned with a nil writer.
+func openOutput(ctx context.Context, execPerFrame, sinkURL string) (io.Writer, Sink, error) {
+	if sinkURL != "" {
+		sink, err := openSink(ctx, sinkURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		if w, ok := sink.(ANSISink); ok {
+			return w, sink, nil
+		}
+		return nil, sink, nil
+	}
+	if execPerFrame != "" {
+		return newExecFrameWriter(ctx, execPerFrame), nil, nil
+	}
+	return os.Stdout, nil, nil
+}
+
+// terminalWriter wraps out, limiting the glyphs written to charset and
+// pacing output to baud bits per second if baud is positive.
+func terminalWriter(ctx context.Context, out io.Writer, charset string, baud int) io.Writer {
+	out = newCharsetWriter(out, charset)
+	if baud > 0 {
+		out = newBaudWriter(ctx, out, baud)
+	}
+	return out
+}
+
+// recordFrames records frames to the file at path as they pass, if path is
+// not empty.  The returned function waits for the recording to be saved,
+// logging any error.
+func recordFrames(ctx context.Context, path string, frames <-chan *ANSIFrame, opts *FrameOptions) (<-chan *ANSIFrame, func(), error) {
+	if path == "" {
+		return frames, func() {}, nil
+	}
+	rec, err := openRecorder(path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rec.Tee(ctx, frames), func() {
+		err := rec.Close()
+		if err != nil {
+			logger(logRender).Error("recording not saved", "path", path, "err", err)
+		}
+	}, nil
 }
 
 func dimensionsFromTerminal(fopts *FrameOptions) (int, int, error) {
@@ -132,12 +1082,10 @@ func dimensionsFromTerminal(fopts *FrameOptions) (int, int, error) {
 	if err != nil {
 		return 0, 0, fmt.Errorf("terminal dimensions: %w", err)
 	}
-	if Debug {
-		log.Printf("terminal dimensions: %d x %d", w, h)
-	}
+	logger(logRender).Debug("terminal dimensions", "width", w, "height", h)
 
 	// correct for wrap/overflow due to newlines and padding.
-	w -= len(fopts.Pad)
+	w -= 2 * fopts.padWidth()
 	w -= 1
 	h -= 1
 
@@ -148,92 +1096,92 @@ type Frame struct {
 	Image     image.Image
 	Delay     time.Duration
 	LoopCount int
+
+	// Source is the index of the input the frame was decoded from.
+	Source int
+
+	// Index is the position of the frame in its input, counting from 1, or
+	// 0 for frames made by stages.  Changed is the region of Image which
+	// differs from the frame before it in the input, or empty if the region
+	// is unknown.  Stages which move pixels or change them depending on
+	// other pixels must scale or clear Changed.
+	Index   int
+	Changed image.Rectangle
+
+	// Preview is true for a coarse frame decoded from part of an image which
+	// is still downloading.  The next frame is drawn in its place.
+	Preview bool
+
+	// LoopEnd, if positive, is the loop of the Player which ends before the
+	// frame is drawn.
+	LoopEnd int
 }
 
 type ANSIFrame struct {
 	Buffer    *frameBuffer
+	Rows      int
+	Cols      int // columns up to the right edge of the image
 	Delay     time.Duration
 	LoopCount int
+	LoopEnd   int
 }
 
-func LoopFrames(ctx context.Context, frames <-chan *Frame, fopts *FrameOptions) <-chan *Frame {
-	var allFrames []*Frame
-	looped := make(chan *Frame)
-	go func() {
-		defer close(looped)
-
-	collectFrames:
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case f, ok := <-frames:
-				if !ok {
-					break collectFrames
-				}
-				allFrames = append(allFrames, f)
-				select {
-				case <-ctx.Done():
-					return
-				case looped <- f:
-				}
-			}
-		}
-
-		if len(allFrames) == 0 {
-			return
+func ResizeFrames(ctx context.Context, width, height int, fontAspect float64, frames <-chan *Frame) <-chan *Frame {
+	if width == 0 && height == 0 {
+		return frames
+	}
+	// the frames of an animation almost always share their dimensions so
+	// the target size is only computed when they change.
+	var sizeOrig, size, area image.Point
+	// resize the images to the proper size and aspect ratio
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		img := f.Image
+		if img.Bounds().Size() != sizeOrig {
+			sizeOrig = img.Bounds().Size()
+			size, area = sizeTarget(sizeOrig, width, height, fontAspect)
 		}
-
-		numloop := allFrames[0].LoopCount
-		if fopts.Repeat >= 0 {
-			numloop = fopts.Repeat
-		} else if fopts.Repeat < 0 {
-			numloop = -1
+		changed := f.Changed.Sub(img.Bounds().Min)
+		if size != sizeOrig {
+			img = scaleImage(img, size.X, size.Y)
+			changed = scaleRect(changed, sizeOrig, size)
 		}
-
-		for n := 0; n != numloop; n++ {
-			for _, f := range allFrames {
-				select {
-				case <-ctx.Done():
-					return
-				case looped <- f:
-				}
-			}
+		if area != size {
+			img = centerImage(img, area)
+			changed = changed.Add(area.Sub(size).Div(2))
 		}
-	}()
-	return looped
+		return &Frame{
+			Image:     img,
+			Delay:     f.Delay,
+			LoopCount: f.LoopCount,
+			Source:    f.Source,
+			Index:     f.Index,
+			Changed:   changed,
+			Preview:   f.Preview,
+		}, nil
+	})
 }
 
-func ResizeFrames(ctx context.Context, width, height int, fontAspect float64, frames <-chan *Frame) <-chan *Frame {
-	if width == 0 && height == 0 {
+// ScaleFrames resizes frames to factor times their size in cells.  Frames
+// are normalized for fontAspect before factor is applied.  If factor is 1x1
+// the frames are not modified.
+func ScaleFrames(ctx context.Context, factor scaleFactor, fontAspect float64, frames <-chan *Frame) <-chan *Frame {
+	if factor.X == 1 && factor.Y == 1 {
 		return frames
 	}
-	scaled := make(chan *Frame)
-	go func() {
-		defer close(scaled)
-		// resize the images to the proper size and aspect ratio
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case f, ok := <-frames:
-				if !ok {
-					return
-				}
-				img := f.Image
-				sizeOrig := img.Bounds().Size()
-				size := sizeRect(sizeOrig, width, height, fontAspect)
-				if size != sizeOrig { // it is super unlikely for this to happen
-					img = resize.Resize(uint(size.X), uint(size.Y), img, 0)
-				}
-				scaled <- &Frame{
-					Image: img,
-					Delay: f.Delay,
-				}
-			}
-		}
-	}()
-	return scaled
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		size := sizeNormal(f.Image.Bounds().Size(), fontAspect)
+		w, h := factor.scale(size.X, size.Y)
+		changed := f.Changed.Sub(f.Image.Bounds().Min)
+		return &Frame{
+			Image:     scaleImage(f.Image, w, h),
+			Delay:     f.Delay,
+			LoopCount: f.LoopCount,
+			Source:    f.Source,
+			Index:     f.Index,
+			Changed:   scaleRect(changed, f.Image.Bounds().Size(), image.Pt(w, h)),
+			Preview:   f.Preview,
+		}, nil
+	})
 }
 
 type DecodeOptions struct {
@@ -246,7 +1194,7 @@ type FrameOptions struct {
 	// Delay is the time to wait between animating frames.
 	Delay int
 
-	// Pad is a string prepended to each row of pixels.
+	// Pad is a string written before and after each row of pixels.
 	Pad string
 
 	// Animate will animate the frames when true.  Animation is accomplished by
@@ -254,24 +1202,148 @@ type FrameOptions struct {
 	// frame.
 	Animate bool
 
+	// Text is printed beside the image, one line per row of pixels.  Lines
+	// that do not fit beside the image continue below it.
+	Text []string
+
+	// TextWidth is the total width available for the image and Text.  If
+	// TextWidth is positive Text is wrapped to fit.
+	TextWidth int
+
+	// TextLeft places Text to the left of the image instead of the right.
+	TextLeft bool
+
+	// Histogram draws a histogram of the image's color channels below it.
+	Histogram bool
+
+	// BidiIsolate wraps each line in Unicode left-to-right isolate controls
+	// so terminals performing bidirectional reordering leave it intact.
+	BidiIsolate bool
+
+	// Strategy is how animation frames are positioned, StrategyCursor or
+	// StrategyRegion.  The zero value is equivalent to StrategyCursor.
+	Strategy string
+
+	// CursorAfter is where the cursor is left after drawing, CursorBelow,
+	// CursorRight or CursorSaveRestore.  The zero value is equivalent to
+	// CursorBelow.
+	CursorAfter string
+
+	// Interlace writes still images as even lines followed by odd lines so
+	// that they appear sooner over slow connections.  It has no effect on
+	// animations.  Images taller than InterlaceRows, the height of the
+	// terminal, are interlaced in bands of InterlaceRows lines so that the
+	// cursor never moves up past the top of the screen.
+	Interlace     bool
+	InterlaceRows int
+
+	// Progressive decodes still images fetched over HTTP while they
+	// download, sending coarse Preview frames of progressive JPEGs and PNGs
+	// before the image.  It has no effect on animations.
+	Progressive bool
+
+	// Partial redraws only the cells of an animation frame which changed,
+	// for frames whose Changed region is known.
+	Partial bool
+
+	// MaxFrameBytes, if positive, is the most output written for a frame.
+	// Frames which encode to more are encoded at lower quality, merging runs
+	// of similar cells, reducing colors and finally shrinking the frame,
+	// until they fit.
+	MaxFrameBytes int
+
+	// Render is the layer cells are colored in, RenderBackground or
+	// RenderForeground.  The zero value is equivalent to RenderBackground.
+	Render string
+
+	// Blocks is the number of pixels drawn in each cell, BlocksFull or
+	// BlocksHalf.  BlocksHalf overrides Render.  The zero value is
+	// equivalent to BlocksFull.
+	Blocks string
+
+	// Sync wraps each animation frame in synchronized update sequences so
+	// the terminal displays it atomically.
+	Sync bool
+
+	// Passthrough wraps sequences that GNU screen does not understand so that
+	// they reach the outer terminal.
+	Passthrough bool
+
+	// Deterministic writes animation frames as soon as they are ready rather
+	// than pacing them, and disables keyboard controls, so output does not
+	// depend on timing.
+	Deterministic bool
+
+	// MinDelay is the shortest time between animation frames when Delay is
+	// zero.
+	MinDelay time.Duration
+
+	// GIFShortDelay, if not zero, is the delay of GIF frames with a delay of
+	// 0 or 1 centiseconds, as if the GIF were drawn by a browser.
+	GIFShortDelay time.Duration
+
+	// Throttle lengthens frame delays, when Delay is zero, that would write
+	// output faster than the terminal can consume it, as estimated from how
+	// long frames take to write.  See TerminalShare.
+	Throttle bool
+
 	// Repeat specifies the number of times to render the frame sequence.  If
 	// Repeat is zero the frames are rendered just once.  If Repeat is less
 	// than zero the frames are rendered indefinitely.
 	Repeat int
+
+	// StageHook, if not nil, is called every StageInterval with statistics
+	// for each stage of the pipeline.  If StageInterval is not positive
+	// StageStatsInterval is used.
+	StageHook     func([]StageStats)
+	StageInterval time.Duration
+
+	// LoopHook, if not nil, is called as the end of each loop is drawn with
+	// the number of loops played, counting from 1, before the first frame
+	// of the next loop is drawn.  The last loop ends when the last frame of
+	// the player has been drawn.
+	LoopHook func(loop int)
+
+	monitor  *stageMonitor
+	player   *Player // ends the last loop
+	controls *playbackControls
+	live     *liveOptions
+}
+
+// lines returns the number of terminal lines drawing n rows of pixels.
+func (opts *FrameOptions) lines(n int) int {
+	if opts.Blocks == BlocksHalf {
+		return (n + 1) / 2
+	}
+	return n
+}
+
+// padWidth returns the number of terminal columns occupied by opts.Pad.
+func (opts *FrameOptions) padWidth() int {
+	return utf8.RuneCountInString(opts.Pad)
 }
 
 func writeANSIFrames(ctx context.Context, frames <-chan *Frame, p ANSIPalette, opts *FrameOptions) <-chan *ANSIFrame {
-	draw := make(chan *ANSIFrame)
+	draw := make(chan *ANSIFrame, PipelineBuffer)
 
 	go func() {
 		defer close(draw)
 
-		// Keep two buffers so one can be filled while the other is being drawn.
-		buffers := nbuffer(2)
+		// Keep enough buffers that one can be filled while the others are
+		// queued or being drawn.
+		buffers := nbuffer(PipelineBuffer + 2)
 		nframe := 0
-		lastRect := image.Rectangle{}
+		lastRows := 0
+		lastPreview := false // the last frame is replaced by the next
 		animate := opts != nil && opts.Animate
 
+		// the last frame encoded in full successfully, drawn again in place
+		// of a frame which cannot be encoded.
+		var prev []byte
+		var prevRows, prevCols int
+		partial := newPartialFrames(opts)
+		budget := newFrameBudget(opts)
+
 		for {
 			select {
 			case <-ctx.Done():
@@ -281,23 +1353,61 @@ func writeANSIFrames(ctx context.Context, frames <-chan *Frame, p ANSIPalette, o
 					return
 				}
 
-				buf := buffers[nframe%2]
+				buf := buffers[nframe%len(buffers)]
 
-				if animate {
+				if (animate && opts.Strategy != StrategyRegion) || lastPreview {
 					// Reset the cursor to the top of the image
-					up := lastRect.Size().Y
-					lastRect = f.Image.Bounds()
-					if up > 0 {
-						fmt.Fprintf(buf, "\033[%dA", up)
+					if lastRows > 0 {
+						fmt.Fprintf(buf, "\033[%dA", lastRows)
 					}
 				}
 
-				writeANSIPixels(buf, f.Image, p, opts.Pad)
+				start := len(buf.b)
+				fp, fo := p, opts
+				if opts != nil {
+					fp, fo = opts.live.frameOptions(p, opts)
+				}
+				var rows, cols int
+				var err error
+				region, isPartial := partial.Region(f, fp, fo)
+				if isPartial {
+					rows, cols, err = encodeANSIRegion(buf, f.Image, region, fp, fo)
+				} else {
+					rows, cols, err = encodeANSIFrame(buf, f.Image, fp, fo)
+				}
+				reduced := false
+				if err == nil && budget.Exceeded(len(buf.b)-start) {
+					buf.b = buf.b[:start]
+					rows, cols, err = budget.Encode(buf, f.Image, fp, fo)
+					isPartial, reduced = false, true
+				}
+				// the changed region of the next frame cannot be drawn over
+				// a frame of reduced quality.
+				partial.Drawn(f, fp, fo, err == nil && !reduced)
+				if err != nil {
+					// a bad frame should not end the animation.
+					logger(logRender).Error("frame not encoded", "frame", nframe, "err", err)
+					buf.b = buf.b[:start]
+					if prev != nil {
+						buf.Write(prev)
+						rows, cols = prevRows, prevCols
+					} else {
+						rows, cols = encodePlaceholder(buf, f.Image, fp, fo)
+					}
+				} else if animate && !isPartial {
+					prev = append(prev[:0], buf.b[start:]...)
+					prevRows, prevCols = rows, cols
+				}
+				lastRows = rows
+				lastPreview = f.Preview && !animate
 
 				b := &ANSIFrame{
 					Buffer:    buf,
+					Rows:      lastRows,
+					Cols:      cols,
 					Delay:     f.Delay,
 					LoopCount: f.LoopCount,
+					LoopEnd:   f.LoopEnd,
 				}
 
 				select {
@@ -313,6 +1423,59 @@ func writeANSIFrames(ctx context.Context, frames <-chan *Frame, p ANSIPalette, o
 	return draw
 }
 
+// encodeANSIFrame writes img to buf and returns the number of rows and
+// columns it occupies.  A panic while encoding img, such as for a color
+// missing from a palette or a nil image, is returned as an error, leaving
+// incomplete output in buf.
+func encodeANSIFrame(buf *frameBuffer, img image.Image, p ANSIPalette, opts *FrameOptions) (rows, cols int, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+
+	if opts.Interlace && !opts.Animate {
+		var lines frameBuffer
+		rows = writeANSIPixels(&lines, img, p, opts)
+		if opts.Histogram {
+			rows += writeHistogram(&lines, img, opts)
+		}
+		writeInterlaced(buf, lines.b, opts.InterlaceRows)
+	} else {
+		rows = writeANSIPixels(buf, img, p, opts)
+		if opts.Histogram {
+			rows += writeHistogram(buf, img, opts)
+		}
+	}
+
+	cols = img.Bounds().Dx() + 2*opts.padWidth()
+	if text, textWidth := textColumn(opts, img.Bounds().Dx()); opts.TextLeft && text != nil {
+		cols += textWidth + 1
+	}
+	return rows, cols, nil
+}
+
+// encodePlaceholder writes a transparent frame the size of img, which could
+// not be encoded, to buf.  Nothing is written if the size of img is unknown.
+func encodePlaceholder(buf *frameBuffer, img image.Image, p ANSIPalette, opts *FrameOptions) (rows, cols int) {
+	start := len(buf.b)
+	defer func() {
+		if recover() != nil {
+			buf.b = buf.b[:start]
+			rows, cols = 0, 0
+		}
+	}()
+	if img == nil {
+		return 0, 0
+	}
+	rows, cols, err := encodeANSIFrame(buf, image.NewRGBA(img.Bounds()), p, opts)
+	if err != nil {
+		buf.b = buf.b[:start]
+		return 0, 0
+	}
+	return rows, cols
+}
+
 // drawANSIFrames encodes images received over frames as ANSI escape sequences
 // using p and writes them to w.  drawANSIFrames does not use opts.Repeat.
 func drawANSIFrames(ctx context.Context, w io.Writer, frames <-chan *ANSIFrame, opts *FrameOptions) error {
@@ -329,32 +1492,64 @@ func drawANSIFrames(ctx context.Context, w io.Writer, frames <-chan *ANSIFrame,
 	// frame counter and timing
 	nframe := 0
 	start := time.Now()
+	var rate throughput
 	defer func() {
-		if Debug {
-			dur := time.Since(start)
-			secs := float64(dur) / float64(time.Second)
-			fps := float64(nframe) / secs
-			log.Printf("fps: %.2f", fps)
-		}
+		dur := time.Since(start)
+		secs := float64(dur) / float64(time.Second)
+		fps := float64(nframe) / secs
+		logger(logRender).Info("fps", "fps", fps, "bytes_per_sec", rate.Rate())
 	}()
 	frameStart := time.Time{}
-	_ = frameStart
 
+	var monitor *stageMonitor
+	if opts != nil {
+		monitor = opts.monitor
+	}
+	stats := monitor.stage("write")
+
+	var region *scrollRegion
+	if animate && opts.Strategy == StrategyRegion {
+		region = new(scrollRegion)
+		defer region.End(w)
+	}
+
+	var last *ANSIFrame
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case f, ok := <-frames:
 			if !ok {
-				return nil
+				if last == nil || opts == nil {
+					return nil
+				}
+				opts.loopEnded(opts.player.Ended())
+				if opts.controls != nil && opts.controls.shown {
+					_, err := io.WriteString(w, "\033[J")
+					if err != nil {
+						return err
+					}
+				}
+				if opts.CursorAfter == "" {
+					return nil
+				}
+				if region != nil {
+					err := region.End(w)
+					if err != nil {
+						return err
+					}
+				}
+				_, err := io.WriteString(w, cursorAfter(opts, last))
+				return err
 			}
+			last = f
 
-			if Debug && nframe == 0 {
-				log.Printf("time to first frame: %s", time.Since(debugProcStartTime))
+			if nframe == 0 {
+				logger(logRender).Info("time to first frame", "duration", time.Since(debugProcStartTime))
 			}
 
 			// Delay this animation frame before rendering by setting frameGate
-			if animate && nframe > 0 {
+			if animate && nframe > 0 && !opts.Deterministic {
 				delay := time.Duration(opts.Delay) * time.Millisecond
 				if delay == 0 {
 					delay = f.Delay
@@ -362,58 +1557,292 @@ func drawANSIFrames(ctx context.Context, w io.Writer, frames <-chan *ANSIFrame,
 				if delay == 0 {
 					delay = DelayDefault
 				}
+				if opts.Delay == 0 && delay < opts.MinDelay {
+					delay = opts.MinDelay
+				}
+				if opts.Delay == 0 && opts.Throttle {
+					if floor := rate.floor(len(f.Buffer.b)); delay < floor {
+						logger(logRender).Debug("throttling frame", "frame", nframe, "delay", delay, "floor", floor)
+						delay = floor
+					}
+				}
 				delay -= time.Since(frameStart)
 				frameGate = time.After(delay)
+				if delay < 0 {
+					monitor.update(stats, func(s *StageStats) { s.Late++ })
+				}
 			}
 
 			<-frameGate
+			opts.loopEnded(f.LoopEnd)
 			frameStart = time.Now()
+			size := len(f.Buffer.b)
+
+			if animate && opts.Sync {
+				_, err := io.WriteString(w, opts.passthrough(syncBegin))
+				if err != nil {
+					return err
+				}
+			}
+			if region != nil {
+				if nframe == 0 {
+					rows := f.Rows
+					if opts.controls != nil {
+						// the region's extra line holds the first row.
+						rows += osdRows - 1
+					}
+					err := region.Begin(w, rows)
+					if err != nil {
+						return err
+					}
+				}
+				_, err := io.WriteString(w, region.Frame())
+				if err != nil {
+					return err
+				}
+			}
+			if nframe == 0 && opts != nil && opts.CursorAfter == CursorSaveRestore {
+				_, err := io.WriteString(w, cursorSave(f.Rows, region != nil))
+				if err != nil {
+					return err
+				}
+			}
 
 			err := f.Buffer.FlushTo(w)
 			if err != nil {
 				return err
 			}
+
+			if opts != nil && opts.controls != nil {
+				_, err := io.WriteString(w, opts.controls.OSD(f.Cols))
+				if err != nil {
+					return err
+				}
+			}
+
+			if animate && opts.Sync {
+				_, err := io.WriteString(w, opts.passthrough(syncEnd))
+				if err != nil {
+					return err
+				}
+			}
+
+			if opts != nil && opts.Throttle && opts.controls != nil {
+				if rate.reports == nil {
+					rate.reports = opts.controls.StatusReports()
+				}
+				_, err := io.WriteString(w, rate.probe(size, frameStart))
+				if err != nil {
+					return err
+				}
+			}
+
+			err = flushFrame(w)
+			if err != nil {
+				return err
+			}
+
+			monitor.update(stats, func(s *StageStats) {
+				s.Frames++
+				s.Busy += time.Since(frameStart)
+			})
+
+			if nframe == 0 && opts != nil && opts.controls != nil {
+				opts.controls.Start(ctx)
+			}
 		}
 		nframe++
 	}
 }
 
-func writeANSIPixels(w *frameBuffer, img image.Image, p ANSIPalette, pad string) {
-	writeansii := func() func(color string) {
-		var lastcolor string
-		return func(color string) {
-			if color != lastcolor {
-				lastcolor = color
-				w.WriteString(color)
+// writeANSIPixels writes img to w along with any text from opts that should
+// flow beside it.  writeANSIPixels returns the number of lines written.
+func writeANSIPixels(w *frameBuffer, img image.Image, p ANSIPalette, opts *FrameOptions) int {
+	rect := img.Bounds()
+	size := rect.Size()
+	text, textWidth := textColumn(opts, size.X)
+	rows := opts.lines(size.Y)
+	if len(text) > rows {
+		rows = len(text)
+	}
+	if runtime.GOMAXPROCS(0) == 1 || size.X*size.Y < parallelMinCells {
+		for y := 0; y < rows; y++ {
+			writeANSIRow(w, img, y, p, text, textWidth, opts)
+		}
+		return rows
+	}
+
+	// rows are independent, each ends with the color cleared, so they are
+	// encoded in parallel and then written in order.
+	lines := getRowBuffers(rows)
+	parallelRows(rows, func(y int) {
+		writeANSIRow(&lines[y], img, y, p, text, textWidth, opts)
+	})
+	for i := range lines {
+		w.Write(lines[i].b)
+	}
+	putRowBuffers(lines)
+	return rows
+}
+
+// parallelMinCells is the smallest image, in cells, that writeANSIPixels
+// encodes, and DitherFrames dithers, in parallel.  Smaller images are
+// encoded faster than goroutines can be scheduled.
+const parallelMinCells = 4096
+
+// writeANSIRow writes row y of img to w, with the line of text beside it.
+func writeANSIRow(w *frameBuffer, img image.Image, y int, p ANSIPalette, text []string, textWidth int, opts *FrameOptions) {
+	// every row but the first follows a row which cleared the color.
+	lastcolor := ANSIClear
+	if y == 0 {
+		lastcolor = ""
+	}
+	writeansii := func(color string) {
+		if color != lastcolor {
+			lastcolor = color
+			w.WriteString(color)
+		}
+	}
+	size := img.Bounds().Size()
+	var line string
+	if y < len(text) {
+		line = text[y]
+	}
+	if opts.BidiIsolate {
+		w.WriteString(bidiLRI)
+	}
+	if opts.TextLeft && text != nil {
+		w.WriteString(padText(line, textWidth))
+		w.WriteString(" ")
+	}
+	w.WriteString(opts.Pad)
+	lastcolor = writeANSICells(w, img, y, 0, size.X, lastcolor, p, opts)
+	w.WriteString(opts.Pad)
+	writeansii(ANSIClear)
+	if !opts.TextLeft && line != "" {
+		w.WriteString(" ")
+		w.WriteString(line)
+	}
+	if opts.BidiIsolate {
+		w.WriteString(bidiPDI)
+	}
+	w.WriteString("\n")
+}
+
+// writeANSICells writes cells x0 through x1-1 of line y of img to w,
+// following output which left the color lastcolor and no attributes.  The
+// color left by the cells is returned.  Attributes of the cells are reset
+// after them, so they do not extend to padding or text.  Lines below img are
+// blank.
+func writeANSICells(w *frameBuffer, img image.Image, y, x0, x1 int, lastcolor string, p ANSIPalette, opts *FrameOptions) string {
+	cell := fullBlockCell(img, y, p, opts)
+	if opts.Blocks == BlocksHalf {
+		cell = halfBlockCell(img, y, p)
+	}
+	var lastattr CellAttr
+	for x := x0; x < x1; x++ {
+		code, glyph, attr := cell(x)
+		if code != lastcolor {
+			lastcolor = code
+			w.WriteString(code)
+			if strings.HasPrefix(code, ANSIClear) {
+				// the reset clears attributes along with colors.
+				lastattr = 0
 			}
 		}
-	}()
+		if attr != lastattr {
+			w.WriteString(sgrAttr(lastattr, attr))
+			lastattr = attr
+		}
+		w.WriteString(glyph)
+	}
+	w.WriteString(sgrAttr(lastattr, 0))
+	return lastcolor
+}
+
+// fullBlockCell returns a function giving the escape sequence, glyph and
+// attributes which draw the cell in column x of line y of img, one pixel
+// per cell.
+func fullBlockCell(img image.Image, y int, p ANSIPalette, opts *FrameOptions) func(x int) (string, string, CellAttr) {
+	sgr, glyph := p.ANSI, " "
+	if opts.Render == RenderForeground {
+		sgr, glyph = p.ANSIForeground, renderBlock
+	}
 	rect := img.Bounds()
-	size := rect.Size()
-	for y := 0; y < size.Y; y++ {
-		w.WriteString(pad)
-		for x := 0; x < size.X; x++ {
-			color := img.At(rect.Min.X+x, rect.Min.Y+y)
-			writeansii(p.ANSI(color))
-			w.WriteString(" ")
+	return func(x int) (string, string, CellAttr) {
+		if y >= rect.Dy() {
+			return ANSIClear, " ", 0
+		}
+		code := sgr(img.At(rect.Min.X+x, rect.Min.Y+y))
+		attr := cellAttrAt(img, rect.Min.X+x, rect.Min.Y+y)
+		if code == ANSIClear {
+			// transparent cells are left blank in either mode.
+			return code, " ", attr
+		}
+		return code, glyph, attr
+	}
+}
+
+// halfBlockCell returns a function giving the escape sequence, glyph and
+// attributes which draw the cell in column x of line y of img, holding
+// pixel rows 2y and 2y+1.  The upper pixel is drawn in the foreground of an
+// upper half block and the lower pixel in its background.  Where a pixel is
+// transparent the other is drawn alone over the default background, and
+// cells whose pixels have the same color are drawn as spaces so that they
+// share sequences with runs of full cells.  The attributes of a cell are
+// those of its upper pixel.
+func halfBlockCell(img image.Image, y int, p ANSIPalette) func(x int) (string, string, CellAttr) {
+	rect := img.Bounds()
+	top, bottom := 2*y, 2*y+1
+	return func(x int) (string, string, CellAttr) {
+		var upper, lower color.Color
+		var attr CellAttr
+		if top < rect.Dy() {
+			upper = img.At(rect.Min.X+x, rect.Min.Y+top)
+			attr = cellAttrAt(img, rect.Min.X+x, rect.Min.Y+top)
+			if p.Convert(upper) == nil {
+				upper = nil
+			}
+		}
+		if bottom < rect.Dy() {
+			lower = img.At(rect.Min.X+x, rect.Min.Y+bottom)
+			if p.Convert(lower) == nil {
+				lower = nil
+			}
+		}
+		switch {
+		case upper == nil && lower == nil:
+			return ANSIClear, " ", attr
+		case lower == nil:
+			return ANSIClear + p.ANSIForeground(upper), halfBlockUpper, attr
+		case upper == nil:
+			return ANSIClear + p.ANSIForeground(lower), halfBlockLower, attr
+		case p.ANSI(upper) == p.ANSI(lower):
+			return p.ANSI(upper), " ", attr
 		}
-		w.WriteString(pad)
-		writeansii(ANSIClear)
-		w.WriteString("\n")
+		return p.ANSIForeground(upper) + p.ANSI(lower), halfBlockUpper, attr
 	}
 }
 
 func decodeFramesArgs(ctx context.Context, stdin bool, args []string, fopts *FrameOptions) (<-chan *Frame, error) {
 	if stdin || len(args) == 0 {
-		return decodeFrames(ctx, os.Stdin, fopts)
-	} else if flag.NArg() == 1 {
-		return decodeFramesURL(ctx, flag.Arg(0), fopts)
+		frames, err := decodeFrames(ctx, os.Stdin, fopts)
+		if err != nil {
+			return nil, fmt.Errorf("decoding standard input: %w", err)
+		}
+		return frames, nil
+	} else if len(args) == 1 {
+		frames, err := decodeFramesURL(ctx, args[0], fopts)
+		if err != nil {
+			return nil, fmt.Errorf("decoding image %s: %w", args[0], err)
+		}
+		return frames, nil
 	} else {
 		// decode all the images given as arguments and concatenate their
 		// frames.
-		frames := make(chan *Frame)
+		frames := make(chan *Frame, PipelineBuffer)
 		var frameChans []<-chan *Frame
-		for _, filename := range flag.Args() {
+		for _, filename := range args {
 			frames, err := decodeFramesURL(ctx, filename, fopts)
 			if err != nil {
 				return nil, fmt.Errorf("decoding image %s: %w", filename, err)
@@ -422,9 +1851,14 @@ func decodeFramesArgs(ctx context.Context, stdin bool, args []string, fopts *Fra
 		}
 		go func() {
 			defer close(frames)
-			for _, c := range frameChans {
+			for i, c := range frameChans {
 				for frame := range c {
-					frames <- frame
+					frame.Source = i
+					select {
+					case <-ctx.Done():
+						return
+					case frames <- frame:
+					}
 				}
 			}
 		}()
@@ -464,7 +1898,14 @@ func decodeFramesHTTP(ctx context.Context, u string, fopts *FrameOptions) (<-cha
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	body := resp.Body
+	streaming := false // the body is read after returning
+	defer func() {
+		if !streaming {
+			body.Close()
+		}
+	}()
+	logger(logHTTP).Debug("response", "url", u, "status", resp.Status, "content_type", resp.Header.Get("Content-Type"))
 
 	if resp.StatusCode >= 400 {
 		resp.Body = nil
@@ -480,7 +1921,12 @@ func decodeFramesHTTP(ctx context.Context, u string, fopts *FrameOptions) (<-cha
 		return nil, fmt.Errorf("http: %v %v", resp.Status, u)
 	}
 	switch resp.Header.Get("Content-Type") {
-	case "application/octet-stream", "image/png", "image/gif", "image/jpeg":
+	case "application/octet-stream", "image/png", "image/gif", "image/jpeg",
+		"image/x-icon", "image/vnd.microsoft.icon":
+		if fopts != nil && fopts.Progressive && !fopts.Animate {
+			streaming = true
+			return decodeFramesProgressive(ctx, resp.Body, fopts)
+		}
 		return decodeFrames(ctx, resp.Body, fopts)
 	default:
 		return nil, fmt.Errorf("mime: %v %v", resp.Header.Get("Content-Type"), u)
@@ -498,10 +1944,11 @@ func decodeFramesFile(ctx context.Context, filename string, fopts *FrameOptions)
 
 func decodeFrames(ctx context.Context, r io.Reader, fopts *FrameOptions) (<-chan *Frame, error) {
 	var confbuf bytes.Buffer
-	_, format, err := image.DecodeConfig(io.TeeReader(r, &confbuf))
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &confbuf))
 	if err != nil {
 		return nil, err
 	}
+	logger(logDecode).Debug("decoding image", "format", format, "width", config.Width, "height", config.Height)
 	r = io.MultiReader(&confbuf, r)
 	if format == "gif" {
 		return decodeFramesGIF(ctx, r, fopts)
@@ -520,10 +1967,11 @@ func decodeFrames(ctx context.Context, r io.Reader, fopts *FrameOptions) (<-chan
 }
 
 func decodeFramesGIF(ctx context.Context, r io.Reader, fopts *FrameOptions) (<-chan *Frame, error) {
-	img, err := gif.DecodeAll(r)
+	img, err := gif.DecodeAllFrames(r)
 	if err != nil {
 		return nil, err
 	}
+	logger(logDecode).Debug("decoded gif", "frames", len(img.Image), "loop_count", img.LoopCount)
 
 	renderer := newGIFRenderer(img, func(b image.Rectangle) draw.Image { return image.NewRGBA64(b) })
 	for renderer.RenderNext() {
@@ -544,6 +1992,11 @@ func decodeFramesGIF(ctx context.Context, r io.Reader, fopts *FrameOptions) (<-c
 				Image:     fimg,
 				Delay:     time.Duration(img.Delay[i]) * timeUnit,
 				LoopCount: img.LoopCount,
+				Index:     i + 1,
+				Changed:   gifChanged(img, i),
+			}
+			if img.Delay[i] <= 1 && fopts != nil && fopts.GIFShortDelay > 0 {
+				f.Delay = fopts.GIFShortDelay
 			}
 
 			select {
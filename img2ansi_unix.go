@@ -4,6 +4,8 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -11,3 +13,8 @@ import (
 func getTermDim() (w, h int, err error) {
 	return terminal.GetSize(int(os.Stdout.Fd()))
 }
+
+// notifyResize causes c to receive a value when the terminal is resized.
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
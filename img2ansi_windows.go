@@ -2,4 +2,8 @@
 
 package main
 
+import "os"
+
 func getTermDim() (w, h int, err error)
+
+func notifyResize(c chan<- os.Signal) {}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Mouse reporting modes: any-event tracking with SGR extended coordinates.
+const (
+	mouseOn  = "\033[?1003h\033[?1006h"
+	mouseOff = "\033[?1006l\033[?1003l"
+)
+
+// inspector displays a still image on the alternate screen and reports the
+// source pixel beneath the mouse or keyboard cursor in a status line.
+type inspector struct {
+	src    image.Image // the image before scaling
+	img    image.Image // the image as drawn, one pixel per cell
+	p      ANSIPalette
+	opts   *FrameOptions
+	w      io.Writer
+	cursor image.Point // in img coordinates, relative to img.Bounds().Min
+}
+
+// inputEvent is a key press or mouse event read from the terminal.
+type inputEvent struct {
+	Key   string      // a printable key or one of "up", "down", "left", "right", "enter", "esc", or "status" for a device status report
+	Mouse bool        // the event is a mouse event at Cell
+	Click bool        // the mouse event is a button press
+	Cell  image.Point // zero-based cell coordinates of a mouse event
+}
+
+// runInspector shows img, a scaled copy of src, until the user quits.  If
+// pick is true the user may select a pixel by clicking it or pressing enter
+// and runInspector returns the selected pixel's coordinates in src.
+func runInspector(ctx context.Context, src, img image.Image, p ANSIPalette, opts *FrameOptions, pick bool) (image.Point, bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return image.Point{}, false, err
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return image.Point{}, false, fmt.Errorf("terminal: %w", err)
+	}
+	defer terminal.Restore(fd, state)
+
+	in := &inspector{src: src, img: img, p: p, opts: opts, w: &crlfWriter{w: os.Stdout}}
+	io.WriteString(in.w, "\033[?1049h"+mouseOn)
+	defer io.WriteString(in.w, ANSIClear+mouseOff+"\033[?25h\033[?1049l")
+	in.draw()
+	in.drawStatus(pick)
+
+	events := readInputEvents(ctx, tty)
+	for {
+		select {
+		case <-ctx.Done():
+			return image.Point{}, false, nil
+		case ev, ok := <-events:
+			if !ok {
+				return image.Point{}, false, nil
+			}
+			switch {
+			case ev.Key == "q" || ev.Key == "esc" || ev.Key == "\x03":
+				return image.Point{}, false, nil
+			case ev.Key == "enter" && pick:
+				return in.sourcePoint(in.cursor), true, nil
+			case ev.Mouse:
+				pt, ok := in.cellPoint(ev.Cell)
+				if !ok {
+					continue
+				}
+				in.cursor = pt
+				if ev.Click && pick {
+					return in.sourcePoint(in.cursor), true, nil
+				}
+			case ev.Key == "up" || ev.Key == "k":
+				in.move(0, -1)
+			case ev.Key == "down" || ev.Key == "j":
+				in.move(0, 1)
+			case ev.Key == "left" || ev.Key == "h":
+				in.move(-1, 0)
+			case ev.Key == "right" || ev.Key == "l":
+				in.move(1, 0)
+			default:
+				continue
+			}
+			in.drawStatus(pick)
+		}
+	}
+}
+
+func (in *inspector) draw() {
+	buf := nbuffer(1)[0]
+	io.WriteString(in.w, "\033[2J\033[H")
+	writeANSIPixels(buf, in.img, in.p, in.opts)
+	buf.FlushTo(in.w)
+}
+
+// drawStatus writes the status line below the image and places the terminal
+// cursor over the inspected cell.
+func (in *inspector) drawStatus(pick bool) {
+	size := in.img.Bounds().Size()
+	src := in.sourcePoint(in.cursor)
+	c := in.src.At(src.X, src.Y)
+	status := fmt.Sprintf("x=%d y=%d  %s  %s", src.X, src.Y, colorString(c), sgrString(in.p.ANSI(in.img.At(in.img.Bounds().Min.X+in.cursor.X, in.img.Bounds().Min.Y+in.cursor.Y))))
+	if pick {
+		status += "  [enter/click: pick, q: quit]"
+	} else {
+		status += "  [q: quit]"
+	}
+	fmt.Fprintf(in.w, "\033[%d;1H\033[2K%s", size.Y+1, status)
+	fmt.Fprintf(in.w, "\033[%d;%dH\033[?25h", in.cursor.Y+1, in.opts.padWidth()+in.cursor.X+1)
+}
+
+func (in *inspector) move(dx, dy int) {
+	size := in.img.Bounds().Size()
+	in.cursor.X = clampInt(in.cursor.X+dx, 0, size.X-1)
+	in.cursor.Y = clampInt(in.cursor.Y+dy, 0, size.Y-1)
+}
+
+// cellPoint returns the image coordinates of a terminal cell, if the cell
+// displays a pixel.
+func (in *inspector) cellPoint(cell image.Point) (image.Point, bool) {
+	pt := image.Pt(cell.X-in.opts.padWidth(), cell.Y)
+	size := in.img.Bounds().Size()
+	if pt.X < 0 || pt.Y < 0 || pt.X >= size.X || pt.Y >= size.Y {
+		return image.Point{}, false
+	}
+	return pt, true
+}
+
+// sourcePoint maps a point of the scaled image to the pixel of the source
+// image at the center of the cell.
+func (in *inspector) sourcePoint(pt image.Point) image.Point {
+	srect := in.src.Bounds()
+	size := in.img.Bounds().Size()
+	x := (2*pt.X + 1) * srect.Dx() / (2 * size.X)
+	y := (2*pt.Y + 1) * srect.Dy() / (2 * size.Y)
+	return image.Pt(srect.Min.X+x, srect.Min.Y+y)
+}
+
+// colorString formats c as hex RGB along with its alpha when it is not
+// opaque.
+func colorString(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	s := fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	if n.A != 0xff {
+		s += fmt.Sprintf(" alpha=%d", n.A)
+	}
+	return s
+}
+
+// sgrString returns the parameters of the SGR escape sequence seq in a
+// printable form.
+func sgrString(seq string) string {
+	return "SGR " + strings.TrimSuffix(strings.TrimPrefix(seq, "\033["), "m")
+}
+
+// readInputEvents parses key presses and SGR mouse reports read from r.
+func readInputEvents(ctx context.Context, r io.Reader) <-chan inputEvent {
+	events := make(chan inputEvent)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 256)
+		var pending []byte
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			pending = append(pending, buf[:n]...)
+			for len(pending) > 0 {
+				ev, m := parseInputEvent(pending)
+				if m == 0 {
+					break
+				}
+				pending = pending[m:]
+				if ev == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case events <- *ev:
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// parseInputEvent parses the first event in b and returns it along with the
+// number of bytes consumed.  parseInputEvent returns zero bytes consumed if b
+// holds an incomplete escape sequence and a nil event for unrecognized
+// sequences.
+func parseInputEvent(b []byte) (*inputEvent, int) {
+	switch {
+	case b[0] == '\r' || b[0] == '\n':
+		return &inputEvent{Key: "enter"}, 1
+	case b[0] != '\033':
+		return &inputEvent{Key: string(b[:1])}, 1
+	case len(b) == 1:
+		return &inputEvent{Key: "esc"}, 1
+	case b[1] != '[':
+		return &inputEvent{Key: "esc"}, 1
+	}
+	// a CSI sequence ends with a byte in the range 0x40-0x7e.
+	end := -1
+	for i := 2; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, 0
+	}
+	seq := string(b[2:end])
+	switch b[end] {
+	case 'A':
+		return &inputEvent{Key: "up"}, end + 1
+	case 'B':
+		return &inputEvent{Key: "down"}, end + 1
+	case 'C':
+		return &inputEvent{Key: "right"}, end + 1
+	case 'D':
+		return &inputEvent{Key: "left"}, end + 1
+	case 'n':
+		// a device status report, answering a status request.
+		if seq == "0" {
+			return &inputEvent{Key: "status"}, end + 1
+		}
+	case 'M', 'm':
+		if !strings.HasPrefix(seq, "<") {
+			return nil, end + 1
+		}
+		f := strings.Split(seq[1:], ";")
+		if len(f) != 3 {
+			return nil, end + 1
+		}
+		btn, _ := strconv.Atoi(f[0])
+		x, _ := strconv.Atoi(f[1])
+		y, _ := strconv.Atoi(f[2])
+		ev := &inputEvent{Mouse: true, Cell: image.Pt(x-1, y-1)}
+		// bit 5 marks motion, the low bits give the button.
+		ev.Click = b[end] == 'M' && btn&32 == 0 && btn&3 == 0
+		return ev, end + 1
+	}
+	return nil, end + 1
+}
+
+// pickMain implements the pick subcommand, which inspects an image like
+// -interactive and prints the color of the pixel chosen.
+func pickMain(args []string) error {
+	drawMain("pick", args)
+	return nil
+}
+
+// inspectFrames runs the inspector on the first frame received over frames.
+// If pick is true and the user picks a pixel its color and the escape sequence
+// parameters used to draw it are printed to w.
+func inspectFrames(ctx context.Context, w io.Writer, frames <-chan *Frame, width, height int, fontAspect float64, p ANSIPalette, opts *FrameOptions, pick bool) error {
+	f, ok := <-frames
+	if !ok {
+		return fmt.Errorf("no image to inspect")
+	}
+	src := f.Image
+	c := make(chan *Frame, 1)
+	c <- f
+	close(c)
+	scaled, ok := <-ResizeFrames(ctx, width, height, fontAspect, c)
+	if !ok {
+		return ctx.Err()
+	}
+	pt, ok, err := runInspector(ctx, src, scaled.Image, p, opts, pick)
+	if err != nil || !ok {
+		return err
+	}
+	picked := src.At(pt.X, pt.Y)
+	_, err = fmt.Fprintf(w, "%s\t%s\n", colorString(picked), strings.TrimPrefix(sgrString(p.ANSI(picked)), "SGR "))
+	return err
+}
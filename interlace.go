@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// writeInterlaced writes the lines of a still image in src to w in two
+// passes, even lines followed by odd lines, so that on a slow connection the
+// whole image appears at half resolution before it is complete.  The first
+// pass leaves the odd lines blank and the second moves the cursor up to fill
+// them in.  Images of more than band lines are written in bands of that
+// many, one after the other, so the cursor does not move up further than
+// the screen.  The cursor is left below the image, as it would be had the
+// lines been written in order.
+func writeInterlaced(w *frameBuffer, src []byte, band int) {
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	if n := len(lines); n > 0 && len(lines[n-1]) == 0 {
+		lines = lines[:n-1]
+	}
+	if band < 3 {
+		w.Write(src)
+		return
+	}
+	for len(lines) > 0 {
+		n := min(band, len(lines))
+		writeInterlacedBand(w, lines[:n])
+		lines = lines[n:]
+	}
+}
+
+// writeInterlacedBand writes lines, each ending in a newline, in two passes.
+func writeInterlacedBand(w *frameBuffer, lines [][]byte) {
+	n := len(lines)
+	if n < 3 {
+		for _, line := range lines {
+			w.Write(line)
+		}
+		return
+	}
+	for i, line := range lines {
+		if i%2 == 0 {
+			w.Write(line)
+		} else {
+			w.WriteString("\n")
+		}
+	}
+	// the cursor is at the beginning of the line below the band.
+	fmt.Fprintf(w, "\033[%dA", n-1)
+	for i := 1; i < n; i += 2 {
+		w.Write(bytes.TrimSuffix(lines[i], []byte("\n")))
+		down := 2
+		if i+2 >= n {
+			down = n - i
+		}
+		fmt.Fprintf(w, "\r\033[%dB", down)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteInterlaced(t *testing.T) {
+	var src string
+	for i := 0; i < 11; i++ {
+		src += strings.Repeat(string(rune('a'+i)), 4) + "\n"
+	}
+	want := newCellGrid(4, palette256)
+	want.Write([]byte(src))
+	for _, band := range []int{0, 3, 4, 24} {
+		var buf frameBuffer
+		writeInterlaced(&buf, []byte(src), band)
+		got := newCellGrid(4, palette256)
+		got.Write(buf.b)
+		if !reflect.DeepEqual(got.cells, want.cells) || got.y != want.y {
+			t.Errorf("band %d: interlaced lines drawn differently", band)
+		}
+		for _, m := range regexp.MustCompile(`\x1b\[(\d+)A`).FindAllSubmatch(buf.b, -1) {
+			if n, _ := strconv.Atoi(string(m[1])); n >= band {
+				t.Errorf("band %d: cursor moved up %d lines", band, n)
+			}
+		}
+	}
+}
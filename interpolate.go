@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// interpolation is the factor by which -interpolate multiplies the frame
+// rate of animations, written like 2x.
+type interpolation int
+
+func (n *interpolation) String() string {
+	return strconv.Itoa(int(*n)) + "x"
+}
+
+func (n *interpolation) Set(s string) error {
+	v, err := strconv.Atoi(strings.TrimSuffix(s, "x"))
+	if err != nil || v < 1 {
+		return fmt.Errorf("interpolation must be a positive factor like 2x")
+	}
+	*n = interpolation(v)
+	return nil
+}
+
+// InterpolateFrames multiplies the frame rate of animations by n, inserting
+// n-1 frames cross-faded between consecutive frames of each input.  The
+// delay of each frame is divided among it and the frames inserted after it,
+// so animations play at their original speed.  No motion is estimated, so
+// moving objects fade between positions rather than move, but low frame rate
+// GIFs look smoother on terminals able to draw more frames.  Frames of
+// different inputs are left to TransitionFrames.  If loop is true and there
+// is one input, its last frame is also blended into its first, where the
+// animation loops.
+func InterpolateFrames(ctx context.Context, n int, loop bool, frames <-chan *Frame) <-chan *Frame {
+	if n <= 1 {
+		return frames
+	}
+	out := make(chan *Frame, PipelineBuffer)
+	go func() {
+		defer close(out)
+		send := func(f *Frame) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- f:
+				return true
+			}
+		}
+		// a frame is held until the next is received to blend them.
+		var first, prev *Frame
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok := <-frames:
+				if !ok {
+					switch {
+					case prev == nil:
+					case loop && prev != first && prev.Source == first.Source:
+						interpolateBetween(n, prev, first, send)
+					default:
+						send(prev)
+					}
+					return
+				}
+				if first == nil {
+					first = f
+				}
+				if prev != nil && !interpolateBetween(n, prev, f, send) {
+					return
+				}
+				prev = f
+			}
+		}
+	}()
+	return out
+}
+
+// interpolateBetween sends from followed by the frames blending it into to.
+// interpolateBetween returns false if a frame could not be sent.
+func interpolateBetween(n int, from, to *Frame, send func(*Frame) bool) bool {
+	if from.Source != to.Source {
+		return send(from)
+	}
+	delay := from.Delay
+	if delay == 0 {
+		delay = DelayDefault
+	}
+	delay /= time.Duration(n)
+	g := *from
+	g.Delay = delay
+	if !send(&g) {
+		return false
+	}
+	for i := 1; i < n; i++ {
+		p := float64(i) / float64(n)
+		f := &Frame{
+			Image:     transitionImage(TransitionFade{}, from.Image, to.Image, p),
+			Delay:     delay,
+			LoopCount: from.LoopCount,
+			Source:    from.Source,
+		}
+		if !send(f) {
+			return false
+		}
+	}
+	return true
+}
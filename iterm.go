@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+)
+
+// writeITerm2Frames encodes frames as iTerm2 inline images in place of
+// writeANSIFrames.  Frames are sized in pixels, and cell is the size of a
+// terminal cell in pixels, which gives the rows and columns each frame
+// occupies.  Images are drawn as sixel images are: each leaves the cursor
+// below it, and animation frames are drawn over the frame before with their
+// transparent pixels drawn in bg.
+func writeITerm2Frames(ctx context.Context, frames <-chan *Frame, cell image.Point, bg color.Color, opts *FrameOptions) <-chan *ANSIFrame {
+	animate := opts != nil && opts.Animate
+	if !animate {
+		bg = nil
+	}
+	lastRows := 0
+	return writeGraphicsFrames(ctx, frames, func(buf *frameBuffer, f *Frame) (rows, cols int, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("panic: %v", v)
+			}
+		}()
+		if animate && opts.Strategy != StrategyRegion && lastRows > 0 {
+			fmt.Fprintf(buf, "\033[%dA", lastRows)
+		}
+		size := f.Image.Bounds().Size()
+		rows = (size.Y + cell.Y - 1) / cell.Y
+		cols = (size.X + cell.X - 1) / cell.X
+		if rows < 1 {
+			return 0, 0, nil
+		}
+		data, err := iterm2PNG(f.Image, bg)
+		if err != nil {
+			return 0, 0, err
+		}
+		// terminals differ in where an inline image leaves the cursor,
+		// so it is saved and restored as for sixel images.
+		fmt.Fprintf(buf, "%s\033[%dA\0337", strings.Repeat("\n", rows), rows)
+		fmt.Fprintf(buf, "\033]1337;File=inline=1;size=%d;width=%dpx;height=%dpx;preserveAspectRatio=0:", len(data), size.X, size.Y)
+		buf.WriteString(base64.StdEncoding.EncodeToString(data))
+		buf.WriteString("\a")
+		fmt.Fprintf(buf, "\0338\033[%dB\r", rows)
+		lastRows = rows
+		return rows, cols, nil
+	})
+}
+
+// iterm2PNG encodes img as a PNG.  Pixels are transparent or opaque by the
+// alpha threshold, as cells are, and transparent pixels are drawn in bg
+// unless it is nil.
+func iterm2PNG(img image.Image, bg color.Color) ([]byte, error) {
+	rect := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := img.At(x, y)
+			if IsTransparent(c, AlphaThreshold) {
+				if bg == nil {
+					continue
+				}
+				c = bg
+			}
+			out.Set(x-rect.Min.X, y-rect.Min.Y, color.NRGBAModel.Convert(opaque(c)))
+		}
+	}
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	err := enc.Encode(&buf, out)
+	return buf.Bytes(), err
+}
+
+// detectITerm2 reports whether the terminal draws iTerm2 inline images.
+// Terminals which do are recognized by name, as they answer no query for it.
+func detectITerm2() (bool, string) {
+	switch prog := os.Getenv("TERM_PROGRAM"); prog {
+	case "iTerm.app", "WezTerm":
+		return true, "TERM_PROGRAM=" + prog
+	}
+	if os.Getenv("LC_TERMINAL") == "iTerm2" {
+		return true, "LC_TERMINAL=iTerm2"
+	}
+	return false, "TERM_PROGRAM is not iTerm.app or WezTerm"
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"testing"
+)
+
+func TestWriteITerm2Frames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	img := image.NewNRGBA(image.Rect(3, 3, 23, 18))
+	for y := 3; y < 18; y++ {
+		for x := 3; x < 23; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 9), uint8(y * 13), 0x40, 0xff})
+		}
+	}
+	img.Set(3, 3, color.Transparent)
+	c := make(chan *Frame, 1)
+	c <- &Frame{Image: img}
+	close(c)
+	inline := regexp.MustCompile(`\x1b\]1337;File=inline=1;size=([0-9]+);width=20px;height=15px;preserveAspectRatio=0:([^\a]*)\a`)
+	for f := range writeITerm2Frames(ctx, c, image.Pt(8, 16), color.Black, &FrameOptions{}) {
+		if f.Rows != 1 || f.Cols != 3 {
+			t.Errorf("image covers %dx%d cells (expected 3x1)", f.Cols, f.Rows)
+		}
+		m := inline.FindSubmatch(f.Buffer.b)
+		if m == nil {
+			t.Fatalf("no inline image in %q", f.Buffer.b)
+		}
+		data, err := base64.StdEncoding.DecodeString(string(m[2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, a := decoded.At(0, 0).RGBA(); a != 0 {
+			t.Errorf("transparent pixel of a still image drawn")
+		}
+		if !sameColor(decoded.At(5, 7), img.At(8, 10)) {
+			t.Errorf("pixel 5,7 is %v (expected %v)", decoded.At(5, 7), img.At(8, 10))
+		}
+	}
+}
+
+func TestDetectITerm2(t *testing.T) {
+	t.Setenv("LC_TERMINAL", "")
+	for prog, want := range map[string]bool{"WezTerm": true, "iTerm.app": true, "Apple_Terminal": false} {
+		t.Setenv("TERM_PROGRAM", prog)
+		if ok, _ := detectITerm2(); ok != want {
+			t.Errorf("TERM_PROGRAM=%s detected %v", prog, ok)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"image/color"
+	"sort"
+)
+
+// paletteTree is a k-d tree over the RGB values of a color.Palette which
+// finds the nearest palette color without comparing against every entry.
+// Distances are computed exactly as color.Palette.Index computes them and
+// ties go to the lowest index, so Index returns the same result as
+// color.Palette.Index for opaque colors.
+type paletteTree struct {
+	nodes []paletteNode
+	root  int
+}
+
+type paletteNode struct {
+	rgb         [3]uint32
+	index       int
+	axis        int
+	left, right int // -1 if absent
+}
+
+var palette256Tree = newPaletteTree(palette256)
+
+func newPaletteTree(p color.Palette) *paletteTree {
+	t := &paletteTree{}
+	points := make([]paletteNode, len(p))
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		points[i] = paletteNode{rgb: [3]uint32{r, g, b}, index: i}
+	}
+	t.root = t.build(points, 0)
+	return t
+}
+
+// build adds a subtree for points, split on axis at the median, and returns
+// its root.
+func (t *paletteTree) build(points []paletteNode, axis int) int {
+	if len(points) == 0 {
+		return -1
+	}
+	sort.Slice(points, func(i, j int) bool {
+		a, b := points[i], points[j]
+		if a.rgb[axis] != b.rgb[axis] {
+			return a.rgb[axis] < b.rgb[axis]
+		}
+		return a.index < b.index
+	})
+	mid := len(points) / 2
+	n := points[mid]
+	n.axis = axis
+	i := len(t.nodes)
+	t.nodes = append(t.nodes, n)
+	left := t.build(points[:mid], (axis+1)%3)
+	right := t.build(points[mid+1:], (axis+1)%3)
+	t.nodes[i].left, t.nodes[i].right = left, right
+	return i
+}
+
+// Index returns the index of the palette color nearest to c.
+func (t *paletteTree) Index(c color.Color) int {
+	r, g, b, _ := c.RGBA()
+	q := [3]uint32{r, g, b}
+	best, bestSum := -1, uint32(1<<32-1)
+	var search func(i int)
+	search = func(i int) {
+		if i < 0 {
+			return
+		}
+		n := &t.nodes[i]
+		sum := sqDiff(q[0], n.rgb[0]) + sqDiff(q[1], n.rgb[1]) + sqDiff(q[2], n.rgb[2])
+		if sum < bestSum || (sum == bestSum && n.index < best) {
+			best, bestSum = n.index, sum
+		}
+		near, far := n.left, n.right
+		if q[n.axis] >= n.rgb[n.axis] {
+			near, far = far, near
+		}
+		search(near)
+		// the far side can only hold a color at least as near as the best
+		// if the splitting plane is.  equal distances are searched for
+		// lower indexes.
+		if sqDiff(q[n.axis], n.rgb[n.axis]) <= bestSum {
+			search(far)
+		}
+	}
+	search(t.root)
+	return best
+}
+
+// sqDiff is the distance function used by color.Palette.Index.
+func sqDiff(x, y uint32) uint32 {
+	d := x - y
+	return (d * d) >> 2
+}
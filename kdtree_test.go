@@ -0,0 +1,46 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestPaletteTreeIndex(t *testing.T) {
+	for r := 0; r < 256; r += 3 {
+		for g := 0; g < 256; g += 3 {
+			for b := 0; b < 256; b += 3 {
+				c := color.RGBA{uint8(r), uint8(g), uint8(b), 0xff}
+				want := palette256.Index(c)
+				got := palette256Tree.Index(c)
+				if got != want {
+					t.Fatalf("%v: index %d, want %d", c, got, want)
+				}
+			}
+		}
+	}
+}
+
+// benchmarkColors returns a spread of colors to look up in the palette.
+func benchmarkColors() []color.Color {
+	var colors []color.Color
+	for i := 0; i < 4096; i++ {
+		colors = append(colors, color.RGBA{uint8(i * 7), uint8(i * 13), uint8(i * 29), 0xff})
+	}
+	return colors
+}
+
+func BenchmarkPalette256Scalar(b *testing.B) {
+	colors := benchmarkColors()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		palette256.Index(colors[i%len(colors)])
+	}
+}
+
+func BenchmarkPalette256Tree(b *testing.B) {
+	colors := benchmarkColors()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		palette256Tree.Index(colors[i%len(colors)])
+	}
+}
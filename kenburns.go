@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"time"
+)
+
+// KenBurnsFrames turns each still image received over frames into an
+// animation lasting dur that slowly zooms and pans a crop window across the
+// image.  Every frame of the animation has the size of the smallest window, so
+// that later stages resize them all alike.  Inputs that decode into more than
+// one frame pass through unchanged.
+func KenBurnsFrames(ctx context.Context, dur time.Duration, frames <-chan *Frame) <-chan *Frame {
+	if dur <= 0 {
+		return frames
+	}
+	n := int(dur / DelayDefault)
+	if n < 2 {
+		n = 2
+	}
+
+	out := make(chan *Frame, PipelineBuffer)
+	go func() {
+		defer close(out)
+		send := func(f *Frame) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- f:
+				return true
+			}
+		}
+		expand := func(f *Frame) bool {
+			size := kenBurnsSize(f.Image.Bounds().Size())
+			for i := 0; i < n; i++ {
+				p := smoothstep(float64(i) / float64(n-1))
+				g := &Frame{
+					Image:  scaleImage(kenBurnsCrop(f.Image, p), size.X, size.Y),
+					Delay:  DelayDefault,
+					Source: f.Source,
+				}
+				if !send(g) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// A frame is held until it is known whether more frames follow from
+		// the same input.
+		var pending *Frame
+		animated := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok := <-frames:
+				if !ok {
+					if pending != nil {
+						expand(pending)
+					}
+					return
+				}
+				if pending != nil && pending.Source == f.Source {
+					animated = true
+				}
+				if pending != nil {
+					var ok bool
+					if animated {
+						ok = send(pending)
+					} else {
+						ok = expand(pending)
+					}
+					if !ok {
+						return
+					}
+				}
+				if pending != nil && pending.Source != f.Source {
+					animated = false
+				}
+				pending = f
+			}
+		}
+	}()
+	return out
+}
+
+// kenBurnsZoom is the size of the crop window at the end of the path, as a
+// fraction of the image.
+const kenBurnsZoom = 0.75
+
+// kenBurnsSize returns the size of the frames made from an image of the
+// given size, the size of the crop window at the end of its path.
+func kenBurnsSize(size image.Point) image.Point {
+	return image.Pt(
+		max(1, int(round(float64(size.X)*kenBurnsZoom))),
+		max(1, int(round(float64(size.Y)*kenBurnsZoom))))
+}
+
+// kenBurnsCrop returns the crop of img at fraction p along the path of the
+// crop window.  The window shrinks to kenBurnsZoom of the image while
+// drifting from the center toward the upper right.
+func kenBurnsCrop(img image.Image, p float64) image.Image {
+	const endX, endY = 0.65, 0.35
+
+	rect := img.Bounds()
+	size := rect.Size()
+	scale := 1 - (1-kenBurnsZoom)*p
+	w := int(round(float64(size.X) * scale))
+	h := int(round(float64(size.Y) * scale))
+	// the center of the window moves linearly between the center of the image
+	// and (endX, endY).
+	cx := (0.5 + (endX-0.5)*p) * float64(size.X)
+	cy := (0.5 + (endY-0.5)*p) * float64(size.Y)
+	x0 := clampInt(int(round(cx-float64(w)/2)), 0, size.X-w)
+	y0 := clampInt(int(round(cy-float64(h)/2)), 0, size.Y-h)
+	return cropImage(img, image.Rect(x0, y0, x0+w, y0+h).Add(rect.Min))
+}
+
+// cropImage returns the part of img within crop, sharing its pixels if
+// possible.
+func cropImage(img image.Image, crop image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(crop)
+	}
+	dst := image.NewRGBA64(image.Rectangle{Max: crop.Size()})
+	draw.Draw(dst, dst.Bounds(), img, crop.Min, draw.Src)
+	return dst
+}
+
+// smoothstep eases p in and out of the interval [0, 1].
+func smoothstep(p float64) float64 {
+	return p * p * (3 - 2*p)
+}
+
+func clampInt(x, min, max int) int {
+	if x < min {
+		return min
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// kittyChunkSize is the most base64 encoded data sent in one kitty graphics
+// command.  Larger images are sent in chunks.
+const kittyChunkSize = 4096
+
+// kittyImageID identifies the image an animation is drawn in, so that its
+// frames replace the pixels of the image in place.  It is taken from the
+// process ID so that two animations in one terminal do not share an image.
+var kittyImageID = uint32(os.Getpid())&0xffffff | 1<<24
+
+// kittyPlacement is a position on the screen for kitty images, given by
+// -place and -z-index, so that a program running img2ansi can draw an image
+// in part of its interface.  ID, given by -image-id, names the image so that
+// img2ansi erase can delete it later.
+type kittyPlacement struct {
+	Col, Row int    // the cell of the top left corner of the image, from 1
+	X, Y     int    // the offset in pixels of the image within the cell
+	Z        int    // the z-index, negative to draw the image below text
+	ID       uint32 // the image ID, or zero to leave still images unnamed
+}
+
+func (p *kittyPlacement) String() string {
+	if p == nil || p.Col == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d+%d+%d", p.Col, p.Row, p.X, p.Y)
+}
+
+// Set parses a placement of the form COLxROW or COLxROW+X+Y.
+func (p *kittyPlacement) Set(s string) error {
+	q := kittyPlacement{Z: p.Z, ID: p.ID}
+	n, _ := fmt.Sscanf(s, "%dx%d+%d+%d", &q.Col, &q.Row, &q.X, &q.Y)
+	// trailing text is not an error to Sscanf.
+	valid := n == 2 && s == fmt.Sprintf("%dx%d", q.Col, q.Row) || n == 4 && s == q.String()
+	if !valid || q.Col < 1 || q.Row < 1 || q.X < 0 || q.Y < 0 {
+		return fmt.Errorf("invalid placement %q (expected COLxROW+X+Y)", s)
+	}
+	*p = q
+	return nil
+}
+
+// writeKittyFrames encodes frames with the kitty graphics protocol in place
+// of writeANSIFrames.  Frames are sized in pixels, and cell is the size of a
+// terminal cell in pixels, which gives the rows and columns each frame
+// occupies.  Like ANSI frames, each image leaves the cursor below it, unless
+// place gives the position of images, which are then drawn there without
+// moving the cursor or occupying any lines.  The first frame of an animation
+// is placed as an image, and each frame after it replaces the pixels of that
+// image, only those which changed if the frame follows the one before it in
+// its input, so the terminal neither scrolls nor redraws the rest of the
+// screen.  The image is placed again if the size of the frames changes.
+// Images are given the ID of place, if it has one, and the image of an
+// animation is otherwise given kittyImageID.
+func writeKittyFrames(ctx context.Context, frames <-chan *Frame, cell image.Point, place *kittyPlacement, opts *FrameOptions) <-chan *ANSIFrame {
+	var id uint32
+	if place != nil {
+		id = place.ID
+	}
+	if place != nil && place.Col == 0 {
+		place = nil
+	}
+	stillKeys := ""
+	if id != 0 {
+		stillKeys = fmt.Sprintf("i=%d,", id)
+	} else {
+		id = kittyImageID
+	}
+	animate := opts != nil && opts.Animate
+	var last *Frame // the last frame drawn in the animation image
+	var lastRows int
+	return writeGraphicsFrames(ctx, frames, func(buf *frameBuffer, f *Frame) (rows, cols int, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("panic: %v", v)
+			}
+		}()
+
+		rect := f.Image.Bounds()
+		size := rect.Size()
+		rows = (size.Y + cell.Y - 1) / cell.Y
+		cols = (size.X + cell.X - 1) / cell.X
+		if rows < 1 {
+			return 0, 0, nil
+		}
+		if place != nil {
+			// placed images leave no lines below them.
+			rows, cols = 0, 0
+		}
+		if !animate {
+			encodeKittyImage(buf, f.Image, rows, place, stillKeys)
+			return rows, cols, nil
+		}
+		if last != nil && last.Image.Bounds().Size() == size {
+			r := rect
+			if !f.Changed.Empty() && f.Source == last.Source && f.Index == last.Index+1 {
+				r = f.Changed.Intersect(rect)
+			}
+			if !r.Empty() {
+				keys := fmt.Sprintf("a=f,i=%d,r=1,X=1,x=%d,y=%d,s=%d,v=%d,f=32,o=z,q=2",
+					id, r.Min.X-rect.Min.X, r.Min.Y-rect.Min.Y, r.Dx(), r.Dy())
+				writeKittyCommand(buf, keys, kittyPixels(f.Image, r))
+			}
+			last = f
+			return rows, cols, nil
+		}
+		if last != nil {
+			// the image is replaced by one of the new size.
+			if place == nil && opts.Strategy != StrategyRegion && lastRows > 0 {
+				fmt.Fprintf(buf, "\033[%dA", lastRows)
+			}
+			buf.WriteString(kittyErase(id))
+		}
+		encodeKittyImage(buf, f.Image, rows, place, fmt.Sprintf("i=%d,", id))
+		last, lastRows = f, rows
+		return rows, cols, nil
+	})
+}
+
+// kittyErase returns the kitty graphics command deleting the image with the
+// given ID and its placements, or every image placed if id is zero.
+func kittyErase(id uint32) string {
+	if id == 0 {
+		return "\033_Ga=d,d=A,q=2\033\\"
+	}
+	return fmt.Sprintf("\033_Ga=d,d=I,i=%d,q=2\033\\", id)
+}
+
+// eraseMain implements the erase subcommand, which deletes kitty images
+// drawn with -image-id, or all images, so that scripts can manage images
+// which stay on the screen after img2ansi exits.
+func eraseMain(args []string) error {
+	fs := flag.NewFlagSet("erase", flag.ExitOnError)
+	id := fs.Uint("id", 0, "the -image-id of the image to delete")
+	all := fs.Bool("all", false, "delete every image placed in the terminal")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: img2ansi erase -id N | -all\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || (*id == 0) == !*all {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *id > math.MaxUint32 {
+		return fmt.Errorf("erase: image ID %d is too large", *id)
+	}
+	_, err := io.WriteString(os.Stdout, kittyErase(uint32(*id)))
+	return err
+}
+
+// encodeKittyImage writes img to buf as a kitty image placed at the cursor,
+// covering rows lines, with the given keys, ending in a comma, added to
+// the command.  Lines for the image are made first, scrolling the screen if
+// needed, and the cursor is left below the image.  If place is not nil the
+// image is placed there instead and the cursor is returned to where it was.
+func encodeKittyImage(buf *frameBuffer, img image.Image, rows int, place *kittyPlacement, keys string) {
+	size := img.Bounds().Size()
+	keys = fmt.Sprintf("a=T,%sf=32,o=z,s=%d,v=%d", keys, size.X, size.Y)
+	if place != nil {
+		fmt.Fprintf(buf, "\0337\033[%d;%dH", place.Row, place.Col)
+		keys += fmt.Sprintf(",X=%d,Y=%d,z=%d", place.X, place.Y, place.Z)
+	} else {
+		fmt.Fprintf(buf, "%s\033[%dA", strings.Repeat("\n", rows), rows)
+	}
+	// C=1 keeps the cursor where the image is placed.
+	writeKittyCommand(buf, keys+",C=1,q=2", kittyPixels(img, img.Bounds()))
+	if place != nil {
+		buf.WriteString("\0338")
+	} else {
+		fmt.Fprintf(buf, "\033[%dB\r", rows)
+	}
+}
+
+// writeKittyCommand writes a kitty graphics command with the given keys and
+// payload, split into chunks of kittyChunkSize encoded bytes.  The keys are
+// sent with the first chunk, and every chunk but the last has m=1.
+func writeKittyCommand(buf *frameBuffer, keys string, data []byte) {
+	payload := base64.StdEncoding.EncodeToString(data)
+	for first := true; first || payload != ""; first = false {
+		chunk := payload[:min(len(payload), kittyChunkSize)]
+		payload = payload[len(chunk):]
+		buf.WriteString("\033_G")
+		if first {
+			buf.WriteString(keys)
+			buf.WriteString(",")
+		}
+		if payload != "" {
+			buf.WriteString("m=1;")
+		} else {
+			buf.WriteString("m=0;")
+		}
+		buf.WriteString(chunk)
+		buf.WriteString("\033\\")
+	}
+}
+
+// kittyPixels returns the pixels of img within r as zlib compressed RGBA
+// bytes, as f=32,o=z expects.  Pixels are transparent or opaque by the alpha
+// threshold, as cells are.
+func kittyPixels(img image.Image, r image.Rectangle) []byte {
+	pix := make([]byte, 0, 4*r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := img.At(x, y)
+			if IsTransparent(c, AlphaThreshold) {
+				pix = append(pix, 0, 0, 0, 0)
+				continue
+			}
+			n := color.NRGBAModel.Convert(opaque(c)).(color.NRGBA)
+			pix = append(pix, n.R, n.G, n.B, 0xff)
+		}
+	}
+	var out bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&out, zlib.BestSpeed)
+	zw.Write(pix)
+	zw.Close()
+	return out.Bytes()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var kittyCommand = regexp.MustCompile(`\x1b_G([^;\x1b]*);?([^\x1b]*)\x1b\\`)
+
+// kittyCommands returns the keys of each kitty graphics command in data,
+// joined with those of the chunks after it, and its decompressed payload.
+func kittyCommands(t *testing.T, data []byte) (keys []string, payloads [][]byte) {
+	var encoded string
+	for _, m := range kittyCommand.FindAllSubmatch(data, -1) {
+		k, chunk := string(m[1]), string(m[2])
+		if encoded == "" {
+			keys = append(keys, k)
+		}
+		encoded += chunk
+		if strings.Contains(k, "m=1") {
+			continue
+		}
+		var payload []byte
+		if encoded != "" {
+			z, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(z))
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, _ = io.ReadAll(zr)
+		}
+		payloads = append(payloads, payload)
+		encoded = ""
+	}
+	return keys, payloads
+}
+
+func TestWriteKittyFrames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			// noise keeps the image from compressing into one chunk.
+			first.Set(x, y, color.RGBA{uint8(x * y * 37), uint8(x * 91), uint8(y * 53), 0xff})
+		}
+	}
+	second := image.NewRGBA(first.Rect)
+	copy(second.Pix, first.Pix)
+	changed := image.Rect(4, 5, 10, 8)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			second.Set(x, y, color.Transparent)
+		}
+	}
+	c := make(chan *Frame, 3)
+	c <- &Frame{Image: first, Index: 1}
+	c <- &Frame{Image: second, Index: 2, Changed: changed}
+	c <- &Frame{Image: image.NewRGBA(image.Rect(0, 0, 20, 10)), Index: 3}
+	close(c)
+
+	opts := &FrameOptions{Animate: true, Strategy: StrategyCursor}
+	var out [][]byte
+	for f := range writeKittyFrames(ctx, c, image.Pt(8, 16), nil, opts) {
+		out = append(out, append([]byte(nil), f.Buffer.b...))
+		if len(out) == 1 && (f.Rows != 2 || f.Cols != 5) {
+			t.Errorf("first frame covers %dx%d cells (expected 5x2)", f.Cols, f.Rows)
+		}
+		f.Buffer.b = f.Buffer.b[:0]
+	}
+	if len(out) != 3 {
+		t.Fatalf("%d frames written", len(out))
+	}
+
+	keys, payloads := kittyCommands(t, out[0])
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "a=T,") || len(payloads[0]) != 40*30*4 {
+		t.Errorf("first frame sent as %q with %d bytes", keys, len(payloads[0]))
+	}
+	if n := len(kittyCommand.FindAll(out[0], -1)); n < 2 {
+		t.Errorf("first frame sent in %d chunks", n)
+	}
+	keys, payloads = kittyCommands(t, out[1])
+	if len(keys) != 1 || !strings.Contains(keys[0], "a=f,") || !strings.Contains(keys[0], "x=4,y=5,s=6,v=3,") {
+		t.Errorf("changed region sent as %q", keys)
+	} else if !bytes.Equal(payloads[0], make([]byte, 6*3*4)) {
+		t.Errorf("changed region pixels %v", payloads[0])
+	}
+	if bytes.Contains(out[1], []byte("\033[")) {
+		t.Errorf("cursor moved to update the image: %q", out[1])
+	}
+	keys, _ = kittyCommands(t, out[2])
+	if len(keys) != 2 || !strings.HasPrefix(keys[0], "a=d,") || !strings.HasPrefix(keys[1], "a=T,") {
+		t.Errorf("resized frame sent as %q", keys)
+	}
+}
+
+func TestKittyPlacement(t *testing.T) {
+	for _, s := range []string{"3x4", "3x4+2+7"} {
+		var p kittyPlacement
+		if err := p.Set(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "3", "0x4", "3x4+2", "3x4+2+7x", "3x-4"} {
+		var p kittyPlacement
+		if err := p.Set(s); err == nil {
+			t.Errorf("%q: parsed as %v", s, p)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan *Frame, 1)
+	c <- &Frame{Image: image.NewRGBA(image.Rect(0, 0, 20, 40))}
+	close(c)
+	place := &kittyPlacement{Col: 3, Row: 4, X: 2, Y: 7, Z: -1}
+	for f := range writeKittyFrames(ctx, c, image.Pt(8, 16), place, &FrameOptions{}) {
+		if f.Rows != 0 || f.Cols != 0 {
+			t.Errorf("placed image covers %dx%d cells below the cursor", f.Cols, f.Rows)
+		}
+		out := f.Buffer.b
+		if !bytes.HasPrefix(out, []byte("\0337\033[4;3H")) || !bytes.HasSuffix(out, []byte("\0338")) {
+			t.Errorf("image not placed at 3x4: %q", out)
+		}
+		keys, _ := kittyCommands(t, out)
+		if len(keys) != 1 || !strings.Contains(keys[0], ",X=2,Y=7,z=-1,") {
+			t.Errorf("image placed with %q", keys)
+		}
+	}
+}
+
+func TestKittyImageID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, animate := range []bool{false, true} {
+		c := make(chan *Frame, 2)
+		c <- &Frame{Image: image.NewRGBA(image.Rect(0, 0, 20, 40))}
+		c <- &Frame{Image: image.NewRGBA(image.Rect(0, 0, 10, 20))}
+		close(c)
+		place := &kittyPlacement{ID: 7}
+		var keys []string
+		for f := range writeKittyFrames(ctx, c, image.Pt(8, 16), place, &FrameOptions{Animate: animate}) {
+			k, _ := kittyCommands(t, f.Buffer.b)
+			keys = append(keys, k...)
+		}
+		for _, k := range keys {
+			if !strings.Contains(k, "i=7,") {
+				t.Errorf("animate %v: image drawn with %q", animate, k)
+			}
+		}
+	}
+	if s := kittyErase(7); s != "\033_Ga=d,d=I,i=7,q=2\033\\" {
+		t.Errorf("image 7 erased with %q", s)
+	}
+	if s := kittyErase(0); s != "\033_Ga=d,d=A,q=2\033\\" {
+		t.Errorf("all images erased with %q", s)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Modules which log messages.  Each may be given its own level with
+// -log-level.
+const (
+	logHTTP   = "http"
+	logDecode = "decode"
+	logRender = "render"
+)
+
+var logModules = []string{logHTTP, logDecode, logRender}
+
+// Formats of log messages.
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// logLevels is a flag.Value holding the minimum level of messages logged by
+// each module, as a comma separated list such as "info,http=debug".  An entry
+// without a module sets the level of modules not listed.
+type logLevels struct {
+	def     slog.Level
+	modules map[string]slog.Level
+}
+
+func (l *logLevels) String() string {
+	if l == nil {
+		return ""
+	}
+	s := []string{strings.ToLower(l.def.String())}
+	for _, module := range logModules {
+		if level, ok := l.modules[module]; ok {
+			s = append(s, module+"="+strings.ToLower(level.String()))
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *logLevels) Set(s string) error {
+	for _, field := range strings.Split(s, ",") {
+		module, name, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			module, name = "", module
+		}
+		var level slog.Level
+		err := level.UnmarshalText([]byte(name))
+		if err != nil {
+			return fmt.Errorf("invalid log level %q", name)
+		}
+		if module == "" {
+			l.def = level
+			continue
+		}
+		if !slices.Contains(logModules, module) {
+			return fmt.Errorf("unknown log module %q: not one of %q", module, logModules)
+		}
+		if l.modules == nil {
+			l.modules = make(map[string]slog.Level)
+		}
+		l.modules[module] = level
+	}
+	return nil
+}
+
+// level returns the minimum level of messages logged by module.
+func (l *logLevels) level(module string) slog.Level {
+	if level, ok := l.modules[module]; ok {
+		return level
+	}
+	return l.def
+}
+
+// lower lowers the level of modules, or of all modules if none are given, to
+// at most level so that verbosity flags add to the messages selected with
+// -log-level.
+func (l *logLevels) lower(level slog.Level, modules ...string) {
+	if len(modules) == 0 {
+		l.def = min(l.def, level)
+		for module := range l.modules {
+			l.modules[module] = min(l.modules[module], level)
+		}
+		return
+	}
+	if l.modules == nil {
+		l.modules = make(map[string]slog.Level)
+	}
+	for _, module := range modules {
+		l.modules[module] = min(l.level(module), level)
+	}
+}
+
+// LogLevels holds the levels selected with -log-level, -v and -vv.  Only
+// warnings and errors are logged by default.
+var LogLevels = &logLevels{def: slog.LevelWarn}
+
+// logOutput formats and writes the messages of all modules.
+var logOutput, _ = newLogHandler(os.Stderr, LogText)
+
+// newLogHandler returns a handler writing messages to w in format.  Levels
+// are checked by the loggers of each module, the handler writes every
+// message it is given.
+func newLogHandler(w io.Writer, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{}
+	switch format {
+	case LogText:
+		// messages are read by people as they happen, times only add
+		// noise as they do for the standard logger.
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+		return slog.NewTextHandler(w, opts), nil
+	case LogJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("log format not one of %q", []string{LogText, LogJSON})
+	}
+}
+
+// logger returns the logger for messages from module.
+func logger(module string) *slog.Logger {
+	h := logOutput.WithAttrs([]slog.Attr{slog.String("module", module)})
+	return slog.New(&moduleHandler{Handler: h, module: module})
+}
+
+// moduleHandler drops messages below the level of its module.
+type moduleHandler struct {
+	slog.Handler
+	module string
+}
+
+func (h *moduleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= LogLevels.level(h.module)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &moduleHandler{Handler: h.Handler.WithAttrs(attrs), module: h.module}
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return &moduleHandler{Handler: h.Handler.WithGroup(name), module: h.module}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mqttPort        = "1883"
+	mqttTopic       = "img2ansi"
+	mqttAckTimeout  = 5 * time.Second
+	mqttConnect     = 0x10
+	mqttConnack     = 0x20
+	mqttPublish     = 0x30
+	mqttPuback      = 0x40
+	mqttDisconnect  = 0xe0
+	mqttProtocolLvl = 4 // MQTT 3.1.1
+)
+
+// mqttSink publishes frames to a topic on an MQTT broker for displays which
+// subscribe to it.  It is configured by a URL of the form
+//
+//	mqtt://[user:password@]host[:port][/topic][?qos=0|1][&format=rgb|json][&fps=N]
+//
+// With format=rgb, the default, each message is the frame's width and height
+// as big-endian 16-bit integers followed by its pixels as RGB triples.  With
+// format=json each message is a frame in the json-cells format.  If fps is
+// given frames arriving faster than fps are not published.
+type mqttSink struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	topic  string
+	qos    int
+	format string
+	minGap time.Duration
+	last   time.Time
+	id     uint16
+	buf    []byte
+}
+
+func newMQTTSink(u *url.URL) (*mqttSink, error) {
+	s := &mqttSink{
+		topic:  strings.TrimPrefix(u.Path, "/"),
+		format: "rgb",
+	}
+	if s.topic == "" {
+		s.topic = mqttTopic
+	}
+	q := u.Query()
+	if v := q.Get("qos"); v != "" {
+		qos, err := strconv.Atoi(v)
+		if err != nil || qos < 0 || qos > 1 {
+			return nil, fmt.Errorf("mqtt sink: qos must be 0 or 1")
+		}
+		s.qos = qos
+	}
+	switch v := q.Get("format"); v {
+	case "", "rgb":
+	case "json":
+		s.format = v
+	default:
+		return nil, fmt.Errorf("mqtt sink: format not one of %q", []string{"rgb", "json"})
+	}
+	if v := q.Get("fps"); v != "" {
+		fps, err := strconv.ParseFloat(v, 64)
+		if err != nil || fps <= 0 {
+			return nil, fmt.Errorf("mqtt sink: fps must be positive")
+		}
+		s.minGap = time.Duration(float64(time.Second) / fps)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), mqttPort)
+	}
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	s.r = bufio.NewReader(conn)
+	err = s.connect(u.User)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt sink: %w", err)
+	}
+	return s, nil
+}
+
+// connect sends CONNECT and waits for the broker to accept it.
+func (s *mqttSink) connect(user *url.Userinfo) error {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = mqttString(payload, fmt.Sprintf("img2ansi-%d", os.Getpid()))
+	if user != nil {
+		flags |= 0x80
+		payload = mqttString(payload, user.Username())
+		if password, ok := user.Password(); ok {
+			flags |= 0x40
+			payload = mqttString(payload, password)
+		}
+	}
+	var pkt []byte
+	pkt = mqttString(pkt, "MQTT")
+	// keep alive is disabled because frames may not be published regularly.
+	pkt = append(pkt, mqttProtocolLvl, flags, 0, 0)
+	pkt = append(pkt, payload...)
+	err := s.writePacket(mqttConnect, pkt)
+	if err != nil {
+		return err
+	}
+	// a broker which never answers would otherwise hang startup.
+	s.conn.SetReadDeadline(time.Now().Add(mqttAckTimeout))
+	defer s.conn.SetReadDeadline(time.Time{})
+	typ, body, err := s.readPacket()
+	if err != nil {
+		return err
+	}
+	if typ != mqttConnack || len(body) < 2 {
+		return errors.New("unexpected response to connect")
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("connection refused (code %d)", body[1])
+	}
+	return nil
+}
+
+func (s *mqttSink) WritePixels(size image.Point, pixels []color.RGBA) error {
+	now := time.Now()
+	if s.minGap > 0 && !s.last.IsZero() && now.Sub(s.last) < s.minGap {
+		return nil
+	}
+	s.last = now
+
+	var msg []byte
+	if s.format == "json" {
+		var err error
+		msg, err = json.Marshal(pixelsJSONFrame(size, pixels))
+		if err != nil {
+			return err
+		}
+	} else {
+		msg = s.buf[:0]
+		msg = binary.BigEndian.AppendUint16(msg, uint16(size.X))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(size.Y))
+		for _, c := range pixels {
+			msg = append(msg, c.R, c.G, c.B)
+		}
+		s.buf = msg
+	}
+
+	pkt := mqttString(nil, s.topic)
+	if s.qos > 0 {
+		s.id++
+		if s.id == 0 {
+			s.id = 1
+		}
+		pkt = binary.BigEndian.AppendUint16(pkt, s.id)
+	}
+	pkt = append(pkt, msg...)
+	err := s.writePacket(mqttPublish|byte(s.qos<<1), pkt)
+	if err != nil {
+		return fmt.Errorf("mqtt sink: %w", err)
+	}
+	if s.qos == 0 {
+		return nil
+	}
+	s.conn.SetReadDeadline(time.Now().Add(mqttAckTimeout))
+	defer s.conn.SetReadDeadline(time.Time{})
+	for {
+		typ, body, err := s.readPacket()
+		if err != nil {
+			return fmt.Errorf("mqtt sink: %w", err)
+		}
+		if typ == mqttPuback && len(body) >= 2 && binary.BigEndian.Uint16(body) == s.id {
+			return nil
+		}
+	}
+}
+
+func (s *mqttSink) Close() error {
+	s.writePacket(mqttDisconnect, nil)
+	return s.conn.Close()
+}
+
+// writePacket writes a packet with the given first header byte and body.
+func (s *mqttSink) writePacket(header byte, body []byte) error {
+	pkt := []byte{header}
+	// the remaining length is a base-128 varint.
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	pkt = append(pkt, body...)
+	_, err := s.conn.Write(pkt)
+	return err
+}
+
+// readPacket reads a packet and returns its type, with flags cleared, and
+// body.
+func (s *mqttSink) readPacket() (byte, []byte, error) {
+	header, err := s.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n := 0
+	for shift := 0; ; shift += 7 {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift > 21 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(s.r, body)
+	return header & 0xf0, body, err
+}
+
+// mqttString appends s to b as a length-prefixed string.
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
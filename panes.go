@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// paneLayout is the number of columns and rows of panes the terminal is
+// divided into.
+type paneLayout struct {
+	Cols, Rows int
+}
+
+// Ways of playing multiple inputs accepted by -merge.
+const (
+	// MergeSequential concatenates the frames of the inputs.
+	MergeSequential = "sequential"
+
+	// MergeConcurrent plays the inputs at once, each in its own pane, with
+	// their frames interleaved on a single timeline.
+	MergeConcurrent = "concurrent"
+)
+
+// autoPaneLayout returns the most nearly square layout with room for n
+// panes, wider than it is tall when it cannot be square.
+func autoPaneLayout(n int) paneLayout {
+	l := paneLayout{Cols: 1, Rows: 1}
+	for l.Cols*l.Rows < n {
+		if l.Cols <= l.Rows {
+			l.Cols++
+		} else {
+			l.Rows++
+		}
+	}
+	return l
+}
+
+func (l paneLayout) String() string {
+	return fmt.Sprintf("%dx%d", l.Cols, l.Rows)
+}
+
+func parsePaneLayout(s string) (paneLayout, error) {
+	var l paneLayout
+	_, err := fmt.Sscanf(s, "%dx%d", &l.Cols, &l.Rows)
+	if err != nil || l.Cols < 1 || l.Rows < 1 {
+		return l, fmt.Errorf("invalid pane layout %q (expected COLSxROWS)", s)
+	}
+	return l, nil
+}
+
+// pane is a region of the terminal playing the frames of one input.
+type pane struct {
+	frames <-chan *Frame
+	rect   image.Rectangle // cells, zero-based
+	next   time.Time
+	done   bool
+	last   *Frame
+}
+
+// runPanes divides the terminal according to layout and plays the inputs
+// named by args simultaneously, one per pane, on the alternate screen.
+func runPanes(ctx context.Context, args []string, layout paneLayout, fontAspect float64, p ANSIPalette, fopts *FrameOptions) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no inputs for panes")
+	}
+	if len(args) > layout.Cols*layout.Rows {
+		return fmt.Errorf("%d inputs do not fit in %dx%d panes", len(args), layout.Cols, layout.Rows)
+	}
+	width, height, err := getTermDim()
+	if err != nil {
+		return fmt.Errorf("terminal dimensions: %w", err)
+	}
+	paneWidth := width / layout.Cols
+	paneHeight := height / layout.Rows
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var panes []*pane
+	for i, arg := range args {
+		frames, err := decodeFramesURL(ctx, arg, fopts)
+		if err != nil {
+			return fmt.Errorf("decoding image %s: %w", arg, err)
+		}
+		scaled := ResizeFrames(ctx, paneWidth, paneHeight, fontAspect, frames)
+		min := image.Pt((i%layout.Cols)*paneWidth, (i/layout.Cols)*paneHeight)
+		panes = append(panes, &pane{
+			frames: NewPlayer(fopts).Play(ctx, scaled),
+			rect:   image.Rectangle{min, min.Add(image.Pt(paneWidth, paneHeight))},
+		})
+	}
+
+	w := os.Stdout
+	io.WriteString(w, "\033[?1049h\033[?25l\033[2J")
+	defer io.WriteString(w, ANSIClear+"\033[?25h\033[?1049l")
+
+	return drawPanes(ctx, w, panes, p, fopts)
+}
+
+// drawPanes schedules the frames of all panes on a single timeline, drawing
+// whichever pane is due next until every pane has finished.
+func drawPanes(ctx context.Context, w io.Writer, panes []*pane, p ANSIPalette, fopts *FrameOptions) error {
+	buf := nbuffer(1)[0]
+	start := time.Now()
+	for i := range panes {
+		panes[i].next = start
+	}
+	for {
+		var due *pane
+		for _, pn := range panes {
+			if !pn.done && (due == nil || pn.next.Before(due.next)) {
+				due = pn
+			}
+		}
+		if due == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(due.next)):
+		}
+
+		var f *Frame
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil
+		case f, ok = <-due.frames:
+		}
+		if !ok {
+			due.done = true
+			continue
+		}
+
+		delay := time.Duration(fopts.Delay) * time.Millisecond
+		if delay == 0 {
+			delay = f.Delay
+		}
+		if delay == 0 {
+			delay = DelayDefault
+		}
+		due.next = due.next.Add(delay)
+		if now := time.Now(); due.next.Before(now) {
+			// the pane fell behind, do not try to catch up.
+			due.next = now
+		}
+		if f == due.last {
+			// still images are looped like animations but need not be
+			// redrawn.
+			continue
+		}
+		due.last = f
+
+		if fopts.Sync {
+			buf.WriteString(fopts.passthrough(syncBegin))
+		}
+		writePane(buf, due.rect, f.Image, p)
+		if fopts.Sync {
+			buf.WriteString(fopts.passthrough(syncEnd))
+		}
+		err := buf.FlushTo(w)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writePane writes img centered in rect, positioning each row with absolute
+// cursor addressing so that other panes are left intact.
+func writePane(buf *frameBuffer, rect image.Rectangle, img image.Image, p ANSIPalette) {
+	var lines bytes.Buffer
+	lb := nbuffer(1)[0]
+	writeANSIPixels(lb, img, p, &FrameOptions{})
+	lb.FlushTo(&lines)
+
+	size := img.Bounds().Size()
+	left := rect.Min.X + (rect.Dx()-size.X)/2
+	top := rect.Min.Y + (rect.Dy()-size.Y)/2
+	for y, line := range strings.Split(strings.TrimSuffix(lines.String(), "\n"), "\n") {
+		if y >= rect.Dy() {
+			break
+		}
+		fmt.Fprintf(buf, "\033[%d;%dH", top+y+1, left+1)
+		buf.WriteString(line)
+	}
+}
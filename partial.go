@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/bmatsuo/img2ansi/gif"
+)
+
+// gifChanged returns the region of frame i of g which differs from frame i-1
+// as gifrenderer draws them, the sub-rectangle the frame was encoded with.
+// The first frame, and frames following one disposed of by clearing the
+// canvas, are new everywhere and an empty rectangle is returned.
+func gifChanged(g *gif.GIF, i int) image.Rectangle {
+	if i == 0 {
+		return image.Rectangle{}
+	}
+	switch g.Disposal[i-1] {
+	case gif.DisposalBackground, gif.DisposalPrevious:
+		return image.Rectangle{}
+	}
+	return g.Image[i].Rect.Intersect(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+}
+
+// scaleRect returns the region of an image resized from size from to size to
+// by DefaultScaler which corresponds to r.  The region is grown by a pixel on
+// each side to cover pixels which resizing blends with those of r.  The
+// region is unknown, and empty, unless DefaultScaler is a localScaler.
+func scaleRect(r image.Rectangle, from, to image.Point) image.Rectangle {
+	if r.Empty() || from.X == 0 || from.Y == 0 || !localScaler(DefaultScaler) {
+		return image.Rectangle{}
+	}
+	ceil := func(a, b int) int { return (a + b - 1) / b }
+	s := image.Rect(
+		r.Min.X*to.X/from.X-1,
+		r.Min.Y*to.Y/from.Y-1,
+		ceil(r.Max.X*to.X, from.X)+1,
+		ceil(r.Max.Y*to.Y, from.Y)+1,
+	)
+	return s.Intersect(image.Rectangle{Max: to})
+}
+
+// partialFrames decides which animation frames can be drawn by redrawing
+// only their Changed region over the frame drawn before them.  Most GIFs
+// only change a small part of the canvas in each frame, so much less output
+// is written.  A frame is drawn in full unless it follows the frame drawn
+// before it in its input, with the same size, palette, render mode and
+// block mode.
+type partialFrames struct {
+	enabled bool
+	last    *Frame // the frame drawn last, or nil if it failed to draw
+	p       ANSIPalette
+	render  string
+	blocks  string
+}
+
+func newPartialFrames(opts *FrameOptions) *partialFrames {
+	return &partialFrames{
+		enabled: opts != nil && opts.Animate && opts.Partial &&
+			opts.Text == nil && !opts.Histogram && !opts.BidiIsolate,
+	}
+}
+
+// Region returns the region of f to draw, or false if f must be drawn in
+// full.
+func (pf *partialFrames) Region(f *Frame, p ANSIPalette, opts *FrameOptions) (image.Rectangle, bool) {
+	last := pf.last
+	if !pf.enabled || last == nil || f.Changed.Empty() || last.Index == 0 {
+		return image.Rectangle{}, false
+	}
+	if f.Source != last.Source || f.Index != last.Index+1 || f.Image.Bounds() != last.Image.Bounds() {
+		return image.Rectangle{}, false
+	}
+	if p != pf.p || opts.Render != pf.render || opts.Blocks != pf.blocks {
+		return image.Rectangle{}, false
+	}
+	return f.Changed, true
+}
+
+// Drawn records that f was drawn with p and opts, successfully if ok.
+func (pf *partialFrames) Drawn(f *Frame, p ANSIPalette, opts *FrameOptions, ok bool) {
+	pf.last = nil
+	if ok {
+		pf.last = f
+	}
+	pf.p = p
+	if opts != nil {
+		pf.render = opts.Render
+		pf.blocks = opts.Blocks
+	}
+}
+
+// encodeANSIRegion writes the cells of img within r to buf, to be drawn over
+// the previous frame with the cursor at the top left of the frame.  Each row
+// of r is reached by moving the cursor, which is left below the frame where
+// drawing a full frame leaves it.  encodeANSIRegion returns the number of
+// rows and columns occupied by the frame, as encodeANSIFrame does.
+func encodeANSIRegion(buf *frameBuffer, img image.Image, r image.Rectangle, p ANSIPalette, opts *FrameOptions) (rows, cols int, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+
+	rect := img.Bounds()
+	r = r.Intersect(rect).Sub(rect.Min)
+	rows = opts.lines(rect.Dy())
+	cols = rect.Dx() + 2*opts.padWidth()
+	// the lines drawing the rows of r.
+	first, last := r.Min.Y, r.Max.Y
+	if opts.Blocks == BlocksHalf {
+		first, last = first/2, opts.lines(last)
+	}
+	y := 0
+	for row := first; row < last; row++ {
+		// a count of 0 moves the cursor as 1 does.
+		if row > y {
+			fmt.Fprintf(buf, "\033[%dB", row-y)
+			y = row
+		}
+		buf.WriteString("\r")
+		if x := opts.padWidth() + r.Min.X; x > 0 {
+			fmt.Fprintf(buf, "\033[%dC", x)
+		}
+		last := writeANSICells(buf, img, row, r.Min.X, r.Max.X, "", p, opts)
+		if r.Max.X == rect.Dx() {
+			// the padding right of a row has the color of its last cell.
+			buf.WriteString(opts.Pad)
+		}
+		if last != ANSIClear {
+			buf.WriteString(ANSIClear)
+		}
+	}
+	buf.WriteString("\r")
+	if rows > y {
+		fmt.Fprintf(buf, "\033[%dB", rows-y)
+	}
+	return rows, cols, nil
+}
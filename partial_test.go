@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// TestPartialFrames checks that frames drawn by redrawing their changed
+// regions leave the screen as drawing them in full does.
+func TestPartialFrames(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomColor := func() color.Color {
+		return color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+	}
+	first := image.NewRGBA(image.Rect(0, 0, 30, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 30; x++ {
+			first.Set(x, y, randomColor())
+		}
+	}
+	frames := []*Frame{{Image: first, Index: 1}}
+	for i, r := range []image.Rectangle{
+		image.Rect(3, 2, 9, 7),
+		image.Rect(20, 10, 30, 16),
+		image.Rect(0, 0, 1, 1),
+		image.Rect(11, 5, 14, 9),
+	} {
+		img := image.NewRGBA(first.Rect)
+		draw.Draw(img, img.Rect, frames[i].Image, image.Point{}, draw.Src)
+		draw.Draw(img, r, image.NewUniform(randomColor()), image.Point{}, draw.Src)
+		frames = append(frames, &Frame{Image: img, Index: i + 2, Changed: r})
+	}
+
+	screens := func(blocks string, partial bool) ([][][]jsonCell, int) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		opts := &FrameOptions{Animate: true, Strategy: StrategyCursor, Pad: " ", Partial: partial, Blocks: blocks}
+		c := make(chan *Frame, len(frames))
+		for _, f := range frames {
+			c <- f
+		}
+		close(c)
+		// the frames are resized to check the changed regions are scaled.
+		resized := ResizeFrames(ctx, 15, 0, 0.5, c)
+		grid := newCellGrid(40, palette256)
+		var screens [][][]jsonCell
+		size := 0
+		for f := range writeANSIFrames(ctx, resized, new(Palette256), opts) {
+			size += len(f.Buffer.b)
+			grid.Write(f.Buffer.b)
+			f.Buffer.b = f.Buffer.b[:0]
+			var screen [][]jsonCell
+			for _, row := range grid.cells {
+				screen = append(screen, append([]jsonCell(nil), row...))
+			}
+			screens = append(screens, screen)
+		}
+		return screens, size
+	}
+	for _, blocks := range []string{BlocksFull, BlocksHalf} {
+		full, fullSize := screens(blocks, false)
+		partial, partialSize := screens(blocks, true)
+		if len(full) != len(frames) || len(partial) != len(frames) {
+			t.Fatalf("%s: %d and %d frames drawn (expected %d)", blocks, len(full), len(partial), len(frames))
+		}
+		for i := range full {
+			if !reflect.DeepEqual(full[i], partial[i]) {
+				t.Errorf("%s: frame %d drawn partially differs from the full frame", blocks, i)
+			}
+		}
+		if partialSize >= fullSize {
+			t.Errorf("%s: partial frames wrote %d bytes, full frames %d", blocks, partialSize, fullSize)
+		}
+	}
+}
+
+func TestScaleRect(t *testing.T) {
+	defer func(s Scaler) { DefaultScaler = s }(DefaultScaler)
+	r := image.Rect(4, 4, 6, 6)
+	from, to := image.Pt(10, 10), image.Pt(20, 20)
+	for name, want := range map[string]image.Rectangle{
+		"box":         image.Rect(7, 7, 13, 13),
+		"nearest":     image.Rect(7, 7, 13, 13),
+		"pixel":       image.Rect(7, 7, 13, 13),
+		"bilinear":    {},
+		"catmull-rom": {},
+	} {
+		DefaultScaler = scalers[name]
+		if got := scaleRect(r, from, to); got != want {
+			t.Errorf("%s: %v (expected %v)", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"time"
+)
+
+// Corners of the main image that a picture-in-picture may be placed in.
+var pipPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// pipFraction is the size of a picture-in-picture relative to the main image.
+const pipFraction = 3
+
+// PiPFrames overlays the looping animation pip on a corner of each frame
+// received over frames.  The pip frame shown is chosen by the time elapsed in
+// the main animation so the two play at their own rates.  PiPFrames must
+// receive frames that are already scaled to cells.
+func PiPFrames(ctx context.Context, pip []*Frame, pos string, fontAspect float64, frames <-chan *Frame) <-chan *Frame {
+	if len(pip) == 0 {
+		return frames
+	}
+	var total time.Duration
+	for _, f := range pip {
+		total += frameDelay(f)
+	}
+
+	scaled := make(map[image.Point][]image.Image)
+	var t time.Duration
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		rect := f.Image.Bounds()
+		imgs, ok := scaled[rect.Size()]
+		if !ok {
+			imgs = scalePiP(pip, rect.Size(), fontAspect)
+			scaled[rect.Size()] = imgs
+		}
+
+		// find the pip frame being displayed at time t.
+		i := 0
+		for elapsed := t % total; elapsed >= frameDelay(pip[i]); i++ {
+			elapsed -= frameDelay(pip[i])
+		}
+
+		img := image.NewRGBA64(rect)
+		draw.Draw(img, rect, f.Image, rect.Min, draw.Src)
+		src := imgs[i]
+		draw.Draw(img, pipRect(rect, src.Bounds().Size(), pos), src, src.Bounds().Min, draw.Over)
+
+		g := &Frame{
+			Image:     img,
+			Delay:     f.Delay,
+			LoopCount: f.LoopCount,
+			Source:    f.Source,
+			LoopEnd:   f.LoopEnd,
+		}
+		if f.Delay > 0 {
+			t += f.Delay
+		} else {
+			t += DelayDefault
+		}
+		return g, nil
+	})
+}
+
+// scalePiP scales the pip frames to fit within a fraction of a main image of
+// the given size.
+func scalePiP(pip []*Frame, size image.Point, fontAspect float64) []image.Image {
+	imgs := make([]image.Image, len(pip))
+	for i, f := range pip {
+		s := sizeRect(f.Image.Bounds().Size(), size.X/pipFraction, size.Y/pipFraction, fontAspect)
+		if s.X < 1 || s.Y < 1 {
+			s = image.Pt(1, 1)
+		}
+		imgs[i] = scaleImage(f.Image, s.X, s.Y)
+	}
+	return imgs
+}
+
+// pipRect returns the rectangle of the given size in the corner of rect named
+// by pos, inset by one cell.
+func pipRect(rect image.Rectangle, size image.Point, pos string) image.Rectangle {
+	min := image.Pt(rect.Min.X+1, rect.Min.Y+1)
+	switch pos {
+	case "top-right", "bottom-right":
+		min.X = rect.Max.X - size.X - 1
+	}
+	switch pos {
+	case "bottom-left", "bottom-right":
+		min.Y = rect.Max.Y - size.Y - 1
+	}
+	return image.Rectangle{min, min.Add(size)}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Stage is a step of the frame pipeline.  A stage receives frames until
+// frames is closed or ctx is done, and closes the channel it returns when it
+// stops.  A stage with nothing to do may return frames itself.
+type Stage func(ctx context.Context, frames <-chan *Frame) <-chan *Frame
+
+// Pipeline is a sequence of named stages which frames flow through in order.
+// The output of each stage is recorded by the pipeline's stageMonitor under
+// the stage's name.
+type Pipeline struct {
+	monitor  *stageMonitor
+	stages   []pipelineStage
+	deadline time.Time
+}
+
+type pipelineStage struct {
+	name     string
+	stage    Stage
+	optional bool
+}
+
+// NewPipeline returns an empty pipeline whose stages are recorded by
+// monitor, which may be nil.
+func NewPipeline(monitor *stageMonitor) *Pipeline {
+	return &Pipeline{monitor: monitor}
+}
+
+// Add appends stage to the pipeline.  A nil stage is skipped.
+func (p *Pipeline) Add(name string, stage Stage) *Pipeline {
+	if stage != nil {
+		p.stages = append(p.stages, pipelineStage{name: name, stage: stage})
+	}
+	return p
+}
+
+// AddOptional appends stage to the pipeline as a stage which is skipped if
+// frames reach it after the pipeline's deadline, see SetDeadline.  Stages
+// which improve output but are not needed to draw it may be optional.
+func (p *Pipeline) AddOptional(ok bool, name string, stage Stage) *Pipeline {
+	if !ok || stage == nil {
+		return p
+	}
+	p.stages = append(p.stages, pipelineStage{name: name, stage: stage, optional: true})
+	return p
+}
+
+// SetDeadline sets the time after which optional stages are skipped.  The
+// zero time, the default, never skips them.
+func (p *Pipeline) SetDeadline(t time.Time) *Pipeline {
+	p.deadline = t
+	return p
+}
+
+// AddIf appends stage to the pipeline if ok is true, so that stages enabled
+// by flags can be listed along with the rest.
+func (p *Pipeline) AddIf(ok bool, name string, stage Stage) *Pipeline {
+	if !ok {
+		return p
+	}
+	return p.Add(name, stage)
+}
+
+// Run starts the stages, connecting frames to the first, and returns the
+// output of the last.  Stages stop when ctx is done.
+func (p *Pipeline) Run(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+	for _, s := range p.stages {
+		stage := s.stage
+		if s.optional && !p.deadline.IsZero() {
+			stage = skipAfter(p.deadline, s.name, stage)
+		}
+		out := stage(ctx, frames)
+		if out != frames {
+			// stages which had nothing to do are not worth recording.
+			out = monitorFrames(ctx, p.monitor, s.name, out)
+		}
+		frames = out
+	}
+	return frames
+}
+
+// skipAfter returns a stage which runs stage unless the first frame arrives
+// after deadline, in which case frames pass through unchanged.  The choice is
+// made once so that frames are not reordered.
+func skipAfter(deadline time.Time, name string, stage Stage) Stage {
+	return func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		out := make(chan *Frame, PipelineBuffer)
+		go func() {
+			defer close(out)
+			var first *Frame
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok := <-frames:
+				if !ok {
+					return
+				}
+				first = f
+			}
+			var result <-chan *Frame
+			if late := time.Since(deadline); late > 0 {
+				logger(logRender).Debug("stage skipped", "stage", name, "late", late)
+				result = prependFrame(ctx, first, frames)
+			} else {
+				result = stage(ctx, prependFrame(ctx, first, frames))
+			}
+			for f := range result {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- f:
+				}
+			}
+		}()
+		return out
+	}
+}
+
+// prependFrame returns a channel receiving f followed by frames.
+func prependFrame(ctx context.Context, f *Frame, frames <-chan *Frame) <-chan *Frame {
+	out := make(chan *Frame, PipelineBuffer)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- f:
+			}
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok = <-frames:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// mapFrames implements a stage which transforms each frame independently.
+// Each frame received over frames is passed to fn and the frame fn returns is
+// sent on the returned channel.  If fn returns an error the pipeline fails
+// with it, see failPipeline.
+func mapFrames(ctx context.Context, frames <-chan *Frame, fn func(f *Frame) (*Frame, error)) <-chan *Frame {
+	out := make(chan *Frame, PipelineBuffer)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok := <-frames:
+				if !ok {
+					return
+				}
+				g, err := fn(f)
+				if err != nil {
+					failPipeline(ctx, err)
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- g:
+				}
+			}
+		}
+	}()
+	return out
+}
+
+type pipelineFailKey struct{}
+
+// withPipelineErrors returns a context through which the goroutines of a
+// pipeline report errors with failPipeline.  The first error reported
+// cancels the context, stopping the pipeline, and is returned by
+// context.Cause.
+func withPipelineErrors(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	ctx = context.WithValue(ctx, pipelineFailKey{}, cancel)
+	return ctx, func() { cancel(nil) }
+}
+
+// failPipeline stops the pipeline running with ctx because of err.  If ctx
+// was not created by withPipelineErrors the error is dropped, the goroutine
+// reporting it must still stop.
+func failPipeline(ctx context.Context, err error) {
+	if cancel, ok := ctx.Value(pipelineFailKey{}).(context.CancelCauseFunc); ok {
+		cancel(err)
+	}
+}
+
+// pipelineError returns err, the result of consuming the output of a
+// pipeline, unless the pipeline failed, in which case the failure is returned
+// instead.  Consumers often stop with ctx.Err() when the pipeline fails,
+// which does not say why.
+func pipelineError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil && cause != ctx.Err() {
+		return cause
+	}
+	return err
+}
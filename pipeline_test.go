@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkLeaks fails t if the number of running goroutines has not returned to
+// its value at the time checkLeaks was called when the returned function is
+// called.
+func checkLeaks(t *testing.T) func() {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	return func() {
+		t.Helper()
+		var n int
+		for i := 0; i < 100; i++ {
+			n = runtime.NumGoroutine()
+			if n <= before {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		buf := make([]byte, 1<<16)
+		buf = buf[:runtime.Stack(buf, true)]
+		t.Errorf("leaked %d goroutines:\n%s", n-before, buf)
+	}
+}
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	return img
+}
+
+// endlessFrames sends frames until ctx is cancelled.
+func endlessFrames(ctx context.Context) <-chan *Frame {
+	c := make(chan *Frame)
+	go func() {
+		defer close(c)
+		img := testImage(8, 8)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case c <- &Frame{Image: img, Delay: time.Millisecond}:
+			}
+		}
+	}()
+	return c
+}
+
+// testStageCancel receives one frame from a stage and then stops reading
+// before cancelling ctx.  All goroutines started by the stage must exit.
+func testStageCancel[T any](t *testing.T, stage func(ctx context.Context, frames <-chan *Frame) <-chan T) {
+	t.Helper()
+	done := checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := stage(ctx, endlessFrames(ctx))
+	select {
+	case <-out:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no output from stage")
+	}
+	// give the stage time to block on its next send.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	done()
+}
+
+func TestStagesCancel(t *testing.T) {
+	opts := &FrameOptions{Animate: true, Repeat: -1}
+	stages := map[string]func(ctx context.Context, frames <-chan *Frame) <-chan *Frame{
+		"resize": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return ResizeFrames(ctx, 4, 4, 0.5, frames)
+		},
+		"loop": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return LoopFrames(ctx, frames, opts)
+		},
+		"effect": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return EffectFrames(ctx, frameEffects["rainbow"], true, func() ANSIPalette { return DefaultPalette8 }, frames)
+		},
+		"transition": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return TransitionFrames(ctx, frameTransitions["fade"], 10*time.Millisecond, frames)
+		},
+		"interpolate": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return InterpolateFrames(ctx, 2, true, frames)
+		},
+		"kenburns": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return KenBurnsFrames(ctx, 10*time.Millisecond, frames)
+		},
+		"alpha": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return AlphaFrames(ctx, alphaThresholds{1, 0.5}, frames)
+		},
+		"dither": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return DitherFrames(ctx, NewBlueNoise(1), 0.2, frames)
+		},
+		"tonemap": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return ToneMapFrames(ctx, toneMapReinhard, frames)
+		},
+		"crop": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return CropFrames(ctx, 8, 2, 0.5, true, frames)
+		},
+		"remove-bg": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return RemoveBackgroundFrames(ctx, 0.1, frames)
+		},
+		"tile": func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+			return TileFrames(ctx, 16, 16, false, frames)
+		},
+	}
+	for name, stage := range stages {
+		t.Run(name, func(t *testing.T) {
+			testStageCancel(t, stage)
+		})
+	}
+	t.Run("ansi", func(t *testing.T) {
+		testStageCancel(t, func(ctx context.Context, frames <-chan *Frame) <-chan *ANSIFrame {
+			return writeANSIFrames(ctx, frames, new(Palette256), opts)
+		})
+	})
+}
+
+func TestDecodeFramesArgsCancel(t *testing.T) {
+	dir := t.TempDir()
+	var args []string
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		filename := filepath.Join(dir, name)
+		f, err := os.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = png.Encode(f, testImage(4, 4))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, filename)
+	}
+
+	done := checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	frames, err := decodeFramesArgs(ctx, false, args, &FrameOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := <-frames
+	if f == nil || f.Source != 0 {
+		t.Fatalf("unexpected first frame: %v", f)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	done()
+}
+
+func TestPlayerNoAnimate(t *testing.T) {
+	done := checkLeaks(t)
+	defer done()
+	ctx := context.Background()
+	opts := &FrameOptions{Repeat: -1}
+	c := make(chan *Frame, 2)
+	c <- &Frame{Image: testImage(2, 2)}
+	c <- &Frame{Image: testImage(2, 2)}
+	close(c)
+	n := 0
+	for range LoopFrames(ctx, c, opts) {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("expected frames to be played once without animation, got %d frames", n)
+	}
+}
+
+func TestPlayerLoopEnd(t *testing.T) {
+	done := checkLeaks(t)
+	defer done()
+	ctx := context.Background()
+	player := NewPlayer(&FrameOptions{Animate: true, Repeat: 2})
+	c := make(chan *Frame, 2)
+	c <- &Frame{Image: testImage(2, 2)}
+	c <- &Frame{Image: testImage(2, 2)}
+	close(c)
+	var ends []int
+	for f := range player.Play(ctx, c) {
+		ends = append(ends, f.LoopEnd)
+	}
+	if !reflect.DeepEqual(ends, []int{0, 0, 1, 0, 2, 0}) {
+		t.Errorf("loops ended before frames %v", ends)
+	}
+	if player.Ended() != 3 {
+		t.Errorf("playback ended after loop %d", player.Ended())
+	}
+}
+
+func TestDrawLoopHook(t *testing.T) {
+	var out bytes.Buffer
+	var loops []int
+	opts := &FrameOptions{
+		Animate:       true,
+		Deterministic: true,
+		LoopHook: func(loop int) {
+			loops = append(loops, loop)
+			fmt.Fprintf(&out, "<%d>", loop)
+		},
+	}
+	opts.player = NewPlayer(opts)
+	opts.player.ended = 2
+	frames := make(chan *ANSIFrame, 3)
+	for i, end := range []int{0, 1, 0} {
+		frames <- &ANSIFrame{Buffer: &frameBuffer{b: []byte(fmt.Sprint(i))}, LoopEnd: end}
+	}
+	close(frames)
+	if err := drawANSIFrames(context.Background(), &out, frames, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loops, []int{1, 2}) {
+		t.Errorf("loop hook called for loops %v", loops)
+	}
+	if got := out.String(); !strings.HasPrefix(got, "0<1>12<2>") {
+		t.Errorf("loops ended out of order with frames: %q", got)
+	}
+}
+
+func TestPlayerHold(t *testing.T) {
+	done := checkLeaks(t)
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames := func(source, n int) <-chan *Frame {
+		c := make(chan *Frame, n)
+		for i := 0; i < n; i++ {
+			c <- &Frame{Image: testImage(2, 2), Source: source}
+		}
+		close(c)
+		return c
+	}
+	for _, repeat := range []int{-1, 0} {
+		ctx, cancel := context.WithCancel(ctx)
+		player := NewPlayer(&FrameOptions{Animate: true, Repeat: repeat})
+		player.Hold()
+		out := player.Play(ctx, frames(0, 1))
+		<-out
+		select {
+		case <-out:
+			t.Fatalf("repeat %d: held frame played again", repeat)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if !player.Load(frames(1, 2)) {
+			t.Fatalf("repeat %d: load failed while holding", repeat)
+		}
+		for i := 0; i < 2; i++ {
+			if f := <-out; f.Source != 1 {
+				t.Fatalf("repeat %d: frame from source %d after load", repeat, f.Source)
+			}
+		}
+		cancel()
+		for range out {
+		}
+	}
+}
+
+func TestPlayerLoad(t *testing.T) {
+	done := checkLeaks(t)
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames := func(source, n int) <-chan *Frame {
+		c := make(chan *Frame, n)
+		for i := 0; i < n; i++ {
+			c <- &Frame{Image: testImage(2, 2), Source: source}
+		}
+		close(c)
+		return c
+	}
+	player := NewPlayer(&FrameOptions{Animate: true, Repeat: -1})
+	out := player.Play(ctx, frames(0, 2))
+	if f := <-out; f.Source != 0 {
+		t.Fatalf("first frame from source %d", f.Source)
+	}
+	if !player.Load(frames(1, 3)) {
+		t.Fatal("load failed during playback")
+	}
+	// frames of the first source may already be queued.
+	for i := 0; i < 10; i++ {
+		if f := <-out; f.Source == 1 {
+			break
+		}
+		if i == 9 {
+			t.Fatal("loaded frames not played")
+		}
+	}
+	for i := 0; i < 6; i++ {
+		if f := <-out; f.Source != 1 {
+			t.Fatalf("frame from source %d after load", f.Source)
+		}
+	}
+	if _, total, _ := player.Position(); total != 3 {
+		t.Errorf("%d frames after load, expected 3", total)
+	}
+	player.Stop()
+	for range out {
+	}
+	if player.Load(frames(2, 1)) {
+		t.Error("load succeeded after playback ended")
+	}
+}
+
+func TestPipelineFail(t *testing.T) {
+	done := checkLeaks(t)
+	defer done()
+	ctx, cancel := withPipelineErrors(context.Background())
+	defer cancel()
+	errFail := errors.New("stage failed")
+	n := 0
+	pipeline := NewPipeline(nil)
+	pipeline.Add("count", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+			n++
+			if n == 3 {
+				return nil, errFail
+			}
+			return f, nil
+		})
+	})
+	pipeline.AddIf(false, "skipped", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+		t.Error("disabled stage started")
+		return frames
+	})
+	out := pipeline.Run(ctx, endlessFrames(ctx))
+	var received int
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-out:
+			if open {
+				received++
+			}
+		case <-timeout:
+			t.Fatal("pipeline did not stop")
+		}
+	}
+	if received != 2 {
+		t.Errorf("received %d frames, expected 2", received)
+	}
+	if err := context.Cause(ctx); err != errFail {
+		t.Errorf("cause %v, expected %v", err, errFail)
+	}
+}
+
+// panicPalette panics on red pixels, as a palette might for a color it
+// cannot index.
+type panicPalette struct{ Palette256 }
+
+func (p *panicPalette) ANSI(c color.Color) string {
+	if r, g, b, _ := c.RGBA(); r == 0xffff && g == 0 && b == 0 {
+		panic("bad color")
+	}
+	return p.Palette256.ANSI(c)
+}
+
+func TestWriteANSIFramesPanic(t *testing.T) {
+	uniform := func(c color.Color) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		return img
+	}
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	frames := make(chan *Frame, 4)
+	frames <- &Frame{Image: uniform(red)}
+	frames <- &Frame{Image: uniform(blue)}
+	frames <- &Frame{Image: uniform(red)}
+	frames <- &Frame{Image: nil}
+	close(frames)
+
+	opts := &FrameOptions{Animate: true}
+	var out []string
+	var rows []int
+	for f := range writeANSIFrames(context.Background(), frames, new(panicPalette), opts) {
+		var b strings.Builder
+		f.Buffer.FlushTo(&b)
+		out = append(out, b.String())
+		rows = append(rows, f.Rows)
+	}
+	if len(out) != 4 {
+		t.Fatalf("%d frames, expected 4", len(out))
+	}
+	if rows[0] != 8 || strings.Contains(out[0], "\033[48;5;") {
+		t.Errorf("first frame is not a transparent placeholder: %d rows %q", rows[0], out[0])
+	}
+	// frames after the first start by moving the cursor up.
+	body := func(s string) string { return s[strings.Index(s, "A")+1:] }
+	if body(out[2]) != body(out[1]) || rows[2] != rows[1] {
+		t.Errorf("failed frame not replaced by the previous frame")
+	}
+	if body(out[3]) != body(out[1]) || rows[3] != rows[1] {
+		t.Errorf("nil frame not replaced by the previous frame: %q", out[3])
+	}
+}
+
+func TestPipelineDeadline(t *testing.T) {
+	done := checkLeaks(t)
+	defer done()
+	for _, test := range []struct {
+		name     string
+		deadline time.Time
+		marked   bool
+	}{
+		{"no deadline", time.Time{}, true},
+		{"before deadline", time.Now().Add(time.Hour), true},
+		{"after deadline", time.Now().Add(-time.Second), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			frames := make(chan *Frame, 3)
+			for i := 0; i < 3; i++ {
+				frames <- &Frame{Source: i}
+			}
+			close(frames)
+			pipeline := NewPipeline(nil).SetDeadline(test.deadline)
+			pipeline.AddOptional(true, "mark", func(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+				return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+					g := *f
+					g.Delay = time.Second
+					return &g, nil
+				})
+			})
+			var i int
+			for f := range pipeline.Run(ctx, frames) {
+				if f.Source != i {
+					t.Errorf("frame %d received in position %d", f.Source, i)
+				}
+				if marked := f.Delay != 0; marked != test.marked {
+					t.Errorf("frame %d marked %v, expected %v", i, marked, test.marked)
+				}
+				i++
+			}
+			if i != 3 {
+				t.Errorf("received %d frames, expected 3", i)
+			}
+		})
+	}
+}
+
+func TestInterpolateFramesLoop(t *testing.T) {
+	a := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	b := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	b.Set(0, 0, color.RGBA{200, 100, 0, 255})
+	for _, loop := range []bool{false, true} {
+		frames := make(chan *Frame, 2)
+		frames <- &Frame{Image: a, Delay: 100 * time.Millisecond}
+		frames <- &Frame{Image: b, Delay: 60 * time.Millisecond}
+		close(frames)
+		var got []*Frame
+		for f := range InterpolateFrames(context.Background(), 2, loop, frames) {
+			got = append(got, f)
+		}
+		n := 3
+		if loop {
+			n = 4
+		}
+		if len(got) != n {
+			t.Fatalf("loop %v: %d frames (expected %d)", loop, len(got), n)
+		}
+		if got[0].Image != a || got[2].Image != b {
+			t.Errorf("loop %v: input frames not kept in place", loop)
+		}
+		if got[1].Delay != 50*time.Millisecond {
+			t.Errorf("loop %v: inserted frame delay %v (expected 50ms)", loop, got[1].Delay)
+		}
+		if !loop {
+			continue
+		}
+		if got[2].Delay != 30*time.Millisecond || got[3].Delay != 30*time.Millisecond {
+			t.Errorf("last frame delays %v and %v (expected 30ms)", got[2].Delay, got[3].Delay)
+		}
+		c := color.RGBAModel.Convert(got[3].Image.At(0, 0)).(color.RGBA)
+		if c.R != 100 || c.G != 50 {
+			t.Errorf("wrap frame %v, expected halfway from the last frame to the first", c)
+		}
+	}
+}
+
+// TestEffectFadeInStill checks that a still image faded in is drawn as the
+// fade ends rather than black.
+func TestEffectFadeInStill(t *testing.T) {
+	c := make(chan *Frame, 1)
+	c <- &Frame{Image: testImage(2, 2)}
+	close(c)
+	truecolor := func() ANSIPalette { return new(PaletteTrueColor) }
+	for f := range EffectFrames(context.Background(), frameEffects["fade-in"], false, truecolor, c) {
+		if r, g, b, _ := f.Image.At(1, 1).RGBA(); r == 0 && g == 0 && b == 0 {
+			t.Errorf("still image faded in drawn black")
+		}
+	}
+}
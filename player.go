@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Player loops a sequence of frames and allows playback to be controlled
+// while it runs.  Frames are retained as they are received so that they can
+// be replayed and sought.
+type Player struct {
+	repeat  int
+	animate bool
+	hold    bool // hold the last frame when playback would end
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	frames []*Frame
+	loaded bool // all frames have been received
+	pos    int  // index of the next frame
+	loop   int  // number of completed loops
+	ending int  // loop ended before the next frame, or zero
+	ended  int  // last loop played once playback has ended, or zero
+	paused bool
+	steps  int // frames to play while paused
+	speed  float64
+	stop   bool
+
+	loads chan playerLoad // sources passed to Load
+	done  chan struct{}   // closed when playback ends
+}
+
+// NewPlayer returns a Player which loops frames according to opts.Repeat.
+// Frames are only looped when opts.Animate is true.  The first frame of each
+// loop after the first has LoopEnd set to the number of the loop before it.
+func NewPlayer(opts *FrameOptions) *Player {
+	p := &Player{
+		repeat:  opts.Repeat,
+		animate: opts.Animate,
+		speed:   1,
+		loads:   make(chan playerLoad),
+		done:    make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// LoopFrames plays frames with a new Player.
+func LoopFrames(ctx context.Context, frames <-chan *Frame, fopts *FrameOptions) <-chan *Frame {
+	return NewPlayer(fopts).Play(ctx, frames)
+}
+
+// Play receives frames and returns a channel over which they are sent in
+// playback order.  The frames of the first loop are sent as soon as they are
+// received.  Play must only be called once.
+func (p *Player) Play(ctx context.Context, frames <-chan *Frame) <-chan *Frame {
+	stop := context.AfterFunc(ctx, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.cond.Broadcast()
+	})
+
+	go func() {
+		setLoaded := func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.loaded = true
+			p.cond.Broadcast()
+		}
+		defer setLoaded()
+		for {
+			// once frames is closed and set to nil only Load can
+			// provide more.
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.done:
+				return
+			case load := <-p.loads:
+				frames = load.frames
+				p.mu.Lock()
+				p.frames = nil
+				p.loaded = false
+				p.pos = 0
+				p.loop = 0
+				p.ending = 0
+				p.cond.Broadcast()
+				p.mu.Unlock()
+				close(load.reset)
+			case f, ok := <-frames:
+				if !ok {
+					frames = nil
+					setLoaded()
+					continue
+				}
+				p.mu.Lock()
+				p.frames = append(p.frames, f)
+				p.cond.Broadcast()
+				p.mu.Unlock()
+			}
+		}
+	}()
+
+	looped := make(chan *Frame, PipelineBuffer)
+	go func() {
+		defer close(looped)
+		defer close(p.done)
+		defer stop()
+		for {
+			f, ok := p.next(ctx)
+			if !ok {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case looped <- f:
+			}
+		}
+	}()
+	return looped
+}
+
+// next waits until a frame should be played and returns it.
+func (p *Player) next(ctx context.Context) (*Frame, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if ctx.Err() != nil || p.stop {
+			return nil, false
+		}
+		if p.paused && p.steps == 0 {
+			p.cond.Wait()
+			continue
+		}
+		if p.pos >= len(p.frames) {
+			if !p.loaded {
+				p.cond.Wait()
+				continue
+			}
+			if p.hold && (len(p.frames) <= 1 || p.loop == p.loops()) {
+				// wait for Load.
+				p.cond.Wait()
+				continue
+			}
+			if len(p.frames) == 0 || !p.rewind() {
+				return nil, false
+			}
+		}
+		break
+	}
+	f := p.frames[p.pos]
+	p.pos++
+	if p.paused {
+		p.steps--
+	}
+	if p.speed == 1 && p.ending == 0 {
+		return f, true
+	}
+	g := *f
+	g.LoopEnd, p.ending = p.ending, 0
+	if p.speed != 1 {
+		g.Delay = time.Duration(float64(frameDelay(f)) / p.speed)
+	}
+	return &g, true
+}
+
+// rewind moves to the beginning of the next loop, returning false if all
+// loops have been played.
+func (p *Player) rewind() bool {
+	if p.loop == p.loops() {
+		p.ended = p.loop + 1
+		return false
+	}
+	p.loop++
+	p.ending = p.loop
+	p.pos = 0
+	return true
+}
+
+// loops returns the number of times the frames are played again after the
+// first, or -1 if they are looped indefinitely.
+func (p *Player) loops() int {
+	if !p.animate {
+		// without animation each loop would be drawn below the last.
+		return 0
+	}
+	if p.repeat >= 0 {
+		return p.repeat
+	}
+	return -1
+}
+
+// Hold makes the Player keep its last frame displayed once every loop has
+// been played, rather than ending playback, until Load replaces the frames.
+// A single frame is held without being looped, so that it is not drawn again
+// for nothing.  Hold must be called before Play.
+func (p *Player) Hold() {
+	p.hold = true
+}
+
+// Ended returns the number of the last loop, counting from 1, once playback
+// has ended after playing every loop.  Otherwise, including when playback is
+// stopped, Ended returns zero.  Ended may be called on a nil Player.
+func (p *Player) Ended() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ended
+}
+
+// loopEnded calls opts.LoopHook as loop ends, if loop is positive.  It is
+// called by the stage drawing frames, so that the hook runs when the end of
+// the loop is displayed rather than when the Player reaches it.
+func (opts *FrameOptions) loopEnded(loop int) {
+	if opts != nil && opts.LoopHook != nil && loop > 0 {
+		opts.LoopHook(loop)
+	}
+}
+
+// Pause stops playback after the frame currently being played.
+func (p *Player) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+	p.steps = 0
+}
+
+// Resume continues paused playback.
+func (p *Player) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	p.cond.Broadcast()
+}
+
+// Paused reports whether playback is paused.
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// Next plays a single frame while playback is paused.
+func (p *Player) Next() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		p.steps++
+		p.cond.Broadcast()
+	}
+}
+
+// Seek makes frame i the next frame played.  If i is out of range the nearest
+// received frame is used instead.
+func (p *Player) Seek(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pos = clampInt(i, 0, len(p.frames)-1)
+	if p.pos < 0 {
+		p.pos = 0
+	}
+	p.cond.Broadcast()
+}
+
+// SetSpeed scales the rate of playback.  A speed of 2 plays frames twice as
+// fast as their delays specify.  Speeds that are not positive are ignored.
+func (p *Player) SetSpeed(speed float64) {
+	if speed <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.speed = speed
+}
+
+// Load replaces the frames being played with frames, which are played from
+// the beginning as they are received.  Load returns false if playback has
+// ended.  Load must not be called before Play.
+func (p *Player) Load(frames <-chan *Frame) bool {
+	load := playerLoad{frames, make(chan struct{})}
+	select {
+	case p.loads <- load:
+	case <-p.done:
+		return false
+	}
+	// frames already queued may be played, but no others of the previous
+	// source once Load returns.
+	<-load.reset
+	return true
+}
+
+// playerLoad is a source passed to Load.  reset is closed once the frames
+// of the previous source have been discarded.
+type playerLoad struct {
+	frames <-chan *Frame
+	reset  chan struct{}
+}
+
+// Redraw plays the most recently played frame again while playback is
+// paused, so that changes to how frames are drawn are seen.
+func (p *Player) Redraw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return
+	}
+	if p.pos > 0 {
+		p.pos--
+	}
+	p.steps++
+	p.cond.Broadcast()
+}
+
+// Stop ends playback after the frame currently being played.
+func (p *Player) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stop = true
+	p.cond.Broadcast()
+}
+
+// Elapsed returns the playing time of the frames before the next frame in
+// the current loop and the playing time of all frames received.  Times are
+// not adjusted for the playback speed.
+func (p *Player) Elapsed() (elapsed, total time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, f := range p.frames {
+		if i < p.pos {
+			elapsed += frameDelay(f)
+		}
+		total += frameDelay(f)
+	}
+	return elapsed, total
+}
+
+// frameDelay returns the time f is displayed for when played.
+func frameDelay(f *Frame) time.Duration {
+	if f.Delay > 0 {
+		return f.Delay
+	}
+	return DelayDefault
+}
+
+// Position returns the index of the most recently played frame and the
+// number of frames received.  The number of frames is final once loaded is
+// true.
+func (p *Player) Position() (frame, total int, loaded bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pos - 1, len(p.frames), p.loaded
+}
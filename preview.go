@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PreviewBudget is the time -preview-mode allows from startup until frames
+// reach the optional stages of the pipeline.  Optional stages reached later
+// are skipped, so that scrolling through a directory in a file manager is
+// not held up by a large image.
+const PreviewBudget = 100 * time.Millisecond
+
+// previewFlags are set by -preview-mode unless they are given explicitly.
+// File managers capture the output of previewers, so the terminal cannot be
+// queried and output must fit the preview pane exactly.
+var previewFlags = map[string]string{
+	"color":         "256",
+	"animation":     StrategyCursor,
+	"sync":          "off",
+	"passthrough":   "off",
+	"assume-remote": "off",
+	"deterministic": "true",
+	"pad":           "",
+}
+
+// parsePreviewArgs interprets the arguments file managers pass to previewers.
+// lf passes the path of the file followed by the width, height and position
+// of the preview pane, and scripts for ranger and nnn are easily written to
+// do the same.  The position is ignored.  If args only names an image, zero
+// dimensions are returned.
+//
+// -preview-mode exits with status 0 when a preview was drawn and 1
+// otherwise, which ranger treats as no preview and lf as a preview that must
+// not be cached.
+func parsePreviewArgs(args []string) (image string, width, height int, err error) {
+	switch len(args) {
+	case 1:
+		return args[0], 0, 0, nil
+	case 3, 5:
+	default:
+		return "", 0, 0, fmt.Errorf("preview arguments must be: image [width height [x y]]")
+	}
+	width, err = strconv.Atoi(args[1])
+	if err == nil {
+		height, err = strconv.Atoi(args[2])
+	}
+	if err != nil || width < 1 || height < 1 {
+		return "", 0, 0, fmt.Errorf("invalid preview dimensions %q %q", args[1], args[2])
+	}
+	return args[0], width, height, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+)
+
+// profiles are named bundles of flag values for common scenarios.  A
+// profile only sets flags which were not given explicitly.
+var profiles = map[string]map[string]string{
+	// ssh-slow keeps output small and avoids terminal queries, which each
+	// cost a round trip.  Animations play at most 10 frames per second,
+	// keeping the delays of slower frames.
+	"ssh-slow": {
+		"color":     "8",
+		"animation": StrategyCursor,
+		"sync":      "off",
+		"min-delay": "100ms",
+	},
+	// local-truecolor uses the most accurate palette and every capability
+	// the terminal reports.
+	"local-truecolor": {
+		"color":     "truecolor",
+		"animation": "auto",
+		"sync":      "auto",
+	},
+	// bbs targets 80 column, 8 color terminals that cannot be queried.
+	"bbs": {
+		"color":       "8",
+		"cols":        "80",
+		"animation":   StrategyCursor,
+		"sync":        "off",
+		"passthrough": "off",
+	},
+	// crisp keeps text and charts in screenshots, such as of dashboards,
+	// readable: colors are not dithered, images are only resized by whole
+	// number ratios and contrast is increased.
+	"crisp": {
+		"dither":     "",
+		"snap-scale": "true",
+		"contrast":   "1.4",
+	},
+	// motd produces output suitable for saving and printing with cat.
+	"motd": {
+		"color":       "256",
+		"cols":        "80",
+		"animation":   StrategyCursor,
+		"sync":        "off",
+		"passthrough": "off",
+		"pad":         "",
+	},
+}
+
+func Profiles() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile sets the flags of the named profile in fs.  Flags which have
+// already been set are not changed so they override the profile.
+func applyProfile(fs *flag.FlagSet, name string) error {
+	profile, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("profile not one of %q", Profiles())
+	}
+	return setUnsetFlags(fs, profile)
+}
+
+// setUnsetFlags sets the flags in values which have not been set already.
+func setUnsetFlags(fs *flag.FlagSet, values map[string]string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, value := range values {
+		if set[name] {
+			continue
+		}
+		err := fs.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("profile flag %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ProgressiveInterval is the least time between coarse frames decoded from
+// an image which is still downloading.
+var ProgressiveInterval = 100 * time.Millisecond
+
+// decodeFramesProgressive decodes an image from body while it is read.
+// Progressive JPEGs, and PNGs, are sent as coarse Preview frames each time
+// more of them has arrived, followed by the image decoded in full.  Previews
+// are made apart from the reading of body and are dropped while the frames
+// before them are still being drawn, so the download is never held up.  PNGs
+// are inflated as they arrive and never held in memory whole, while JPEGs are
+// kept until they are complete because each preview decodes every scan
+// received.  Other formats are decoded as decodeFrames decodes them.  body is
+// closed once it has been read.  An image which cannot be downloaded or
+// decoded fails the pipeline.
+func decodeFramesProgressive(ctx context.Context, body io.ReadCloser, fopts *FrameOptions) (<-chan *Frame, error) {
+	var head bytes.Buffer
+	_, format, err := image.DecodeConfig(io.TeeReader(body, &head))
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	switch format {
+	case "jpeg":
+		return decodeJPEGProgressive(ctx, &head, body), nil
+	case "png":
+		return decodePNGProgressive(ctx, io.MultiReader(&head, body), body), nil
+	}
+	defer body.Close()
+	return decodeFrames(ctx, io.MultiReader(&head, body), fopts)
+}
+
+// decodePNGProgressive decodes the PNG read from r, sending previews of it as
+// it is inflated.  body is closed once r has been read.
+func decodePNGProgressive(ctx context.Context, r io.Reader, body io.Closer) <-chan *Frame {
+	c := make(chan *Frame, 1)
+	go func() {
+		defer close(c)
+		defer body.Close()
+		s := &pngStream{updated: make(chan struct{}, 1)}
+		stop := make(chan struct{})
+		previews := make(chan struct{})
+		go func() {
+			defer close(previews)
+			sendPreviews(ctx, c, s.updated, stop, "png", s.preview)
+		}()
+		err := s.decode(r)
+		close(stop)
+		<-previews
+		if err != nil {
+			failPipeline(ctx, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case c <- &Frame{Image: s.img}:
+		}
+	}()
+	return c
+}
+
+// decodeJPEGProgressive decodes the JPEG beginning with head and continuing
+// in body, sending previews of its scans as they arrive.
+func decodeJPEGProgressive(ctx context.Context, head *bytes.Buffer, body io.ReadCloser) <-chan *Frame {
+	c := make(chan *Frame, 1)
+	go func() {
+		defer close(c)
+		defer body.Close()
+		var mu sync.Mutex
+		data := head
+		updated := make(chan struct{}, 1)
+		stop := make(chan struct{})
+		previews := make(chan struct{})
+		go func() {
+			defer close(previews)
+			sendPreviews(ctx, c, updated, stop, "jpeg", func() (image.Image, int) {
+				// data is only appended to, so the bytes received so
+				// far do not change while the preview is decoded.
+				mu.Lock()
+				b := data.Bytes()
+				mu.Unlock()
+				return previewJPEG(b)
+			})
+		}()
+		buf := make([]byte, 32<<10)
+		var err error
+		for err == nil {
+			var n int
+			n, err = body.Read(buf)
+			mu.Lock()
+			data.Write(buf[:n])
+			mu.Unlock()
+			notify(updated)
+		}
+		close(stop)
+		<-previews
+		if err != io.EOF {
+			failPipeline(ctx, fmt.Errorf("downloading image: %w", err))
+			return
+		}
+		img, err := jpeg.Decode(data)
+		if err != nil {
+			failPipeline(ctx, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case c <- &Frame{Image: img}:
+		}
+	}()
+	return c
+}
+
+// sendPreviews sends the images returned by preview to c as Preview frames
+// until stop is closed.  preview is called when updated receives, at most
+// once every ProgressiveInterval, and its image is sent only if its progress
+// has advanced.  A preview is dropped if the frame before it has not been
+// received, so that decoding never waits for drawing.
+func sendPreviews(ctx context.Context, c chan<- *Frame, updated, stop <-chan struct{}, format string, preview func() (image.Image, int)) {
+	var last time.Time
+	shown := 0 // progress of the last preview sent
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-updated:
+		}
+		if wait := ProgressiveInterval - time.Since(last); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+		last = time.Now()
+		img, progress := preview()
+		if img == nil || progress <= shown {
+			continue
+		}
+		select {
+		case c <- &Frame{Image: img, Preview: true}:
+			shown = progress
+			logger(logDecode).Debug("decoded preview", "format", format, "progress", progress)
+		default:
+		}
+	}
+}
+
+// notify signals c, which has a buffer of one, without waiting.
+func notify(c chan<- struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// previewJPEG decodes the complete scans at the beginning of data, part of a
+// progressive JPEG, as a coarse image.  The number of scans decoded is
+// returned with the image.  previewJPEG returns nil for baseline JPEGs,
+// which cannot be decoded in part.
+func previewJPEG(data []byte) (image.Image, int) {
+	ends, progressive := jpegScans(data)
+	if !progressive || len(ends) == 0 {
+		return nil, 0
+	}
+	// the decoder reconstructs a progressive image from the scans it has
+	// read when it reaches the end of the image.
+	end := ends[len(ends)-1]
+	trunc := append(data[:end:end], 0xff, 0xd9)
+	img, err := jpeg.Decode(bytes.NewReader(trunc))
+	if err != nil {
+		return nil, 0
+	}
+	return img, len(ends)
+}
+
+// jpegScans returns the offsets in data at which each complete scan of a JPEG
+// ends, and whether the JPEG is progressive.
+func jpegScans(data []byte) (ends []int, progressive bool) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, false
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return ends, progressive
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff:
+			// fill byte
+			i++
+			continue
+		case marker == 0xd9:
+			return ends, progressive
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			i += 2
+			continue
+		case marker == 0xc2:
+			progressive = true
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker != 0xda {
+			continue
+		}
+		// entropy coded data continues to the next marker which is not a
+		// stuffed byte or a restart marker.
+		for ; i+1 < len(data); i++ {
+			if next := data[i+1]; data[i] == 0xff && next != 0 && (next < 0xd0 || next > 0xd7) {
+				break
+			}
+		}
+		if i+1 >= len(data) {
+			return ends, progressive
+		}
+		ends = append(ends, i)
+	}
+	return ends, progressive
+}
+
+// adam7 are the passes of an interlaced PNG: the position of their first
+// pixel and the distance between their pixels.
+var adam7 = []pngPass{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// adam7Blocks are the blocks of pixels which the first n passes of an
+// interlaced PNG give one pixel of, at the top left of the block.
+var adam7Blocks = []image.Point{{8, 8}, {4, 8}, {4, 4}, {2, 4}, {2, 2}, {1, 2}, {1, 1}}
+
+// pngPass is a pass over the pixels of a PNG.  Images which are not
+// interlaced have one pass over every pixel.
+type pngPass struct{ x, y, dx, dy int }
+
+// size returns the number of columns and rows of pixels in pass p over an
+// image of the given size.
+func (p pngPass) size(width, height int) (int, int) {
+	return max(0, (width-p.x+p.dx-1)/p.dx), max(0, (height-p.y+p.dy-1)/p.dy)
+}
+
+// pngHeader holds the fields of a PNG's IHDR chunk.
+type pngHeader struct {
+	width, height int
+	depth         int // bits per sample
+	colorType     byte
+	interlaced    bool
+}
+
+// pngDepths are the bit depths allowed for each color type.
+var pngDepths = map[byte][]int{
+	0: {1, 2, 4, 8, 16},
+	2: {8, 16},
+	3: {1, 2, 4, 8},
+	4: {8, 16},
+	6: {8, 16},
+}
+
+// parsePNGHeader parses the body of an IHDR chunk.
+func parsePNGHeader(b []byte) (pngHeader, error) {
+	if len(b) != 13 {
+		return pngHeader{}, fmt.Errorf("png: invalid IHDR")
+	}
+	h := pngHeader{
+		width:      int(binary.BigEndian.Uint32(b[0:])),
+		height:     int(binary.BigEndian.Uint32(b[4:])),
+		depth:      int(b[8]),
+		colorType:  b[9],
+		interlaced: b[12] == 1,
+	}
+	if h.width <= 0 || h.height <= 0 || int64(h.width)*int64(h.height) > 1<<31/8 {
+		return pngHeader{}, fmt.Errorf("png: invalid dimensions %dx%d", h.width, h.height)
+	}
+	if !slices.Contains(pngDepths[h.colorType], h.depth) {
+		return pngHeader{}, fmt.Errorf("png: unsupported color type %d with bit depth %d", h.colorType, h.depth)
+	}
+	if b[10] != 0 || b[11] != 0 || b[12] > 1 {
+		return pngHeader{}, fmt.Errorf("png: unsupported compression, filter or interlace method")
+	}
+	return h, nil
+}
+
+// bitsPerPixel returns the number of bits encoding each pixel.
+func (h *pngHeader) bitsPerPixel() int {
+	channels := map[byte]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[h.colorType]
+	return channels * h.depth
+}
+
+// rowBytes returns the length of a filtered row of width pixels.
+func (h *pngHeader) rowBytes(width int) int {
+	return 1 + (width*h.bitsPerPixel()+7)/8
+}
+
+// passes returns the passes over the pixels of the image.
+func (h *pngHeader) passes() []pngPass {
+	if h.interlaced {
+		return adam7
+	}
+	return []pngPass{{0, 0, 1, 1}}
+}
+
+// pngStream decodes a PNG as it is read, inflating its image data as each
+// chunk arrives, so that the rows or interlace passes decoded so far can be
+// drawn as a preview.  The image is decoded to NRGBA, or NRGBA64 for images
+// with 16 bits a sample.
+type pngStream struct {
+	h       pngHeader
+	palette []color.NRGBA
+	trns    []byte
+
+	// updated is signalled each time more of the image is decoded.
+	updated chan struct{}
+
+	mu     sync.Mutex
+	img    draw.Image
+	rows   int // rows decoded of an image which is not interlaced
+	passes int // interlace passes decoded
+}
+
+// decode reads a PNG from r.  The image is decoded when decode returns nil.
+func (s *pngStream) decode(r io.Reader) (err error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	var sig [8]byte
+	_, err = io.ReadFull(r, sig[:])
+	if err != nil {
+		return err
+	}
+	if string(sig[:]) != signature {
+		return fmt.Errorf("png: invalid signature")
+	}
+
+	// image data is inflated by s.inflate as it is written to idat.
+	var idat *io.PipeWriter
+	inflated := make(chan error, 1)
+	defer func() {
+		if idat != nil {
+			idat.CloseWithError(err)
+		}
+	}()
+	for {
+		var head [8]byte
+		_, err = io.ReadFull(r, head[:])
+		if err != nil {
+			return noEOF(err)
+		}
+		n := int64(binary.BigEndian.Uint32(head[:4]))
+		typ := string(head[4:])
+		if n > 1<<31-1 {
+			return fmt.Errorf("png: chunk too long")
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(head[4:])
+		body := io.TeeReader(io.LimitReader(r, n), crc)
+		switch typ {
+		case "IHDR", "PLTE", "tRNS":
+			if n > 3*256 {
+				return fmt.Errorf("png: invalid %s", typ)
+			}
+			b := make([]byte, n)
+			_, err = io.ReadFull(body, b)
+			if err != nil {
+				return noEOF(err)
+			}
+			err = s.setChunk(typ, b)
+		case "IDAT":
+			if s.h.width == 0 {
+				return fmt.Errorf("png: image data before IHDR")
+			}
+			if idat == nil {
+				pr, pw := io.Pipe()
+				idat = pw
+				go func() {
+					err := s.inflate(pr)
+					pr.CloseWithError(err)
+					inflated <- err
+				}()
+			}
+			err = copyChunk(idat, body, n)
+		default:
+			err = copyChunk(io.Discard, body, n)
+		}
+		if err != nil {
+			return err
+		}
+		var sum [4]byte
+		_, err = io.ReadFull(r, sum[:])
+		if err != nil {
+			return noEOF(err)
+		}
+		if binary.BigEndian.Uint32(sum[:]) != crc.Sum32() {
+			return fmt.Errorf("png: invalid checksum of %s", typ)
+		}
+		if typ == "IEND" {
+			if idat == nil {
+				return fmt.Errorf("png: no image data")
+			}
+			idat.Close()
+			idat = nil
+			return <-inflated
+		}
+	}
+}
+
+// copyChunk copies the n bytes of a chunk's body to w.
+func copyChunk(w io.Writer, body io.Reader, n int64) error {
+	m, err := io.Copy(w, body)
+	if err == nil && m < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// noEOF returns io.ErrUnexpectedEOF in place of io.EOF, for input ending
+// before the IEND chunk.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// setChunk records the header, palette or transparency chunk b.
+func (s *pngStream) setChunk(typ string, b []byte) error {
+	var err error
+	switch typ {
+	case "IHDR":
+		s.h, err = parsePNGHeader(b)
+	case "PLTE":
+		if len(b)%3 != 0 {
+			return fmt.Errorf("png: invalid PLTE")
+		}
+		s.palette = make([]color.NRGBA, len(b)/3)
+		for i := range s.palette {
+			s.palette[i] = color.NRGBA{b[3*i], b[3*i+1], b[3*i+2], 0xff}
+		}
+	case "tRNS":
+		s.trns = b
+		if s.h.colorType == 3 {
+			for i := 0; i < len(b) && i < len(s.palette); i++ {
+				s.palette[i].A = b[i]
+			}
+		}
+	}
+	return err
+}
+
+// inflate decompresses the image data read from r and decodes its rows.  One
+// row is held, along with the row before it which filters refer to.
+func (s *pngStream) inflate(r io.Reader) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return err
+	}
+	rect := image.Rect(0, 0, s.h.width, s.h.height)
+	s.mu.Lock()
+	if s.h.depth == 16 {
+		s.img = image.NewNRGBA64(rect)
+	} else {
+		s.img = image.NewNRGBA(rect)
+	}
+	s.mu.Unlock()
+
+	bpp := max(1, s.h.bitsPerPixel()/8)
+	cur := make([]byte, s.h.rowBytes(s.h.width))
+	prev := make([]byte, len(cur))
+	for _, p := range s.h.passes() {
+		w, rows := p.size(s.h.width, s.h.height)
+		if w > 0 {
+			n := s.h.rowBytes(w)
+			cur, prev = cur[:n], prev[:n]
+			clear(prev)
+		}
+		for i := 0; i < rows && w > 0; i++ {
+			_, err := io.ReadFull(zr, cur)
+			if err != nil {
+				return noEOF(err)
+			}
+			err = unfilterPNG(cur[0], cur[1:], prev[1:], bpp)
+			if err != nil {
+				return err
+			}
+			s.mu.Lock()
+			s.setRow(cur[1:], p, i, w)
+			if !s.h.interlaced {
+				s.rows = i + 1
+			}
+			s.mu.Unlock()
+			notify(s.updated)
+			cur, prev = prev, cur
+		}
+		s.mu.Lock()
+		s.passes++
+		s.mu.Unlock()
+		notify(s.updated)
+	}
+	// the checksum follows the rows.
+	_, err = io.Copy(io.Discard, zr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// unfilterPNG reverses the filter ft applied to row, given the row before
+// it, prev, and the number of bytes in a pixel, bpp.
+func unfilterPNG(ft byte, row, prev []byte, bpp int) error {
+	switch ft {
+	case 0:
+	case 1:
+		for i := bpp; i < len(row); i++ {
+			row[i] += row[i-bpp]
+		}
+	case 2:
+		for i := range row {
+			row[i] += prev[i]
+		}
+	case 3:
+		for i := range row {
+			left := 0
+			if i >= bpp {
+				left = int(row[i-bpp])
+			}
+			row[i] += byte((left + int(prev[i])) / 2)
+		}
+	case 4:
+		abs := func(x int) int {
+			if x < 0 {
+				return -x
+			}
+			return x
+		}
+		for i := range row {
+			var a, c int
+			if i >= bpp {
+				a, c = int(row[i-bpp]), int(prev[i-bpp])
+			}
+			b := int(prev[i])
+			pa, pb, pc := abs(b-c), abs(a-c), abs(a+b-2*c)
+			switch {
+			case pa <= pb && pa <= pc:
+				row[i] += byte(a)
+			case pb <= pc:
+				row[i] += byte(b)
+			default:
+				row[i] += byte(c)
+			}
+		}
+	default:
+		return fmt.Errorf("png: invalid filter type %d", ft)
+	}
+	return nil
+}
+
+// setRow draws row i of pass p, holding w pixels, to the image.
+func (s *pngStream) setRow(row []byte, p pngPass, i, w int) {
+	y := p.y + i*p.dy
+	// sample returns sample j of the row, of a depth less than 16 bits.
+	sample := func(j int) int {
+		if s.h.depth == 8 {
+			return int(row[j])
+		}
+		bit := j * s.h.depth
+		return int(row[bit/8]>>(8-s.h.depth-bit%8)) & (1<<s.h.depth - 1)
+	}
+	// sample16 returns sample j of the row, of 16 bits.
+	sample16 := func(j int) uint16 {
+		return binary.BigEndian.Uint16(row[2*j:])
+	}
+	// transparent reports whether the samples, one for gray or three for
+	// color, are the color tRNS makes transparent.
+	transparent := func(v ...int) bool {
+		if s.h.colorType == 3 || len(s.trns) != 2*len(v) {
+			return false
+		}
+		for k, x := range v {
+			if int(binary.BigEndian.Uint16(s.trns[2*k:])) != x {
+				return false
+			}
+		}
+		return true
+	}
+	for j := 0; j < w; j++ {
+		x := p.x + j*p.dx
+		if s.h.depth == 16 {
+			img := s.img.(*image.NRGBA64)
+			var c color.NRGBA64
+			switch s.h.colorType {
+			case 0:
+				g := sample16(j)
+				c = color.NRGBA64{g, g, g, 0xffff}
+				if transparent(int(g)) {
+					c.A = 0
+				}
+			case 2:
+				c = color.NRGBA64{sample16(3 * j), sample16(3*j + 1), sample16(3*j + 2), 0xffff}
+				if transparent(int(c.R), int(c.G), int(c.B)) {
+					c.A = 0
+				}
+			case 4:
+				g := sample16(2 * j)
+				c = color.NRGBA64{g, g, g, sample16(2*j + 1)}
+			case 6:
+				c = color.NRGBA64{sample16(4 * j), sample16(4*j + 1), sample16(4*j + 2), sample16(4*j + 3)}
+			}
+			img.SetNRGBA64(x, y, c)
+			continue
+		}
+		img := s.img.(*image.NRGBA)
+		var c color.NRGBA
+		switch s.h.colorType {
+		case 0:
+			v := sample(j)
+			g := uint8(v * 0xff / (1<<s.h.depth - 1))
+			c = color.NRGBA{g, g, g, 0xff}
+			if transparent(v) {
+				c.A = 0
+			}
+		case 2:
+			c = color.NRGBA{row[3*j], row[3*j+1], row[3*j+2], 0xff}
+			if transparent(int(c.R), int(c.G), int(c.B)) {
+				c.A = 0
+			}
+		case 3:
+			// indexes beyond the palette are opaque black, as
+			// image/png decodes them.
+			c = color.NRGBA{A: 0xff}
+			if k := sample(j); k < len(s.palette) {
+				c = s.palette[k]
+			}
+		case 4:
+			c = color.NRGBA{row[2*j], row[2*j], row[2*j], row[2*j+1]}
+		case 6:
+			c = color.NRGBA{row[4*j], row[4*j+1], row[4*j+2], row[4*j+3]}
+		}
+		img.SetNRGBA(x, y, c)
+	}
+}
+
+// preview returns a copy of the image as far as it is decoded, or nil if
+// none of it is.  The rows of an image which is not interlaced are drawn as
+// far as they have been decoded and the rest left transparent.  For
+// interlaced images each complete pass is drawn in blocks filling the pixels
+// of the passes after it.  The number of rows or passes decoded is returned
+// with the image.
+func (s *pngStream) preview() (image.Image, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.img == nil {
+		// the header may still be being read.
+		return nil, 0
+	}
+	progress := s.rows
+	if s.h.interlaced {
+		progress = s.passes
+	}
+	if progress == 0 {
+		return nil, 0
+	}
+	if !s.h.interlaced || progress == len(adam7) {
+		switch img := s.img.(type) {
+		case *image.NRGBA:
+			return &image.NRGBA{Pix: bytes.Clone(img.Pix), Stride: img.Stride, Rect: img.Rect}, progress
+		case *image.NRGBA64:
+			return &image.NRGBA64{Pix: bytes.Clone(img.Pix), Stride: img.Stride, Rect: img.Rect}, progress
+		}
+	}
+	block := adam7Blocks[progress-1]
+	rect := s.img.Bounds()
+	coarse := image.NewRGBA64(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			coarse.Set(x, y, s.img.At(x-(x-rect.Min.X)%block.X, y-(y-rect.Min.Y)%block.Y))
+		}
+	}
+	return coarse, progress
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testProgressiveImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 21, 13))
+	for y := 0; y < 13; y++ {
+		for x := 0; x < 21; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 12), uint8(y * 19), uint8(x * y), 0xff})
+		}
+	}
+	return img
+}
+
+// encodeInterlacedPNG encodes img as an interlaced PNG, which image/png does
+// not write.
+func encodeInterlacedPNG(img *image.NRGBA) []byte {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	var raw []byte
+	for _, p := range adam7 {
+		for y := p.y; y < h; y += p.dy {
+			if p.x >= w {
+				break
+			}
+			raw = append(raw, 0)
+			for x := p.x; x < w; x += p.dx {
+				raw = append(raw, img.Pix[img.PixOffset(x, y):][:4]...)
+			}
+		}
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(raw)
+	zw.Close()
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	writeChunk := func(typ string, b []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(b)))
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(typ))
+		crc.Write(b)
+		buf.WriteString(typ)
+		buf.Write(b)
+		binary.Write(&buf, binary.BigEndian, crc.Sum32())
+	}
+	writeChunk("IHDR", []byte{0, 0, 0, byte(w), 0, 0, 0, byte(h), 8, 6, 0, 0, 1})
+	// the image data is split between chunks, as encoders may write it.
+	data := z.Bytes()
+	writeChunk("IDAT", data[:len(data)/2])
+	writeChunk("IDAT", data[len(data)/2:])
+	writeChunk("IEND", nil)
+	return buf.Bytes()
+}
+
+// sameColor reports whether c1 and c2 are the same color.
+func sameColor(c1, c2 color.Color) bool {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+func TestPreviewPNG(t *testing.T) {
+	img := testProgressiveImage()
+	data := encodeInterlacedPNG(img)
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.(*image.NRGBA).Pix, img.Pix) {
+		t.Fatalf("interlaced test image decoded incorrectly")
+	}
+
+	r, w := io.Pipe()
+	stream := &pngStream{updated: make(chan struct{}, 1)}
+	done := make(chan error, 1)
+	go func() { done <- stream.decode(r) }()
+	last := 0
+	check := func() {
+		preview, progress := stream.preview()
+		if preview == nil {
+			if last > 0 {
+				t.Fatalf("no preview after pass %d", last)
+			}
+			return
+		}
+		if progress < last {
+			t.Fatalf("pass %d decoded after pass %d", progress, last)
+		}
+		last = progress
+		// every pixel has the color of the top left of its block.
+		block := adam7Blocks[progress-1]
+		for y := 0; y < 13; y++ {
+			for x := 0; x < 21; x++ {
+				if !sameColor(preview.At(x, y), img.At(x-x%block.X, y-y%block.Y)) {
+					t.Fatalf("pixel %d,%d of pass %d differs", x, y, progress)
+				}
+			}
+		}
+	}
+	for i := 0; i < len(data); i += 16 {
+		w.Write(data[i:min(i+16, len(data))])
+		check()
+	}
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if last != len(adam7) {
+		t.Errorf("last preview of pass %d", last)
+	}
+}
+
+func TestPNGStream(t *testing.T) {
+	src := testProgressiveImage()
+	gray := image.NewGray(src.Rect)
+	gray16 := image.NewGray16(src.Rect)
+	rgba64 := image.NewNRGBA64(src.Rect)
+	paletted := image.NewPaletted(src.Rect, color.Palette{
+		color.Black, color.White, color.NRGBA{0xff, 0, 0, 0x80}, color.NRGBA{0, 0, 0xff, 0},
+	})
+	for y := 0; y < 13; y++ {
+		for x := 0; x < 21; x++ {
+			gray.Set(x, y, src.At(x, y))
+			gray16.Set(x, y, color.Gray16{uint16(x*3001 + y*7)})
+			rgba64.Set(x, y, color.NRGBA64{uint16(x * 3001), uint16(y * 5003), 0x1234, uint16(0xffff - x*y)})
+			paletted.SetColorIndex(x, y, uint8((x+y)%4))
+		}
+	}
+	for _, img := range []image.Image{src, gray, gray16, rgba64, paletted} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		want, err := png.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream := &pngStream{updated: make(chan struct{}, 1)}
+		if err := stream.decode(&buf); err != nil {
+			t.Fatalf("%T: %v", img, err)
+		}
+		for y := 0; y < 13; y++ {
+			for x := 0; x < 21; x++ {
+				if !sameColor(stream.img.At(x, y), want.At(x, y)) {
+					t.Fatalf("%T: pixel %d,%d is %v (expected %v)", img, x, y, stream.img.At(x, y), want.At(x, y))
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+	data := buf.Bytes()
+	stream := &pngStream{updated: make(chan struct{}, 1)}
+	if err := stream.decode(bytes.NewReader(data[:len(data)-20])); err == nil {
+		t.Errorf("truncated image decoded")
+	}
+}
+
+func TestDecodeFramesProgressive(t *testing.T) {
+	interval := ProgressiveInterval
+	ProgressiveInterval = 0
+	defer func() { ProgressiveInterval = interval }()
+
+	img := testProgressiveImage()
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	r, w := io.Pipe()
+	go func() {
+		for i := 0; i < len(data); i += 64 {
+			w.Write(data[i:min(i+64, len(data))])
+		}
+		w.Close()
+	}()
+	frames, err := decodeFramesProgressive(context.Background(), r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*Frame
+	for f := range frames {
+		got = append(got, f)
+	}
+	if len(got) < 2 {
+		t.Fatalf("%d frames decoded (expected previews)", len(got))
+	}
+	for i, f := range got {
+		if f.Preview != (i < len(got)-1) {
+			t.Errorf("frame %d preview %v", i, f.Preview)
+		}
+	}
+	final := got[len(got)-1].Image
+	for y := 0; y < 13; y++ {
+		for x := 0; x < 21; x++ {
+			if !sameColor(final.At(x, y), img.At(x, y)) {
+				t.Fatalf("pixel %d,%d of the final frame differs from the image", x, y)
+			}
+		}
+	}
+}
+
+func TestDecodeFramesProgressiveError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testProgressiveImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()[:buf.Len()-20]
+	ctx, stop := withPipelineErrors(context.Background())
+	defer stop()
+	frames, err := decodeFramesProgressive(ctx, io.NopCloser(bytes.NewReader(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for f := range frames {
+		if !f.Preview {
+			t.Errorf("truncated image decoded")
+		}
+	}
+	if context.Cause(ctx) == nil {
+		t.Errorf("truncated image did not fail the pipeline")
+	}
+}
+
+func TestDecodeFramesHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	_, err := decodeFramesHTTP(context.Background(), srv.URL, &FrameOptions{Progressive: true})
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("error %v (expected 404)", err)
+	}
+}
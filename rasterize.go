@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// RasterizeColumns is the width of ANSI art without a SAUCE record giving
+// its width, that of the terminals BBS art was drawn for.
+const RasterizeColumns = 80
+
+// RasterizeMaxColumns is the widest art rasterize draws.
+const RasterizeMaxColumns = 1024
+
+// cellGridMaxCells bounds the cells of a cellGrid.  Art is cut off at the
+// row reaching the bound, so that cursor movement in an untrusted file
+// cannot grow the grid without limit.
+const cellGridMaxCells = 1 << 20
+
+// rasterizeFace is the font cells are drawn with.  Block elements, shades
+// and box drawing characters, which it lacks, are drawn to fill their cells
+// instead.
+var rasterizeFace = basicfont.Face7x13
+
+// vgaColors are the 16 colors of the VGA text mode, in which most BBS art was
+// drawn.  Colors 16-255 are those of the 256 color palette.
+var vgaColors = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xff},
+	color.RGBA{0xaa, 0x00, 0x00, 0xff},
+	color.RGBA{0x00, 0xaa, 0x00, 0xff},
+	color.RGBA{0xaa, 0x55, 0x00, 0xff},
+	color.RGBA{0x00, 0x00, 0xaa, 0xff},
+	color.RGBA{0xaa, 0x00, 0xaa, 0xff},
+	color.RGBA{0x00, 0xaa, 0xaa, 0xff},
+	color.RGBA{0xaa, 0xaa, 0xaa, 0xff},
+	color.RGBA{0x55, 0x55, 0x55, 0xff},
+	color.RGBA{0xff, 0x55, 0x55, 0xff},
+	color.RGBA{0x55, 0xff, 0x55, 0xff},
+	color.RGBA{0xff, 0xff, 0x55, 0xff},
+	color.RGBA{0x55, 0x55, 0xff, 0xff},
+	color.RGBA{0xff, 0x55, 0xff, 0xff},
+	color.RGBA{0x55, 0xff, 0xff, 0xff},
+	color.RGBA{0xff, 0xff, 0xff, 0xff},
+}
+
+// rasterizeMain implements the rasterize subcommand, which draws ANSI art to
+// a PNG image, the inverse of what img2ansi otherwise does, for archiving
+// BBS art and previewing it where there is no terminal.  The cells of the art
+// can also be written in the json-cells format of -output-format.
+func rasterizeMain(args []string) error {
+	fs := flag.NewFlagSet("rasterize", flag.ExitOnError)
+	output := fs.String("o", "", "path of the output file, a PNG image or, if it ends in .json, the cells of the art (default the input path with a .png extension)")
+	columns := fs.Int("cols", 0, "width of the art in columns, where lines wrap (default the width in the file's SAUCE record, or 80)")
+	encoding := fs.String("encoding", "auto", "character encoding of the art (auto, cp437, utf8); auto uses utf8 if the art is valid UTF-8")
+	colors := fs.String("colors", "vga", "colors of the first 16 palette entries (vga, xterm)")
+	scale := fs.Int("scale", 1, "enlarge the image by an integer factor")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: img2ansi rasterize [flags] art.ans\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *scale < 1 {
+		return fmt.Errorf("rasterize: scale must be positive")
+	}
+	if *columns < 0 || *columns > RasterizeMaxColumns {
+		return fmt.Errorf("rasterize: cols must not be negative or more than %d", RasterizeMaxColumns)
+	}
+	var p color.Palette
+	switch *colors {
+	case "vga":
+		p = append(append(p, vgaColors...), palette256[16:]...)
+	case "xterm":
+		p = palette256
+	default:
+		return fmt.Errorf("rasterize: colors not one of %q", []string{"vga", "xterm"})
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data, sauceCols := stripSAUCE(data)
+	if *columns == 0 {
+		*columns = RasterizeColumns
+		if sauceCols > 0 {
+			*columns = min(sauceCols, RasterizeMaxColumns)
+		}
+	}
+	data, err = decodeANSIArt(data, *encoding)
+	if err != nil {
+		return fmt.Errorf("rasterize: %w", err)
+	}
+
+	grid := newCellGrid(*columns, p)
+	grid.Write(data)
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".png"
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(*output) == ".json" {
+		err = json.NewEncoder(f).Encode(grid.Frame())
+	} else {
+		err = png.Encode(f, drawCellGrid(grid.cells, p, *scale))
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stripSAUCE removes the SAUCE record, and the DOS end of file character
+// preceding it, from ANSI art.  The width of the art in the record, if any,
+// is returned.
+func stripSAUCE(data []byte) ([]byte, int) {
+	cols := 0
+	if n := len(data); n >= 128 && bytes.HasPrefix(data[n-128:], []byte("SAUCE00")) {
+		rec := data[n-128:]
+		// character data stores its width in TInfo1.
+		if rec[94] == 1 {
+			cols = int(binary.LittleEndian.Uint16(rec[96:98]))
+		}
+		data = data[:n-128]
+	}
+	if i := bytes.IndexByte(data, 0x1a); i >= 0 {
+		data = data[:i]
+	}
+	return data, cols
+}
+
+// decodeANSIArt decodes ANSI art in the named character encoding to UTF-8.
+// The encoding "auto" is UTF-8 if data is valid UTF-8 and code page 437, the
+// encoding of DOS and BBS art, if it is not.
+func decodeANSIArt(data []byte, encoding string) ([]byte, error) {
+	switch {
+	case encoding == "cp437", encoding == "auto" && !utf8.Valid(data):
+		return charmap.CodePage437.NewDecoder().Bytes(data)
+	case encoding == "utf8", encoding == "auto":
+		return data, nil
+	}
+	return nil, fmt.Errorf("encoding not one of %q", []string{"auto", "cp437", "utf8"})
+}
+
+// cellGrid is the screen ANSI art is drawn on, holding cells as they are
+// written by -output-format json-cells.  Only the sequences used by ANSI art
+// are interpreted: colors and attributes, cursor movement and erasing.
+type cellGrid struct {
+	cols    int
+	maxRows int
+	palette color.Palette
+	cells   [][]jsonCell
+
+	x, y         int
+	wrap         bool // the next character begins a new line
+	saveX, saveY int
+
+	fg, bg                   *string
+	fgIndex                  int // index of fg in the palette, or -1
+	bold, underline, reverse bool
+}
+
+func newCellGrid(cols int, p color.Palette) *cellGrid {
+	return &cellGrid{cols: cols, maxRows: cellGridMaxCells / cols, palette: p, fgIndex: -1}
+}
+
+// row returns row y, or the last row of the grid if y is beyond it.
+func (g *cellGrid) row(y int) int {
+	return min(y, g.maxRows-1)
+}
+
+// Write draws ANSI text on the grid.
+func (g *cellGrid) Write(data []byte) (int, error) {
+	for i := 0; i < len(data); {
+		if n := escapeLen(data[i:]); n > 0 {
+			g.escape(string(data[i : i+n]))
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		i += size
+		switch r {
+		case '\n':
+			g.x, g.y, g.wrap = 0, g.row(g.y+1), false
+		case '\r':
+			g.x, g.wrap = 0, false
+		case '\t':
+			for g.put(' '); g.x%8 != 0 && !g.wrap; {
+				g.put(' ')
+			}
+		default:
+			if r >= ' ' {
+				g.put(r)
+			}
+		}
+	}
+	return len(data), nil
+}
+
+// put writes r at the cursor and advances it.  Lines wrap when the next
+// character is written, so a newline after a full line does not leave an
+// empty one.
+func (g *cellGrid) put(r rune) {
+	if g.wrap {
+		g.x, g.y, g.wrap = 0, g.row(g.y+1), false
+	}
+	fg, bg := g.fg, g.bg
+	if g.bold && g.fgIndex >= 0 && g.fgIndex < 8 {
+		fg = hexColor(g.palette[g.fgIndex+8])
+	}
+	if g.reverse {
+		fg, bg = bg, fg
+		if fg == nil {
+			fg = hexColor(g.palette[0])
+		}
+		if bg == nil {
+			bg = hexColor(g.palette[7])
+		}
+	}
+	*g.cell(g.x, g.y) = jsonCell{Glyph: string(r), FG: fg, BG: bg, Bold: g.bold, Underline: g.underline}
+	g.x++
+	if g.x >= g.cols {
+		g.x, g.wrap = g.cols-1, true
+	}
+}
+
+// cell returns the cell at column x of row y, extending the grid to reach
+// it.
+func (g *cellGrid) cell(x, y int) *jsonCell {
+	for len(g.cells) <= y {
+		row := make([]jsonCell, g.cols)
+		for i := range row {
+			row[i].Glyph = " "
+		}
+		g.cells = append(g.cells, row)
+	}
+	return &g.cells[y][x]
+}
+
+// escape interprets an escape sequence.
+func (g *cellGrid) escape(seq string) {
+	if len(seq) < 3 || seq[1] != '[' {
+		return
+	}
+	var params []int
+	for _, s := range strings.Split(seq[2:len(seq)-1], ";") {
+		n, _ := strconv.Atoi(s)
+		// no parameter moves the cursor beyond the grid, larger ones
+		// would overflow.
+		params = append(params, min(n, cellGridMaxCells))
+	}
+	arg := func(i, def int) int {
+		if i < len(params) && params[i] > 0 {
+			return params[i]
+		}
+		return def
+	}
+	g.wrap = false
+	switch seq[len(seq)-1] {
+	case 'm':
+		g.sgr(params)
+	case 'A':
+		g.y = max(0, g.y-arg(0, 1))
+	case 'B':
+		g.y = g.row(g.y + arg(0, 1))
+	case 'C':
+		g.x = min(g.cols-1, g.x+arg(0, 1))
+	case 'D':
+		g.x = max(0, g.x-arg(0, 1))
+	case 'H', 'f':
+		g.y = g.row(arg(0, 1) - 1)
+		g.x = min(g.cols, arg(1, 1)) - 1
+	case 'J':
+		if arg(0, 0) == 2 {
+			g.cells = nil
+			g.x, g.y = 0, 0
+		}
+	case 'K':
+		for x := g.x; x < g.cols; x++ {
+			*g.cell(x, g.y) = jsonCell{Glyph: " ", BG: g.bg}
+		}
+	case 's':
+		g.saveX, g.saveY = g.x, g.y
+	case 'u':
+		g.x, g.y = g.saveX, g.saveY
+	}
+}
+
+// sgr sets the colors and attributes of the characters which follow.
+func (g *cellGrid) sgr(params []int) {
+	for i := 0; i < len(params); i++ {
+		switch n := params[i]; {
+		case n == 0:
+			g.fg, g.bg, g.fgIndex = nil, nil, -1
+			g.bold, g.underline, g.reverse = false, false, false
+		case n == 1:
+			g.bold = true
+		case n == 22:
+			g.bold = false
+		case n == 4:
+			g.underline = true
+		case n == 24:
+			g.underline = false
+		case n == 7:
+			g.reverse = true
+		case n == 27:
+			g.reverse = false
+		case n >= 30 && n <= 37:
+			g.fg, g.fgIndex = hexColor(g.palette[n-30]), n-30
+		case n == 39:
+			g.fg, g.fgIndex = nil, -1
+		case n >= 40 && n <= 47:
+			g.bg = hexColor(g.palette[n-40])
+		case n == 49:
+			g.bg = nil
+		case n >= 90 && n <= 97:
+			g.fg, g.fgIndex = hexColor(g.palette[n-90+8]), -1
+		case n >= 100 && n <= 107:
+			g.bg = hexColor(g.palette[n-100+8])
+		case n == 38 || n == 48:
+			var c color.Color
+			switch {
+			case i+2 < len(params) && params[i+1] == 5:
+				c = g.palette[params[i+2]&0xff]
+				i += 2
+			case i+4 < len(params) && params[i+1] == 2:
+				c = color.RGBA{uint8(params[i+2]), uint8(params[i+3]), uint8(params[i+4]), 0xff}
+				i += 4
+			default:
+				return
+			}
+			if n == 38 {
+				g.fg, g.fgIndex = hexColor(c), -1
+			} else {
+				g.bg = hexColor(c)
+			}
+		}
+	}
+}
+
+// Frame returns the cells of the grid as a json-cells frame.
+func (g *cellGrid) Frame() *jsonFrame {
+	return &jsonFrame{Width: g.cols, Height: len(g.cells), Cells: g.cells}
+}
+
+// drawCellGrid draws cells to an image, each cell the size of a character
+// of rasterizeFace enlarged by scale.  Cells without colors are drawn with
+// the terminal defaults of light gray on black.
+func drawCellGrid(cells [][]jsonCell, p color.Palette, scale int) image.Image {
+	cw, ch := rasterizeFace.Advance, rasterizeFace.Height
+	cols := 0
+	for _, row := range cells {
+		cols = max(cols, len(row))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, cols*cw, len(cells)*ch))
+	d := font.Drawer{Dst: img, Face: rasterizeFace}
+	for y, row := range cells {
+		for x, c := range row {
+			fg, bg := p[7], p[0]
+			// cells hold colors formatted by hexColor.
+			if c.FG != nil {
+				fg, _ = parseHexColor(*c.FG)
+			}
+			if c.BG != nil {
+				bg, _ = parseHexColor(*c.BG)
+			}
+			r := image.Rect(x*cw, y*ch, (x+1)*cw, (y+1)*ch)
+			draw.Draw(img, r, image.NewUniform(bg), image.Point{}, draw.Src)
+			if c.Underline {
+				u := image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y)
+				draw.Draw(img, u, image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+			glyph, _ := utf8.DecodeRuneInString(c.Glyph)
+			if glyph == ' ' || drawCellGlyph(img, r, glyph, fg) {
+				continue
+			}
+			d.Src = image.NewUniform(fg)
+			d.Dot = fixed.P(r.Min.X, r.Min.Y+rasterizeFace.Ascent)
+			d.DrawString(c.Glyph)
+			if c.Bold {
+				// the face has no bold variant, the glyph is thickened
+				// by drawing it again a pixel to the right.
+				d.Dot = fixed.P(r.Min.X+1, r.Min.Y+rasterizeFace.Ascent)
+				d.DrawString(c.Glyph)
+			}
+		}
+	}
+	if scale == 1 {
+		return img
+	}
+	big := image.NewRGBA(image.Rect(0, 0, img.Rect.Dx()*scale, img.Rect.Dy()*scale))
+	for y := 0; y < big.Rect.Dy(); y++ {
+		for x := 0; x < big.Rect.Dx(); x++ {
+			big.SetRGBA(x, y, img.RGBAAt(x/scale, y/scale))
+		}
+	}
+	return big
+}
+
+// boxLines gives the lines of box drawing characters leaving the center of
+// their cells, up, down, left and right, 1 for a single line and 2 for a
+// double line.
+var boxLines = map[rune][4]int{
+	'─': {0, 0, 1, 1}, '│': {1, 1, 0, 0},
+	'┌': {0, 1, 0, 1}, '┐': {0, 1, 1, 0}, '└': {1, 0, 0, 1}, '┘': {1, 0, 1, 0},
+	'├': {1, 1, 0, 1}, '┤': {1, 1, 1, 0}, '┬': {0, 1, 1, 1}, '┴': {1, 0, 1, 1}, '┼': {1, 1, 1, 1},
+	'═': {0, 0, 2, 2}, '║': {2, 2, 0, 0},
+	'╔': {0, 2, 0, 2}, '╗': {0, 2, 2, 0}, '╚': {2, 0, 0, 2}, '╝': {2, 0, 2, 0},
+	'╠': {2, 2, 0, 2}, '╣': {2, 2, 2, 0}, '╦': {0, 2, 2, 2}, '╩': {2, 0, 2, 2}, '╬': {2, 2, 2, 2},
+	'╒': {0, 1, 0, 2}, '╕': {0, 1, 2, 0}, '╘': {1, 0, 0, 2}, '╛': {1, 0, 2, 0},
+	'╓': {0, 2, 0, 1}, '╖': {0, 2, 1, 0}, '╙': {2, 0, 0, 1}, '╜': {2, 0, 1, 0},
+	'╞': {1, 1, 0, 2}, '╡': {1, 1, 2, 0}, '╟': {2, 2, 0, 1}, '╢': {2, 2, 1, 0},
+	'╤': {0, 1, 2, 2}, '╧': {1, 0, 2, 2}, '╥': {0, 2, 1, 1}, '╨': {2, 0, 1, 1},
+	'╪': {1, 1, 2, 2}, '╫': {2, 2, 1, 1},
+}
+
+// drawCellGlyph draws block elements, shades and box drawing characters to
+// fill the cell r, so that adjacent cells join as they do in a terminal.
+// drawCellGlyph returns false for other characters.
+func drawCellGlyph(img *image.RGBA, r image.Rectangle, glyph rune, fg color.Color) bool {
+	fill := func(x0, y0, x1, y1 int) {
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(fg), image.Point{}, draw.Src)
+	}
+	midX, midY := (r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2
+	switch glyph {
+	case '█':
+		fill(r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+	case '▀':
+		fill(r.Min.X, r.Min.Y, r.Max.X, midY)
+	case '▄':
+		fill(r.Min.X, midY, r.Max.X, r.Max.Y)
+	case '▌':
+		fill(r.Min.X, r.Min.Y, midX, r.Max.Y)
+	case '▐':
+		fill(midX, r.Min.Y, r.Max.X, r.Max.Y)
+	case '░', '▒', '▓':
+		// shades set one, two or three of every four pixels.
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := (x-r.Min.X)%2 + 2*((y-r.Min.Y)%2)
+				if (glyph == '░' && i == 0) || (glyph == '▒' && (i == 0 || i == 3)) || (glyph == '▓' && i != 1) {
+					img.Set(x, y, fg)
+				}
+			}
+		}
+	default:
+		lines, ok := boxLines[glyph]
+		if !ok {
+			return false
+		}
+		// double lines are drawn one pixel either side of the center.
+		offsets := [3][]int{nil, {0}, {-1, 1}}
+		for _, d := range offsets[lines[0]] {
+			fill(midX+d, r.Min.Y, midX+d+1, midY+1)
+		}
+		for _, d := range offsets[lines[1]] {
+			fill(midX+d, midY, midX+d+1, r.Max.Y)
+		}
+		for _, d := range offsets[lines[2]] {
+			fill(r.Min.X, midY+d, midX+1, midY+d+1)
+		}
+		for _, d := range offsets[lines[3]] {
+			fill(midX, midY+d, r.Max.X, midY+d+1)
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestCellGrid(t *testing.T) {
+	grid := newCellGrid(4, palette256)
+	grid.Write([]byte("\033[1;31mab\033[0mcd\nef\033[1A\033[44mg\033[2;4H\033[7mh\r\n\033[0;38;5;21mi"))
+	expect := [][]string{
+		{"a #ff0000 -", "b #ff0000 -", "g - #000080", "d - -"},
+		{"e - -", "f - -", "  - -", "h #000080 #c0c0c0"},
+		{"i #0000ff -", "  - -", "  - -", "  - -"},
+	}
+	str := func(s *string) string {
+		if s == nil {
+			return "-"
+		}
+		return *s
+	}
+	if len(grid.cells) != len(expect) {
+		t.Fatalf("%d rows (expected %d)", len(grid.cells), len(expect))
+	}
+	for y, row := range grid.cells {
+		for x, c := range row {
+			s := c.Glyph + " " + str(c.FG) + " " + str(c.BG)
+			if s != expect[y][x] {
+				t.Errorf("cell %d,%d %q (expected %q)", x, y, s, expect[y][x])
+			}
+		}
+	}
+}
+
+func TestCellGridBounds(t *testing.T) {
+	grid := newCellGrid(4, palette256)
+	grid.Write([]byte("a\033[999999999;999999999Hb\033[1;1H\033[9223372036854775807Bc\n\nd"))
+	if len(grid.cells) != grid.maxRows {
+		t.Fatalf("%d rows (expected %d)", len(grid.cells), grid.maxRows)
+	}
+	last := grid.cells[len(grid.cells)-1]
+	if last[3].Glyph != "b" || last[0].Glyph != "d" {
+		t.Errorf("last row %v, expected b at the last column and d at the first", last)
+	}
+}
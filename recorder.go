@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// recordedFrame is a copy of an ANSIFrame taken before the frame's buffer is
+// reused.
+type recordedFrame struct {
+	data  []byte
+	rows  int
+	cols  int
+	delay time.Duration
+}
+
+// recorder saves the frames drawn to the terminal to a file.  Frames are
+// recorded at the times their delays give rather than when the terminal
+// drew them, so a recording plays at the intended speed even if drawing was
+// slowed by the terminal, -baud or pausing.  Files named *.cast are
+// asciinema recordings (asciicast v2), others receive the ANSI output.
+type recorder struct {
+	f    *os.File
+	w    *bufio.Writer
+	cast bool
+	opts *FrameOptions
+
+	frames chan recordedFrame
+	done   chan struct{}
+	err    error
+}
+
+func openRecorder(path string, opts *FrameOptions) (*recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{
+		f:    f,
+		w:    bufio.NewWriter(f),
+		cast: filepath.Ext(path) == ".cast",
+		opts: opts,
+	}, nil
+}
+
+// Tee returns a channel receiving frames, recording each frame as it passes.
+// Tee must be called once.
+func (r *recorder) Tee(ctx context.Context, frames <-chan *ANSIFrame) <-chan *ANSIFrame {
+	out := make(chan *ANSIFrame, PipelineBuffer)
+	r.frames = make(chan recordedFrame, PipelineBuffer+2)
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		r.err = r.record(r.frames)
+		// the display continues without the recording, the error is
+		// returned by Close.
+		for range r.frames {
+		}
+	}()
+	go func() {
+		defer close(out)
+		defer close(r.frames)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok := <-frames:
+				if !ok {
+					return
+				}
+				delay := time.Duration(r.opts.Delay) * time.Millisecond
+				if delay == 0 {
+					delay = f.Delay
+				}
+				if delay == 0 {
+					delay = DelayDefault
+				}
+				// the frame is drawn from the copy, so that f.Buffer may be
+				// reused while the copy is queued.
+				data := bytes.Clone(f.Buffer.b)
+				f.Buffer.b = f.Buffer.b[:0]
+				g := *f
+				g.Buffer = &frameBuffer{b: data}
+				r.frames <- recordedFrame{data, f.Rows, f.Cols, delay}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- &g:
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// record writes frames to the file as they are received.
+func (r *recorder) record(frames <-chan recordedFrame) error {
+	var t time.Duration
+	prevRows := 0
+	nframe := 0
+	for f := range frames {
+		var frame bytes.Buffer
+		if nframe > 0 && r.opts.Animate && r.opts.Strategy == StrategyRegion && prevRows > 0 {
+			// frames drawn in a scroll region are positioned by the
+			// region, recordings move the cursor instead.
+			fmt.Fprintf(&frame, "\033[%dA", prevRows)
+		}
+		frame.Write(f.data)
+		prevRows = f.rows
+
+		if !r.cast {
+			_, err := frame.WriteTo(r.w)
+			if err == nil {
+				err = r.w.Flush()
+			}
+			if err != nil {
+				return err
+			}
+			nframe++
+			continue
+		}
+
+		if nframe == 0 {
+			err := r.writeCastHeader(f)
+			if err != nil {
+				return err
+			}
+		}
+		data := frame.Bytes()
+		if n := escapeLen(data); nframe > 0 && n > 2 && data[1] == '[' && data[n-1] == 'A' {
+			// the cast is played on an empty screen, so the image is at
+			// its top and frames are moved home rather than up, which
+			// is where -ansi-input splits frames.
+			data = append([]byte("\033[H"), data[n:]...)
+		}
+		// asciinema plays output through a terminal emulator, which does
+		// not return the carriage at line feeds as the tty driver does.
+		data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+		event, err := json.Marshal([]any{t.Seconds(), "o", string(data)})
+		if err != nil {
+			return err
+		}
+		_, err = r.w.Write(append(event, '\n'))
+		if err == nil {
+			err = r.w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		if r.opts.Animate {
+			t += f.delay
+		}
+		nframe++
+	}
+	return nil
+}
+
+// writeCastHeader writes the asciicast header, sized to the terminal or to
+// the first frame f if output is not a terminal.
+func (r *recorder) writeCastHeader(f recordedFrame) error {
+	width, height, err := getTermDim()
+	if err != nil {
+		width, height = f.cols, f.rows+1
+	}
+	header := map[string]any{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": time.Now().Unix(),
+		"env":       map[string]string{"TERM": os.Getenv("TERM")},
+	}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close waits for frames received by Tee to be recorded and closes the file.
+func (r *recorder) Close() error {
+	if r.done != nil {
+		<-r.done
+	}
+	err := r.err
+	if err == nil {
+		err = r.w.Flush()
+	}
+	cerr := r.f.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecorderCast(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.cast")
+	r, err := openRecorder(path, &FrameOptions{Animate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := make(chan *ANSIFrame, 3)
+	for _, f := range []struct {
+		data  string
+		delay time.Duration
+	}{
+		{"ab\ncd\n", 100 * time.Millisecond},
+		{"\033[2Aef\ngh\n", 250 * time.Millisecond},
+		{"\033[2Aij\nkl\n", 0},
+	} {
+		frames <- &ANSIFrame{Buffer: &frameBuffer{b: []byte(f.data)}, Rows: 2, Cols: 2, Delay: f.delay}
+	}
+	close(frames)
+	for range r.Tee(context.Background(), frames) {
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		t.Fatal("no header")
+	}
+	var header struct {
+		Version int `json:"version"`
+		Width   int `json:"width"`
+		Height  int `json:"height"`
+	}
+	err = json.Unmarshal(s.Bytes(), &header)
+	if err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	if header.Version != 2 {
+		t.Errorf("version %d, want 2", header.Version)
+	}
+	if _, _, err := getTermDim(); err != nil && (header.Width != 2 || header.Height != 3) {
+		t.Errorf("header sized %dx%d, want the first frame and a line below it, 2x3", header.Width, header.Height)
+	}
+
+	var times []float64
+	var output []string
+	for s.Scan() {
+		var event []any
+		err := json.Unmarshal(s.Bytes(), &event)
+		if err != nil {
+			t.Fatalf("event %q: %v", s.Text(), err)
+		}
+		if len(event) != 3 || event[1] != "o" {
+			t.Fatalf("event %q is not output", s.Text())
+		}
+		times = append(times, event[0].(float64))
+		output = append(output, event[2].(string))
+	}
+	if wantTimes := []float64{0, 0.1, 0.35}; !reflect.DeepEqual(times, wantTimes) {
+		t.Errorf("event times %v, want %v", times, wantTimes)
+	}
+	wantOutput := []string{"ab\r\ncd\r\n", "\033[Hef\r\ngh\r\n", "\033[Hij\r\nkl\r\n"}
+	if !reflect.DeepEqual(output, wantOutput) {
+		t.Errorf("event output %q, want %q", output, wantOutput)
+	}
+}
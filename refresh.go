@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errPlaybackEnded is returned when an image cannot be loaded because the
+// Player has stopped.
+var errPlaybackEnded = errors.New("playback has ended")
+
+// refresher decodes its source again every interval and plays the new frames
+// in place of the old ones, for images which change such as weather maps,
+// dashboard panels and webcam stills.  The frames of each fetch have a Source
+// one greater than the fetch before, so that a transition stage following
+// the Player can tell them apart.
+type refresher struct {
+	url      string
+	interval time.Duration
+	player   *Player
+	prepare  func(ctx context.Context, frames <-chan *Frame) <-chan *Frame
+	fopts    *FrameOptions
+	cancel   context.CancelFunc // stops the stages preparing the current source
+}
+
+// Run refreshes the source until ctx is done or playback ends.  A fetch that
+// fails is logged and the frames already playing are kept.
+func (r *refresher) Run(ctx context.Context) {
+	defer func() {
+		if r.cancel != nil {
+			r.cancel()
+		}
+	}()
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for source := 1; ; source++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		err := r.load(ctx, source)
+		if errors.Is(err, errPlaybackEnded) {
+			return
+		}
+		if err != nil {
+			logger(logHTTP).Error("refresh failed", "url", r.url, "err", err)
+		}
+	}
+}
+
+// load decodes the source and plays its frames, numbered source, in place of
+// the current ones.
+func (r *refresher) load(ctx context.Context, source int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	frames, err := decodeFramesURL(ctx, r.url, r.fopts)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("decoding image %s: %w", r.url, err)
+	}
+	frames = mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		f.Source = source
+		return f, nil
+	})
+	frames = monitorFrames(ctx, r.fopts.monitor, "decode", frames)
+	if !r.player.Load(r.prepare(ctx, frames)) {
+		cancel()
+		return errPlaybackEnded
+	}
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.cancel = cancel
+	logger(logHTTP).Debug("refreshed", "url", r.url, "source", source)
+	return nil
+}
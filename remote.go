@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// RemoteMinDelay is the shortest delay between animation frames for remote
+// sessions, limiting animations to 15 frames per second unless -delay is
+// given.
+const RemoteMinDelay = time.Second / 15
+
+// TerminalQueries allows img2ansi to query the terminal.  Queries are
+// disabled for remote sessions because jump hosts and multiplexers may
+// mangle or delay responses.
+var TerminalQueries = true
+
+var errQueriesDisabled = errors.New("terminal queries are disabled")
+
+// sshRemote reports whether img2ansi is running in an SSH session.
+func sshRemote() bool {
+	return os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_CLIENT") != ""
+}
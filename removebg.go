@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// RemoveBackgroundFrames makes the background of frames transparent, so that
+// images such as logos on a white background blend into the terminal.  The
+// background is the color shared by most of the border of a frame, and it is
+// removed by flooding inward from the border through pixels whose channels
+// are all within tolerance of it, as a fraction of the channel range.  Frames
+// whose border has no dominant color are passed through unchanged.
+func RemoveBackgroundFrames(ctx context.Context, tolerance float64, frames <-chan *Frame) <-chan *Frame {
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		// the background found can differ between frames, so any pixel
+		// may have changed.
+		g := *f
+		g.Changed = image.Rectangle{}
+		if img := removeBackground(f.Image, tolerance); img != nil {
+			g.Image = img
+		}
+		return &g, nil
+	})
+}
+
+// removeBackground returns a copy of src with its background transparent, or
+// nil if src has no background to remove.
+func removeBackground(src image.Image, tolerance float64) image.Image {
+	rect := src.Bounds()
+	var border []image.Point
+	for x := rect.Min.X; x < rect.Max.X; x++ {
+		border = append(border, image.Pt(x, rect.Min.Y), image.Pt(x, rect.Max.Y-1))
+	}
+	for y := rect.Min.Y + 1; y < rect.Max.Y-1; y++ {
+		border = append(border, image.Pt(rect.Min.X, y), image.Pt(rect.Max.X-1, y))
+	}
+	bg, ok := dominantColor(src, border)
+	if !ok {
+		return nil
+	}
+
+	limit := uint32(tolerance * 0xffff)
+	diff := func(a, b uint32) uint32 {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+	br, bgreen, bb, _ := bg.RGBA()
+	isBackground := func(p image.Point) bool {
+		c := src.At(p.X, p.Y)
+		if IsTransparent(c, AlphaThreshold) {
+			// transparent pixels are already background and connect it.
+			return true
+		}
+		r, g, b, _ := opaque(c).RGBA()
+		return diff(r, br) <= limit && diff(g, bgreen) <= limit && diff(b, bb) <= limit
+	}
+
+	img := image.NewNRGBA64(rect)
+	draw.Draw(img, rect, src, rect.Min, draw.Src)
+	seen := make([]bool, rect.Dx()*rect.Dy())
+	visit := func(p image.Point) bool {
+		i := (p.Y-rect.Min.Y)*rect.Dx() + (p.X - rect.Min.X)
+		if seen[i] {
+			return false
+		}
+		seen[i] = true
+		return isBackground(p)
+	}
+	var stack []image.Point
+	for _, p := range border {
+		if visit(p) {
+			stack = append(stack, p)
+		}
+	}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		img.SetNRGBA64(p.X, p.Y, color.NRGBA64{})
+		for _, q := range []image.Point{{p.X - 1, p.Y}, {p.X + 1, p.Y}, {p.X, p.Y - 1}, {p.X, p.Y + 1}} {
+			if q.In(rect) && visit(q) {
+				stack = append(stack, q)
+			}
+		}
+	}
+	return img
+}
+
+// dominantColor returns the most common opaque color among the pixels of img
+// at points, with channels compared at 4 bits of precision.  It reports false
+// if fewer than half of points share a color.
+func dominantColor(img image.Image, points []image.Point) (color.Color, bool) {
+	type key [3]uint32
+	counts := make(map[key]int)
+	var sums = make(map[key][3]uint32)
+	for _, p := range points {
+		c := img.At(p.X, p.Y)
+		if IsTransparent(c, AlphaThreshold) {
+			continue
+		}
+		r, g, b, _ := opaque(c).RGBA()
+		k := key{r >> 12, g >> 12, b >> 12}
+		counts[k]++
+		s := sums[k]
+		sums[k] = [3]uint32{s[0] + r>>8, s[1] + g>>8, s[2] + b>>8}
+	}
+	var best key
+	n := 0
+	for k, count := range counts {
+		// ties are broken by color, not by the random order of the map.
+		tie := count == n && (k[0] < best[0] || k[0] == best[0] && (k[1] < best[1] || k[1] == best[1] && k[2] < best[2]))
+		if count > n || tie {
+			best, n = k, count
+		}
+	}
+	if n == 0 || 2*n < len(points) {
+		return nil, false
+	}
+	s := sums[best]
+	return color.RGBA{R: uint8(s[0] / uint32(n)), G: uint8(s[1] / uint32(n)), B: uint8(s[2] / uint32(n)), A: 0xff}, true
+}
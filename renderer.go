@@ -51,7 +51,7 @@ func (r *gifrenderer) renderFrame(i int) {
 		r.frame = r.Draw(bounds)
 		var fill image.Image
 		if disposal == gif.DisposalBackground {
-			fill = image.NewUniform(r.GIF.Config.ColorModel.(color.Palette)[r.GIF.BackgroundIndex])
+			fill = image.NewUniform(r.background())
 		} else {
 			fill = image.NewUniform(color.Transparent)
 		}
@@ -60,8 +60,7 @@ func (r *gifrenderer) renderFrame(i int) {
 		disposal := r.GIF.Disposal[i-1]
 		// disposal unspecified and DisposalNone are handled the same way, leave the frame as it is
 		if disposal == gif.DisposalBackground {
-			c := r.GIF.Config.ColorModel.(color.Palette)[r.GIF.BackgroundIndex]
-			img := image.NewUniform(c)
+			img := image.NewUniform(r.background())
 			draw.Draw(r.frame, bounds, img, bounds.Min, draw.Src)
 		} else if disposal == gif.DisposalPrevious {
 			fill := image.NewUniform(color.Transparent)
@@ -87,6 +86,17 @@ func (r *gifrenderer) renderFrame(i int) {
 	r.Frames = append(r.Frames, framecp)
 }
 
+// background returns the background color of the GIF.  GIFs without a
+// global color table, and those whose background index is outside of it,
+// have a transparent background, which is how browsers draw them.
+func (r *gifrenderer) background() color.Color {
+	p, ok := r.GIF.Config.ColorModel.(color.Palette)
+	if !ok || int(r.GIF.BackgroundIndex) >= len(p) {
+		return color.Transparent
+	}
+	return p[r.GIF.BackgroundIndex]
+}
+
 func (r *gifrenderer) RenderFrames() {
 	for i := range r.GIF.Image {
 		r.renderFrame(i)
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/bmatsuo/img2ansi/gif"
+)
+
+// testBackgroundGIF returns a GIF of two 2x2 frames over a 4x4 screen whose
+// first frame is disposed to the background.
+func testBackgroundGIF(model color.Model, background byte) *gif.GIF {
+	local := color.Palette{color.RGBA{0xff, 0, 0, 0xff}}
+	frame := func(x, y int) *image.Paletted {
+		return image.NewPaletted(image.Rect(x, y, x+2, y+2), local)
+	}
+	return &gif.GIF{
+		Image:          []*image.Paletted{frame(0, 0), frame(2, 2)},
+		Delay:          []int{0, 0},
+		Disposal:       []byte{gif.DisposalBackground, gif.DisposalBackground},
+		Transparent:    []byte{0, 0},
+		HasTransparent: []bool{false, false},
+		Config: image.Config{
+			ColorModel: model,
+			Width:      4,
+			Height:     4,
+		},
+		BackgroundIndex: background,
+	}
+}
+
+func TestGIFRendererBackground(t *testing.T) {
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	for _, test := range []struct {
+		name  string
+		model color.Model
+		index byte
+		bg    color.Color
+	}{
+		{"palette", color.Palette{color.Black, green}, 1, green},
+		{"no palette", color.Palette(nil), 0, color.Transparent},
+		{"short palette", color.Palette{color.Black, green}, 7, color.Transparent},
+		{"not a palette", color.RGBAModel, 0, color.Transparent},
+		{"nil model", nil, 0, color.Transparent},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := newGIFRenderer(testBackgroundGIF(test.model, test.index), func(b image.Rectangle) draw.Image {
+				return image.NewRGBA(b)
+			})
+			r.RenderAll()
+			if len(r.Frames) != 2 {
+				t.Fatalf("rendered %d frames, expected 2", len(r.Frames))
+			}
+			// the first frame is cleared to the background before the
+			// second is drawn.
+			want := color.RGBAModel.Convert(test.bg)
+			if c := r.Frames[1].At(0, 0); c != want {
+				t.Errorf("background %v, expected %v", c, want)
+			}
+			if c := r.Frames[1].At(3, 3); c != (color.RGBA{0xff, 0, 0, 0xff}) {
+				t.Errorf("frame pixel %v, expected red", c)
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	"math"
+	"strconv"
+	"strings"
 )
 
 // sizeRect returns a point with dimensions less than or equal to the
@@ -32,6 +37,55 @@ func sizeRect(size image.Point, width, height int, fontAspect float64) image.Poi
 	return _sizeHeight(size, height)
 }
 
+// MaxScale limits resizing so that no source pixel covers more than MaxScale
+// cells in either direction.  Images already smaller than the requested size
+// divided by MaxScale are resized to MaxScale times their size, adjusted for
+// the font aspect ratio.  If MaxScale is not positive images are enlarged
+// without limit.
+var MaxScale = 0.0
+
+// SnapScale limits resizing to whole number ratios in each direction, so
+// that every cell samples source pixels at the same intervals and thin lines
+// and text are not drawn unevenly.  Images resized to a ratio in between are
+// made smaller and centered in the area they would have filled.
+var SnapScale = false
+
+// sizeTarget returns the size images of the given size are resized to for
+// width and height, which is sizeRect(size, width, height, fontAspect) unless
+// that would enlarge them beyond MaxScale or SnapScale does not allow it.
+// The area that the image would have filled without the limits is also
+// returned, so that a limited image can be centered within it.
+func sizeTarget(size image.Point, width, height int, fontAspect float64) (target, area image.Point) {
+	area = sizeRect(size, width, height, fontAspect)
+	target = area
+	if MaxScale > 0 {
+		w := max(1, int(round(float64(size.X)*MaxScale)))
+		h := max(1, int(round(float64(size.Y)*MaxScale)))
+		limit := sizeRect(size, w, h, fontAspect)
+		if area.X > limit.X || area.Y > limit.Y {
+			target = limit
+		}
+	}
+	if SnapScale {
+		target = image.Pt(snapLength(size.X, target.X), snapLength(size.Y, target.Y))
+	}
+	return target, area
+}
+
+// snapLength returns the largest length no greater than target which length
+// n is enlarged to by a whole number, or reduced to by sampling every kth
+// unit.
+func snapLength(n, target int) int {
+	if n <= 0 || target <= 0 {
+		return target
+	}
+	if target >= n {
+		return n * (target / n)
+	}
+	k := (n + target - 1) / target
+	return (n + k - 1) / k
+}
+
 // _sizeWidth returns a point with X equal to width and the same aspect ratio
 // as size.
 func _sizeWidth(sizeNorm image.Point, width int) image.Point {
@@ -65,3 +119,63 @@ func sizeNormal(size image.Point, fontAspect float64) image.Point {
 func round(x float64) float64 {
 	return math.Floor(x + 0.5)
 }
+
+// scaleFactor is a flag.Value holding independent X and Y scale factors.
+type scaleFactor struct {
+	X, Y float64
+}
+
+func (f *scaleFactor) String() string {
+	if f.X == f.Y {
+		return strconv.FormatFloat(f.X, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f.X, 'g', -1, 64) + "x" + strconv.FormatFloat(f.Y, 'g', -1, 64)
+}
+
+func (f *scaleFactor) Set(s string) error {
+	xs, ys, ok := strings.Cut(s, "x")
+	if !ok {
+		ys = xs
+	}
+	x, err := strconv.ParseFloat(xs, 64)
+	if err != nil {
+		return err
+	}
+	y, err := strconv.ParseFloat(ys, 64)
+	if err != nil {
+		return err
+	}
+	if x <= 0 || y <= 0 {
+		return fmt.Errorf("scale factor must be positive")
+	}
+	f.X, f.Y = x, y
+	return nil
+}
+
+// scale multiplies width and height by their factors.  Dimensions which are
+// not positive are left unchanged and scaled dimensions are at least one.
+func (f scaleFactor) scale(width, height int) (int, int) {
+	if width > 0 {
+		width = int(math.Max(1, round(float64(width)*f.X)))
+	}
+	if height > 0 {
+		height = int(math.Max(1, round(float64(height)*f.Y)))
+	}
+	return width, height
+}
+
+// LetterboxFill is the color of the area around images centered by
+// MaxScale and SnapScale.  If LetterboxFill is nil the area is transparent.
+var LetterboxFill color.Color
+
+// centerImage returns img drawn in the center of an image of the given size
+// filled with LetterboxFill.
+func centerImage(img image.Image, size image.Point) image.Image {
+	dst := image.NewRGBA64(image.Rectangle{Max: size})
+	if LetterboxFill != nil {
+		draw.Draw(dst, dst.Rect, image.NewUniform(LetterboxFill), image.Point{}, draw.Src)
+	}
+	off := size.Sub(img.Bounds().Size()).Div(2)
+	draw.Draw(dst, img.Bounds().Sub(img.Bounds().Min).Add(off), img, img.Bounds().Min, draw.Src)
+	return dst
+}
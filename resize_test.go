@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestSnapLength(t *testing.T) {
+	for _, c := range []struct{ n, target, expect int }{
+		{100, 100, 100},
+		{100, 250, 200},
+		{100, 99, 50},
+		{100, 50, 50},
+		{100, 34, 34},
+		{100, 33, 25},
+		{7, 1, 1},
+	} {
+		if got := snapLength(c.n, c.target); got != c.expect {
+			t.Errorf("snapLength(%d, %d) = %d (expected %d)", c.n, c.target, got, c.expect)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "sync"
+
+// rowPanic carries the first panic of the calls made by parallelRows back to
+// the goroutine calling it, which cannot recover from panics on other
+// goroutines.
+type rowPanic struct {
+	once  sync.Once
+	value any
+}
+
+// call calls fn(y), recording a panic instead of letting it crash the
+// program.
+func (p *rowPanic) call(fn func(y int), y int) {
+	defer func() {
+		if v := recover(); v != nil {
+			p.once.Do(func() { p.value = v })
+		}
+	}()
+	fn(y)
+}
+
+// raise panics with the recorded panic, if there was one.
+func (p *rowPanic) raise() {
+	if p.value != nil {
+		panic(p.value)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !quantpool
+
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallelRows calls fn for each row y in [0, n) using all available CPUs
+// and returns when every call has returned.  Goroutines are started for each
+// call.  Building with the quantpool tag replaces them with a pool of
+// long-lived workers, see rows_pool.go.  If fn panics the panic is raised
+// again by parallelRows, where the caller can recover from it.
+func parallelRows(n int, fn func(y int)) {
+	workers := min(n, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	var p rowPanic
+	next := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range next {
+				p.call(fn, y)
+			}
+		}()
+	}
+	for y := 0; y < n; y++ {
+		next <- y
+	}
+	close(next)
+	wg.Wait()
+	p.raise()
+}
+
+// getRowBuffers returns n empty buffers for encoding rows, which are returned
+// with putRowBuffers once their contents have been copied.
+func getRowBuffers(n int) []frameBuffer {
+	return make([]frameBuffer, n)
+}
+
+func putRowBuffers(lines []frameBuffer) {}
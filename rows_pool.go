@@ -0,0 +1,80 @@
+//go:build quantpool
+
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// rowJob is a row to be processed by a rowPool worker.
+type rowJob struct {
+	fn    func(y int)
+	y     int
+	wg    *sync.WaitGroup
+	panic *rowPanic
+}
+
+// rowPool runs per-row work, quantization and dithering, on workers which
+// are started once and locked to their own OS threads.  It is used instead
+// of starting goroutines for each frame when img2ansi is built with the
+// quantpool tag, which reduces scheduling overhead and keeps the caches of
+// each thread warm when rendering live video at large terminal sizes.
+type rowPool struct {
+	jobs chan rowJob
+}
+
+var defaultRowPool = newRowPool(runtime.GOMAXPROCS(0))
+
+func newRowPool(workers int) *rowPool {
+	p := &rowPool{jobs: make(chan rowJob, 4*workers)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			runtime.LockOSThread()
+			for job := range p.jobs {
+				job.panic.call(job.fn, job.y)
+				job.wg.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// parallelRows calls fn for each row y in [0, n) on the workers of
+// defaultRowPool and returns when every call has returned.  If fn panics the
+// panic is raised again by parallelRows and the workers keep running.
+func parallelRows(n int, fn func(y int)) {
+	var wg sync.WaitGroup
+	var p rowPanic
+	wg.Add(n)
+	for y := 0; y < n; y++ {
+		defaultRowPool.jobs <- rowJob{fn: fn, y: y, wg: &wg, panic: &p}
+	}
+	wg.Wait()
+	p.raise()
+}
+
+// rowBuffers holds row buffers between frames so that their memory is
+// reused rather than allocated for every frame.
+var rowBuffers = sync.Pool{
+	New: func() any { return new([]frameBuffer) },
+}
+
+// getRowBuffers returns n empty buffers for encoding rows, which are returned
+// with putRowBuffers once their contents have been copied.
+func getRowBuffers(n int) []frameBuffer {
+	p := rowBuffers.Get().(*[]frameBuffer)
+	lines := *p
+	if cap(lines) < n {
+		lines = append(lines[:cap(lines)], make([]frameBuffer, n-cap(lines))...)
+	}
+	lines = lines[:n]
+	for i := range lines {
+		lines[i].b = lines[i].b[:0]
+	}
+	return lines
+}
+
+func putRowBuffers(lines []frameBuffer) {
+	rowBuffers.Put(&lines)
+}
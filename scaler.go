@@ -0,0 +1,185 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Scaler resizes images.  Scale returns src resized to width by height
+// pixels, with bounds at the origin.  Scale must not modify src and may be
+// called from multiple goroutines.
+type Scaler interface {
+	Scale(src image.Image, width, height int) image.Image
+}
+
+// DefaultScaler resizes frames wherever img2ansi resizes them.  It is set by
+// -scaler.  Images are shrunk by averaging the pixels each pixel covers, as
+// photos alias badly when pixels are skipped.
+var DefaultScaler Scaler = BoxScaler{}
+
+// scalers are the Scalers selected by -scaler.
+var scalers = map[string]Scaler{
+	"box":         BoxScaler{},
+	"nearest":     DrawScaler{xdraw.NearestNeighbor},
+	"bilinear":    DrawScaler{xdraw.ApproxBiLinear},
+	"catmull-rom": DrawScaler{xdraw.CatmullRom},
+	"pixel":       PixelScaler{},
+}
+
+// Scalers returns the names of the Scalers selected by -scaler.
+func Scalers() []string {
+	var names []string
+	for name := range scalers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// localScaler reports whether s computes each pixel from the source pixels
+// it covers and their nearest neighbors alone, so that a changed region of
+// the source only changes the region scaleRect gives.  Interpolators with
+// wider kernels blend pixels further away.
+func localScaler(s Scaler) bool {
+	switch s := s.(type) {
+	case BoxScaler, PixelScaler:
+		return true
+	case DrawScaler:
+		return s.Interpolator == xdraw.NearestNeighbor
+	}
+	return false
+}
+
+// scaleImage resizes src with DefaultScaler, which is skipped if src
+// already has the given size.
+func scaleImage(src image.Image, width, height int) image.Image {
+	width, height = max(1, width), max(1, height)
+	if src.Bounds().Size() == image.Pt(width, height) && src.Bounds().Min == (image.Point{}) {
+		return src
+	}
+	return DefaultScaler.Scale(src, width, height)
+}
+
+// DrawScaler is a Scaler using an interpolator from golang.org/x/image/draw.
+// Nearest neighbor interpolation keeps the colors of cells exact, while the
+// smoother interpolators blend neighboring pixels, which suits photos
+// shrunk by large ratios.
+type DrawScaler struct {
+	Interpolator xdraw.Interpolator
+}
+
+func (s DrawScaler) Scale(src image.Image, width, height int) image.Image {
+	// the interpolators have fast paths drawing to RGBA images, and
+	// terminals display 8 bits a channel at most.
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	s.Interpolator.Scale(dst, dst.Rect, src, src.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// BoxScaler is a Scaler which shrinks images by averaging the block of
+// pixels each pixel covers, and enlarges them by repeating pixels.  Shrinking
+// a photo by a large ratio blends its detail rather than sampling a pixel of
+// it, which would alias.
+type BoxScaler struct{}
+
+func (BoxScaler) Scale(src image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	rect := src.Bounds()
+	if rect.Empty() {
+		return dst
+	}
+	xs := boxSpans(rect.Min.X, rect.Dx(), width)
+	ys := boxSpans(rect.Min.Y, rect.Dy(), height)
+	src64, _ := src.(image.RGBA64Image)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b, a uint64
+			for sy := ys[y][0]; sy < ys[y][1]; sy++ {
+				for sx := xs[x][0]; sx < xs[x][1]; sx++ {
+					var c color.RGBA64
+					if src64 != nil {
+						c = src64.RGBA64At(sx, sy)
+					} else {
+						cr, cg, cb, ca := src.At(sx, sy).RGBA()
+						c = color.RGBA64{uint16(cr), uint16(cg), uint16(cb), uint16(ca)}
+					}
+					r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+				}
+			}
+			n := uint64((ys[y][1] - ys[y][0]) * (xs[x][1] - xs[x][0]))
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// boxSpans divides the n source pixels beginning at min between size pixels.
+// Pixel i covers the source pixels from spans[i][0] up to spans[i][1], at
+// least one of them, so that pixels are repeated when enlarging.
+func boxSpans(min, n, size int) [][2]int {
+	spans := make([][2]int, size)
+	for i := range spans {
+		start, end := min+i*n/size, min+(i+1)*n/size
+		spans[i] = [2]int{start, max(end, start+1)}
+	}
+	return spans
+}
+
+// PixelScaler is a Scaler for pixel art.  Images are enlarged by whole
+// number ratios by repeating each pixel, and shrunk by whole number ratios
+// by drawing each block of pixels in its most common color, so that outlines
+// and details one pixel wide are kept rather than skipped or blurred.  Other
+// ratios are resized with nearest neighbor interpolation.
+type PixelScaler struct{}
+
+func (PixelScaler) Scale(src image.Image, width, height int) image.Image {
+	rect := src.Bounds()
+	size := rect.Size()
+	if rect.Empty() {
+		return image.NewRGBA64(image.Rect(0, 0, width, height))
+	}
+	if width%size.X != 0 && size.X%width != 0 || height%size.Y != 0 && size.Y%height != 0 {
+		return DrawScaler{xdraw.NearestNeighbor}.Scale(src, width, height)
+	}
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+	// each destination pixel covers a block of bw by bh source pixels, one
+	// source pixel when enlarging.
+	bw, bh := max(1, size.X/width), max(1, size.Y/height)
+	counts := make(map[color.RGBA64]int, bw*bh)
+	for y := 0; y < height; y++ {
+		sy := rect.Min.Y + y*size.Y/height
+		for x := 0; x < width; x++ {
+			sx := rect.Min.X + x*size.X/width
+			dst.SetRGBA64(x, y, blockMode(src, image.Rect(sx, sy, sx+bw, sy+bh), counts))
+		}
+	}
+	return dst
+}
+
+// blockMode returns the most common color of src within r, preferring the
+// color found first on a tie.  counts is cleared and used to count colors.
+func blockMode(src image.Image, r image.Rectangle, counts map[color.RGBA64]int) color.RGBA64 {
+	if r.Dx() == 1 && r.Dy() == 1 {
+		return color.RGBA64Model.Convert(src.At(r.Min.X, r.Min.Y)).(color.RGBA64)
+	}
+	clear(counts)
+	var mode color.RGBA64
+	most := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.RGBA64Model.Convert(src.At(x, y)).(color.RGBA64)
+			counts[c]++
+			if n := counts[c]; n > most {
+				mode, most = c, n
+			}
+		}
+	}
+	return mode
+}
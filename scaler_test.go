@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestPixelScaler checks that pixel art is enlarged by repeating pixels and
+// shrunk to the most common color of each block, keeping a line one pixel
+// wide which sampling could skip.
+func TestPixelScaler(t *testing.T) {
+	white := color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}
+	black := color.RGBA64{0, 0, 0, 0xffff}
+	src := image.NewRGBA64(image.Rect(10, 10, 14, 14))
+	for y := 10; y < 14; y++ {
+		for x := 10; x < 14; x++ {
+			src.SetRGBA64(x, y, white)
+		}
+	}
+	// a 2x1 line in the top left block, the most common color of no other.
+	src.SetRGBA64(10, 10, black)
+	src.SetRGBA64(11, 10, black)
+
+	big := PixelScaler{}.Scale(src, 12, 8)
+	if b := big.Bounds(); b != image.Rect(0, 0, 12, 8) {
+		t.Fatalf("enlarged bounds %v", b)
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			if big.At(x, y) != src.At(10+x/3, 10+y/2) {
+				t.Fatalf("enlarged pixel %d,%d is %v", x, y, big.At(x, y))
+			}
+		}
+	}
+
+	small := PixelScaler{}.Scale(src, 2, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 2; x++ {
+			expect := white
+			if x == 0 && y == 0 {
+				expect = black
+			}
+			if small.At(x, y) != expect {
+				t.Errorf("shrunk pixel %d,%d is %v", x, y, small.At(x, y))
+			}
+		}
+	}
+}
+
+// TestBoxScaler checks that a checkerboard of single pixels, which sampling
+// draws in one of its colors, is shrunk to gray.
+func TestBoxScaler(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 1000, 1000))
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 1000; x++ {
+			if (x+y)%2 == 0 {
+				src.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+	small := BoxScaler{}.Scale(src, 10, 10)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			r, g, b, _ := small.At(x, y).RGBA()
+			if r>>8 < 0x70 || r>>8 > 0x90 || r != g || g != b {
+				t.Fatalf("shrunk pixel %d,%d is %v", x, y, small.At(x, y))
+			}
+		}
+	}
+
+	big := BoxScaler{}.Scale(src.SubImage(image.Rect(1, 0, 3, 2)), 4, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			r, _, _, _ := big.At(x, y).RGBA()
+			r0, _, _, _ := src.At(1+x/2, y/2).RGBA()
+			if r != r0 {
+				t.Fatalf("enlarged pixel %d,%d is %v", x, y, big.At(x, y))
+			}
+		}
+	}
+}
+
+func TestScaleEmpty(t *testing.T) {
+	src := image.NewRGBA(image.Rect(3, 3, 3, 5))
+	for name, s := range scalers {
+		if b := s.Scale(src, 4, 2).Bounds(); b != image.Rect(0, 0, 4, 2) {
+			t.Errorf("%s: empty image scaled to %v", name, b)
+		}
+	}
+}
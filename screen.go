@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// screenMaxString is the longest string GNU screen accepts in a single
+// device control string.  Longer strings are silently truncated.
+const screenMaxString = 768
+
+// inScreen reports whether output is going to a GNU screen session.
+func inScreen() bool {
+	return os.Getenv("STY") != "" && os.Getenv("TMUX") == ""
+}
+
+// screenPassthrough wraps seq in device control strings which GNU screen
+// forwards verbatim to the outer terminal.  Sequences longer than screen's
+// string limit are split across multiple device control strings.
+func screenPassthrough(seq string) string {
+	var b strings.Builder
+	for len(seq) > 0 {
+		n := screenMaxString
+		if n > len(seq) {
+			n = len(seq)
+		}
+		b.WriteString("\033P")
+		b.WriteString(seq[:n])
+		b.WriteString("\033\\")
+		seq = seq[n:]
+	}
+	return b.String()
+}
+
+// passthrough returns seq in the form required to reach the terminal
+// described by opts.
+func (opts *FrameOptions) passthrough(seq string) string {
+	if opts != nil && opts.Passthrough {
+		return screenPassthrough(seq)
+	}
+	return seq
+}
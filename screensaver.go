@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// screensaverExts are the file extensions of images the screensaver displays.
+var screensaverExts = map[string]bool{
+	".gif":  true,
+	".jpeg": true,
+	".jpg":  true,
+	".png":  true,
+}
+
+// runScreensaver displays random images from dir on the terminal's alternate
+// screen, showing each for interval, until a key is pressed or ctx is
+// cancelled.
+func runScreensaver(ctx context.Context, dir string, interval time.Duration, fontAspect float64, p ANSIPalette) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && screensaverExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no images found in %s", dir)
+	}
+
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("terminal: %w", err)
+	}
+	defer terminal.Restore(fd, state)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		var b [1]byte
+		os.Stdin.Read(b[:])
+		cancel()
+	}()
+
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	defer signal.Stop(resized)
+
+	// The terminal is in raw mode so newlines must be translated.
+	w := &crlfWriter{w: os.Stdout}
+	io.WriteString(w, "\033[?1049h\033[?25l")
+	defer io.WriteString(w, ANSIClear+"\033[?25h\033[?1049l")
+
+	last := -1
+	for ctx.Err() == nil && len(paths) > 0 {
+		i := rand.Intn(len(paths))
+		if i == last && len(paths) > 1 {
+			i = (i + 1) % len(paths)
+		}
+		err := showScreensaverImage(ctx, w, paths[i], interval, fontAspect, p, resized)
+		if err != nil {
+			logger(logDecode).Debug("screensaver: skipping image", "path", paths[i], "err", err)
+			paths = append(paths[:i], paths[i+1:]...)
+			last = -1
+			continue
+		}
+		last = i
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no images in %s could be decoded", dir)
+	}
+	return nil
+}
+
+// showScreensaverImage displays the image at path centered on the screen for
+// interval.  The image is redrawn whenever the terminal is resized.
+func showScreensaverImage(ctx context.Context, w io.Writer, path string, interval time.Duration, fontAspect float64, p ANSIPalette, resized <-chan os.Signal) error {
+	fopts := &FrameOptions{Animate: true, GIFShortDelay: GIFShortDelayDefault}
+	decoded, err := decodeFramesFile(ctx, path, fopts)
+	if err != nil {
+		return err
+	}
+	var frames []*Frame
+	for f := range decoded {
+		frames = append(frames, f)
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames")
+	}
+	if len(frames) > 1 {
+		fopts.Repeat = -1
+	}
+
+	showCtx, stop := context.WithTimeout(ctx, interval)
+	defer stop()
+	for {
+		width, height, err := getTermDim()
+		if err != nil {
+			return fmt.Errorf("terminal dimensions: %w", err)
+		}
+		width--
+		height--
+		size := sizeRect(frames[0].Image.Bounds().Size(), width, height, fontAspect)
+		fopts.Pad = strings.Repeat(" ", (width-size.X)/2)
+		io.WriteString(w, ANSIClear+"\033[2J\033[H"+strings.Repeat("\n", (height-size.Y)/2))
+
+		drawCtx, cancelDraw := context.WithCancel(showCtx)
+		c := make(chan *Frame, len(frames))
+		for _, f := range frames {
+			c <- f
+		}
+		close(c)
+		scaled := ResizeFrames(drawCtx, width, height, fontAspect, c)
+		looped := LoopFrames(drawCtx, scaled, fopts)
+		ansiFrames := writeANSIFrames(drawCtx, looped, p, fopts)
+		done := make(chan error, 1)
+		go func() {
+			done <- drawANSIFrames(drawCtx, w, ansiFrames, fopts)
+		}()
+
+		select {
+		case <-resized:
+			cancelDraw()
+			<-done
+			continue
+		case <-showCtx.Done():
+			cancelDraw()
+			<-done
+			return nil
+		case err := <-done:
+			if err != nil {
+				cancelDraw()
+				return err
+			}
+		}
+		// the image has been drawn completely and is left on screen.
+		select {
+		case <-resized:
+			cancelDraw()
+		case <-showCtx.Done():
+			cancelDraw()
+			return nil
+		}
+	}
+}
+
+// crlfWriter translates newlines into carriage return, newline pairs for
+// terminals in raw mode.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (w *crlfWriter) Write(p []byte) (int, error) {
+	_, err := w.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n")))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// capabilityProfile records what the user saw when img2ansi selftest drew
+// its test patterns.  It is saved in the user's config directory, keyed by
+// the terminal it was made in, and takes precedence over terminal detection
+// in that terminal, since detection can be fooled by TERM naming a different
+// terminal than the one in use.
+type capabilityProfile struct {
+	Term    string `json:"term"`
+	Program string `json:"program,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Colors is the number of palette colors the terminal drew correctly: 8,
+	// 16 or 256, or 0 if none of the tests passed.
+	Colors int `json:"colors"`
+
+	// Truecolor is true if direct RGB colors were drawn smoothly.
+	Truecolor bool `json:"truecolor"`
+
+	// Transparency is true if cells without a background color show the
+	// terminal's own background.
+	Transparency bool `json:"transparency"`
+
+	// Blocks is true if block glyphs fill their cells without gaps, as
+	// required by -render=foreground.
+	Blocks bool `json:"blocks"`
+
+	Tested time.Time `json:"tested"`
+}
+
+// capabilityProfilePath returns the default location of the capability
+// profiles.
+func capabilityProfilePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "img2ansi", "capabilities.json"), nil
+}
+
+// capabilityProfileKey returns the key of the capability profile for the
+// terminal in use, identified by TERM along with TERM_PROGRAM and its
+// version, or VTE_VERSION, as terminals which share a TERM differ in what
+// they draw.
+func capabilityProfileKey() (key, term, program, version string) {
+	term = os.Getenv("TERM")
+	program = os.Getenv("TERM_PROGRAM")
+	version = os.Getenv("TERM_PROGRAM_VERSION")
+	if program == "" && os.Getenv("VTE_VERSION") != "" {
+		program, version = "VTE", os.Getenv("VTE_VERSION")
+	}
+	return strings.TrimSpace(term + " " + program + " " + version), term, program, version
+}
+
+// readCapabilityProfiles reads the capability profiles saved at path, keyed
+// by capabilityProfileKey.
+func readCapabilityProfiles(path string) (map[string]*capabilityProfile, error) {
+	profiles := make(map[string]*capabilityProfile)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return profiles, err
+	}
+	err = json.Unmarshal(b, &profiles)
+	return profiles, err
+}
+
+var (
+	loadedProfile     *capabilityProfile
+	loadedProfilePath string
+	profileLoaded     bool
+)
+
+// selftestProfile returns the capability profile saved by img2ansi selftest
+// for the terminal in use, or nil if there is none.
+func selftestProfile() *capabilityProfile {
+	if profileLoaded {
+		return loadedProfile
+	}
+	profileLoaded = true
+	path, err := capabilityProfilePath()
+	if err != nil {
+		return nil
+	}
+	profiles, err := readCapabilityProfiles(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger(logRender).Warn("capability profile not read", "path", path, "err", err)
+		}
+		return nil
+	}
+	key, _, _, _ := capabilityProfileKey()
+	p := profiles[key]
+	if p == nil {
+		return nil
+	}
+	loadedProfile, loadedProfilePath = p, fmt.Sprintf("%s (%s)", path, key)
+	return p
+}
+
+// selftestMain implements the selftest subcommand, which draws test patterns
+// and asks whether they look right, saving the answers as a capability
+// profile that later runs use to choose a palette.
+func selftestMain(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	path, _ := capabilityProfilePath()
+	output := fs.String("o", path, "path of the capability profiles to save the profile of this terminal in")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: img2ansi selftest [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		return fmt.Errorf("selftest: no config directory, use -o")
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	r := bufio.NewReader(os.Stdin)
+	ask := func(draw func(w *bufio.Writer), question string) (bool, error) {
+		w.WriteString("\n")
+		draw(w)
+		w.WriteString("\n" + question + " [y/n] ")
+		w.Flush()
+		for {
+			line, err := r.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "y", "yes":
+				return true, nil
+			case "n", "no":
+				return false, nil
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = fmt.Errorf("selftest: no answer")
+				}
+				return false, err
+			}
+			fmt.Fprint(os.Stdout, "please answer y or n: ")
+		}
+	}
+
+	key, term, program, version := capabilityProfileKey()
+	p := &capabilityProfile{Term: term, Program: program, Version: version, Tested: time.Now()}
+	for _, test := range []struct {
+		draw     func(w *bufio.Writer)
+		question string
+		pass     func()
+	}{
+		{drawSelftest8, "Do you see eight different colors, starting with black?", func() { p.Colors = 8 }},
+		{drawSelftest16, "Is each color in the second row a brighter version of the one above it?", func() { p.Colors = max(p.Colors, 16) }},
+		{drawSelftest256, "Do you see smooth bands of color above a ramp of 24 distinct grays?", func() { p.Colors = max(p.Colors, 256) }},
+		{drawSelftestTruecolor, "Do the gradients change smoothly, without stripes of repeated color?", func() { p.Truecolor = true }},
+		{drawSelftestAlpha, "Do the gaps in the checkerboard show the terminal's own background?", func() { p.Transparency = true }},
+		{drawSelftestGlyphs, "Do the blocks fill their cells, without gaps between them or the rows?", func() { p.Blocks = true }},
+	} {
+		ok, err := ask(test.draw, test.question)
+		if err != nil {
+			return err
+		}
+		if ok {
+			test.pass()
+		}
+	}
+	profiles, err := readCapabilityProfiles(*output)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// profiles saved before they were keyed by terminal cannot be told
+		// apart, so they are replaced.
+		fmt.Fprintf(os.Stdout, "\nreplacing unreadable capability profiles: %v\n", err)
+		profiles = make(map[string]*capabilityProfile)
+	}
+	profiles[key] = p
+	b, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(*output), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(*output, append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "\ncapability profile of %s saved to %s\n", key, *output)
+	return nil
+}
+
+func drawSelftest8(w *bufio.Writer) {
+	for i := 0; i < 8; i++ {
+		w.WriteString(sgr8(i, false) + "    ")
+	}
+	w.WriteString(ANSIClear + "\n")
+}
+
+func drawSelftest16(w *bufio.Writer) {
+	for row := 0; row < 2; row++ {
+		for i := 0; i < 8; i++ {
+			w.WriteString(sgr16(row*8+i, false) + "    ")
+		}
+		w.WriteString(ANSIClear + "\n")
+	}
+}
+
+// drawSelftest256 draws the color cube as six rows, one for each level of
+// red, followed by the grayscale ramp.
+func drawSelftest256(w *bufio.Writer) {
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				w.WriteString(sgr256(16+r*36+g*6+b, false) + " ")
+			}
+		}
+		w.WriteString(ANSIClear + "\n")
+	}
+	for i := 232; i < 256; i++ {
+		w.WriteString(sgr256(i, false) + " ")
+	}
+	w.WriteString(ANSIClear + "\n")
+}
+
+// drawSelftestTruecolor draws gradients with more steps than any palette
+// has, which terminals approximating direct color with a palette draw as
+// visible stripes.
+func drawSelftestTruecolor(w *bufio.Writer) {
+	const steps = 72
+	for _, channel := range [][3]int{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 1}} {
+		for i := 0; i < steps; i++ {
+			v := i * 255 / (steps - 1)
+			fmt.Fprintf(w, "\033[48;2;%d;%d;%dm ", v*channel[0], v*channel[1], v*channel[2])
+		}
+		w.WriteString(ANSIClear + "\n")
+	}
+}
+
+// drawSelftestAlpha draws a checkerboard of colored cells and transparent
+// cells, drawn as img2ansi draws transparent pixels.
+func drawSelftestAlpha(w *bufio.Writer) {
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 16; x++ {
+			if (x/2+y)%2 == 0 {
+				w.WriteString(sgr256(202, false) + " ")
+			} else {
+				w.WriteString(ANSIClear + " ")
+			}
+		}
+		w.WriteString(ANSIClear + "\n")
+	}
+}
+
+// drawSelftestGlyphs draws rows of block glyphs in a color different from
+// the background behind them, so that gaps are visible.
+func drawSelftestGlyphs(w *bufio.Writer) {
+	for y := 0; y < 3; y++ {
+		w.WriteString(sgr256(21, false) + sgr256(226, true))
+		for _, g := range []string{renderBlock, "▀", "▄", "▌", "▐"} {
+			w.WriteString(strings.Repeat(g, 4) + " ")
+		}
+		w.WriteString(ANSIClear + "\n")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// sheetGap is the number of columns between images on a contact sheet.
+const sheetGap = 2
+
+// sheetMain implements the sheet subcommand, which draws many images as a
+// single contact sheet, a grid of thumbnails labeled with their file names,
+// for browsing a directory of images over SSH.
+func sheetMain(args []string) error {
+	fs := flag.NewFlagSet("sheet", flag.ExitOnError)
+	columns := fs.Int("columns", 4, "number of images in each row")
+	output := fs.String("o", "", "path of the output file (default standard output)")
+	width := fs.Int("width", 0, "width of the sheet in terminal columns (default the terminal width, or 80)")
+	height := fs.Int("height", 0, "height of each thumbnail in terminal lines (default square thumbnails)")
+	paletteName := fs.String("color", ColorAuto, "color palette (auto, 8, 256, truecolor, gray, ...)")
+	fontAspect := fs.Float64("fontaspect", 0.5, "aspect ratio (width/height)")
+	number := fs.Bool("number", false, "number the labels in the order images are given")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: img2ansi sheet [flags] image ...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *columns < 1 {
+		return fmt.Errorf("sheet: columns must be positive")
+	}
+
+	toTerminal := *output == "" && terminal.IsTerminal(int(os.Stdout.Fd()))
+	if *width == 0 {
+		*width = 80
+		if w, _, err := getTermDim(); err == nil && toTerminal {
+			*width = w
+		}
+	}
+	cellWidth := (*width - sheetGap*(*columns-1)) / *columns
+	if cellWidth < 1 {
+		return fmt.Errorf("sheet: %d columns do not fit in a width of %d", *columns, *width)
+	}
+	cellHeight := *height
+	if cellHeight == 0 {
+		cellHeight = max(1, int(float64(cellWidth)**fontAspect))
+	}
+
+	if *paletteName == ColorAuto {
+		*paletteName = "256"
+		if toTerminal {
+			*paletteName, _, _ = detectPalette("contact sheets are drawn with cells")
+		}
+	}
+	palette := ansiPalettes[*paletteName]
+	if palette == nil {
+		return fmt.Errorf("sheet: color palette not one of %q", ANSIPalettes())
+	}
+
+	var thumbs []sheetThumb
+	for i, arg := range fs.Args() {
+		label := filepath.Base(arg)
+		if *number {
+			label = fmt.Sprintf("%d. %s", i+1, label)
+		}
+		lines, err := sheetThumbnail(arg, cellWidth, cellHeight, *fontAspect, palette)
+		if err != nil {
+			logger(logDecode).Warn("image skipped", "image", arg, "err", err)
+			continue
+		}
+		thumbs = append(thumbs, sheetThumb{lines: lines, label: label})
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *output != "" {
+		var err error
+		f, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	writeSheet(bw, thumbs, *columns, cellWidth)
+	err := bw.Flush()
+	if f != nil {
+		if err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return err
+}
+
+// sheetThumb is an image drawn for a contact sheet.
+type sheetThumb struct {
+	lines []string // rendered rows, each ending with the color cleared
+	label string
+}
+
+// sheetThumbnail decodes the first frame of the image at urlstr and renders
+// it to fit within width by height cells.
+func sheetThumbnail(urlstr string, width, height int, fontAspect float64, p ANSIPalette) ([]string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames, err := decodeFramesURL(ctx, urlstr, &FrameOptions{})
+	if err != nil {
+		return nil, err
+	}
+	f, ok := <-ResizeFrames(ctx, width, height, fontAspect, frames)
+	if !ok {
+		return nil, fmt.Errorf("no frames")
+	}
+	var out bytes.Buffer
+	buf := nbuffer(1)[0]
+	writeANSIPixels(buf, f.Image, p, &FrameOptions{})
+	buf.FlushTo(&out)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	// rows are centered with spaces, which are drawn without color
+	// because every row ends with the color cleared.
+	pad := width - f.Image.Bounds().Dx()
+	for i := range lines {
+		lines[i] = strings.Repeat(" ", pad/2) + lines[i] + strings.Repeat(" ", pad-pad/2)
+	}
+	return lines, nil
+}
+
+// writeSheet writes thumbs in rows of columns, each thumbnail cellWidth
+// columns wide with its label centered below it.
+func writeSheet(w io.Writer, thumbs []sheetThumb, columns, cellWidth int) {
+	blank := strings.Repeat(" ", cellWidth)
+	gap := strings.Repeat(" ", sheetGap)
+	for start := 0; start < len(thumbs); start += columns {
+		row := thumbs[start:min(start+columns, len(thumbs))]
+		height := 0
+		for _, t := range row {
+			height = max(height, len(t.lines))
+		}
+		for y := 0; y < height; y++ {
+			var line strings.Builder
+			for i, t := range row {
+				if i > 0 {
+					line.WriteString(gap)
+				}
+				if y < len(t.lines) {
+					line.WriteString(t.lines[y])
+				} else {
+					line.WriteString(blank)
+				}
+			}
+			io.WriteString(w, strings.TrimRight(line.String(), " ")+"\n")
+		}
+		var labels strings.Builder
+		for i, t := range row {
+			if i > 0 {
+				labels.WriteString(gap)
+			}
+			labels.WriteString(sheetLabel(t.label, cellWidth))
+		}
+		io.WriteString(w, strings.TrimRight(labels.String(), " ")+"\n\n")
+	}
+}
+
+// sheetLabel centers label in width columns, shortening it with an ellipsis
+// if it does not fit.
+func sheetLabel(label string, width int) string {
+	if n := utf8.RuneCountInString(label); n > width {
+		runes := []rune(label)
+		if width > 1 {
+			return string(runes[:width-1]) + "…"
+		}
+		return string(runes[:width])
+	}
+	left := (width - utf8.RuneCountInString(label)) / 2
+	return padText(strings.Repeat(" ", left)+label, width)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// PixelSink displays frames on a device other than the terminal, such as an
+// LED matrix.  Each pixel of a frame corresponds to one device pixel.
+type PixelSink interface {
+	// WritePixels displays a row-major sequence of colors, one for each pixel
+	// of a frame with the given size.
+	WritePixels(size image.Point, pixels []color.RGBA) error
+	Close() error
+}
+
+// ANSISink receives frames as they would be drawn in the terminal, such as
+// the clients of a WebSocket server.  Each frame is written whole and then
+// FlushFrame is called.
+type ANSISink interface {
+	io.Writer
+	frameFlusher
+	Close() error
+}
+
+// Sink is a PixelSink or an ANSISink.
+type Sink interface {
+	Close() error
+}
+
+// openSink returns the sink described by rawurl.  The URL scheme determines
+// the protocol used.  Any sink URL of a PixelSink may have a layout query
+// parameter naming a layout file (see readSinkLayout).  Commands given as
+// exec: sinks are not URLs and are parsed by newExecSink.  Sinks are closed
+// when ctx is done.
+func openSink(ctx context.Context, rawurl string) (Sink, error) {
+	if spec, ok := strings.CutPrefix(rawurl, "exec:"); ok {
+		return newExecSink(spec)
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "ws" {
+		return newWSBroadcaster(ctx, u)
+	}
+	var layout []int
+	if path := u.Query().Get("layout"); path != "" {
+		layout, err = readSinkLayout(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var sink PixelSink
+	switch u.Scheme {
+	case "artnet":
+		sink, err = newArtNetSink(u)
+	case "wled", "ddp":
+		sink, err = newDDPSink(u)
+	case "mqtt":
+		sink, err = newMQTTSink(u)
+	default:
+		return nil, fmt.Errorf("unrecognized sink: %v", rawurl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if layout != nil {
+		sink = &layoutSink{PixelSink: sink, layout: layout}
+	}
+	return sink, nil
+}
+
+// readSinkLayout reads a JSON array from path which gives, for each pixel of
+// a frame in row-major order, the index of the LED that displays it.  Pixels
+// mapped to a negative index are not displayed.
+func readSinkLayout(path string) ([]int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var layout []int
+	err = json.Unmarshal(b, &layout)
+	if err != nil {
+		return nil, fmt.Errorf("sink layout %s: %w", path, err)
+	}
+	return layout, nil
+}
+
+// layoutSink rearranges pixels according to a layout before writing them to
+// the underlying PixelSink.
+type layoutSink struct {
+	PixelSink
+	layout []int
+	buf    []color.RGBA
+}
+
+func (s *layoutSink) WritePixels(size image.Point, pixels []color.RGBA) error {
+	n := 0
+	for _, i := range s.layout {
+		if i >= n {
+			n = i + 1
+		}
+	}
+	leds := s.buf[:0]
+	for i := 0; i < n; i++ {
+		leds = append(leds, color.RGBA{})
+	}
+	for i, c := range pixels {
+		if i < len(s.layout) && s.layout[i] >= 0 {
+			leds[s.layout[i]] = c
+		}
+	}
+	s.buf = leds
+	return s.PixelSink.WritePixels(size, leds)
+}
+
+// sinkPixels quantizes img using p and returns its pixels in row-major order.
+// Transparent pixels are black.  If serpentine is true every other row is
+// reversed, matching LED matrices wired in a zigzag.
+func sinkPixels(img image.Image, p ANSIPalette, serpentine bool) (image.Point, []color.RGBA) {
+	rect := img.Bounds()
+	size := rect.Size()
+	pixels := make([]color.RGBA, 0, size.X*size.Y)
+	for y := 0; y < size.Y; y++ {
+		row := len(pixels)
+		for x := 0; x < size.X; x++ {
+			var rgba color.RGBA
+			if c := p.Convert(img.At(rect.Min.X+x, rect.Min.Y+y)); c != nil {
+				rgba = color.RGBAModel.Convert(c).(color.RGBA)
+			}
+			pixels = append(pixels, rgba)
+		}
+		if serpentine && y%2 == 1 {
+			for i, j := row, len(pixels)-1; i < j; i, j = i+1, j-1 {
+				pixels[i], pixels[j] = pixels[j], pixels[i]
+			}
+		}
+	}
+	return size, pixels
+}
+
+// drawSinkFrames writes frames to sink, pacing them in the same way
+// drawANSIFrames paces an animation.
+func drawSinkFrames(ctx context.Context, sink PixelSink, frames <-chan *Frame, p ANSIPalette, serpentine bool, opts *FrameOptions) error {
+	nframe := 0
+	frameStart := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case f, ok := <-frames:
+			if !ok {
+				opts.loopEnded(opts.player.Ended())
+				return nil
+			}
+			if nframe > 0 {
+				delay := time.Duration(opts.Delay) * time.Millisecond
+				if delay == 0 {
+					delay = f.Delay
+				}
+				if delay == 0 {
+					delay = DelayDefault
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(delay - time.Since(frameStart)):
+				}
+			}
+			opts.loopEnded(f.LoopEnd)
+			frameStart = time.Now()
+			size, pixels := sinkPixels(f.Image, p, serpentine)
+			err := sink.WritePixels(size, pixels)
+			if err != nil {
+				return err
+			}
+			if nframe == 0 {
+				logger(logRender).Info("time to first frame", "duration", time.Since(debugProcStartTime))
+			}
+		}
+		nframe++
+	}
+}
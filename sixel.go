@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Output protocols accepted by -protocol.
+const (
+	// ProtocolAuto draws images with the first protocol of capabilityChain
+	// which the terminal supports, when -color=auto.
+	ProtocolAuto = "auto"
+
+	// ProtocolANSI draws images with the colors of terminal cells.
+	ProtocolANSI = "ansi"
+
+	// ProtocolSixel draws images as DEC sixel graphics, a pixel for each
+	// pixel of the frame, in terminals such as mlterm, foot and xterm with
+	// sixel enabled.
+	ProtocolSixel = "sixel"
+
+	// ProtocolKitty draws images with the kitty graphics protocol, a pixel
+	// for each pixel of the frame, in terminals such as kitty and ghostty.
+	ProtocolKitty = "kitty"
+
+	// ProtocolITerm2 draws images as PNG files with the inline image escape
+	// of iTerm2, a pixel for each pixel of the frame, in terminals such as
+	// iTerm2 and WezTerm which do not draw sixel or kitty graphics by
+	// default.
+	ProtocolITerm2 = "iterm2"
+)
+
+// SixelColors is the number of color registers the colors of each sixel
+// image are reduced to.  Terminals drawing sixel graphics almost all
+// provide at least 256.
+var SixelColors = 256
+
+// TargetVT340 is the -target drawing sixel images within the limits of a DEC
+// VT340: 16 color registers, and a screen of 800x480 pixels in cells of
+// 10x20 which the terminal cannot be asked for.
+const TargetVT340 = "vt340"
+
+// vt340Colors, vt340Cell and vt340Screen are the limits of a VT340.
+var (
+	vt340Colors = 16
+	vt340Cell   = image.Pt(10, 20)
+	vt340Screen = image.Pt(800, 480)
+)
+
+// fitVT340 returns the largest width and height in pixels, no greater than
+// those given unless they are zero, of an image which fits the screen of a
+// VT340 above the line the cursor is left on.
+func fitVT340(width, height int) (int, int) {
+	maxWidth, maxHeight := vt340Screen.X, vt340Screen.Y-vt340Cell.Y
+	if width <= 0 || width > maxWidth {
+		width = maxWidth
+	}
+	if height <= 0 || height > maxHeight {
+		height = maxHeight
+	}
+	return width, height
+}
+
+// DefaultCellSize is the size of a terminal cell in pixels assumed when the
+// terminal does not report it.
+var DefaultCellSize = image.Pt(10, 20)
+
+// cellSizeResponse matches the response to an XTWINOPS request for the size
+// of a cell in pixels, which gives its height and then its width.
+var cellSizeResponse = regexp.MustCompile(`\x1b\[6;([0-9]+);([0-9]+)t`)
+
+// cellSize returns the size of a terminal cell in pixels, as reported by the
+// terminal, or DefaultCellSize.
+func cellSize() image.Point {
+	resp, err := queryTerminal("\033[16t")
+	if err == nil {
+		if m := cellSizeResponse.FindSubmatch(resp); m != nil {
+			h, _ := strconv.Atoi(string(m[1]))
+			w, _ := strconv.Atoi(string(m[2]))
+			if w > 0 && h > 0 {
+				logger(logRender).Debug("cell size", "width", w, "height", h)
+				return image.Pt(w, h)
+			}
+		}
+		err = fmt.Errorf("terminal did not report its cell size")
+	}
+	logger(logRender).Debug("cell size assumed", "width", DefaultCellSize.X, "height", DefaultCellSize.Y, "err", err)
+	return DefaultCellSize
+}
+
+// writeSixelFrames encodes frames as sixel images in place of
+// writeANSIFrames, for terminals which draw sixel graphics.  Frames are
+// sized in pixels, and cell is the size of a terminal cell in pixels, which
+// gives the rows and columns each frame occupies.  Like ANSI frames, each
+// image leaves the cursor below it, and animation frames are drawn over the
+// frame before.  Transparent pixels are not drawn, except in animations,
+// where they are drawn in bg so that they cover the frame before.
+func writeSixelFrames(ctx context.Context, frames <-chan *Frame, cell image.Point, bg color.Color, opts *FrameOptions) <-chan *ANSIFrame {
+	animate := opts != nil && opts.Animate
+	if !animate {
+		bg = nil
+	}
+	lastRows := 0
+	return writeGraphicsFrames(ctx, frames, func(buf *frameBuffer, f *Frame) (rows, cols int, err error) {
+		if animate && opts.Strategy != StrategyRegion && lastRows > 0 {
+			fmt.Fprintf(buf, "\033[%dA", lastRows)
+		}
+		rows, cols, err = encodeSixelFrame(buf, f.Image, cell, bg)
+		if err == nil {
+			lastRows = rows
+		}
+		return rows, cols, err
+	})
+}
+
+// writeGraphicsFrames encodes frames with encode, which draws a frame as an
+// image rather than as cells, and sends them to be drawn as writeANSIFrames
+// does.  A frame which cannot be encoded is logged and written as nothing,
+// leaving the frame before it in its place.
+func writeGraphicsFrames(ctx context.Context, frames <-chan *Frame, encode func(buf *frameBuffer, f *Frame) (rows, cols int, err error)) <-chan *ANSIFrame {
+	draw := make(chan *ANSIFrame, PipelineBuffer)
+	go func() {
+		defer close(draw)
+
+		buffers := nbuffer(PipelineBuffer + 2)
+		nframe := 0
+		var lastRows, lastCols int
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok := <-frames:
+				if !ok {
+					return
+				}
+				buf := buffers[nframe%len(buffers)]
+				start := len(buf.b)
+				rows, cols, err := encode(buf, f)
+				if err != nil {
+					// a bad frame should not end the animation.
+					logger(logRender).Error("frame not encoded", "frame", nframe, "err", err)
+					buf.b = buf.b[:start]
+					rows, cols = lastRows, lastCols
+				}
+				lastRows, lastCols = rows, cols
+
+				select {
+				case <-ctx.Done():
+					return
+				case draw <- &ANSIFrame{
+					Buffer:    buf,
+					Rows:      rows,
+					Cols:      cols,
+					Delay:     f.Delay,
+					LoopCount: f.LoopCount,
+					LoopEnd:   f.LoopEnd,
+				}:
+				}
+				nframe++
+			}
+		}
+	}()
+	return draw
+}
+
+// encodeSixelFrame writes img to buf as a sixel image at the cursor and
+// returns the number of rows and columns of cells it covers.  Lines for the
+// image are made first, scrolling the screen if needed, because terminals
+// differ in where a sixel image leaves the cursor.  The cursor is saved
+// before the image is drawn and restored after it, then moved below it.
+func encodeSixelFrame(buf *frameBuffer, img image.Image, cell image.Point, bg color.Color) (rows, cols int, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+
+	size := img.Bounds().Size()
+	rows = (size.Y + cell.Y - 1) / cell.Y
+	cols = (size.X + cell.X - 1) / cell.X
+	if rows < 1 {
+		return 0, 0, nil
+	}
+	fmt.Fprintf(buf, "%s\033[%dA\0337", strings.Repeat("\n", rows), rows)
+	encodeSixel(buf, img, bg)
+	fmt.Fprintf(buf, "\0338\033[%dB\r", rows)
+	return rows, cols, nil
+}
+
+// encodeSixel writes img as a sixel image, its colors reduced to at most
+// SixelColors color registers.  Transparent pixels are drawn in bg, or left
+// as they were if bg is nil.
+func encodeSixel(buf *frameBuffer, img image.Image, bg color.Color) {
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	// the distinct colors of the image, each pixel's position in colors
+	// until registers are chosen and then its register, or -1 if it is not
+	// drawn.
+	var colors []color.RGBA
+	distinct := make(map[color.RGBA]int)
+	index := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			index[i] = -1
+			c := img.At(rect.Min.X+x, rect.Min.Y+y)
+			if IsTransparent(c, AlphaThreshold) {
+				c = bg
+			} else {
+				c = opaque(c)
+			}
+			if c == nil {
+				continue
+			}
+			r, g, b, _ := c.RGBA()
+			rgb := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+			k, ok := distinct[rgb]
+			if !ok {
+				k = len(colors)
+				distinct[rgb] = k
+				colors = append(colors, rgb)
+			}
+			index[i] = k
+		}
+	}
+	counts := make(map[color.RGBA]int, len(colors))
+	for _, k := range index {
+		if k >= 0 {
+			counts[colors[k]]++
+		}
+	}
+	pal := quantizeSixel(counts, SixelColors)
+	tree := newPaletteTree(pal)
+	regs := make([]int, len(colors))
+	for k, c := range colors {
+		regs[k] = tree.Index(c)
+	}
+	for i, k := range index {
+		if k >= 0 {
+			index[i] = regs[k]
+		}
+	}
+
+	// pixels which are not drawn keep the color behind them, P2=1.
+	fmt.Fprintf(buf, "\033P0;1;0q\"1;1;%d;%d", w, h)
+	for i, c := range pal {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(buf, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+	bits := make([]byte, w)
+	for band := 0; band < h; band += 6 {
+		// the registers used in the band.
+		used := make(map[int]bool)
+		var regs []int
+		for y := band; y < min(band+6, h); y++ {
+			for _, i := range index[y*w : (y+1)*w] {
+				if i >= 0 && !used[i] {
+					used[i] = true
+					regs = append(regs, i)
+				}
+			}
+		}
+		sort.Ints(regs)
+		for n, reg := range regs {
+			if n > 0 {
+				// return to the start of the band for the next color.
+				buf.WriteString("$")
+			}
+			for x := range bits {
+				bits[x] = 0
+				for dy := 0; dy < 6 && band+dy < h; dy++ {
+					if index[(band+dy)*w+x] == reg {
+						bits[x] |= 1 << dy
+					}
+				}
+			}
+			fmt.Fprintf(buf, "#%d", reg)
+			writeSixelRuns(buf, bits)
+		}
+		buf.WriteString("-")
+	}
+	buf.WriteString("\033\\")
+}
+
+// writeSixelRuns writes the sixels of a band of one color, bits holding the
+// pixels of each column, with runs of a sixel repeated.  Trailing empty
+// sixels are omitted.
+func writeSixelRuns(buf *frameBuffer, bits []byte) {
+	end := len(bits)
+	for end > 0 && bits[end-1] == 0 {
+		end--
+	}
+	for x := 0; x < end; {
+		n := 1
+		for x+n < end && bits[x+n] == bits[x] {
+			n++
+		}
+		c := byte(0x3f + bits[x])
+		if n > 3 {
+			fmt.Fprintf(buf, "!%d%c", n, c)
+		} else {
+			buf.WriteString(strings.Repeat(string(c), n))
+		}
+		x += n
+	}
+}
+
+// quantizeSixel returns a palette of at most n colors for the opaque colors
+// counted in counts.  If there are no more than n distinct colors they are
+// used exactly, otherwise the palette is chosen by median cut: the box of
+// colors covering the widest range in a channel is split at its median in
+// that channel until there are n boxes, and each box contributes the mean of
+// its colors.
+func quantizeSixel(counts map[color.RGBA]int, n int) color.Palette {
+	type entry struct {
+		c     color.RGBA
+		count int
+	}
+	entries := make([]entry, 0, len(counts))
+	for c, count := range counts {
+		entries = append(entries, entry{c, count})
+	}
+	// map order is random, the palette should not be.
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].c, entries[j].c
+		return a.R < b.R || a.R == b.R && (a.G < b.G || a.G == b.G && a.B < b.B)
+	})
+	if len(entries) <= n {
+		pal := make(color.Palette, len(entries))
+		for i, e := range entries {
+			pal[i] = e.c
+		}
+		return pal
+	}
+
+	channel := func(c color.RGBA, k int) uint8 { return [3]uint8{c.R, c.G, c.B}[k] }
+	// widest returns the channel with the widest range in box and the range.
+	widest := func(box []entry) (int, int) {
+		best, bestRange := 0, -1
+		for k := 0; k < 3; k++ {
+			lo, hi := 255, 0
+			for _, e := range box {
+				v := int(channel(e.c, k))
+				lo, hi = min(lo, v), max(hi, v)
+			}
+			if hi-lo > bestRange {
+				best, bestRange = k, hi-lo
+			}
+		}
+		return best, bestRange
+	}
+	// the widest channel of each box and its range, or -1 for boxes of one
+	// color, which cannot be split.
+	type box struct {
+		entries []entry
+		axis    int
+		width   int
+	}
+	newBox := func(entries []entry) box {
+		if len(entries) < 2 {
+			return box{entries, 0, -1}
+		}
+		k, r := widest(entries)
+		return box{entries, k, r}
+	}
+	boxes := []box{newBox(entries)}
+	for len(boxes) < n {
+		split := -1
+		for i, b := range boxes {
+			if b.width > 0 && (split < 0 || b.width > boxes[split].width) {
+				split = i
+			}
+		}
+		if split < 0 {
+			break
+		}
+		es, axis := boxes[split].entries, boxes[split].axis
+		sort.SliceStable(es, func(i, j int) bool { return channel(es[i].c, axis) < channel(es[j].c, axis) })
+		total := 0
+		for _, e := range es {
+			total += e.count
+		}
+		// the median pixel, leaving at least one color on either side.
+		mid, seen := 1, es[0].count
+		for mid < len(es)-1 && seen+es[mid].count <= total/2 {
+			seen += es[mid].count
+			mid++
+		}
+		boxes[split] = newBox(es[:mid])
+		boxes = append(boxes, newBox(es[mid:]))
+	}
+	pal := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		var r, g, bl, total int
+		for _, e := range b.entries {
+			r += int(e.c.R) * e.count
+			g += int(e.c.G) * e.count
+			bl += int(e.c.B) * e.count
+			total += e.count
+		}
+		pal[i] = color.RGBA{uint8(r / total), uint8(g / total), uint8(bl / total), 0xff}
+	}
+	return pal
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// decodeSixel decodes the sixel image in data, which must use the sequences
+// encodeSixel writes.  Pixels which are not drawn are nil.
+func decodeSixel(t *testing.T, data string) [][]color.Color {
+	start := strings.Index(data, "q\"1;1;")
+	end := strings.Index(data, "\033\\")
+	if start < 0 || end < start {
+		t.Fatalf("no sixel image in %q", data)
+	}
+	s := data[start+len("q\"1;1;") : end]
+	var w, h int
+	var regs = make(map[int]color.Color)
+	number := func() int {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		n, _ := strconv.Atoi(s[:i])
+		s = s[i:]
+		return n
+	}
+	w = number()
+	s = s[1:]
+	h = number()
+	img := make([][]color.Color, h)
+	for y := range img {
+		img[y] = make([]color.Color, w)
+	}
+	reg, x, band := 0, 0, 0
+	for len(s) > 0 {
+		c := s[0]
+		s = s[1:]
+		switch {
+		case c == '#':
+			reg = number()
+			if len(s) > 0 && s[0] == ';' {
+				var v [4]int
+				for i := range v {
+					s = s[1:]
+					v[i] = number()
+				}
+				regs[reg] = color.RGBA{uint8(v[1] * 255 / 100), uint8(v[2] * 255 / 100), uint8(v[3] * 255 / 100), 0xff}
+			}
+		case c == '$':
+			x = 0
+		case c == '-':
+			x, band = 0, band+6
+		case c == '!':
+			n := number()
+			bits := s[0] - 0x3f
+			s = s[1:]
+			for i := 0; i < n; i++ {
+				for dy := 0; dy < 6; dy++ {
+					if bits&(1<<dy) != 0 {
+						img[band+dy][x] = regs[reg]
+					}
+				}
+				x++
+			}
+		case c >= 0x3f && c <= 0x7e:
+			for dy := 0; dy < 6; dy++ {
+				if (c-0x3f)&(1<<dy) != 0 {
+					img[band+dy][x] = regs[reg]
+				}
+			}
+			x++
+		default:
+			t.Fatalf("unexpected %q in sixel data", c)
+		}
+	}
+	return img
+}
+
+func TestEncodeSixel(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 9, 8))
+	colors := []color.RGBA{{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			src.Set(x, y, colors[(x/2+y)%3])
+		}
+	}
+	src.Set(4, 7, color.Transparent)
+	var buf frameBuffer
+	rows, cols, err := encodeSixelFrame(&buf, src, image.Pt(4, 5), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 || cols != 3 {
+		t.Errorf("image covers %dx%d cells (expected 3x2)", cols, rows)
+	}
+	img := decodeSixel(t, string(buf.b))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			c := img[y][x]
+			if x == 4 && y == 7 {
+				if c != nil {
+					t.Errorf("transparent pixel drawn as %v", c)
+				}
+				continue
+			}
+			if c != src.At(x, y) {
+				t.Fatalf("pixel %d,%d decoded as %v (expected %v)", x, y, c, src.At(x, y))
+			}
+		}
+	}
+}
+
+func TestQuantizeSixel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var colors []color.Color
+	counts := make(map[color.RGBA]int)
+	for i := 0; i < 5000; i++ {
+		c := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+		colors = append(colors, c)
+		counts[c]++
+	}
+	pal := quantizeSixel(counts, 16)
+	if len(pal) != 16 {
+		t.Fatalf("%d colors chosen (expected 16)", len(pal))
+	}
+	// random colors are spread over the cube, so every color is near a
+	// color of the palette.
+	for _, c := range colors {
+		if d := colorDiff(c, pal[pal.Index(c)]); d > 0xffff/2 {
+			t.Errorf("%v quantized to %v", c, pal[pal.Index(c)])
+		}
+	}
+}
+
+func TestFitVT340(t *testing.T) {
+	for _, test := range []struct{ w, h, fitW, fitH int }{
+		{0, 0, 800, 460},
+		{400, 0, 400, 460},
+		{1600, 1000, 800, 460},
+		{300, 200, 300, 200},
+	} {
+		if w, h := fitVT340(test.w, test.h); w != test.fitW || h != test.fitH {
+			t.Errorf("%dx%d fit as %dx%d (expected %dx%d)", test.w, test.h, w, h, test.fitW, test.fitH)
+		}
+	}
+}
+
+// TestSixelVT340Registers checks that an image of many colors uses no more
+// registers than a VT340 has.
+func TestSixelVT340Registers(t *testing.T) {
+	defer func(n int) { SixelColors = n }(SixelColors)
+	SixelColors = vt340Colors
+	img := image.NewRGBA(image.Rect(0, 0, 64, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 20), uint8(x * y), 0xff})
+		}
+	}
+	var buf frameBuffer
+	encodeSixel(&buf, img, nil)
+	defs := regexp.MustCompile(`#([0-9]+);2;`).FindAllSubmatch(buf.b, -1)
+	if len(defs) == 0 || len(defs) > vt340Colors {
+		t.Errorf("%d color registers defined", len(defs))
+	}
+	if !bytes.Contains(buf.b, []byte(`"1;1;64;12`)) {
+		t.Errorf("raster attributes missing: %q", buf.b[:min(len(buf.b), 40)])
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StageStatsInterval is the default interval at which StageStats are
+// reported.
+const StageStatsInterval = time.Second
+
+// StageStats summarizes the frames produced by a pipeline stage since the
+// previous report.  A stage that makes the next stage Wait is a bottleneck,
+// while a stage that is Blocked is being held back by a slower stage
+// following it.
+type StageStats struct {
+	Name string
+	// Frames is the number of frames the stage produced.
+	Frames int
+	// Wait is the time spent waiting for the stage to produce frames.
+	Wait time.Duration
+	// Blocked is the time spent waiting for the next stage to accept frames.
+	Blocked time.Duration
+	// Busy is the time spent writing frames.  It is only reported for the
+	// final stage, which writes to the terminal.
+	Busy time.Duration
+	// Queue is the largest number of frames buffered ahead of the next stage.
+	Queue int
+	// Late is the number of frames that were written after they were due.
+	// Frames are never dropped, so late frames slow down the animation.
+	Late int
+}
+
+// stageMonitor collects StageStats for the stages of a pipeline and passes
+// them to a hook periodically.
+type stageMonitor struct {
+	hook func([]StageStats)
+
+	mu     sync.Mutex
+	stages []*StageStats
+}
+
+// newStageMonitor returns a stageMonitor which reports to opts.StageHook
+// until ctx is done.  If opts.StageHook is nil newStageMonitor returns nil,
+// which is a valid stageMonitor that records nothing.
+func newStageMonitor(ctx context.Context, opts *FrameOptions) *stageMonitor {
+	if opts.StageHook == nil {
+		return nil
+	}
+	interval := opts.StageInterval
+	if interval <= 0 {
+		interval = StageStatsInterval
+	}
+	m := &stageMonitor{hook: opts.StageHook}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Report()
+			}
+		}
+	}()
+	return m
+}
+
+// Report passes the stats collected since the last report to the hook and
+// resets them.
+func (m *stageMonitor) Report() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	stats := make([]StageStats, len(m.stages))
+	for i, s := range m.stages {
+		stats[i] = *s
+		*s = StageStats{Name: s.Name}
+	}
+	m.mu.Unlock()
+	m.hook(stats)
+}
+
+func (m *stageMonitor) stage(name string) *StageStats {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &StageStats{Name: name}
+	m.stages = append(m.stages, s)
+	return s
+}
+
+// update calls fn to modify the stats of s.
+func (m *stageMonitor) update(s *StageStats, fn func(s *StageStats)) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(s)
+}
+
+// monitorFrames records the output of the stage named name as it is passed
+// through unchanged.  If m is nil monitorFrames returns in.
+func monitorFrames[T any](ctx context.Context, m *stageMonitor, name string, in <-chan T) <-chan T {
+	if m == nil {
+		return in
+	}
+	s := m.stage(name)
+	out := make(chan T, PipelineBuffer)
+	go func() {
+		defer close(out)
+		for {
+			start := time.Now()
+			var v T
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok = <-in:
+				if !ok {
+					return
+				}
+			}
+			wait := time.Since(start)
+			queue := len(in)
+			start = time.Now()
+			select {
+			case <-ctx.Done():
+				return
+			case out <- v:
+			}
+			blocked := time.Since(start)
+			m.update(s, func(s *StageStats) {
+				s.Frames++
+				s.Wait += wait
+				s.Blocked += blocked
+				if queue > s.Queue {
+					s.Queue = queue
+				}
+			})
+		}
+	}()
+	return out
+}
+
+// logStageStats is a StageHook which writes stats to the log.
+func logStageStats(stats []StageStats) {
+	for _, s := range stats {
+		logger(logRender).Info("stage",
+			"name", s.Name,
+			"frames", s.Frames,
+			"wait", s.Wait.Round(time.Millisecond),
+			"blocked", s.Blocked.Round(time.Millisecond),
+			"busy", s.Busy.Round(time.Millisecond),
+			"queue", s.Queue,
+			"late", s.Late)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// TerminalCache allows responses to terminal queries to be saved in the
+// user's config directory, keyed by TERM and the version the terminal
+// reports, so that a terminal is only asked about its capabilities once.
+// Each query costs a round trip at startup and terminals which do not
+// understand a query may echo it as garbage.
+var TerminalCache = true
+
+// terminalCacheEntry holds the responses of one terminal.
+type terminalCacheEntry struct {
+	Term    string `json:"term"`
+	Version string `json:"version"`
+
+	// Responses maps each query to the terminal's response.  DA holds the
+	// device attributes which followed the response, see queryTerminalDA.
+	Responses map[string]cachedResponse `json:"responses"`
+
+	Updated time.Time `json:"updated"`
+}
+
+type cachedResponse struct {
+	Resp string `json:"resp"`
+	DA   string `json:"da"`
+}
+
+// xtversionResponse matches the response to an XTVERSION query, giving the
+// terminal's name and version.
+var xtversionResponse = regexp.MustCompile(`\x1bP>\|([^\x1b]*)\x1b\\`)
+
+// da2Response matches the response to a Secondary Device Attributes request,
+// giving the terminal's type and firmware version.
+var da2Response = regexp.MustCompile(`\x1b\[>([0-9;]*)c`)
+
+var (
+	terminalCacheEntries map[string]*terminalCacheEntry
+	terminalCacheKey     string
+	terminalCacheLoaded  bool
+	terminalCacheDirty   bool
+)
+
+// terminalCachePath returns the location of the terminal cache.
+func terminalCachePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "img2ansi", "terminals.json"), nil
+}
+
+// terminalCacheEntryFor returns the cache entry for the terminal in use,
+// creating it if the terminal has not been seen before.  The terminal is
+// identified by TERM and its response to XTVERSION, or to a Secondary Device
+// Attributes request if it does not support XTVERSION.  If the terminal
+// cannot be identified nil is returned and queries are not cached.
+func terminalCacheEntryFor() *terminalCacheEntry {
+	if terminalCacheLoaded {
+		return terminalCacheEntries[terminalCacheKey]
+	}
+	terminalCacheLoaded = true
+	if !TerminalCache || !TerminalQueries {
+		return nil
+	}
+	path, err := terminalCachePath()
+	if err != nil {
+		return nil
+	}
+
+	resp, err := queryTerminal("\033[>0q\033[>c")
+	if err != nil {
+		logger(logRender).Debug("terminal version query failed", "err", err)
+		return nil
+	}
+	var version string
+	if m := xtversionResponse.FindSubmatch(resp); m != nil {
+		version = string(m[1])
+	} else if m := da2Response.FindSubmatch(resp); m != nil {
+		version = "DA2 " + string(m[1])
+	} else {
+		logger(logRender).Debug("terminal did not report a version, queries are not cached")
+		return nil
+	}
+	term := os.Getenv("TERM")
+	terminalCacheKey = term + " " + version
+
+	terminalCacheEntries = make(map[string]*terminalCacheEntry)
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &terminalCacheEntries)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger(logRender).Warn("terminal cache not read", "path", path, "err", err)
+		terminalCacheEntries = make(map[string]*terminalCacheEntry)
+	}
+	entry := terminalCacheEntries[terminalCacheKey]
+	if entry == nil {
+		entry = &terminalCacheEntry{Term: term, Version: version}
+		terminalCacheEntries[terminalCacheKey] = entry
+	}
+	if entry.Responses == nil {
+		entry.Responses = make(map[string]cachedResponse)
+	}
+	logger(logRender).Debug("terminal identified", "term", term, "version", version, "cached", len(entry.Responses))
+	return entry
+}
+
+// queryTerminalCached is like queryTerminalDA but answers from the terminal
+// cache when the query has been sent to the terminal before.  Only queries
+// whose responses depend on nothing but the terminal may be cached, not the
+// cursor position.
+func queryTerminalCached(query string) (resp, da []byte, err error) {
+	entry := terminalCacheEntryFor()
+	if entry != nil {
+		if r, ok := entry.Responses[query]; ok {
+			return []byte(r.Resp), []byte(r.DA), nil
+		}
+	}
+	resp, da, err = queryTerminalDA(query)
+	if err == nil && entry != nil {
+		entry.Responses[query] = cachedResponse{Resp: string(resp), DA: string(da)}
+		entry.Updated = time.Now()
+		terminalCacheDirty = true
+	}
+	return resp, da, err
+}
+
+// saveTerminalCache writes the terminal cache if new responses were added to
+// it.
+func saveTerminalCache() error {
+	if !terminalCacheDirty {
+		return nil
+	}
+	path, err := terminalCachePath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(terminalCacheEntries, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	terminalCacheDirty = false
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	terminfoMagic16 = 0432  // legacy format, 16-bit numbers
+	terminfoMagic32 = 01036 // ncurses 6.1 format, 32-bit numbers
+
+	terminfoMaxColors = 13 // index of the colors numeric capability
+)
+
+// terminfo holds the capabilities of a compiled terminfo entry which
+// img2ansi uses.  Extended capabilities, such as RGB and Tc, are included in
+// the maps.
+type terminfo struct {
+	Names   []string
+	Bools   map[string]bool
+	Numbers map[string]int
+	Strings map[string]string
+}
+
+// terminfoDirs returns the directories searched for terminfo entries, in the
+// order ncurses searches them.
+func terminfoDirs() []string {
+	var dirs []string
+	if dir := os.Getenv("TERMINFO"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("TERMINFO_DIRS")) {
+		if dir == "" {
+			dir = "/usr/share/terminfo"
+		}
+		dirs = append(dirs, dir)
+	}
+	return append(dirs, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo")
+}
+
+// loadTerminfo reads the terminfo entry for the terminal named term.
+func loadTerminfo(term string) (*terminfo, error) {
+	if term == "" || strings.ContainsAny(term, "/\\") {
+		return nil, fmt.Errorf("terminfo: invalid terminal name %q", term)
+	}
+	for _, dir := range terminfoDirs() {
+		// entries are stored under their first letter, or its hex code on
+		// case-insensitive file systems.
+		for _, sub := range []string{term[:1], fmt.Sprintf("%02x", term[0])} {
+			b, err := os.ReadFile(filepath.Join(dir, sub, term))
+			if err == nil {
+				return parseTerminfo(b)
+			}
+		}
+	}
+	return nil, fmt.Errorf("terminfo: no entry for %s", term)
+}
+
+var errTerminfoShort = errors.New("terminfo: entry is truncated")
+
+// terminfoReader reads the sections of a compiled entry.
+type terminfoReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *terminfoReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.off+n > len(r.b) {
+		r.err = errTerminfoShort
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *terminfoReader) short() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(int16(binary.LittleEndian.Uint16(b)))
+}
+
+func (r *terminfoReader) number(size int) int {
+	if size == 4 {
+		b := r.bytes(4)
+		if b == nil {
+			return 0
+		}
+		return int(int32(binary.LittleEndian.Uint32(b)))
+	}
+	return r.short()
+}
+
+func (r *terminfoReader) align() {
+	if r.off%2 == 1 {
+		r.off++
+	}
+}
+
+// parseTerminfo parses a compiled terminfo entry as described in term(5).
+// Only the standard colors capability and extended capabilities are named,
+// other standard capabilities are ignored.
+func parseTerminfo(b []byte) (*terminfo, error) {
+	r := &terminfoReader{b: b}
+	magic := r.short()
+	numSize := 2
+	switch magic {
+	case terminfoMagic16:
+	case terminfoMagic32:
+		numSize = 4
+	default:
+		return nil, fmt.Errorf("terminfo: bad magic number %#o", magic)
+	}
+	namesLen, nbool, nnum, nstr, strLen := r.short(), r.short(), r.short(), r.short(), r.short()
+	ti := &terminfo{
+		Bools:   make(map[string]bool),
+		Numbers: make(map[string]int),
+		Strings: make(map[string]string),
+	}
+	names := r.bytes(namesLen)
+	ti.Names = strings.Split(string(bytes.TrimRight(names, "\x00")), "|")
+	r.bytes(nbool)
+	r.align()
+	for i := 0; i < nnum; i++ {
+		n := r.number(numSize)
+		if i == terminfoMaxColors && n >= 0 {
+			ti.Numbers["colors"] = n
+		}
+	}
+	r.bytes(nstr * 2)
+	r.bytes(strLen)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// the extended section is optional.
+	r.align()
+	if r.off >= len(b) {
+		return ti, nil
+	}
+	xbool, xnum, xstr, _, xtableLen := r.short(), r.short(), r.short(), r.short(), r.short()
+	bools := r.bytes(xbool)
+	r.align()
+	nums := make([]int, xnum)
+	for i := range nums {
+		nums[i] = r.number(numSize)
+	}
+	offsets := make([]int, xstr+xbool+xnum+xstr)
+	for i := range offsets {
+		offsets[i] = r.short()
+	}
+	table := r.bytes(xtableLen)
+	if r.err != nil {
+		return nil, r.err
+	}
+	cstring := func(off int) string {
+		if off < 0 || off >= len(table) {
+			return ""
+		}
+		s := table[off:]
+		if i := bytes.IndexByte(s, 0); i >= 0 {
+			s = s[:i]
+		}
+		return string(s)
+	}
+	// names follow the last string value in the table.
+	namesBase := 0
+	for _, off := range offsets[:xstr] {
+		if off >= 0 {
+			if end := off + len(cstring(off)) + 1; end > namesBase {
+				namesBase = end
+			}
+		}
+	}
+	names2 := offsets[xstr:]
+	for i := 0; i < xbool; i++ {
+		ti.Bools[cstring(namesBase+names2[i])] = bools[i] == 1
+	}
+	for i := 0; i < xnum; i++ {
+		if nums[i] >= 0 {
+			ti.Numbers[cstring(namesBase+names2[xbool+i])] = nums[i]
+		}
+	}
+	for i := 0; i < xstr; i++ {
+		if offsets[i] >= 0 {
+			ti.Strings[cstring(namesBase+names2[xbool+xnum+i])] = cstring(offsets[i])
+		}
+	}
+	return ti, nil
+}
+
+// xtgettcapResponse matches a successful XTGETTCAP response, which gives
+// hex-encoded names and values.
+var xtgettcapResponse = regexp.MustCompile(`\x1bP1\+r([0-9A-Fa-f]+)(?:=([0-9A-Fa-f]*))?\x1b\\`)
+
+// queryTermcap asks the terminal for the values of the named terminfo
+// capabilities with XTGETTCAP.  Capabilities the terminal does not report are
+// missing from the returned map.  Boolean capabilities have empty values.
+func queryTermcap(names ...string) (map[string]string, error) {
+	var query strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&query, "\033P+q%s\033\\", strings.ToUpper(hex.EncodeToString([]byte(name))))
+	}
+	resp, _, err := queryTerminalCached(query.String())
+	if err != nil {
+		return nil, err
+	}
+	caps := make(map[string]string)
+	for _, m := range xtgettcapResponse.FindAllSubmatch(resp, -1) {
+		name, err := hex.DecodeString(string(m[1]))
+		if err != nil {
+			continue
+		}
+		value, err := hex.DecodeString(string(m[2]))
+		if err != nil {
+			continue
+		}
+		caps[string(name)] = string(value)
+	}
+	return caps, nil
+}
+
+// terminalColors describes the color support reported by the terminal or its
+// terminfo entry.
+type terminalColors struct {
+	Colors  int  // maximum number of palette colors
+	Counted bool // Colors is known
+	RGB     bool // direct color is supported
+	Source  string
+}
+
+var detectedColors *terminalColors
+
+// detectColors returns the color support of the terminal.  The terminal is
+// asked first using XTGETTCAP, because TERM often names a different terminal
+// than the one in use, and otherwise the terminfo entry for TERM is read.
+func detectColors() *terminalColors {
+	if detectedColors != nil {
+		return detectedColors
+	}
+	tc := &terminalColors{}
+	detectedColors = tc
+	caps, err := queryTermcap("colors", "RGB", "Tc")
+	if err == nil && len(caps) > 0 {
+		tc.Source = "XTGETTCAP"
+		tc.Colors, err = strconv.Atoi(caps["colors"])
+		tc.Counted = err == nil
+		_, rgb := caps["RGB"]
+		_, truecolor := caps["Tc"]
+		tc.RGB = rgb || truecolor
+		return tc
+	}
+	term := os.Getenv("TERM")
+	ti, err := loadTerminfo(term)
+	if err != nil {
+		return tc
+	}
+	tc.Source = "terminfo " + term
+	// entries without colors are monochrome.
+	tc.Colors = ti.Numbers["colors"]
+	tc.Counted = true
+	tc.RGB = ti.Bools["RGB"] || ti.Bools["Tc"] || ti.Numbers["RGB"] > 0 || ti.Strings["RGB"] != ""
+	return tc
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// QueryTimeout bounds how long img2ansi waits for a terminal to answer a
+// query.
+var QueryTimeout = 200 * time.Millisecond
+
+var errQueryTimeout = errors.New("terminal did not respond")
+
+// da1Response matches the response to a Primary Device Attributes request,
+// which virtually every terminal answers.
+var da1Response = regexp.MustCompile(`\x1b\[\?[0-9;]*c`)
+
+// queryTerminal writes query to the controlling terminal and returns the
+// terminal's response.  A Primary Device Attributes request follows query so
+// that terminals which ignore query still respond, the returned response
+// excludes the device attributes.
+func queryTerminal(query string) ([]byte, error) {
+	resp, _, err := queryTerminalDA(query)
+	return resp, err
+}
+
+// queryTerminalDA is like queryTerminal but also returns the device
+// attributes response.
+func queryTerminalDA(query string) (resp, da []byte, err error) {
+	if !TerminalQueries {
+		return nil, nil, errQueriesDisabled
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer terminal.Restore(fd, state)
+
+	_, err = tty.WriteString(query + "\033[c")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deadline := time.Now().Add(QueryTimeout)
+	buf := make([]byte, 256)
+	for {
+		err := tty.SetReadDeadline(deadline)
+		if err != nil {
+			return nil, nil, err
+		}
+		n, err := tty.Read(buf)
+		resp = append(resp, buf[:n]...)
+		if loc := da1Response.FindIndex(resp); loc != nil {
+			return resp[:loc[0]], resp[loc[0]:loc[1]], nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, nil, errQueryTimeout
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// queryDeviceAttributes returns the attributes the terminal reports in
+// response to a Primary Device Attributes request.  The first attribute is
+// the terminal's conformance level.
+func queryDeviceAttributes() ([]int, error) {
+	_, da, err := queryTerminalCached("")
+	if err != nil {
+		return nil, err
+	}
+	var attrs []int
+	for _, s := range strings.Split(strings.Trim(string(da), "\x1b[?c"), ";") {
+		n, err := strconv.Atoi(s)
+		if err == nil {
+			attrs = append(attrs, n)
+		}
+	}
+	return attrs, nil
+}
+
+// queryPrivateMode reports whether the terminal recognizes DEC private mode
+// mode, using DECRQM.
+func queryPrivateMode(mode int) (bool, error) {
+	resp, _, err := queryTerminalCached(fmt.Sprintf("\033[?%d$p", mode))
+	if err != nil {
+		return false, err
+	}
+	m := regexp.MustCompile(fmt.Sprintf(`\x1b\[\?%d;([0-9])\$y`, mode)).FindSubmatch(resp)
+	if m == nil {
+		return false, nil
+	}
+	// 0 is "not recognized" and 4 is "permanently reset".
+	return !bytes.Equal(m[1], []byte("0")) && !bytes.Equal(m[1], []byte("4")), nil
+}
+
+// queryCursorRow returns the row of the cursor, counting from one.
+func queryCursorRow() (int, error) {
+	resp, err := queryTerminal("\033[6n")
+	if err != nil {
+		return 0, err
+	}
+	m := regexp.MustCompile(`\x1b\[([0-9]+);[0-9]+R`).FindSubmatch(resp)
+	if m == nil {
+		return 0, fmt.Errorf("terminal did not report the cursor position")
+	}
+	return strconv.Atoi(string(m[1]))
+}
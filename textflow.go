@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// textColumn returns the lines of opts.Text to print beside an image that is
+// imgWidth cells wide, wrapped to fit within opts.TextWidth if it is positive.
+// The width of the widest returned line is returned along with the lines.
+func textColumn(opts *FrameOptions, imgWidth int) ([]string, int) {
+	if opts == nil || len(opts.Text) == 0 {
+		return nil, 0
+	}
+	lines := opts.Text
+	if opts.TextWidth > 0 {
+		cols := opts.TextWidth - imgWidth - 2*opts.padWidth() - 1
+		if cols < 1 {
+			cols = 1
+		}
+		lines = wrapText(lines, cols)
+	}
+	width := 0
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
+		}
+	}
+	return lines, width
+}
+
+// wrapText breaks lines so that none is longer than width runes.  Lines are
+// broken at spaces when possible.
+func wrapText(lines []string, width int) []string {
+	var wrapped []string
+	for _, line := range lines {
+		for utf8.RuneCountInString(line) > width {
+			runes := []rune(line)
+			cut := width
+			if i := strings.LastIndex(string(runes[:width+1]), " "); i > 0 {
+				cut = utf8.RuneCountInString(string(runes[:width+1])[:i])
+			}
+			wrapped = append(wrapped, strings.TrimRight(string(runes[:cut]), " "))
+			line = strings.TrimLeft(string(runes[cut:]), " ")
+		}
+		wrapped = append(wrapped, line)
+	}
+	return wrapped
+}
+
+// padText pads s with spaces on the right so it is width runes long.
+func padText(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-n)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Themes accepted by -theme, describing the background output is drawn on.
+const (
+	ThemeAuto  = "auto"
+	ThemeDark  = "dark"
+	ThemeLight = "light"
+)
+
+// Theme is the background of the terminal, dark or light.
+type Theme struct {
+	// Name is ThemeDark or ThemeLight.
+	Name string
+
+	// Background is the background color reported by the terminal, or black
+	// or white if it is not known.
+	Background color.Color
+}
+
+// newTheme returns the theme with the given name and the background color
+// typical of it.
+func newTheme(name string) *Theme {
+	if name == ThemeLight {
+		return &Theme{Name: ThemeLight, Background: color.White}
+	}
+	return &Theme{Name: ThemeDark, Background: color.Black}
+}
+
+// themeFor returns the theme of the background color bg.
+func themeFor(bg color.Color) *Theme {
+	t := newTheme(ThemeDark)
+	if luminance(bg) > 0.5 {
+		t.Name = ThemeLight
+	}
+	t.Background = bg
+	return t
+}
+
+// detectTheme returns the theme of the terminal along with a description of
+// the evidence.  The terminal is asked for its background color, and
+// otherwise COLORFGBG, set by rxvt and some other terminals, gives the palette
+// index of the background.  Terminals are assumed to be dark when neither
+// tells.
+func detectTheme() (*Theme, string) {
+	bg, err := queryBackgroundColor()
+	if err == nil {
+		return themeFor(bg), fmt.Sprintf("terminal reported background %s", *hexColor(bg))
+	}
+	if v := os.Getenv("COLORFGBG"); v != "" {
+		fields := strings.Split(v, ";")
+		n, err := strconv.Atoi(fields[len(fields)-1])
+		if err == nil && n >= 0 && n < 16 {
+			// the light colors of the first 16 are white and the bright
+			// colors but bright black.
+			if n == 7 || n > 8 {
+				return newTheme(ThemeLight), "COLORFGBG=" + v
+			}
+			return newTheme(ThemeDark), "COLORFGBG=" + v
+		}
+	}
+	return newTheme(ThemeDark), "background unknown, assuming dark"
+}
+
+// oscBackgroundResponse matches the response to an OSC 11 query, the
+// background color with 1 to 4 hex digits for each channel.
+var oscBackgroundResponse = regexp.MustCompile(`\x1b\]11;rgb:([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})`)
+
+// queryBackgroundColor asks the terminal for its background color.  The
+// response is not cached, as the background is a setting of the profile in
+// use rather than of the terminal.
+func queryBackgroundColor() (color.Color, error) {
+	resp, err := queryTerminal("\033]11;?\033\\")
+	if err != nil {
+		return nil, err
+	}
+	return parseOSCColor(resp)
+}
+
+// parseOSCColor returns the color in a response to an OSC 11 query.
+func parseOSCColor(resp []byte) (color.Color, error) {
+	m := oscBackgroundResponse.FindSubmatch(resp)
+	if m == nil {
+		return nil, fmt.Errorf("terminal did not report its background color")
+	}
+	var c [3]uint16
+	for i, hex := range m[1:] {
+		v, _ := strconv.ParseUint(string(hex), 16, 16)
+		// channels are scaled from as many digits as were given.
+		c[i] = uint16(v * 0xffff / (1<<(4*len(hex)) - 1))
+	}
+	return color.RGBA64{c[0], c[1], c[2], 0xffff}, nil
+}
+
+// themeContrastMin is the least difference in luminance between an image
+// with transparency and the background, on average, which is not warned
+// about.
+const themeContrastMin = 0.15
+
+// WarnContrastFrames logs a warning if the first frame of an image has
+// transparent pixels and its opaque pixels are too close to the background
+// of t to be seen, such as a black logo on a dark terminal.  Frames are
+// passed through unchanged.
+func WarnContrastFrames(ctx context.Context, t *Theme, frames <-chan *Frame) <-chan *Frame {
+	warned := false
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		if warned || f.Index > 1 {
+			return f, nil
+		}
+		if c, ok := transparencyContrast(f, t.Background); ok && c < themeContrastMin {
+			warned = true
+			logger(logRender).Warn("image with transparency may be hard to see on the terminal background", "theme", t.Name, "contrast", c)
+		}
+		return f, nil
+	})
+}
+
+// transparencyContrastSamples is about the most pixels transparencyContrast
+// reads from a frame.
+const transparencyContrastSamples = 1 << 16
+
+// transparencyContrast returns the average difference in luminance between
+// the opaque pixels of f and bg, or false if f has no transparent pixels.
+// Frames are not yet resized, so large frames are sampled on a grid.
+func transparencyContrast(f *Frame, bg color.Color) (float64, bool) {
+	rect := f.Image.Bounds()
+	step := max(1, int(math.Sqrt(float64(rect.Dx()*rect.Dy())/transparencyContrastSamples)))
+	bgLum := luminance(bg)
+	var sum float64
+	var transparent, n int
+	for y := rect.Min.Y; y < rect.Max.Y; y += step {
+		for x := rect.Min.X; x < rect.Max.X; x += step {
+			c := f.Image.At(x, y)
+			if IsTransparent(c, AlphaThreshold) {
+				transparent++
+				continue
+			}
+			d := luminance(c) - bgLum
+			if d < 0 {
+				d = -d
+			}
+			sum += d
+			n++
+		}
+	}
+	if transparent == 0 || n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseOSCColor(t *testing.T) {
+	for resp, expect := range map[string]color.RGBA64{
+		"\033]11;rgb:ffff/ffff/ffff\033\\": {0xffff, 0xffff, 0xffff, 0xffff},
+		"\033]11;rgb:1e1e/1e1e/2e2e\007":   {0x1e1e, 0x1e1e, 0x2e2e, 0xffff},
+		"\033]11;rgb:f/8/0\033\\":          {0xffff, 0x8888, 0, 0xffff},
+	} {
+		c, err := parseOSCColor([]byte(resp))
+		if err != nil {
+			t.Errorf("%q: %v", resp, err)
+		} else if c != expect {
+			t.Errorf("%q parsed as %v (expected %v)", resp, c, expect)
+		}
+	}
+	if _, err := parseOSCColor([]byte("\033]10;rgb:0/0/0\033\\")); err == nil {
+		t.Errorf("foreground color parsed as the background")
+	}
+	if th := themeFor(color.RGBA{0xfd, 0xf6, 0xe3, 0xff}); th.Name != ThemeLight {
+		t.Errorf("light background has theme %s", th.Name)
+	}
+}
+
+func TestTransparencyContrast(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		img.Set(x, 1, color.RGBA{0x10, 0x10, 0x10, 0xff})
+	}
+	f := &Frame{Image: img}
+	if c, ok := transparencyContrast(f, color.Black); !ok || c >= themeContrastMin {
+		t.Errorf("dark image on a dark background has contrast %v %v", c, ok)
+	}
+	if c, ok := transparencyContrast(f, color.White); !ok || c < themeContrastMin {
+		t.Errorf("dark image on a light background has contrast %v %v", c, ok)
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+	if _, ok := transparencyContrast(f, color.Black); ok {
+		t.Errorf("contrast of an opaque image checked")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "time"
+
+// TerminalShare is the largest fraction of time the terminal may spend
+// consuming animation frames when FrameOptions.Throttle is set.  The rest is
+// left idle so that the terminal keeps up with its output and stays
+// responsive to input, rather than freezing under a firehose of frames from
+// a GIF with tiny delays.
+const TerminalShare = 0.5
+
+// throughputDecay is the weight of past frames in a throughput estimate,
+// applied each time a frame is measured.
+const throughputDecay = 0.8
+
+// statusRequest asks the terminal for a device status report.  The terminal
+// answers once it has processed everything written before the request.
+const statusRequest = "\033[5n"
+
+// statusTimeout is how long a status request may go unanswered before the
+// terminal is assumed not to answer and measurement stops.
+const statusTimeout = 2 * time.Second
+
+// throughput estimates the rate at which a terminal draws output.  A write
+// returns once the output is buffered by the operating system, long before
+// the terminal has drawn it, so the time to draw a frame is instead measured
+// by following it with a status request and waiting for the answer.  The
+// round trip to the terminal is subtracted, taking the quickest answer as
+// the round trip.  One frame is measured at a time, while the frames written
+// before its answer arrives are not.
+type throughput struct {
+	// bytes and secs are sums of the size and draw time of frames, decayed
+	// so that the estimate follows changes in the terminal.
+	bytes float64
+	secs  float64
+
+	// reports receives the time the terminal answers each status request,
+	// or is nil if the terminal cannot be asked.
+	reports <-chan time.Time
+
+	probing    bool      // a status request is unanswered
+	probeSize  int       // the size of the frame the request follows
+	probeStart time.Time // when writing the frame began
+	latency    time.Duration
+	stopped    bool // the terminal did not answer
+}
+
+// add measures a frame of n bytes which took d to draw.
+func (t *throughput) add(n int, d time.Duration) {
+	t.bytes = t.bytes*throughputDecay + float64(n)
+	t.secs = t.secs*throughputDecay + d.Seconds()
+}
+
+// probe returns the status request to write after a frame of n bytes which
+// began to be written at start, or an empty string if the frame is not
+// measured.
+func (t *throughput) probe(n int, start time.Time) string {
+	t.update()
+	if t.reports == nil || t.probing || t.stopped {
+		return ""
+	}
+	t.probing, t.probeSize, t.probeStart = true, n, start
+	return statusRequest
+}
+
+// update measures the frame of an answered status request.
+func (t *throughput) update() {
+	if !t.probing {
+		return
+	}
+	select {
+	case at := <-t.reports:
+		t.probing = false
+		d := at.Sub(t.probeStart)
+		if t.latency == 0 || d < t.latency {
+			t.latency = d
+		}
+		t.add(t.probeSize, d-t.latency)
+	default:
+		if time.Since(t.probeStart) > statusTimeout {
+			t.probing, t.stopped = false, true
+		}
+	}
+}
+
+// Rate returns the estimated throughput in bytes per second, or zero if
+// nothing has been measured.
+func (t *throughput) Rate() float64 {
+	if t.secs == 0 {
+		return 0
+	}
+	return t.bytes / t.secs
+}
+
+// floor returns the shortest delay before a frame of n bytes which keeps the
+// terminal's share of time spent on frames within TerminalShare.
+func (t *throughput) floor(n int) time.Duration {
+	rate := t.Rate()
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(n) / rate / TerminalShare * float64(time.Second))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThroughputProbe(t *testing.T) {
+	reports := make(chan time.Time, 1)
+	var rate throughput
+	start := time.Now()
+	if req := rate.probe(1000, start); req != "" {
+		t.Errorf("status requested without a terminal to answer")
+	}
+	rate.reports = reports
+
+	// the first answer, for a frame drawn at once, gives the round trip.
+	if req := rate.probe(0, start); req != statusRequest {
+		t.Fatalf("status request %q", req)
+	}
+	if req := rate.probe(5000, start); req != "" {
+		t.Errorf("second request while the first is unanswered")
+	}
+	reports <- start.Add(50 * time.Millisecond)
+	start = start.Add(time.Second)
+	if req := rate.probe(10000, start); req != statusRequest {
+		t.Fatalf("no request after an answer")
+	}
+	// 10000 bytes drawn in 100ms past the round trip.
+	reports <- start.Add(150 * time.Millisecond)
+	rate.update()
+	if r := rate.Rate(); r < 99000 || r > 101000 {
+		t.Errorf("rate %f bytes per second (expected 100000)", r)
+	}
+	if floor := rate.floor(10000); floor != 200*time.Millisecond {
+		t.Errorf("floor %s (expected 200ms)", floor)
+	}
+}
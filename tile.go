@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"image"
+)
+
+// TileFrames repeats each frame received over frames to fill a width x height
+// cell frame.  If mirror is true alternating tiles are flipped so that their
+// edges meet seamlessly.
+func TileFrames(ctx context.Context, width, height int, mirror bool, frames <-chan *Frame) <-chan *Frame {
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		return &Frame{
+			Image:     tileImage(f.Image, width, height, mirror),
+			Delay:     f.Delay,
+			LoopCount: f.LoopCount,
+			Source:    f.Source,
+			Preview:   f.Preview,
+		}, nil
+	})
+}
+
+func tileImage(img image.Image, width, height int, mirror bool) image.Image {
+	rect := img.Bounds()
+	size := rect.Size()
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+	if size.X == 0 || size.Y == 0 {
+		return dst
+	}
+	for y := 0; y < height; y++ {
+		ty, sy := y/size.Y, y%size.Y
+		if mirror && ty%2 == 1 {
+			sy = size.Y - 1 - sy
+		}
+		for x := 0; x < width; x++ {
+			tx, sx := x/size.X, x%size.X
+			if mirror && tx%2 == 1 {
+				sx = size.X - 1 - sx
+			}
+			dst.Set(x, y, img.At(rect.Min.X+sx, rect.Min.Y+sy))
+		}
+	}
+	return dst
+}
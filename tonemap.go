@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ToneMap compresses a linear luminance with unbounded range into [0, 1].
+// White is the smallest luminance in the frame which should map to 1, for
+// operators which use it.
+type ToneMap func(l, white float64) float64
+
+var toneMaps = map[string]ToneMap{
+	"reinhard": toneMapReinhard,
+	"aces":     toneMapACES,
+}
+
+func ToneMaps() []string {
+	var names []string
+	for name := range toneMaps {
+		names = append(names, name)
+	}
+	return names
+}
+
+// toneMapReinhard is the extended Reinhard operator, which maps white to 1
+// and compresses highlights below it smoothly.
+func toneMapReinhard(l, white float64) float64 {
+	return l * (1 + l/(white*white)) / (1 + l)
+}
+
+// toneMapACES is Krzysztof Narkowicz's fit of the ACES filmic curve, which
+// has more contrast in the mid-tones than Reinhard.
+func toneMapACES(l, white float64) float64 {
+	const a, b, c, d, e = 2.51, 0.03, 2.43, 0.59, 0.14
+	return math.Min(1, l*(a*l+b)/(l*(c*l+d)+e))
+}
+
+// toneMapKey is the luminance the log-average luminance of a frame is exposed
+// to, middle gray.
+const toneMapKey = 0.18
+
+// ToneMapFrames applies tm to frames with 16 bits per channel, such as
+// 16-bit PNGs, which can hold more highlight detail than a terminal palette
+// shows.  Frames are exposed so their log-average luminance is middle gray.
+// The exposure is computed from the first frame of each input and reused for
+// the rest so that animations do not flicker.  Frames with 8 bits per
+// channel are passed through unchanged.
+func ToneMapFrames(ctx context.Context, tm ToneMap, frames <-chan *Frame) <-chan *Frame {
+	if tm == nil {
+		return frames
+	}
+	type exposure struct{ scale, white float64 }
+	exposures := make(map[int]exposure)
+	return mapFrames(ctx, frames, func(f *Frame) (*Frame, error) {
+		if deepImage(f.Image) {
+			e, ok := exposures[f.Source]
+			if !ok {
+				e.scale, e.white = toneMapExposure(f.Image)
+				exposures[f.Source] = e
+			}
+			g := *f
+			g.Image = toneMapImage(f.Image, tm, e.scale, e.white)
+			f = &g
+		}
+		return f, nil
+	})
+}
+
+// deepImage reports whether img stores more than 8 bits per channel.
+func deepImage(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// linearRGB returns the channels of c at full opacity in linear light.
+func linearRGB(c color.Color) (r, g, b float64) {
+	rf, gf, bf, _ := opaque(c).RGBA()
+	return srgbToLinear(float64(rf) / 0xffff), srgbToLinear(float64(gf) / 0xffff), srgbToLinear(float64(bf) / 0xffff)
+}
+
+// toneMapExposure returns the factor which exposes img at toneMapKey and the
+// resulting luminance of its brightest pixel.
+func toneMapExposure(img image.Image) (scale, white float64) {
+	const delta = 1e-4 // avoids log(0) for black pixels
+	rect := img.Bounds()
+	var sum, peak float64
+	n := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := img.At(x, y)
+			if IsTransparent(c, AlphaThreshold) {
+				continue
+			}
+			r, g, b := linearRGB(c)
+			l := 0.2126*r + 0.7152*g + 0.0722*b
+			sum += math.Log(delta + l)
+			peak = math.Max(peak, l)
+			n++
+		}
+	}
+	if n == 0 || peak == 0 {
+		return 1, 1
+	}
+	scale = toneMapKey / math.Exp(sum/float64(n))
+	return scale, peak * scale
+}
+
+func toneMapImage(src image.Image, tm ToneMap, scale, white float64) image.Image {
+	rect := src.Bounds()
+	img := image.NewNRGBA64(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := src.At(x, y)
+			_, _, _, a := c.RGBA()
+			if a == 0 {
+				continue
+			}
+			r, g, b := linearRGB(c)
+			l := (0.2126*r + 0.7152*g + 0.0722*b) * scale
+			// the luminance is mapped and the channels scaled with it, which
+			// preserves hue better than mapping channels independently.
+			k := 0.0
+			if l > 0 {
+				k = tm(l, white) / l * scale
+			}
+			channel := func(v float64) uint16 {
+				return uint16(0xffff * linearToSRGB(math.Min(1, v*k)))
+			}
+			img.SetNRGBA64(x, y, color.NRGBA64{R: channel(r), G: channel(g), B: channel(b), A: uint16(a)})
+		}
+	}
+	return img
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"time"
+)
+
+// Transition computes the colors of intermediate frames synthesized between
+// the last frame of one input and the first frame of the next.
+type Transition interface {
+	// Color returns the color of the cell at (x, y) in a frame of the given
+	// size when the transition is fraction p complete.
+	Color(from, to color.Color, x, y int, size image.Point, p float64) color.Color
+}
+
+var frameTransitions = map[string]Transition{
+	"fade":     TransitionFade{},
+	"wipe":     TransitionWipe{},
+	"dissolve": TransitionDissolve{},
+}
+
+func FrameTransitions() []string {
+	var names []string
+	for name := range frameTransitions {
+		names = append(names, name)
+	}
+	return names
+}
+
+// TransitionFrames inserts frames rendered with t between frames decoded from
+// different inputs.  The inserted frames span a total of dur.
+func TransitionFrames(ctx context.Context, t Transition, dur time.Duration, frames <-chan *Frame) <-chan *Frame {
+	if t == nil || dur <= 0 {
+		return frames
+	}
+	n := int(dur / DelayDefault)
+	if n < 1 {
+		n = 1
+	}
+	delay := dur / time.Duration(n)
+
+	out := make(chan *Frame, PipelineBuffer)
+	go func() {
+		defer close(out)
+		var prev *Frame
+		send := func(f *Frame) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- f:
+				return true
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case f, ok := <-frames:
+				if !ok {
+					return
+				}
+				if prev != nil && prev.Source != f.Source {
+					// a loop ending before f ends before the
+					// transition to it.
+					loopEnd := f.LoopEnd
+					if loopEnd > 0 {
+						g := *f
+						g.LoopEnd = 0
+						f = &g
+					}
+					for i := 1; i <= n; i++ {
+						p := float64(i) / float64(n+1)
+						g := &Frame{
+							Image:     transitionImage(t, prev.Image, f.Image, p),
+							Delay:     delay,
+							LoopCount: f.LoopCount,
+							Source:    f.Source,
+						}
+						if i == 1 {
+							g.LoopEnd = loopEnd
+						}
+						if !send(g) {
+							return
+						}
+					}
+				}
+				if !send(f) {
+					return
+				}
+				prev = f
+			}
+		}
+	}()
+	return out
+}
+
+// transitionImage renders an intermediate frame between from and to.  The
+// images are aligned at their top-left corners and the result is large enough
+// to contain both.
+func transitionImage(t Transition, from, to image.Image, p float64) image.Image {
+	fromRect := from.Bounds()
+	toRect := to.Bounds()
+	size := fromRect.Size()
+	if s := toRect.Size(); s.X > size.X {
+		size.X = s.X
+	}
+	if s := toRect.Size(); s.Y > size.Y {
+		size.Y = s.Y
+	}
+	img := image.NewRGBA64(image.Rectangle{Max: size})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c0 := from.At(fromRect.Min.X+x, fromRect.Min.Y+y)
+			c1 := to.At(toRect.Min.X+x, toRect.Min.Y+y)
+			if x >= fromRect.Dx() || y >= fromRect.Dy() {
+				c0 = color.Transparent
+			}
+			if x >= toRect.Dx() || y >= toRect.Dy() {
+				c1 = color.Transparent
+			}
+			img.Set(x, y, t.Color(c0, c1, x, y, size, p))
+		}
+	}
+	return img
+}
+
+// TransitionFade cross-fades between frames.
+type TransitionFade struct{}
+
+func (TransitionFade) Color(from, to color.Color, x, y int, size image.Point, p float64) color.Color {
+	r0, g0, b0, a0 := from.RGBA()
+	r1, g1, b1, a1 := to.RGBA()
+	lerp := func(u0, u1 uint32) uint16 {
+		return uint16(float64(u0)*(1-p) + float64(u1)*p)
+	}
+	return color.RGBA64{
+		R: lerp(r0, r1),
+		G: lerp(g0, g1),
+		B: lerp(b0, b1),
+		A: lerp(a0, a1),
+	}
+}
+
+// TransitionWipe reveals the next frame from left to right.
+type TransitionWipe struct{}
+
+func (TransitionWipe) Color(from, to color.Color, x, y int, size image.Point, p float64) color.Color {
+	if float64(x) < p*float64(size.X) {
+		return to
+	}
+	return from
+}
+
+// TransitionDissolve reveals the next frame one cell at a time in a fixed
+// pseudo-random order.
+type TransitionDissolve struct{}
+
+func (TransitionDissolve) Color(from, to color.Color, x, y int, size image.Point, p float64) color.Color {
+	// a cheap integer hash of the cell coordinates gives each cell a stable
+	// threshold in [0, 1).
+	h := uint32(x)*0x9e3779b1 ^ uint32(y)*0x85ebca77
+	h ^= h >> 15
+	h *= 0x2c1b3c6d
+	h ^= h >> 12
+	if float64(h)/(1<<32) < p {
+		return to
+	}
+	return from
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"net"
+	"net/url"
+)
+
+const (
+	ddpPort          = "4048"
+	ddpHeaderLen     = 10
+	ddpMaxData       = 1440 // 480 RGB pixels fit in a standard MTU
+	ddpFlagsVersion1 = 0x40
+	ddpFlagPush      = 0x01
+	ddpTypeRGB24     = 0x0b
+	ddpDestDefault   = 0x01
+)
+
+// ddpSink sends frames using the Distributed Display Protocol, which WLED
+// accepts as a realtime UDP source.
+type ddpSink struct {
+	conn net.Conn
+	seq  uint8
+	buf  []byte
+}
+
+// newDDPSink returns a sink for a URL of the form wled://host[:port].
+func newDDPSink(u *url.URL) (*ddpSink, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), ddpPort)
+	}
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	return &ddpSink{conn: conn}, nil
+}
+
+func (s *ddpSink) WritePixels(size image.Point, pixels []color.RGBA) error {
+	// sequence numbers are 4 bits and zero means sequencing is not used.
+	s.seq = s.seq%15 + 1
+	data := s.buf[:0]
+	for _, c := range pixels {
+		data = append(data, c.R, c.G, c.B)
+	}
+	s.buf = data
+
+	var pkt [ddpHeaderLen + ddpMaxData]byte
+	for off := 0; ; off += ddpMaxData {
+		chunk := data[off:]
+		if len(chunk) > ddpMaxData {
+			chunk = chunk[:ddpMaxData]
+		}
+		flags := byte(ddpFlagsVersion1)
+		if off+len(chunk) == len(data) {
+			// the receiver displays the frame once it receives a push.
+			flags |= ddpFlagPush
+		}
+		pkt[0] = flags
+		pkt[1] = s.seq
+		pkt[2] = ddpTypeRGB24
+		pkt[3] = ddpDestDefault
+		binary.BigEndian.PutUint32(pkt[4:], uint32(off))
+		binary.BigEndian.PutUint16(pkt[8:], uint16(len(chunk)))
+		n := copy(pkt[ddpHeaderLen:], chunk)
+		_, err := s.conn.Write(pkt[:ddpHeaderLen+n])
+		if err != nil {
+			return fmt.Errorf("ddp: %w", err)
+		}
+		if off+n >= len(data) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *ddpSink) Close() error {
+	return s.conn.Close()
+}
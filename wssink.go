@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsBroadcaster serves frames over WebSocket to any number of clients.  Each
+// frame written to it, as delimited by FlushFrame, is sent to every client as
+// a single text message.  Clients which fall behind miss frames rather than
+// slowing down the animation.
+type wsBroadcaster struct {
+	buf      bytes.Buffer
+	upgrader websocket.Upgrader // with no CheckOrigin, only the same origin
+
+	addr    net.Addr // the address listened on
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+	server  *http.Server
+}
+
+// newWSBroadcaster listens on the host of u, for example ws://:8081/stream,
+// and serves frames on its path.  The root path serves a page which displays
+// the frames using xterm.js.  Browsers may only connect from that page,
+// unless the origin query parameters of u name other origins allowed to, or
+// are *, which allows any.
+func newWSBroadcaster(ctx context.Context, u *url.URL) (*wsBroadcaster, error) {
+	path := u.Path
+	if path == "" || path == "/" {
+		path = "/stream"
+	}
+	b := &wsBroadcaster{clients: make(map[chan []byte]bool)}
+	if origins := u.Query()["origin"]; len(origins) > 0 {
+		b.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || slices.Contains(origins, "*") || slices.Contains(origins, origin)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, b.serveStream)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, wsDemoPage, path)
+	})
+	ln, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("websocket sink: %w", err)
+	}
+	b.addr = ln.Addr()
+	logger(logHTTP).Info("serving frames", "url", fmt.Sprintf("ws://%s%s", b.addr, path))
+	b.server = &http.Server{Handler: mux}
+	go b.server.Serve(ln)
+	go func() {
+		<-ctx.Done()
+		b.Close()
+	}()
+	return b, nil
+}
+
+func (b *wsBroadcaster) serveStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	frames := make(chan []byte, 1)
+	b.mu.Lock()
+	b.clients[frames] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, frames)
+		b.mu.Unlock()
+	}()
+
+	// read until the client disconnects so that control messages are
+	// handled.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			err := conn.WriteMessage(websocket.TextMessage, frame)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *wsBroadcaster) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// FlushFrame sends the frame written since the last call to all clients.
+func (b *wsBroadcaster) FlushFrame() error {
+	frame := bytes.Clone(b.buf.Bytes())
+	b.buf.Reset()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		select {
+		case c <- frame:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close disconnects all clients and stops the server.
+func (b *wsBroadcaster) Close() error {
+	b.mu.Lock()
+	for c := range b.clients {
+		close(c)
+		delete(b.clients, c)
+	}
+	b.mu.Unlock()
+	return b.server.Close()
+}
+
+// wsDemoPage displays ANSI frames received from the stream path, which is
+// substituted for %s, in a terminal emulator.
+const wsDemoPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>img2ansi</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/xterm@5.3.0/css/xterm.css">
+<script src="https://cdn.jsdelivr.net/npm/xterm@5.3.0/lib/xterm.js"></script>
+<style>body { margin: 0; background: #000; }</style>
+</head>
+<body>
+<div id="terminal"></div>
+<script>
+var term = new Terminal({cols: 200, rows: 60, convertEol: true});
+term.open(document.getElementById("terminal"));
+var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+var ws = new WebSocket(scheme + location.host + "%s");
+ws.onmessage = function(ev) {
+	// each message is a whole frame, draw it from the top left.
+	term.write("\x1b[H" + ev.data);
+};
+</script>
+</body>
+</html>
+`
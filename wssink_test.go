@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWSBroadcasterOrigin(t *testing.T) {
+	for _, test := range []struct {
+		query  string
+		origin string
+		ok     bool
+	}{
+		{"", "", true},
+		{"", "http://HOST", true},
+		{"", "http://evil.example", false},
+		{"?origin=http://app.example", "http://app.example", true},
+		{"?origin=http://app.example", "http://evil.example", false},
+		{"?origin=*", "http://evil.example", true},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		u, _ := url.Parse("ws://127.0.0.1:0/stream" + test.query)
+		b, err := newWSBroadcaster(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := b.addr.String()
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", strings.Replace(test.origin, "HOST", addr, 1))
+		}
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/stream", header)
+		if (err == nil) != test.ok {
+			t.Errorf("%q from origin %q: connected %v (expected %v)", test.query, test.origin, err == nil, test.ok)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		cancel()
+		b.Close()
+	}
+}